@@ -7,14 +7,26 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
+	"clinical-agent-backend/internal/domain"
+	"clinical-agent-backend/internal/protocol"
+
 	"github.com/gorilla/websocket"
 )
 
 func main() {
 	audioFile := flag.String("file", "", "Path to 16-bit PCM WAV audio file to stream")
 	serverAddr := flag.String("addr", "localhost:8080", "Server address")
+	sampleRate := flag.Int("rate", 16000, "Sample rate of the audio in Hz")
+	language := flag.String("lang", "en-US", "BCP-47 language code")
+	altLanguages := flag.String("alt-langs", "", "Comma-separated alternative language codes, e.g. es-US")
+	model := flag.String("model", "", "Recognition model, e.g. medical_conversation")
+	phraseSets := flag.String("phrase-sets", "", "Comma-separated vocabulary phrase sets to boost (default all)")
+	patient := flag.String("patient", "", "FHIR patient reference, e.g. Patient/123")
+	encounter := flag.String("encounter", "", "FHIR encounter reference, e.g. Encounter/456")
+	practitioner := flag.String("practitioner", "", "FHIR practitioner reference, e.g. Practitioner/789")
 	flag.Parse()
 
 	if *audioFile == "" {
@@ -30,6 +42,36 @@ func main() {
 	}
 	defer c.Close()
 
+	var alternatives []string
+	if *altLanguages != "" {
+		alternatives = strings.Split(*altLanguages, ",")
+	}
+	var sets []string
+	if *phraseSets != "" {
+		sets = strings.Split(*phraseSets, ",")
+	}
+	start, err := protocol.Encode(protocol.TypeStart, 0, protocol.StartPayload{
+		Audio: protocol.AudioConfig{
+			Encoding:                 "LINEAR16",
+			SampleRateHertz:          *sampleRate,
+			Channels:                 1,
+			LanguageCode:             *language,
+			AlternativeLanguageCodes: alternatives,
+		},
+		Encounter: domain.EncounterContext{
+			PatientReference:      *patient,
+			EncounterReference:    *encounter,
+			PractitionerReference: *practitioner,
+		},
+		Recognition: protocol.RecognitionConfig{Model: *model, PhraseSets: sets},
+	})
+	if err != nil {
+		log.Fatalf("encode start: %v", err)
+	}
+	if err := c.WriteMessage(websocket.TextMessage, start); err != nil {
+		log.Fatalf("write start: %v", err)
+	}
+
 	done := make(chan struct{})
 
 	// Receive goroutine
@@ -41,7 +83,12 @@ func main() {
 				log.Println("read:", err)
 				return
 			}
-			log.Printf("Received: %s", message)
+			env, err := protocol.Decode(message)
+			if err != nil {
+				log.Printf("Received undecodable message: %v", err)
+				continue
+			}
+			log.Printf("Received %s #%d: %s", env.Type, env.Seq, env.Payload)
 		}
 	}()
 
@@ -69,7 +116,12 @@ func main() {
 				if err != nil {
 					if err == io.EOF {
 						log.Println("Finished sending audio file")
-						// Keep connection open to receive final results
+						// Ask the server to finish; it closes the connection
+						// after the final results have been sent.
+						end, _ := protocol.Encode(protocol.TypeEnd, 0, nil)
+						if err := c.WriteMessage(websocket.TextMessage, end); err != nil {
+							log.Println("write end:", err)
+						}
 						return
 					}
 					log.Printf("file read error: %v", err)
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"clinical-agent-backend/internal/domain"
+)
+
+func main() {
+	serverAddr := flag.String("addr", "localhost:8080", "Server address")
+	token := flag.String("token", os.Getenv("ADMIN_TOKEN"), "Admin API token (default $ADMIN_TOKEN)")
+	jobIDs := flag.String("jobs", "", "Comma-separated IDs of the encounters' original jobs or sessions")
+	patient := flag.String("patient", "", "FHIR patient reference, e.g. Patient/123")
+	encounter := flag.String("encounter", "", "FHIR encounter reference, e.g. Encounter/456")
+	from := flag.String("from", "", "Only encounters recorded at or after this time (RFC 3339 or YYYY-MM-DD)")
+	to := flag.String("to", "", "Only encounters recorded before this time (RFC 3339 or YYYY-MM-DD)")
+	retranscribe := flag.Bool("retranscribe", false, "Run speech recognition again on the archived audio")
+	dryRun := flag.Bool("dry-run", false, "Show how the notes would change without saving anything")
+	flag.Parse()
+
+	req := domain.ReprocessRequest{
+		PatientReference:   *patient,
+		EncounterReference: *encounter,
+		Retranscribe:       *retranscribe,
+		DryRun:             *dryRun,
+	}
+	if *jobIDs != "" {
+		req.JobIDs = strings.Split(*jobIDs, ",")
+	}
+	var err error
+	if req.From, err = parseTime(*from); err != nil {
+		log.Fatalf("-from: %v", err)
+	}
+	if req.To, err = parseTime(*to); err != nil {
+		log.Fatalf("-to: %v", err)
+	}
+	if req.Empty() {
+		log.Fatal("Please select encounters using -jobs, -patient, -encounter, -from or -to")
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		log.Fatalf("encode request: %v", err)
+	}
+	u := url.URL{Scheme: "http", Host: *serverAddr, Path: "/admin/reprocess"}
+	httpReq, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		log.Fatalf("request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+*token)
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		log.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		msg, _ := io.ReadAll(resp.Body)
+		log.Fatalf("server returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var results []domain.ReprocessResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		log.Fatalf("decode response: %v", err)
+	}
+
+	if len(results) == 0 {
+		fmt.Println("No encounters matched.")
+		return
+	}
+	failed := false
+	for _, r := range results {
+		fmt.Printf("%s %s %s\n", r.JobID, r.Encounter.PatientReference, r.Encounter.EncounterReference)
+		switch {
+		case r.Error != "":
+			failed = true
+			fmt.Printf("  error: %s\n", r.Error)
+		case r.Job != nil:
+			fmt.Printf("  queued job %s as version %d\n", r.Job.ID, r.Version)
+		case r.Diff != nil:
+			printDiff(r)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// parseTime accepts an RFC 3339 time or a date, read as midnight UTC.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// printDiff prints how a dry run's new note differs from the current one.
+func printDiff(r domain.ReprocessResult) {
+	fmt.Printf("  version %d compared with job %s\n", r.Version, r.BaseJobID)
+	if r.Transcript != "" {
+		fmt.Printf("  transcript: %s\n", r.Transcript)
+	}
+	if r.Diff.Empty() {
+		fmt.Println("  no changes")
+		return
+	}
+	printList("symptoms", r.Diff.Symptoms)
+	printList("medications", r.Diff.Medications)
+	printList("hpi", r.Diff.HPI)
+	if c := r.Diff.SourceLanguage; c != nil {
+		fmt.Printf("  source language: %s -> %s\n", c.Old, c.New)
+	}
+}
+
+func printList(name string, d domain.ListDiff) {
+	if d.Empty() {
+		return
+	}
+	fmt.Printf("  %s:\n", name)
+	for _, s := range d.Removed {
+		fmt.Printf("    - %s\n", s)
+	}
+	for _, s := range d.Added {
+		fmt.Printf("    + %s\n", s)
+	}
+}
@@ -2,11 +2,18 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
+	"clinical-agent-backend/internal/archive"
+	"clinical-agent-backend/internal/audio"
 	"clinical-agent-backend/internal/db"
 	"clinical-agent-backend/internal/ingestion"
 	"clinical-agent-backend/internal/intelligence"
@@ -29,49 +36,278 @@ func main() {
 	}
 
 	// Initialize STT Client
-	sttClient, err := intelligence.NewSTTClient(ctx)
+	sttProvider := os.Getenv("STT_PROVIDER")
+	sttBackend, err := intelligence.NewTranscriber(ctx, sttProvider)
 	if err != nil {
 		log.Fatalf("Failed to initialize STT client: %v", err)
 	}
+	defer sttBackend.Close()
+	// Decode audio the provider cannot take natively (FLAC, mu-law, Opus, AAC).
+	ffmpeg := audio.FFmpeg{Path: os.Getenv("FFMPEG_PATH")}
+	if !ffmpeg.Available() {
+		log.Println("ffmpeg not found; Opus and AAC audio is only accepted where the STT provider supports it natively")
+	}
+	sttClient := intelligence.NewTranscodingTranscriber(sttBackend, ffmpeg)
 
-	// Initialize LLM Client
-	llmClient, err := intelligence.NewLLMClient(ctx, projectID, location)
-	if err != nil {
-		log.Fatalf("Failed to initialize LLM client: %v", err)
-	}
-	defer llmClient.Close()
-
-	// Initialize Database
-	dbDSN := fmt.Sprintf("postgres://%s:%s@%s:%s/%s",
-		os.Getenv("DB_USER"),
-		os.Getenv("DB_PASSWORD"),
-		os.Getenv("DB_HOST"),
-		os.Getenv("DB_PORT"),
-		os.Getenv("DB_NAME"),
-	)
-	dbPool, err := db.NewConnection(ctx, dbDSN)
+	// Initialize Entity Extractor
+	ruleExtractor, err := newRuleExtractor()
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		log.Fatalf("Failed to initialize rule-based extractor: %v", err)
+	}
+	var extractor intelligence.EntityExtractor
+	switch mode := os.Getenv("EXTRACTOR"); mode {
+	case "rules":
+		log.Println("Using rule-based entity extraction")
+		extractor = ruleExtractor
+	case "", "llm":
+		llmClient, err := newLLMClient(ctx, projectID, location)
+		if err != nil {
+			log.Fatalf("Failed to initialize LLM client: %v", err)
+		}
+		defer llmClient.Close()
+		extractor = intelligence.NewFallbackExtractor(llmClient, ruleExtractor)
+	default:
+		log.Fatalf("Unknown EXTRACTOR %q (expected \"llm\" or \"rules\")", mode)
 	}
-	defer dbPool.Close()
 
-	// Initialize Repository
-	clinicalRepo := repository.NewClinicalImpressionRepository(dbPool)
+	// Initialize Database and Repository
+	var clinicalRepo repository.ClinicalImpressionRepository
+	var jobRepo repository.TranscriptionJobRepository
+	var vocabularyRepo repository.VocabularyRepository
+	var recordingRepo repository.AudioRecordingRepository
+	switch backend := os.Getenv("REPOSITORY_BACKEND"); backend {
+	case "", "postgres":
+		dbDSN := fmt.Sprintf("postgres://%s:%s@%s:%s/%s",
+			os.Getenv("DB_USER"),
+			os.Getenv("DB_PASSWORD"),
+			os.Getenv("DB_HOST"),
+			os.Getenv("DB_PORT"),
+			os.Getenv("DB_NAME"),
+		)
+		dbPool, err := db.NewConnection(ctx, dbDSN)
+		if err != nil {
+			log.Fatalf("Failed to connect to database: %v", err)
+		}
+		defer dbPool.Close()
+		if err := db.Migrate(ctx, dbPool); err != nil {
+			log.Fatalf("Failed to migrate database: %v", err)
+		}
+		clinicalRepo = repository.NewPostgresRepository(dbPool)
+		jobRepo = repository.NewPostgresJobRepository(dbPool)
+		vocabularyRepo = repository.NewPostgresVocabularyRepository(dbPool)
+		recordingRepo = repository.NewPostgresRecordingRepository(dbPool)
+	case "sqlite":
+		sqlitePath := os.Getenv("SQLITE_PATH")
+		if sqlitePath == "" {
+			sqlitePath = "clinical_agent.db"
+		}
+		sqliteDB, err := db.NewSQLite(ctx, sqlitePath)
+		if err != nil {
+			log.Fatalf("Failed to open SQLite database: %v", err)
+		}
+		defer sqliteDB.Close()
+		clinicalRepo = repository.NewSQLiteRepository(sqliteDB)
+		jobRepo = repository.NewSQLiteJobRepository(sqliteDB)
+		vocabularyRepo = repository.NewSQLiteVocabularyRepository(sqliteDB)
+		recordingRepo = repository.NewSQLiteRecordingRepository(sqliteDB)
+	case "memory":
+		log.Println("Using in-memory repository; impressions will be lost on restart")
+		clinicalRepo = repository.NewMemoryRepository()
+		jobRepo = repository.NewMemoryJobRepository()
+		vocabularyRepo = repository.NewMemoryVocabularyRepository()
+		recordingRepo = repository.NewMemoryRecordingRepository()
+	default:
+		log.Fatalf("Unknown REPOSITORY_BACKEND %q (expected \"postgres\", \"sqlite\" or \"memory\")", backend)
+	}
 
 	// Initialize Ingestion Service
-	ingestionHandler := ingestion.NewHandler(sttClient, llmClient, clinicalRepo)
+	spoolDir := os.Getenv("JOB_SPOOL_DIR")
+	if spoolDir == "" {
+		spoolDir = "data/jobs"
+	}
+	ingestionOpts := []ingestion.Option{
+		ingestion.WithRequireStart(os.Getenv("WS_REQUIRE_START") == "true"),
+		ingestion.WithJobRepository(jobRepo),
+		ingestion.WithJobSpoolDir(spoolDir),
+		ingestion.WithVocabularyRepository(vocabularyRepo),
+		ingestion.WithAdminToken(os.Getenv("ADMIN_TOKEN")),
+	}
+	if dir := os.Getenv("VOCABULARY_DIR"); dir != "" {
+		vocabulary, err := intelligence.LoadVocabularyDir(dir)
+		if err != nil {
+			log.Fatalf("Failed to load vocabulary: %v", err)
+		}
+		log.Printf("Loaded %d phrase sets and %d custom classes from %s", len(vocabulary.PhraseSets), len(vocabulary.CustomClasses), dir)
+		ingestionOpts = append(ingestionOpts, ingestion.WithBaseVocabulary(vocabulary))
+	}
+	if grace := os.Getenv("WS_RESUME_GRACE"); grace != "" {
+		d, err := time.ParseDuration(grace)
+		if err != nil {
+			log.Fatalf("Invalid WS_RESUME_GRACE %q: %v", grace, err)
+		}
+		ingestionOpts = append(ingestionOpts, ingestion.WithResumeGracePeriod(d))
+	}
+	if os.Getenv("VAD_ENABLED") == "true" {
+		var vad audio.VADConfig
+		if v := os.Getenv("VAD_THRESHOLD_DB"); v != "" {
+			if vad.ThresholdDB, err = strconv.ParseFloat(v, 64); err != nil || vad.ThresholdDB >= 0 {
+				log.Fatalf("Invalid VAD_THRESHOLD_DB %q", v)
+			}
+		}
+		if v := os.Getenv("VAD_MAX_SILENCE"); v != "" {
+			if vad.MaxSilence, err = time.ParseDuration(v); err != nil {
+				log.Fatalf("Invalid VAD_MAX_SILENCE %q: %v", v, err)
+			}
+		}
+		ingestionOpts = append(ingestionOpts, ingestion.WithVAD(vad))
+	}
+	if v := os.Getenv("MAX_UPLOAD_SIZE"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			log.Fatalf("Invalid MAX_UPLOAD_SIZE %q", v)
+		}
+		ingestionOpts = append(ingestionOpts, ingestion.WithMaxUploadSize(n))
+	}
+	if dir := os.Getenv("ARCHIVE_DIR"); dir != "" {
+		key, err := archive.ParseKey(os.Getenv("ARCHIVE_KEY"))
+		if err != nil {
+			log.Fatalf("Invalid ARCHIVE_KEY: %v", err)
+		}
+		var retention time.Duration
+		if v := os.Getenv("ARCHIVE_RETENTION"); v != "" {
+			if retention, err = time.ParseDuration(v); err != nil || retention < 0 {
+				log.Fatalf("Invalid ARCHIVE_RETENTION %q", v)
+			}
+		}
+		store, err := archive.NewFileStore(dir)
+		if err != nil {
+			log.Fatalf("Failed to open audio archive: %v", err)
+		}
+		audioArchive, err := archive.New(store, key, recordingRepo, retention)
+		if err != nil {
+			log.Fatalf("Failed to open audio archive: %v", err)
+		}
+		audioArchive.StartRetention(ctx, time.Hour)
+		log.Printf("Archiving audio in %s (retention %s)", dir, retentionString(retention))
+		ingestionOpts = append(ingestionOpts, ingestion.WithArchive(audioArchive))
+	}
+	var taskAttempts int
+	var taskBackoff time.Duration
+	if v := os.Getenv("TASK_MAX_ATTEMPTS"); v != "" {
+		if taskAttempts, err = strconv.Atoi(v); err != nil || taskAttempts < 1 {
+			log.Fatalf("Invalid TASK_MAX_ATTEMPTS %q", v)
+		}
+	}
+	if v := os.Getenv("TASK_RETRY_BACKOFF"); v != "" {
+		if taskBackoff, err = time.ParseDuration(v); err != nil || taskBackoff <= 0 {
+			log.Fatalf("Invalid TASK_RETRY_BACKOFF %q", v)
+		}
+	}
+	ingestionOpts = append(ingestionOpts, ingestion.WithTaskRetries(taskAttempts, taskBackoff))
+	ingestionHandler := ingestion.NewHandler(sttClient, extractor, clinicalRepo, ingestionOpts...)
+
+	// With no workers the server still runs its sessions' tasks, but leaves
+	// uploads and reprocessing to other replicas.
+	jobWorkers := 2
+	if v := os.Getenv("JOB_WORKERS"); v != "" {
+		if jobWorkers, err = strconv.Atoi(v); err != nil || jobWorkers < 0 {
+			log.Fatalf("Invalid JOB_WORKERS %q", v)
+		}
+	}
+	ingestionHandler.StartJobWorkers(ctx, jobWorkers)
+
+	shutdownTimeout := time.Minute
+	if v := os.Getenv("SHUTDOWN_TIMEOUT"); v != "" {
+		if shutdownTimeout, err = time.ParseDuration(v); err != nil || shutdownTimeout <= 0 {
+			log.Fatalf("Invalid SHUTDOWN_TIMEOUT %q", v)
+		}
+	}
 
 	// Register Routes
 	http.HandleFunc("/ws/audio", ingestionHandler.ServeWS)
 	http.HandleFunc("/upload-audio", ingestionHandler.HandleUpload)
+	http.HandleFunc("/uploads", ingestionHandler.HandleCreateUpload)
+	http.HandleFunc("/uploads/{id}", ingestionHandler.HandleResumableUpload)
 	http.HandleFunc("/impressions", ingestionHandler.HandleGetImpressions)
+	http.HandleFunc("/jobs", ingestionHandler.HandleListJobs)
+	http.HandleFunc("/jobs/{id}", ingestionHandler.HandleGetJob)
+	http.HandleFunc("/admin/vocabulary", ingestionHandler.HandleGetVocabulary)
+	http.HandleFunc("/admin/vocabulary/phrase-sets/{name}", ingestionHandler.HandlePhraseSet)
+	http.HandleFunc("/admin/vocabulary/custom-classes/{name}", ingestionHandler.HandleCustomClass)
+	http.HandleFunc("/admin/recordings", ingestionHandler.HandleListRecordings)
+	http.HandleFunc("/admin/recordings/{id}", ingestionHandler.HandleGetRecording)
+	http.HandleFunc("/admin/recordings/{id}/audio", ingestionHandler.HandleRecordingAudio)
+	http.HandleFunc("/admin/reprocess", ingestionHandler.HandleReprocess)
+	http.HandleFunc("/admin/tasks", ingestionHandler.HandleListTasks)
+	http.HandleFunc("/admin/tasks/{id}/retry", ingestionHandler.HandleRetryTask)
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
+	http.HandleFunc("/ready", ingestionHandler.HandleReady)
+
+	server := &http.Server{Addr: ":8080"}
+	go func() {
+		log.Println("Starting Clinical Agent Backend on :8080...")
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	// On SIGTERM, drain sessions and tasks while still answering requests,
+	// so reconnecting clients and job lookups keep working until the load
+	// balancer has noticed /ready failing.
+	signalCtx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, os.Interrupt)
+	<-signalCtx.Done()
+	stop()
+	log.Printf("Shutting down; draining for up to %s", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := ingestionHandler.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Drain incomplete (%v); unfinished tasks stay queued for other servers", err)
+	}
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Server shutdown: %v", err)
+	}
+	log.Println("Server stopped")
+}
+
+// retentionString describes an archive retention period for the log.
+func retentionString(d time.Duration) string {
+	if d == 0 {
+		return "forever"
+	}
+	return d.String()
+}
+
+// newRuleExtractor loads the rule-based extractor from EXTRACTOR_LEXICON_DIR,
+// falling back to the lexicons bundled with the binary.
+func newRuleExtractor() (*intelligence.RuleExtractor, error) {
+	if dir := os.Getenv("EXTRACTOR_LEXICON_DIR"); dir != "" {
+		return intelligence.NewRuleExtractorFromFS(os.DirFS(dir))
+	}
+	return intelligence.NewRuleExtractor()
+}
 
-	log.Println("Starting Clinical Agent Backend on :8080...")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
-		log.Fatalf("Server failed: %v", err)
+// newLLMClient builds the LLM backend selected by LLM_PROVIDER: "gemini"
+// (default) or "openai" for any OpenAI-compatible chat completions server.
+func newLLMClient(ctx context.Context, projectID, location string) (*intelligence.LLMClient, error) {
+	switch provider := os.Getenv("LLM_PROVIDER"); provider {
+	case "", "gemini":
+		return intelligence.NewLLMClient(ctx, projectID, location)
+	case "openai":
+		baseURL := os.Getenv("OPENAI_BASE_URL")
+		if baseURL == "" {
+			baseURL = "https://api.openai.com/v1"
+		}
+		return intelligence.NewOpenAILLMClient(intelligence.OpenAIConfig{
+			BaseURL:    baseURL,
+			Model:      os.Getenv("OPENAI_MODEL"),
+			APIKey:     os.Getenv("OPENAI_API_KEY"),
+			APIVersion: os.Getenv("OPENAI_API_VERSION"),
+			JSONMode:   os.Getenv("OPENAI_JSON_MODE") != "false",
+		})
+	default:
+		return nil, fmt.Errorf("unknown LLM_PROVIDER %q (expected \"gemini\" or \"openai\")", provider)
 	}
 }
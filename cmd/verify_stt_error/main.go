@@ -7,6 +7,8 @@ import (
 	"os/signal"
 	"time"
 
+	"clinical-agent-backend/internal/protocol"
+
 	"github.com/gorilla/websocket"
 )
 
@@ -51,17 +53,22 @@ func main() {
 	}
 	log.Println("Sent 1s of silence")
 
-	// Wait 2 seconds then send EOF
+	// Wait 2 seconds then end the session
 	time.Sleep(2 * time.Second)
 
-	err = c.WriteMessage(websocket.TextMessage, []byte("EOF"))
+	end, err := protocol.Encode(protocol.TypeEnd, 0, nil)
+	if err != nil {
+		log.Println("encode end:", err)
+		return
+	}
+	err = c.WriteMessage(websocket.TextMessage, end)
 	if err != nil {
-		log.Println("write EOF:", err)
+		log.Println("write end:", err)
 		return
 	}
-	log.Println("Sent EOF signal")
+	log.Println("Sent end message")
 
-	// Wait for server to close connection due to EOF (should happen fast)
+	// Wait for server to close connection after end (should happen fast)
 	select {
 	case <-done:
 		log.Println("Client read loop finished (Clean shutdown)")
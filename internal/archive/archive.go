@@ -0,0 +1,295 @@
+// Package archive keeps encrypted copies of the audio notes are extracted
+// from, so a disputed note can be checked against the recording.
+//
+// Each recording is encrypted with its own random data key, which is stored
+// with the recording's metadata after being encrypted with the archive key.
+// Losing the archive key makes every recording unreadable.
+package archive
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"time"
+
+	"clinical-agent-backend/internal/domain"
+	"clinical-agent-backend/internal/repository"
+)
+
+// KeySize is the length of the archive key and of every data key (AES-256).
+const KeySize = 32
+
+// errAborted stops an upload whose recording was abandoned.
+var errAborted = errors.New("recording aborted")
+
+// ParseKey decodes a base64-encoded archive key.
+func ParseKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("archive key is not valid base64: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("archive key must be %d bytes, got %d", KeySize, len(key))
+	}
+	return key, nil
+}
+
+// Archive stores recordings encrypted in a BlobStore and their metadata in
+// a repository.
+type Archive struct {
+	store      BlobStore
+	key        cipher.AEAD
+	recordings repository.AudioRecordingRepository
+	retention  time.Duration
+}
+
+// New creates an archive. Recordings are deleted retention after they are
+// made, once StartRetention is running; zero keeps them forever.
+func New(store BlobStore, key []byte, recordings repository.AudioRecordingRepository, retention time.Duration) (*Archive, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("archive key must be %d bytes, got %d", KeySize, len(key))
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Archive{store: store, key: aead, recordings: recordings, retention: retention}, nil
+}
+
+// objectKey is where a recording's audio is kept in the blob store.
+func objectKey(id string) string {
+	return "recordings/" + id
+}
+
+// Writer encrypts a recording's audio into the archive as it is written.
+// The recording is only stored once Close succeeds.
+type Writer struct {
+	archive *Archive
+	rec     domain.AudioRecording
+	enc     *encrypter
+	pw      *io.PipeWriter
+	stored  chan error
+}
+
+// Create starts archiving a recording. rec needs its ID, source, encounter
+// and audio format; the rest is filled in by Close.
+func (a *Archive) Create(ctx context.Context, rec domain.AudioRecording) (*Writer, error) {
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if rec.DataKey, err = wrapKey(a.key, rec.ID, dataKey); err != nil {
+		return nil, fmt.Errorf("failed to encrypt data key: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+	w := &Writer{archive: a, rec: rec, enc: newEncrypter(aead, pw), pw: pw, stored: make(chan error, 1)}
+	go func() {
+		err := a.store.Put(ctx, objectKey(rec.ID), pr)
+		// Fail further writes if the store gave up early.
+		pr.CloseWithError(err)
+		w.stored <- err
+	}()
+	return w, nil
+}
+
+// Write encrypts p into the archive.
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.enc.Write(p)
+}
+
+// Close finishes the audio and records the recording, returning it.
+func (w *Writer) Close(ctx context.Context) (*domain.AudioRecording, error) {
+	if err := w.enc.Close(); err != nil {
+		w.pw.CloseWithError(err)
+		<-w.stored
+		return nil, fmt.Errorf("failed to archive recording %s: %w", w.rec.ID, err)
+	}
+	w.pw.Close()
+	if err := <-w.stored; err != nil {
+		return nil, fmt.Errorf("failed to archive recording %s: %w", w.rec.ID, err)
+	}
+
+	rec := w.rec
+	rec.Size = w.enc.size
+	rec.SHA256 = hex.EncodeToString(w.enc.hash.Sum(nil))
+	rec.CreatedAt = time.Now().UTC()
+	if w.archive.retention > 0 {
+		rec.ExpiresAt = rec.CreatedAt.Add(w.archive.retention)
+	}
+	if err := w.archive.recordings.Create(ctx, &rec); err != nil {
+		w.archive.store.Delete(ctx, objectKey(rec.ID))
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// Abort discards the recording.
+func (w *Writer) Abort() {
+	w.pw.CloseWithError(errAborted)
+	<-w.stored
+}
+
+// Recording retrieves a recording's metadata, or repository.ErrNotFound.
+func (a *Archive) Recording(ctx context.Context, id string) (*domain.AudioRecording, error) {
+	return a.recordings.FindByID(ctx, id)
+}
+
+// Recordings retrieves the metadata of the recordings matching filter,
+// newest first.
+func (a *Archive) Recordings(ctx context.Context, filter repository.RecordingFilter) ([]*domain.AudioRecording, error) {
+	return a.recordings.Find(ctx, filter)
+}
+
+// Reader reads a recording's decrypted audio. It supports Seek, so it can
+// serve HTTP range requests.
+type Reader struct {
+	*io.SectionReader
+	blob Blob
+}
+
+// Close releases the underlying object.
+func (r *Reader) Close() error {
+	return r.blob.Close()
+}
+
+// Open returns a recording's metadata and a reader for its audio. A missing
+// recording is repository.ErrNotFound.
+func (a *Archive) Open(ctx context.Context, id string) (*domain.AudioRecording, *Reader, error) {
+	rec, err := a.recordings.FindByID(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	dataKey, err := unwrapKey(a.key, rec.ID, rec.DataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	blob, err := a.store.Open(ctx, objectKey(rec.ID))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open audio of recording %s: %w", rec.ID, err)
+	}
+	dec, err := newDecrypter(aead, blob, rec.Size)
+	if err != nil {
+		blob.Close()
+		return nil, nil, fmt.Errorf("recording %s: %w", rec.ID, err)
+	}
+	return rec, &Reader{SectionReader: io.NewSectionReader(dec, 0, rec.Size), blob: blob}, nil
+}
+
+// Stream returns a reader for a recording's audio and its size. Reading
+// to the end checks the audio against the SHA-256 recorded when it was
+// stored; a mismatch fails the last read with ErrCorrupt.
+func (a *Archive) Stream(ctx context.Context, id string) (io.ReadCloser, int64, error) {
+	rec, r, err := a.Open(ctx, id)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &verifyingReader{r: r, hash: sha256.New(), id: rec.ID, want: rec.SHA256}, rec.Size, nil
+}
+
+// Audio reads a recording's whole audio, checking it against the SHA-256
+// recorded when it was stored.
+func (a *Archive) Audio(ctx context.Context, id string) ([]byte, error) {
+	r, _, err := a.Stream(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recording %s: %w", id, err)
+	}
+	return data, nil
+}
+
+// verifyingReader hashes a recording as it is read and compares the sum
+// with the stored one at EOF.
+type verifyingReader struct {
+	r    *Reader
+	hash hash.Hash
+	id   string
+	want string
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(v.hash.Sum(nil)) != v.want {
+		return n, fmt.Errorf("recording %s does not match its SHA-256: %w", v.id, ErrCorrupt)
+	}
+	return n, err
+}
+
+func (v *verifyingReader) Close() error {
+	return v.r.Close()
+}
+
+// Delete removes a recording and its audio.
+func (a *Archive) Delete(ctx context.Context, id string) error {
+	if err := a.store.Delete(ctx, objectKey(id)); err != nil {
+		return err
+	}
+	return a.recordings.Delete(ctx, id)
+}
+
+// DeleteExpired removes the recordings whose retention ran out by now,
+// returning how many were deleted.
+func (a *Archive) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	expired, err := a.recordings.Find(ctx, repository.RecordingFilter{ExpiredBy: now})
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	var errs []error
+	for _, rec := range expired {
+		if err := a.Delete(ctx, rec.ID); err != nil && !errors.Is(err, repository.ErrNotFound) {
+			errs = append(errs, fmt.Errorf("recording %s: %w", rec.ID, err))
+			continue
+		}
+		deleted++
+	}
+	return deleted, errors.Join(errs...)
+}
+
+// StartRetention deletes expired recordings now and then every interval
+// until ctx is cancelled.
+func (a *Archive) StartRetention(ctx context.Context, interval time.Duration) {
+	sweep := func() {
+		n, err := a.DeleteExpired(ctx, time.Now())
+		if n > 0 {
+			log.Printf("Deleted %d archived recordings past their retention", n)
+		}
+		if err != nil {
+			log.Printf("Failed to delete expired recordings: %v", err)
+		}
+	}
+	go func() {
+		sweep()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				sweep()
+			}
+		}
+	}()
+}
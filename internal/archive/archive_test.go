@@ -0,0 +1,182 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"clinical-agent-backend/internal/domain"
+	"clinical-agent-backend/internal/repository"
+)
+
+func newTestArchive(t *testing.T, retention time.Duration) (*Archive, string) {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v", err)
+	}
+	a, err := New(store, bytes.Repeat([]byte{7}, KeySize), repository.NewMemoryRecordingRepository(), retention)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return a, dir
+}
+
+// archiveAudio stores data as recording id, writing it in uneven pieces.
+func archiveAudio(t *testing.T, a *Archive, id string, data []byte) *domain.AudioRecording {
+	t.Helper()
+	ctx := context.Background()
+	w, err := a.Create(ctx, domain.AudioRecording{ID: id, Source: domain.RecordingFromSession})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	for rest := data; len(rest) > 0; {
+		n := min(len(rest), 1000+rand.IntN(9000))
+		if _, err := w.Write(rest[:n]); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		rest = rest[n:]
+	}
+	rec, err := w.Close(ctx)
+	if err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	return rec
+}
+
+func TestArchive_RoundTrip(t *testing.T) {
+	a, dir := newTestArchive(t, 0)
+	ctx := context.Background()
+
+	for _, size := range []int{0, 1, chunkSize, 2*chunkSize + 5} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(rand.IntN(256))
+		}
+		id := fmt.Sprintf("rec-%d", size)
+		rec := archiveAudio(t, a, id, data)
+		sum := sha256.Sum256(data)
+		if rec.Size != int64(size) || rec.SHA256 != hex.EncodeToString(sum[:]) || !rec.ExpiresAt.IsZero() {
+			t.Errorf("size %d: unexpected recording %+v", size, rec)
+		}
+
+		stored, err := os.ReadFile(filepath.Join(dir, "recordings", id))
+		if err != nil {
+			t.Fatalf("failed to read stored object: %v", err)
+		}
+		if size > 16 && bytes.Contains(stored, data[:16]) {
+			t.Errorf("size %d: stored object contains plaintext", size)
+		}
+
+		_, r, err := a.Open(ctx, id)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("size %d: read %d bytes back (%v)", size, len(got), err)
+		}
+		if size > 10 {
+			// A range across the chunk boundary.
+			off := min(int64(size-10), chunkSize-3)
+			part := make([]byte, 10)
+			if _, err := r.ReadAt(part, off); err != nil || !bytes.Equal(part, data[off:off+10]) {
+				t.Errorf("size %d: unexpected range at %d (%v)", size, off, err)
+			}
+		}
+		r.Close()
+
+		if got, err := a.Audio(ctx, id); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("size %d: Audio returned %d bytes (%v)", size, len(got), err)
+		}
+		if sr, n, err := a.Stream(ctx, id); err != nil || n != int64(size) {
+			t.Errorf("size %d: Stream reported %d bytes (%v)", size, n, err)
+		} else if got, err := io.ReadAll(sr); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("size %d: Stream read %d bytes (%v)", size, len(got), err)
+		} else {
+			sr.Close()
+		}
+	}
+}
+
+func TestArchive_DetectsTampering(t *testing.T) {
+	a, dir := newTestArchive(t, 0)
+	ctx := context.Background()
+	archiveAudio(t, a, "rec", bytes.Repeat([]byte("audio"), chunkSize/2))
+	path := filepath.Join(dir, "recordings", "rec")
+	stored, _ := os.ReadFile(path)
+
+	stored[sealedSize+10] ^= 1
+	os.WriteFile(path, stored, 0o600)
+	_, r, err := a.Open(ctx, "rec")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, err := r.ReadAt(make([]byte, 10), 10); err != nil {
+		t.Errorf("expected the untouched chunk to read, got %v", err)
+	}
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected ErrCorrupt for an altered chunk, got %v", err)
+	}
+	r.Close()
+
+	os.WriteFile(path, stored[:sealedSize], 0o600)
+	if _, _, err := a.Open(ctx, "rec"); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected ErrCorrupt for a truncated object, got %v", err)
+	}
+
+	other, _ := New(a.store, bytes.Repeat([]byte{8}, KeySize), a.recordings, 0)
+	if _, _, err := other.Open(ctx, "rec"); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected ErrCorrupt with the wrong archive key, got %v", err)
+	}
+}
+
+func TestArchive_Abort(t *testing.T) {
+	a, dir := newTestArchive(t, 0)
+	ctx := context.Background()
+	w, err := a.Create(ctx, domain.AudioRecording{ID: "rec"})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	w.Write(make([]byte, 3*chunkSize))
+	w.Abort()
+
+	if _, err := a.Recording(ctx, "rec"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected no recording, got %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "recordings")); len(entries) != 0 {
+		t.Errorf("expected no stored objects, got %v", entries)
+	}
+}
+
+func TestArchive_DeleteExpired(t *testing.T) {
+	a, dir := newTestArchive(t, time.Hour)
+	ctx := context.Background()
+	rec := archiveAudio(t, a, "rec", []byte("audio"))
+	if !rec.ExpiresAt.Equal(rec.CreatedAt.Add(time.Hour)) {
+		t.Errorf("expected the recording to expire after an hour, got %+v", rec)
+	}
+
+	if n, err := a.DeleteExpired(ctx, time.Now()); n != 0 || err != nil {
+		t.Errorf("expected nothing to expire yet, deleted %d (%v)", n, err)
+	}
+	if n, err := a.DeleteExpired(ctx, time.Now().Add(2*time.Hour)); n != 1 || err != nil {
+		t.Errorf("expected one recording to expire, deleted %d (%v)", n, err)
+	}
+	if _, err := a.Recording(ctx, "rec"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected the recording to be deleted, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "recordings", "rec")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the audio to be deleted, got %v", err)
+	}
+}
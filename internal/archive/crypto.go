@@ -0,0 +1,189 @@
+package archive
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+)
+
+// Objects are encrypted in chunks so any byte range can be decrypted
+// without reading the rest: each chunk of plaintext is sealed on its own
+// with AES-256-GCM under the recording's data key.
+const (
+	chunkSize  = 64 << 10
+	tagSize    = 16
+	sealedSize = chunkSize + tagSize
+)
+
+// ErrCorrupt is returned when archived audio fails to decrypt: the object
+// was truncated, altered or belongs to another recording.
+var ErrCorrupt = errors.New("archived audio failed its integrity check")
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce returns the nonce for chunk n. Every recording has its own
+// data key, so counting chunks never repeats a nonce under a key.
+func chunkNonce(n int64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], uint64(n))
+	return nonce
+}
+
+// chunkData authenticates whether a chunk is the last, so an object cut at
+// a chunk boundary does not decrypt.
+func chunkData(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// chunkCount returns how many chunks hold size bytes of plaintext. Empty
+// audio still has one, empty, final chunk.
+func chunkCount(size int64) int64 {
+	return max(1, (size+chunkSize-1)/chunkSize)
+}
+
+// wrapKey encrypts a data key with the archive key. The recording ID is
+// authenticated with it, so a key cannot be moved to another recording.
+func wrapKey(archiveKey cipher.AEAD, id string, dataKey []byte) ([]byte, error) {
+	nonce := make([]byte, archiveKey.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return archiveKey.Seal(nonce, nonce, dataKey, []byte(id)), nil
+}
+
+// unwrapKey decrypts a data key wrapped by wrapKey.
+func unwrapKey(archiveKey cipher.AEAD, id string, wrapped []byte) ([]byte, error) {
+	n := archiveKey.NonceSize()
+	if len(wrapped) < n {
+		return nil, ErrCorrupt
+	}
+	key, err := archiveKey.Open(nil, wrapped[:n], wrapped[n:], []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the key of recording %s (wrong archive key?): %w", id, ErrCorrupt)
+	}
+	return key, nil
+}
+
+// encrypter seals plaintext into chunks written to w, hashing it as it
+// goes.
+type encrypter struct {
+	aead cipher.AEAD
+	w    io.Writer
+	buf  []byte
+	out  []byte
+	n    int64
+	size int64
+	hash hash.Hash
+}
+
+func newEncrypter(aead cipher.AEAD, w io.Writer) *encrypter {
+	return &encrypter{
+		aead: aead,
+		w:    w,
+		buf:  make([]byte, 0, 2*chunkSize),
+		out:  make([]byte, 0, sealedSize),
+		hash: sha256.New(),
+	}
+}
+
+// Write buffers p, sealing every complete chunk that more data follows.
+func (e *encrypter) Write(p []byte) (int, error) {
+	e.hash.Write(p)
+	e.size += int64(len(p))
+	e.buf = append(e.buf, p...)
+	for len(e.buf) > chunkSize {
+		if err := e.seal(e.buf[:chunkSize], false); err != nil {
+			return 0, err
+		}
+		e.buf = e.buf[:copy(e.buf, e.buf[chunkSize:])]
+	}
+	return len(p), nil
+}
+
+// Close seals the final chunk.
+func (e *encrypter) Close() error {
+	return e.seal(e.buf, true)
+}
+
+func (e *encrypter) seal(chunk []byte, last bool) error {
+	e.out = e.aead.Seal(e.out[:0], chunkNonce(e.n), chunk, chunkData(last))
+	e.n++
+	_, err := e.w.Write(e.out)
+	return err
+}
+
+// decrypter reads the plaintext of an object at any offset, decrypting
+// only the chunks the read touches.
+type decrypter struct {
+	aead cipher.AEAD
+	blob Blob
+	size int64
+
+	mu     sync.Mutex
+	cached int64
+	plain  []byte
+	sealed []byte
+}
+
+func newDecrypter(aead cipher.AEAD, blob Blob, size int64) (*decrypter, error) {
+	if want := size + chunkCount(size)*tagSize; blob.Size() != want {
+		return nil, fmt.Errorf("object is %d bytes, expected %d: %w", blob.Size(), want, ErrCorrupt)
+	}
+	return &decrypter{aead: aead, blob: blob, size: size, cached: -1, sealed: make([]byte, sealedSize)}, nil
+}
+
+// ReadAt implements io.ReaderAt over the plaintext.
+func (d *decrypter) ReadAt(p []byte, off int64) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := 0
+	for n < len(p) && off < d.size {
+		i := off / chunkSize
+		chunk, err := d.chunk(i)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p[n:], chunk[off-i*chunkSize:])
+		n += c
+		off += int64(c)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// chunk returns the plaintext of chunk i, keeping the last one decrypted
+// for sequential reads.
+func (d *decrypter) chunk(i int64) ([]byte, error) {
+	if i == d.cached {
+		return d.plain, nil
+	}
+	sealed := d.sealed[:min(chunkSize, d.size-i*chunkSize)+tagSize]
+	if _, err := d.blob.ReadAt(sealed, i*sealedSize); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read archived audio: %w", err)
+	}
+	plain, err := d.aead.Open(d.plain[:0], chunkNonce(i), sealed, chunkData(i == chunkCount(d.size)-1))
+	if err != nil {
+		d.cached = -1
+		return nil, fmt.Errorf("chunk %d: %w", i, ErrCorrupt)
+	}
+	d.plain, d.cached = plain, i
+	return plain, nil
+}
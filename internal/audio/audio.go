@@ -0,0 +1,85 @@
+// Package audio decodes and resamples incoming audio into the 16-bit
+// little-endian PCM that speech providers accept universally.
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// Container identifies an audio file or stream format from its leading bytes.
+type Container string
+
+const (
+	ContainerUnknown Container = ""
+	ContainerWAV     Container = "wav"
+	ContainerFLAC    Container = "flac"
+	ContainerOgg     Container = "ogg"
+	ContainerWebM    Container = "webm"
+	ContainerMP4     Container = "mp4"
+)
+
+// SniffLen is the number of leading bytes Sniff needs to recognize every
+// supported container.
+const SniffLen = 12
+
+var ebmlMagic = []byte{0x1A, 0x45, 0xDF, 0xA3}
+
+// Sniff recognizes a container from the first SniffLen bytes of a stream.
+// Raw codecs such as PCM and mu-law have no signature and are reported as
+// ContainerUnknown.
+func Sniff(header []byte) Container {
+	switch {
+	case len(header) >= 12 && bytes.Equal(header[0:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WAVE")):
+		return ContainerWAV
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		return ContainerFLAC
+	case bytes.HasPrefix(header, []byte("OggS")):
+		return ContainerOgg
+	case bytes.HasPrefix(header, ebmlMagic):
+		return ContainerWebM
+	case len(header) >= 8 && bytes.Equal(header[4:8], []byte("ftyp")):
+		return ContainerMP4
+	}
+	return ContainerUnknown
+}
+
+// Format describes 16-bit little-endian interleaved PCM.
+type Format struct {
+	SampleRate int
+	Channels   int
+}
+
+// frameSize is the number of bytes per sample frame (one sample for every
+// channel).
+func (f Format) frameSize() int {
+	return 2 * f.Channels
+}
+
+// putSample appends a 16-bit little-endian sample to buf.
+func putSample(buf []byte, s int16) []byte {
+	return binary.LittleEndian.AppendUint16(buf, uint16(s))
+}
+
+// bufferedReader serves bytes produced a block at a time by fill, which
+// returns io.EOF once the source is exhausted.
+type bufferedReader struct {
+	buf  []byte
+	fill func(buf []byte) ([]byte, error)
+	err  error
+}
+
+func (r *bufferedReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.buf, r.err = r.fill(r.buf[:0])
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+var _ io.Reader = (*bufferedReader)(nil)
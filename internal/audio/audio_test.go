@@ -0,0 +1,178 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/frame"
+	"github.com/mewkiz/flac/meta"
+)
+
+func samples(t *testing.T, r io.Reader) []int16 {
+	t.Helper()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	out := make([]int16, len(data)/2)
+	binary.Read(bytes.NewReader(data), binary.LittleEndian, out)
+	return out
+}
+
+func pcm(s ...int16) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, s)
+	return buf.Bytes()
+}
+
+func TestSniff(t *testing.T) {
+	tests := map[string]Container{
+		"RIFF\x24\x00\x00\x00WAVEfmt ": ContainerWAV,
+		"fLaC\x00\x00\x00\x22":         ContainerFLAC,
+		"OggS\x00\x02":                 ContainerOgg,
+		"\x1a\x45\xdf\xa3\x9f\x42":     ContainerWebM,
+		"\x00\x00\x00\x20ftypM4A ":     ContainerMP4,
+		"\x00\x01\x02\x03":             ContainerUnknown,
+		"":                             ContainerUnknown,
+	}
+	for header, want := range tests {
+		if got := Sniff([]byte(header)); got != want {
+			t.Errorf("Sniff(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestDecodeMuLaw(t *testing.T) {
+	got := samples(t, DecodeMuLaw(bytes.NewReader([]byte{0xFF, 0x7F, 0x00, 0x80})))
+	want := []int16{0, 0, -32124, 32124}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestResample(t *testing.T) {
+	up := samples(t, Resample(bytes.NewReader(pcm(0, 100, 200)), Format{SampleRate: 8000, Channels: 1}, 16000))
+	if want := []int16{0, 50, 100, 150, 200}; !slices.Equal(up, want) {
+		t.Errorf("upsampled: got %v, want %v", up, want)
+	}
+
+	stereo := samples(t, Resample(bytes.NewReader(pcm(1000, -1000, 1000, -1000, 1000, -1000, 1000, -1000)), Format{SampleRate: 32000, Channels: 2}, 16000))
+	if want := []int16{1000, -1000, 1000, -1000}; !slices.Equal(stereo, want) {
+		t.Errorf("downsampled stereo: got %v, want %v", stereo, want)
+	}
+
+	// One second at 44.1 kHz becomes one second at 16 kHz.
+	long := samples(t, Resample(bytes.NewReader(make([]byte, 2*44100)), Format{SampleRate: 44100, Channels: 1}, 16000))
+	if len(long) < 15999 || len(long) > 16001 {
+		t.Errorf("expected ~16000 samples, got %d", len(long))
+	}
+}
+
+// sine returns a second of a sine wave of freq Hz at rate, with peak
+// amplitude 10000.
+func sine(freq float64, rate int) []byte {
+	s := make([]int16, rate)
+	for i := range s {
+		s[i] = int16(10000 * math.Sin(2*math.Pi*freq*float64(i)/float64(rate)))
+	}
+	return pcm(s...)
+}
+
+// rms returns the root mean square of s, leaving out its first and last
+// tenth where the filter meets the ends of the audio.
+func rms(s []int16) float64 {
+	s = s[len(s)/10 : len(s)-len(s)/10]
+	var sum float64
+	for _, v := range s {
+		sum += float64(v) * float64(v)
+	}
+	return math.Sqrt(sum / float64(len(s)))
+}
+
+func TestResample_FiltersAboveNyquist(t *testing.T) {
+	for _, rate := range []int{48000, 44100} {
+		// A 10 kHz tone cannot be represented at 16 kHz; unfiltered it
+		// would fold back to 6 kHz at full strength.
+		high := samples(t, Resample(bytes.NewReader(sine(10000, rate)), Format{SampleRate: rate, Channels: 1}, 16000))
+		if got := rms(high); got > 10000/math.Sqrt2/100 {
+			t.Errorf("%d Hz: expected a 10 kHz tone attenuated by 40 dB, got RMS %.0f", rate, got)
+		}
+
+		speech := samples(t, Resample(bytes.NewReader(sine(1000, rate)), Format{SampleRate: rate, Channels: 1}, 16000))
+		if got := rms(speech); math.Abs(got-10000/math.Sqrt2) > 200 {
+			t.Errorf("%d Hz: expected a 1 kHz tone to pass, got RMS %.0f", rate, got)
+		}
+		if len(speech) < 15999 || len(speech) > 16001 {
+			t.Errorf("%d Hz: expected ~16000 samples, got %d", rate, len(speech))
+		}
+	}
+}
+
+func TestDecodeFLAC(t *testing.T) {
+	want := []int16{0, 1000, -1000, 32767, -32768, 42}
+
+	var encoded bytes.Buffer
+	info := &meta.StreamInfo{
+		BlockSizeMin:  16,
+		BlockSizeMax:  16,
+		SampleRate:    8000,
+		NChannels:     1,
+		BitsPerSample: 16,
+		NSamples:      uint64(len(want)),
+	}
+	enc, err := flac.NewEncoder(&encoded, info)
+	if err != nil {
+		t.Fatalf("NewEncoder failed: %v", err)
+	}
+	sub := &frame.Subframe{SubHeader: frame.SubHeader{Pred: frame.PredVerbatim}, NSamples: len(want)}
+	for _, s := range want {
+		sub.Samples = append(sub.Samples, int32(s))
+	}
+	if err := enc.WriteFrame(&frame.Frame{
+		Header: frame.Header{
+			HasFixedBlockSize: true,
+			BlockSize:         uint16(len(want)),
+			SampleRate:        8000,
+			Channels:          frame.ChannelsMono,
+			BitsPerSample:     16,
+		},
+		Subframes: []*frame.Subframe{sub},
+	}); err != nil {
+		t.Fatalf("WriteFrame failed: %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	r, format, err := DecodeFLAC(&encoded)
+	if err != nil {
+		t.Fatalf("DecodeFLAC failed: %v", err)
+	}
+	if format != (Format{SampleRate: 8000, Channels: 1}) {
+		t.Errorf("unexpected format %+v", format)
+	}
+	if got := samples(t, r); !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDecodeFLAC_Invalid(t *testing.T) {
+	if _, _, err := DecodeFLAC(bytes.NewReader([]byte("not flac"))); err == nil {
+		t.Error("expected an error for non-FLAC input")
+	}
+}
+
+func TestFFmpeg_Missing(t *testing.T) {
+	f := FFmpeg{Path: "/nonexistent/ffmpeg"}
+	if f.Available() {
+		t.Fatal("expected ffmpeg to be unavailable")
+	}
+	if _, err := f.Decode(t.Context(), bytes.NewReader(nil), Format{SampleRate: 16000, Channels: 1}); err != ErrNoFFmpeg {
+		t.Errorf("expected ErrNoFFmpeg, got %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ErrNoFFmpeg is returned when a codec needs ffmpeg and it is not installed.
+var ErrNoFFmpeg = errors.New("ffmpeg is required to decode this audio but was not found")
+
+// FFmpeg decodes codecs with no pure-Go decoder (Opus, AAC) by piping the
+// audio through an ffmpeg process.
+type FFmpeg struct {
+	// Path is the ffmpeg binary. Empty means "ffmpeg" on $PATH.
+	Path string
+}
+
+// Available reports whether the ffmpeg binary can be found.
+func (f FFmpeg) Available() bool {
+	_, err := exec.LookPath(f.path())
+	return err == nil
+}
+
+func (f FFmpeg) path() string {
+	if f.Path == "" {
+		return "ffmpeg"
+	}
+	return f.Path
+}
+
+// Decode starts ffmpeg reading any container it understands from r and
+// returns its output as 16-bit PCM in format out. The process is killed when
+// ctx is cancelled or the returned reader is closed; decoding errors are
+// reported by Read once the output ends.
+func (f FFmpeg) Decode(ctx context.Context, r io.Reader, out Format) (io.ReadCloser, error) {
+	path, err := exec.LookPath(f.path())
+	if err != nil {
+		return nil, ErrNoFFmpeg
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	cmd := exec.CommandContext(ctx, path,
+		"-hide_banner", "-loglevel", "error",
+		"-i", "pipe:0",
+		"-f", "s16le",
+		"-ac", strconv.Itoa(out.Channels),
+		"-ar", strconv.Itoa(out.SampleRate),
+		"pipe:1",
+	)
+	cmd.Stdin = r
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("starting ffmpeg: %w", err)
+	}
+	return &ffmpegReader{stdout: stdout, cmd: cmd, cancel: cancel, stderr: &stderr}, nil
+}
+
+type ffmpegReader struct {
+	stdout io.Reader
+	cmd    *exec.Cmd
+	cancel context.CancelFunc
+	stderr *bytes.Buffer
+	done   bool
+}
+
+func (r *ffmpegReader) Read(p []byte) (int, error) {
+	n, err := r.stdout.Read(p)
+	if err == io.EOF && !r.done {
+		r.done = true
+		if werr := r.cmd.Wait(); werr != nil {
+			return n, fmt.Errorf("ffmpeg: %v: %s", werr, strings.TrimSpace(r.stderr.String()))
+		}
+	}
+	return n, err
+}
+
+func (r *ffmpegReader) Close() error {
+	r.cancel()
+	if !r.done {
+		r.done = true
+		r.cmd.Wait()
+	}
+	return nil
+}
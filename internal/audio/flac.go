@@ -0,0 +1,44 @@
+package audio
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/mewkiz/flac"
+)
+
+// DecodeFLAC decodes a FLAC stream from r into 16-bit PCM, frame by frame,
+// and returns the stream's format. Samples deeper than 16 bits are truncated.
+func DecodeFLAC(r io.Reader) (io.Reader, Format, error) {
+	stream, err := flac.New(r)
+	if err != nil {
+		return nil, Format{}, fmt.Errorf("invalid FLAC stream: %w", err)
+	}
+	format := Format{
+		SampleRate: int(stream.Info.SampleRate),
+		Channels:   int(stream.Info.NChannels),
+	}
+	shift := int(stream.Info.BitsPerSample) - 16
+
+	return &bufferedReader{fill: func(buf []byte) ([]byte, error) {
+		frame, err := stream.ParseNext()
+		if err != nil {
+			if err == io.EOF {
+				return buf, io.EOF
+			}
+			return buf, fmt.Errorf("decoding FLAC frame: %w", err)
+		}
+		for i := 0; i < int(frame.BlockSize); i++ {
+			for _, sub := range frame.Subframes {
+				s := sub.Samples[i]
+				if shift > 0 {
+					s >>= shift
+				} else if shift < 0 {
+					s <<= -shift
+				}
+				buf = putSample(buf, int16(s))
+			}
+		}
+		return buf, nil
+	}}, format, nil
+}
@@ -0,0 +1,33 @@
+package audio
+
+import "io"
+
+// muLawTable maps every G.711 mu-law byte to its linear 16-bit sample.
+var muLawTable = func() [256]int16 {
+	var table [256]int16
+	for i := range table {
+		u := ^byte(i)
+		sign := u & 0x80
+		exponent := (u >> 4) & 0x07
+		mantissa := u & 0x0F
+		sample := ((int16(mantissa) << 3) + 0x84) << exponent
+		sample -= 0x84
+		if sign != 0 {
+			sample = -sample
+		}
+		table[i] = sample
+	}
+	return table
+}()
+
+// DecodeMuLaw expands 8-bit G.711 mu-law audio from r into 16-bit PCM.
+func DecodeMuLaw(r io.Reader) io.Reader {
+	in := make([]byte, 4096)
+	return &bufferedReader{fill: func(buf []byte) ([]byte, error) {
+		n, err := r.Read(in)
+		for _, b := range in[:n] {
+			buf = putSample(buf, muLawTable[b])
+		}
+		return buf, err
+	}}
+}
@@ -0,0 +1,157 @@
+package audio
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// Resample converts 16-bit PCM in format from to the sample rate to, using
+// linear interpolation between neighbouring sample frames. When the rate
+// goes down the audio is first low-pass filtered below the new Nyquist
+// frequency, so higher frequencies are removed rather than folded back
+// into the speech band. Channels are kept. It returns r unchanged when the
+// rates already match.
+func Resample(r io.Reader, from Format, to int) io.Reader {
+	if from.SampleRate == to {
+		return r
+	}
+	if to < from.SampleRate {
+		r = lowPass(r, from, float64(to))
+	}
+	step := float64(from.SampleRate) / float64(to)
+	size := from.frameSize()
+	in := make([]byte, 4096*size)
+	pending := 0 // bytes of a partial frame carried over in in
+
+	// prev and cur are consecutive source frames; pos is the position of
+	// the next output frame between them (0 at prev, 1 at cur).
+	prev := make([]int16, from.Channels)
+	cur := make([]int16, from.Channels)
+	var pos float64
+	primed := false
+
+	return &bufferedReader{fill: func(buf []byte) ([]byte, error) {
+		n, err := io.ReadAtLeast(r, in[pending:], 1)
+		n += pending
+		whole := n - n%size
+		for off := 0; off < whole; off += size {
+			copy(prev, cur)
+			for c := range cur {
+				cur[c] = int16(binary.LittleEndian.Uint16(in[off+2*c:]))
+			}
+			if !primed {
+				// Start on the first source frame.
+				copy(prev, cur)
+				primed = true
+				pos = 1
+			}
+			for ; pos <= 1; pos += step {
+				for c := range cur {
+					s := float64(prev[c]) + (float64(cur[c])-float64(prev[c]))*pos
+					buf = putSample(buf, int16(s))
+				}
+			}
+			pos--
+		}
+		pending = copy(in, in[whole:n])
+		return buf, err
+	}}
+}
+
+// lowPass filters 16-bit PCM in format for resampling to rate, removing
+// what lies above rate's Nyquist frequency. Output frame i matches input
+// frame i: the filter's delay is taken out, and the audio is extended at
+// both ends with its first and last frames.
+func lowPass(r io.Reader, format Format, rate float64) io.Reader {
+	taps := lowPassTaps(float64(format.SampleRate), rate)
+	delay := len(taps) / 2
+	size := format.frameSize()
+	in := make([]byte, 4096*size)
+	pending := 0 // bytes of a partial frame carried over in in
+
+	// history holds the last len(taps) frames of each channel in a ring
+	// starting at head; skip counts the frames to push before the filter
+	// is centred on the first one.
+	history := make([][]float64, format.Channels)
+	for c := range history {
+		history[c] = make([]float64, len(taps))
+	}
+	head, skip := 0, 2*delay
+	last := make([]float64, format.Channels)
+	primed := false
+
+	push := func(buf []byte, frame []float64) []byte {
+		for c := range history {
+			history[c][head] = frame[c]
+		}
+		head = (head + 1) % len(taps)
+		if skip > 0 {
+			skip--
+			return buf
+		}
+		for c := range history {
+			var s float64
+			for k, tap := range taps {
+				s += tap * history[c][(head+k)%len(taps)]
+			}
+			buf = putSample(buf, int16(max(min(math.Round(s), math.MaxInt16), math.MinInt16)))
+		}
+		return buf
+	}
+
+	return &bufferedReader{fill: func(buf []byte) ([]byte, error) {
+		n, err := io.ReadAtLeast(r, in[pending:], 1)
+		n += pending
+		whole := n - n%size
+		for off := 0; off < whole; off += size {
+			for c := range last {
+				last[c] = float64(int16(binary.LittleEndian.Uint16(in[off+2*c:])))
+			}
+			if !primed {
+				// Extend the audio back with its first frame.
+				for range delay {
+					buf = push(buf, last)
+				}
+				primed = true
+			}
+			buf = push(buf, last)
+		}
+		pending = copy(in, in[whole:n])
+		if err == io.EOF && primed {
+			// Extend the audio forward with its last frame to flush the
+			// delayed frames.
+			for range delay {
+				buf = push(buf, last)
+			}
+		}
+		return buf, err
+	}}
+}
+
+// lowPassTaps returns a Blackman-windowed sinc filter for audio at rate
+// from that passes what lies well below to's Nyquist frequency and stops
+// (by about 70 dB) what lies above it. It has an odd number of taps,
+// summing to 1.
+func lowPassTaps(from, to float64) []float64 {
+	// The transition band runs from 0.4 to 0.5 of to. With n taps, a
+	// Blackman window's transition is about 5.5/n of from wide.
+	cutoff := 0.45 * to / from
+	n := int(math.Ceil(5.5*from/(0.1*to))) | 1
+	taps := make([]float64, n)
+	var sum float64
+	for i := range taps {
+		x := float64(i - n/2)
+		sinc := 2 * cutoff
+		if x != 0 {
+			sinc = math.Sin(2*math.Pi*cutoff*x) / (math.Pi * x)
+		}
+		w := 0.42 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n-1)) + 0.08*math.Cos(4*math.Pi*float64(i)/float64(n-1))
+		taps[i] = sinc * w
+		sum += taps[i]
+	}
+	for i := range taps {
+		taps[i] /= sum
+	}
+	return taps
+}
@@ -0,0 +1,278 @@
+package audio
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// vadFrame is the length of the frames a VAD classifies.
+const vadFrame = 20 * time.Millisecond
+
+// VADConfig tunes voice activity detection. Zero fields take the defaults
+// noted.
+type VADConfig struct {
+	// ThresholdDB is the frame energy, in dBFS, at or above which a frame
+	// counts as speech (default -45).
+	ThresholdDB float64
+	// MinSpeech is how long speech must last to start an utterance
+	// (default 100ms), so clicks and bumps are ignored.
+	MinSpeech time.Duration
+	// Hangover is how long silence must last to end an utterance
+	// (default 600ms).
+	Hangover time.Duration
+	// MaxSilence is how much silence after an utterance is still forwarded
+	// (default 2s); the rest is dropped. A negative value forwards all audio.
+	MaxSilence time.Duration
+	// PreRoll is how much dropped audio before an utterance is forwarded
+	// once it starts (default 300ms), so soft onsets are kept.
+	PreRoll time.Duration
+	// KeepAlive forwards one frame this often while dropping silence
+	// (default 5s), so the provider's stream does not time out.
+	KeepAlive time.Duration
+}
+
+// withDefaults fills unset fields.
+func (c VADConfig) withDefaults() VADConfig {
+	if c.ThresholdDB == 0 {
+		c.ThresholdDB = -45
+	}
+	if c.MinSpeech == 0 {
+		c.MinSpeech = 100 * time.Millisecond
+	}
+	if c.Hangover == 0 {
+		c.Hangover = 600 * time.Millisecond
+	}
+	if c.MaxSilence == 0 {
+		c.MaxSilence = 2 * time.Second
+	}
+	if c.PreRoll == 0 {
+		c.PreRoll = 300 * time.Millisecond
+	}
+	if c.KeepAlive == 0 {
+		c.KeepAlive = 5 * time.Second
+	}
+	return c
+}
+
+// VADEventType distinguishes the start and end of an utterance.
+type VADEventType int
+
+const (
+	SpeechStart VADEventType = iota
+	SpeechEnd
+)
+
+// VADEvent marks an utterance boundary. At is the offset from the start of
+// the audio given to the VAD.
+type VADEvent struct {
+	Type VADEventType
+	At   time.Duration
+}
+
+// vadGap records audio dropped before the frame forwarded at sent.
+type vadGap struct {
+	sent    int64 // frames forwarded before the gap
+	dropped int64 // frames dropped up to and including this gap
+}
+
+// VAD detects speech in raw audio by frame energy and drops long silences
+// so they are not sent to the speech provider. It is not safe for
+// concurrent use, except for ReceivedOffset.
+type VAD struct {
+	cfg       VADConfig
+	frameSize int
+	sample    func(b []byte) int16
+	stride    int
+
+	minSpeech, hangover, maxSilence, preRoll, keepAlive int64
+
+	pending []byte
+	frames  int64 // frames processed
+	sent    int64 // frames forwarded
+
+	speaking   bool
+	speechRun  int64
+	silenceRun int64
+	// quiet counts frames since the last utterance ended.
+	quiet int64
+	// held are the most recent droppable frames, kept as pre-roll.
+	held    [][]byte
+	evicted int64
+	// gapsMu guards gaps, which ReceivedOffset reads while Process runs.
+	gapsMu sync.Mutex
+	gaps   []vadGap
+}
+
+// NewVAD creates a VAD for 16-bit little-endian PCM in format, or for
+// 8-bit mu-law if muLaw is set.
+func NewVAD(cfg VADConfig, format Format, muLaw bool) *VAD {
+	cfg = cfg.withDefaults()
+	v := &VAD{cfg: cfg}
+	if muLaw {
+		v.stride = 1
+		v.sample = func(b []byte) int16 { return muLawTable[b[0]] }
+	} else {
+		v.stride = 2
+		v.sample = func(b []byte) int16 { return int16(binary.LittleEndian.Uint16(b)) }
+	}
+	v.frameSize = v.stride * format.Channels * int(int64(format.SampleRate)*int64(vadFrame)/int64(time.Second))
+
+	frames := func(d time.Duration) int64 { return int64((d + vadFrame - 1) / vadFrame) }
+	v.minSpeech = max(frames(cfg.MinSpeech), 1)
+	v.hangover = max(frames(cfg.Hangover), 1)
+	v.maxSilence = frames(cfg.MaxSilence)
+	// Hold the speech frames counted before an utterance starts on top of
+	// the pre-roll.
+	v.preRoll = frames(cfg.PreRoll) + v.minSpeech - 1
+	v.keepAlive = max(frames(cfg.KeepAlive), 1)
+	return v
+}
+
+// Process classifies the audio in data, which may split frames anywhere,
+// and returns the audio to forward together with any utterance boundaries
+// found. An incomplete trailing frame is held until the next call.
+func (v *VAD) Process(data []byte) ([]byte, []VADEvent) {
+	v.pending = append(v.pending, data...)
+	var out []byte
+	var events []VADEvent
+	for len(v.pending) >= v.frameSize {
+		frame := v.pending[:v.frameSize:v.frameSize]
+		v.pending = v.pending[v.frameSize:]
+		out, events = v.processFrame(frame, out, events)
+	}
+	if len(v.pending) == 0 {
+		v.pending = nil
+	}
+	return out, events
+}
+
+// Flush returns the held partial frame and ends an utterance in progress.
+// Call it once the audio ends.
+func (v *VAD) Flush() ([]byte, []VADEvent) {
+	out := v.pending
+	v.pending = nil
+	var events []VADEvent
+	if v.speaking {
+		v.speaking = false
+		events = append(events, VADEvent{Type: SpeechEnd, At: v.offset(v.frames)})
+	}
+	return out, events
+}
+
+// Speaking reports whether an utterance is in progress.
+func (v *VAD) Speaking() bool {
+	return v.speaking
+}
+
+// ReceivedOffset maps an offset in the forwarded audio to the matching
+// offset in the audio given to the VAD, adding back dropped silence. It
+// is safe to call concurrently with Process and Flush.
+func (v *VAD) ReceivedOffset(sent time.Duration) time.Duration {
+	v.gapsMu.Lock()
+	defer v.gapsMu.Unlock()
+	frame := int64(sent / vadFrame)
+	i := sort.Search(len(v.gaps), func(i int) bool { return v.gaps[i].sent > frame })
+	if i == 0 {
+		return sent
+	}
+	return sent + v.offset(v.gaps[i-1].dropped)
+}
+
+func (v *VAD) offset(frames int64) time.Duration {
+	return time.Duration(frames) * vadFrame
+}
+
+func (v *VAD) processFrame(frame, out []byte, events []VADEvent) ([]byte, []VADEvent) {
+	n := v.frames
+	v.frames++
+	speech := v.energyDB(frame) >= v.cfg.ThresholdDB
+
+	if !v.speaking {
+		if speech {
+			v.speechRun++
+		} else {
+			v.speechRun = 0
+		}
+		if v.speechRun >= v.minSpeech {
+			v.speaking = true
+			v.silenceRun = 0
+			events = append(events, VADEvent{Type: SpeechStart, At: v.offset(n - v.speechRun + 1)})
+		}
+	} else {
+		if speech {
+			v.silenceRun = 0
+		} else {
+			v.silenceRun++
+		}
+		if v.silenceRun >= v.hangover {
+			v.speaking = false
+			v.speechRun = 0
+			v.quiet = v.silenceRun - 1
+			events = append(events, VADEvent{Type: SpeechEnd, At: v.offset(n - v.silenceRun + 1)})
+		}
+	}
+
+	if v.speaking {
+		v.quiet = 0
+	} else {
+		v.quiet++
+	}
+	if v.speaking || v.cfg.MaxSilence < 0 || v.quiet <= v.maxSilence {
+		for _, held := range v.held {
+			out = v.forward(out, held)
+		}
+		v.held = v.held[:0]
+		return v.forward(out, frame), events
+	}
+
+	// Past the silence allowance: hold the frame as pre-roll and drop the
+	// oldest, forwarding one every keepAlive frames.
+	v.held = append(v.held, frame)
+	if int64(len(v.held)) > v.preRoll {
+		oldest := v.held[0]
+		v.held = v.held[1:]
+		v.evicted++
+		if v.evicted%v.keepAlive == 0 {
+			out = v.forward(out, oldest)
+		} else {
+			v.drop()
+		}
+	}
+	return out, events
+}
+
+func (v *VAD) forward(out, frame []byte) []byte {
+	v.sent++
+	return append(out, frame...)
+}
+
+func (v *VAD) drop() {
+	v.gapsMu.Lock()
+	defer v.gapsMu.Unlock()
+	if last := len(v.gaps) - 1; last >= 0 && v.gaps[last].sent == v.sent {
+		v.gaps[last].dropped++
+		return
+	}
+	var dropped int64
+	if len(v.gaps) > 0 {
+		dropped = v.gaps[len(v.gaps)-1].dropped
+	}
+	v.gaps = append(v.gaps, vadGap{sent: v.sent, dropped: dropped + 1})
+}
+
+// energyDB returns the RMS level of a frame in dBFS.
+func (v *VAD) energyDB(frame []byte) float64 {
+	var sum float64
+	count := len(frame) / v.stride
+	for i := 0; i+v.stride <= len(frame); i += v.stride {
+		s := float64(v.sample(frame[i:]))
+		sum += s * s
+	}
+	if sum == 0 || count == 0 {
+		return math.Inf(-1)
+	}
+	return 10 * math.Log10(sum/float64(count)/(32768*32768))
+}
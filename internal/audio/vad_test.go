@@ -0,0 +1,76 @@
+package audio
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+// tone returns d of 16 kHz mono PCM: a 440 Hz sine of the given amplitude,
+// or silence if it is 0.
+func tone(d time.Duration, amplitude float64) []byte {
+	n := int(d.Seconds() * 16000)
+	s := make([]int16, n)
+	for i := range s {
+		s[i] = int16(amplitude * math.Sin(2*math.Pi*440*float64(i)/16000))
+	}
+	return pcm(s...)
+}
+
+func TestVAD(t *testing.T) {
+	vad := NewVAD(VADConfig{}, Format{SampleRate: 16000, Channels: 1}, false)
+	var input []byte
+	input = append(input, tone(3*time.Second, 0)...)
+	input = append(input, tone(time.Second, 3000)...)
+	input = append(input, tone(4*time.Second, 0)...)
+
+	// Feed the audio in chunks that split frames.
+	var out []byte
+	var events []VADEvent
+	for chunk := range slices.Chunk(input, 1000) {
+		o, e := vad.Process(chunk)
+		out = append(out, o...)
+		events = append(events, e...)
+	}
+	o, e := vad.Flush()
+	out = append(out, o...)
+	events = append(events, e...)
+
+	want := []VADEvent{{Type: SpeechStart, At: 3 * time.Second}, {Type: SpeechEnd, At: 4 * time.Second}}
+	if !slices.Equal(events, want) {
+		t.Errorf("expected events %v, got %v", want, events)
+	}
+	// 2s of silence is kept after each utterance, plus 300ms of pre-roll.
+	if sent := time.Duration(len(out)/32) * time.Millisecond; sent != 5300*time.Millisecond {
+		t.Errorf("expected 5.3s of audio to be forwarded, got %s", sent)
+	}
+	for sent, received := range map[time.Duration]time.Duration{
+		time.Second:             time.Second,
+		2300 * time.Millisecond: 3 * time.Second,
+		3300 * time.Millisecond: 4 * time.Second,
+	} {
+		if got := vad.ReceivedOffset(sent); got != received {
+			t.Errorf("ReceivedOffset(%s) = %s, want %s", sent, got, received)
+		}
+	}
+}
+
+func TestVAD_KeepsAliveAndForwardsEverythingWhenDisabled(t *testing.T) {
+	silence := tone(20*time.Second, 0)
+
+	vad := NewVAD(VADConfig{KeepAlive: 5 * time.Second}, Format{SampleRate: 16000, Channels: 1}, false)
+	out, events := vad.Process(silence)
+	if len(events) != 0 {
+		t.Errorf("expected no events for silence, got %v", events)
+	}
+	// 2s kept, then one 20ms frame every 5s of the remaining 18s.
+	if frames := len(out) / 640; frames != 100+3 {
+		t.Errorf("expected 103 frames forwarded, got %d", frames)
+	}
+
+	vad = NewVAD(VADConfig{MaxSilence: -1}, Format{SampleRate: 16000, Channels: 1}, false)
+	if out, _ := vad.Process(silence); len(out) != len(silence) {
+		t.Errorf("expected all audio forwarded, got %d of %d bytes", len(out), len(silence))
+	}
+}
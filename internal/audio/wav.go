@@ -0,0 +1,200 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrMalformedWAV is returned for files that claim to be WAV but whose
+	// RIFF structure cannot be parsed.
+	ErrMalformedWAV = errors.New("malformed WAV file")
+	// ErrUnsupportedWAV is returned for well-formed WAV files in a sample
+	// format that cannot be converted for recognition.
+	ErrUnsupportedWAV = errors.New("unsupported WAV format")
+)
+
+// WAV format tags from the fmt chunk.
+const (
+	wavFormatPCM        = 0x0001
+	wavFormatMuLaw      = 0x0007
+	wavFormatExtensible = 0xFFFE
+)
+
+// maxWAVChunks bounds how many chunks are skipped looking for "data", so a
+// corrupt file cannot make the parser spin.
+const maxWAVChunks = 64
+
+// WAVHeader describes the audio in a WAV file.
+type WAVHeader struct {
+	SampleRate    int
+	Channels      int
+	BitsPerSample int
+	// MuLaw is set for G.711 mu-law files (8 bits per sample).
+	MuLaw bool
+	// DataSize is the length of the sample data in bytes, or -1 if the
+	// header does not say (streamed WAVs).
+	DataSize int64
+}
+
+// ReadWAVHeader parses the RIFF header of a WAV file from r, leaving r at
+// the first byte of sample data. Chunks before the data chunk (LIST, fact,
+// ...) are skipped.
+func ReadWAVHeader(r io.Reader) (WAVHeader, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return WAVHeader{}, fmt.Errorf("%w: file too short for a RIFF header", ErrMalformedWAV)
+	}
+	if !bytes.Equal(riff[0:4], []byte("RIFF")) || !bytes.Equal(riff[8:12], []byte("WAVE")) {
+		return WAVHeader{}, fmt.Errorf("%w: missing RIFF/WAVE signature", ErrMalformedWAV)
+	}
+
+	var (
+		h      WAVHeader
+		hasFmt bool
+		tag    uint16
+	)
+	for i := 0; i < maxWAVChunks; i++ {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return WAVHeader{}, fmt.Errorf("%w: no data chunk", ErrMalformedWAV)
+		}
+		id := string(chunk[0:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+
+		switch id {
+		case "fmt ":
+			if size < 16 || size > 1024 {
+				return WAVHeader{}, fmt.Errorf("%w: fmt chunk of %d bytes", ErrMalformedWAV, size)
+			}
+			body := make([]byte, size+size%2)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return WAVHeader{}, fmt.Errorf("%w: truncated fmt chunk", ErrMalformedWAV)
+			}
+			tag = binary.LittleEndian.Uint16(body[0:2])
+			h.Channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			h.SampleRate = int(binary.LittleEndian.Uint32(body[4:8]))
+			h.BitsPerSample = int(binary.LittleEndian.Uint16(body[14:16]))
+			if tag == wavFormatExtensible {
+				// WAVE_FORMAT_EXTENSIBLE keeps the real tag in the first two
+				// bytes of the sub-format GUID.
+				if size < 40 {
+					return WAVHeader{}, fmt.Errorf("%w: truncated extensible fmt chunk", ErrMalformedWAV)
+				}
+				tag = binary.LittleEndian.Uint16(body[24:26])
+			}
+			hasFmt = true
+		case "data":
+			if !hasFmt {
+				return WAVHeader{}, fmt.Errorf("%w: data chunk before fmt chunk", ErrMalformedWAV)
+			}
+			h.DataSize = size
+			if size == 0 || size == 0xFFFFFFFF {
+				h.DataSize = -1
+			}
+			return h, h.validate(tag)
+		default:
+			if _, err := io.CopyN(io.Discard, r, size+size%2); err != nil {
+				return WAVHeader{}, fmt.Errorf("%w: truncated %q chunk", ErrMalformedWAV, id)
+			}
+		}
+	}
+	return WAVHeader{}, fmt.Errorf("%w: no data chunk in the first %d chunks", ErrMalformedWAV, maxWAVChunks)
+}
+
+// maxWAVDataSize is the largest data chunk a RIFF header can describe.
+const maxWAVDataSize = 0xFFFFFFFF - 36
+
+// EncodeWAVHeader returns the 44-byte header of a WAV file holding h's
+// audio: 16-bit PCM, or 8-bit mu-law if h.MuLaw is set. A DataSize of -1,
+// or one too large for RIFF, marks the length as unknown.
+func EncodeWAVHeader(h WAVHeader) []byte {
+	tag, bits := uint16(wavFormatPCM), uint16(16)
+	if h.MuLaw {
+		tag, bits = wavFormatMuLaw, 8
+	}
+	dataSize := uint32(0xFFFFFFFF)
+	if h.DataSize >= 0 && h.DataSize <= maxWAVDataSize {
+		dataSize = uint32(h.DataSize)
+	}
+	blockAlign := uint16(h.Channels) * bits / 8
+
+	b := make([]byte, 0, 44)
+	b = append(b, "RIFF"...)
+	b = binary.LittleEndian.AppendUint32(b, max(dataSize, 36+dataSize))
+	b = append(b, "WAVEfmt "...)
+	b = binary.LittleEndian.AppendUint32(b, 16)
+	b = binary.LittleEndian.AppendUint16(b, tag)
+	b = binary.LittleEndian.AppendUint16(b, uint16(h.Channels))
+	b = binary.LittleEndian.AppendUint32(b, uint32(h.SampleRate))
+	b = binary.LittleEndian.AppendUint32(b, uint32(h.SampleRate)*uint32(blockAlign))
+	b = binary.LittleEndian.AppendUint16(b, blockAlign)
+	b = binary.LittleEndian.AppendUint16(b, bits)
+	b = append(b, "data"...)
+	return binary.LittleEndian.AppendUint32(b, dataSize)
+}
+
+// validate checks that the fmt chunk describes audio DecodeWAV can convert.
+func (h *WAVHeader) validate(tag uint16) error {
+	if h.Channels < 1 || h.SampleRate < 1 {
+		return fmt.Errorf("%w: %d channels at %d Hz", ErrMalformedWAV, h.Channels, h.SampleRate)
+	}
+	switch tag {
+	case wavFormatPCM:
+		switch h.BitsPerSample {
+		case 8, 16, 24, 32:
+			return nil
+		}
+		return fmt.Errorf("%w: %d-bit PCM (supported: 8, 16, 24 or 32)", ErrUnsupportedWAV, h.BitsPerSample)
+	case wavFormatMuLaw:
+		if h.BitsPerSample != 8 {
+			return fmt.Errorf("%w: %d-bit mu-law", ErrUnsupportedWAV, h.BitsPerSample)
+		}
+		h.MuLaw = true
+		return nil
+	}
+	return fmt.Errorf("%w: format tag 0x%04X (supported: PCM, mu-law)", ErrUnsupportedWAV, tag)
+}
+
+// Format returns the PCM format of the samples DecodeWAV produces.
+func (h WAVHeader) Format() Format {
+	return Format{SampleRate: h.SampleRate, Channels: h.Channels}
+}
+
+// DecodeWAV returns the sample data following a header read by
+// ReadWAVHeader from r. PCM at 8, 24 or 32 bits is converted to 16 bits;
+// 16-bit PCM and mu-law are returned as stored. Trailing chunks after the
+// data chunk are not returned.
+func DecodeWAV(r io.Reader, h WAVHeader) io.Reader {
+	if h.DataSize >= 0 {
+		r = io.LimitReader(r, h.DataSize)
+	}
+	if h.MuLaw || h.BitsPerSample == 16 {
+		return r
+	}
+
+	width := h.BitsPerSample / 8
+	in := make([]byte, 4096*width)
+	pending := 0
+	return &bufferedReader{fill: func(buf []byte) ([]byte, error) {
+		n, err := io.ReadAtLeast(r, in[pending:], 1)
+		n += pending
+		whole := n - n%width
+		for off := 0; off < whole; off += width {
+			var s int16
+			switch width {
+			case 1:
+				s = int16(in[off]-128) << 8
+			default:
+				// Keep the two most significant bytes of the little-endian sample.
+				s = int16(binary.LittleEndian.Uint16(in[off+width-2:]))
+			}
+			buf = putSample(buf, s)
+		}
+		pending = copy(in, in[whole:n])
+		return buf, err
+	}}
+}
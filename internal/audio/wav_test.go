@@ -0,0 +1,131 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"testing"
+)
+
+// buildWAV assembles a WAV file with the given fmt fields and sample data.
+func buildWAV(tag, channels uint16, rate uint32, bits uint16, data []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(36+len(data)))
+	b.WriteString("WAVEfmt ")
+	binary.Write(&b, binary.LittleEndian, uint32(16))
+	blockAlign := channels * bits / 8
+	for _, v := range []any{tag, channels, rate, rate * uint32(blockAlign), blockAlign, bits} {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(len(data)))
+	b.Write(data)
+	return b.Bytes()
+}
+
+func TestReadWAVHeader_Sample(t *testing.T) {
+	raw, err := os.ReadFile("../../sample.wav")
+	if err != nil {
+		t.Fatalf("failed to read sample.wav: %v", err)
+	}
+	r := bytes.NewReader(raw)
+	h, err := ReadWAVHeader(r)
+	if err != nil {
+		t.Fatalf("ReadWAVHeader failed: %v", err)
+	}
+	if h.SampleRate != 16000 || h.Channels != 1 || h.BitsPerSample != 16 || h.MuLaw {
+		t.Errorf("unexpected header: %+v", h)
+	}
+
+	// The LIST chunk before the data chunk must be skipped.
+	headerLen := int64(len(raw)) - int64(r.Len())
+	if h.DataSize != int64(len(raw))-headerLen {
+		t.Errorf("data size %d, expected %d", h.DataSize, int64(len(raw))-headerLen)
+	}
+	data, _ := io.ReadAll(DecodeWAV(r, h))
+	if !bytes.Equal(data, raw[headerLen:]) {
+		t.Errorf("decoded data does not match the file's sample data")
+	}
+}
+
+func TestDecodeWAV_ConvertsBitDepth(t *testing.T) {
+	// 24-bit samples 0x123456 and -1.
+	raw := buildWAV(wavFormatPCM, 1, 8000, 24, []byte{0x56, 0x34, 0x12, 0xFF, 0xFF, 0xFF})
+	r := bytes.NewReader(raw)
+	h, err := ReadWAVHeader(r)
+	if err != nil {
+		t.Fatalf("ReadWAVHeader failed: %v", err)
+	}
+	if got := samples(t, DecodeWAV(r, h)); !slices.Equal(got, []int16{0x1234, -1}) {
+		t.Errorf("24-bit: got %v", got)
+	}
+
+	// 8-bit PCM is unsigned around 128.
+	raw = buildWAV(wavFormatPCM, 1, 8000, 8, []byte{0, 128, 255})
+	r = bytes.NewReader(raw)
+	if h, err = ReadWAVHeader(r); err != nil {
+		t.Fatalf("ReadWAVHeader failed: %v", err)
+	}
+	if got := samples(t, DecodeWAV(r, h)); !slices.Equal(got, []int16{-32768, 0, 32512}) {
+		t.Errorf("8-bit: got %v", got)
+	}
+}
+
+func TestReadWAVHeader_MuLaw(t *testing.T) {
+	h, err := ReadWAVHeader(bytes.NewReader(buildWAV(wavFormatMuLaw, 1, 8000, 8, []byte{0xFF})))
+	if err != nil {
+		t.Fatalf("ReadWAVHeader failed: %v", err)
+	}
+	if !h.MuLaw || h.SampleRate != 8000 {
+		t.Errorf("unexpected header: %+v", h)
+	}
+}
+
+func TestEncodeWAVHeader(t *testing.T) {
+	for _, want := range []WAVHeader{
+		{SampleRate: 16000, Channels: 2, BitsPerSample: 16, DataSize: 64000},
+		{SampleRate: 8000, Channels: 1, BitsPerSample: 8, MuLaw: true, DataSize: -1},
+	} {
+		header := EncodeWAVHeader(want)
+		if len(header) != 44 {
+			t.Errorf("expected a 44-byte header, got %d", len(header))
+		}
+		got, err := ReadWAVHeader(bytes.NewReader(header))
+		if err != nil {
+			t.Fatalf("ReadWAVHeader failed: %v", err)
+		}
+		if got != want {
+			t.Errorf("expected %+v, got %+v", want, got)
+		}
+	}
+}
+
+func TestReadWAVHeader_Errors(t *testing.T) {
+	valid := buildWAV(wavFormatPCM, 1, 16000, 16, make([]byte, 4))
+	noData := valid[:36]
+	float := buildWAV(3, 1, 16000, 32, make([]byte, 8))
+	badBits := buildWAV(wavFormatPCM, 1, 16000, 12, make([]byte, 4))
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"truncated", valid[:8], ErrMalformedWAV},
+		{"not riff", []byte("RIFX\x00\x00\x00\x00WAVE"), ErrMalformedWAV},
+		{"no data chunk", noData, ErrMalformedWAV},
+		{"float", float, ErrUnsupportedWAV},
+		{"12-bit", badBits, ErrUnsupportedWAV},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadWAVHeader(bytes.NewReader(tt.data)); !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
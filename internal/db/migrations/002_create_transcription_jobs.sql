@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS transcription_jobs (
+    id VARCHAR(64) PRIMARY KEY,
+    status VARCHAR(20) NOT NULL,
+    patient_reference VARCHAR(255) NOT NULL DEFAULT '',
+    encounter_reference VARCHAR(255) NOT NULL DEFAULT '',
+    practitioner_reference VARCHAR(255) NOT NULL DEFAULT '',
+    encoding VARCHAR(20) NOT NULL,
+    sample_rate_hertz INTEGER NOT NULL,
+    channels INTEGER NOT NULL,
+    language_code VARCHAR(35) NOT NULL,
+    audio_path TEXT NOT NULL DEFAULT '',
+    transcript TEXT NOT NULL DEFAULT '',
+    note JSONB,
+    impression_id VARCHAR(64) NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS transcription_jobs_patient_idx ON transcription_jobs (patient_reference, created_at DESC);
+CREATE INDEX IF NOT EXISTS transcription_jobs_encounter_idx ON transcription_jobs (encounter_reference, created_at DESC);
+CREATE INDEX IF NOT EXISTS transcription_jobs_status_idx ON transcription_jobs (status);
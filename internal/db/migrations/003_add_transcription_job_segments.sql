@@ -0,0 +1 @@
+ALTER TABLE transcription_jobs ADD COLUMN IF NOT EXISTS segments JSONB;
@@ -0,0 +1 @@
+ALTER TABLE transcription_jobs ADD COLUMN IF NOT EXISTS alternative_language_codes TEXT NOT NULL DEFAULT '';
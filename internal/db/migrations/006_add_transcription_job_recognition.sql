@@ -0,0 +1,3 @@
+ALTER TABLE transcription_jobs ADD COLUMN IF NOT EXISTS model VARCHAR(40) NOT NULL DEFAULT '';
+ALTER TABLE transcription_jobs ADD COLUMN IF NOT EXISTS use_enhanced BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE transcription_jobs ADD COLUMN IF NOT EXISTS phrase_sets TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS speech_phrase_sets (
+    name VARCHAR(63) PRIMARY KEY,
+    phrases JSONB NOT NULL,
+    boost REAL NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS speech_custom_classes (
+    name VARCHAR(63) PRIMARY KEY,
+    items JSONB NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
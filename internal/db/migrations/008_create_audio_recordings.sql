@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS audio_recordings (
+    id VARCHAR(64) PRIMARY KEY,
+    source VARCHAR(20) NOT NULL,
+    patient_reference VARCHAR(255) NOT NULL DEFAULT '',
+    encounter_reference VARCHAR(255) NOT NULL DEFAULT '',
+    practitioner_reference VARCHAR(255) NOT NULL DEFAULT '',
+    audio JSONB NOT NULL,
+    size BIGINT NOT NULL,
+    sha256 CHAR(64) NOT NULL,
+    data_key BYTEA NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS audio_recordings_patient_idx ON audio_recordings (patient_reference, created_at DESC);
+CREATE INDEX IF NOT EXISTS audio_recordings_encounter_idx ON audio_recordings (encounter_reference, created_at DESC);
+CREATE INDEX IF NOT EXISTS audio_recordings_expires_idx ON audio_recordings (expires_at);
+
+ALTER TABLE clinical_impressions ADD COLUMN IF NOT EXISTS audio_recording_id VARCHAR(64) NOT NULL DEFAULT '';
@@ -0,0 +1,7 @@
+ALTER TABLE transcription_jobs ADD COLUMN IF NOT EXISTS source VARCHAR(20) NOT NULL DEFAULT 'upload';
+ALTER TABLE transcription_jobs ADD COLUMN IF NOT EXISTS recording_id VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE transcription_jobs ADD COLUMN IF NOT EXISTS reprocess_of VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE transcription_jobs ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+CREATE INDEX IF NOT EXISTS transcription_jobs_reprocess_idx ON transcription_jobs (reprocess_of, created_at DESC);
+CREATE INDEX IF NOT EXISTS transcription_jobs_created_idx ON transcription_jobs (created_at DESC);
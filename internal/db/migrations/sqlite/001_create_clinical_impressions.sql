@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS clinical_impressions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    patient_id TEXT,
+    status TEXT,
+    description TEXT,
+    raw_fhir TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
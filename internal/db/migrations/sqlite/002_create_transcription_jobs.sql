@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS transcription_jobs (
+    id TEXT PRIMARY KEY,
+    status TEXT NOT NULL,
+    patient_reference TEXT NOT NULL DEFAULT '',
+    encounter_reference TEXT NOT NULL DEFAULT '',
+    practitioner_reference TEXT NOT NULL DEFAULT '',
+    encoding TEXT NOT NULL,
+    sample_rate_hertz INTEGER NOT NULL,
+    channels INTEGER NOT NULL,
+    language_code TEXT NOT NULL,
+    audio_path TEXT NOT NULL DEFAULT '',
+    transcript TEXT NOT NULL DEFAULT '',
+    note TEXT,
+    impression_id TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS transcription_jobs_patient_idx ON transcription_jobs (patient_reference, created_at DESC);
+CREATE INDEX IF NOT EXISTS transcription_jobs_encounter_idx ON transcription_jobs (encounter_reference, created_at DESC);
+CREATE INDEX IF NOT EXISTS transcription_jobs_status_idx ON transcription_jobs (status);
@@ -0,0 +1 @@
+ALTER TABLE transcription_jobs ADD COLUMN segments TEXT;
@@ -0,0 +1,3 @@
+ALTER TABLE transcription_jobs ADD COLUMN model TEXT NOT NULL DEFAULT '';
+ALTER TABLE transcription_jobs ADD COLUMN use_enhanced BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE transcription_jobs ADD COLUMN phrase_sets TEXT NOT NULL DEFAULT '';
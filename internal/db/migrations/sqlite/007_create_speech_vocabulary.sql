@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS speech_phrase_sets (
+    name TEXT PRIMARY KEY,
+    phrases TEXT NOT NULL,
+    boost REAL NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS speech_custom_classes (
+    name TEXT PRIMARY KEY,
+    items TEXT NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
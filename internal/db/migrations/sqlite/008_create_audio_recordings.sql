@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS audio_recordings (
+    id TEXT PRIMARY KEY,
+    source TEXT NOT NULL,
+    patient_reference TEXT NOT NULL DEFAULT '',
+    encounter_reference TEXT NOT NULL DEFAULT '',
+    practitioner_reference TEXT NOT NULL DEFAULT '',
+    audio TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    sha256 TEXT NOT NULL,
+    data_key BLOB NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS audio_recordings_patient_idx ON audio_recordings (patient_reference, created_at DESC);
+CREATE INDEX IF NOT EXISTS audio_recordings_encounter_idx ON audio_recordings (encounter_reference, created_at DESC);
+CREATE INDEX IF NOT EXISTS audio_recordings_expires_idx ON audio_recordings (expires_at);
+
+ALTER TABLE clinical_impressions ADD COLUMN audio_recording_id TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,7 @@
+ALTER TABLE transcription_jobs ADD COLUMN source TEXT NOT NULL DEFAULT 'upload';
+ALTER TABLE transcription_jobs ADD COLUMN recording_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE transcription_jobs ADD COLUMN reprocess_of TEXT NOT NULL DEFAULT '';
+ALTER TABLE transcription_jobs ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+CREATE INDEX IF NOT EXISTS transcription_jobs_reprocess_idx ON transcription_jobs (reprocess_of, created_at DESC);
+CREATE INDEX IF NOT EXISTS transcription_jobs_created_idx ON transcription_jobs (created_at DESC);
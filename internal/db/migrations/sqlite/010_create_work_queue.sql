@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS work_queue (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind TEXT NOT NULL,
+    job_id TEXT NOT NULL,
+    status TEXT NOT NULL,
+    payload TEXT,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL,
+    visible_at TIMESTAMP NOT NULL,
+    worker TEXT NOT NULL DEFAULT '',
+    error_code TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS work_queue_ready_idx ON work_queue (visible_at, id) WHERE status IN ('pending', 'running');
+CREATE INDEX IF NOT EXISTS work_queue_job_idx ON work_queue (job_id, id);
+CREATE INDEX IF NOT EXISTS work_queue_status_idx ON work_queue (status);
+
+-- Unfinished jobs were resumed from their status at startup; queue them instead.
+INSERT INTO work_queue (kind, job_id, status, max_attempts, visible_at, created_at, updated_at)
+SELECT 'job', id, 'pending', 5, updated_at, updated_at, updated_at
+FROM transcription_jobs
+WHERE status IN ('queued', 'transcribing', 'extracting')
+ORDER BY created_at;
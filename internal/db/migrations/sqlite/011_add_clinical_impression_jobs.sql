@@ -0,0 +1,3 @@
+ALTER TABLE clinical_impressions ADD COLUMN job_id TEXT NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX IF NOT EXISTS clinical_impressions_job_idx ON clinical_impressions (job_id) WHERE job_id <> '';
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+
+	_ "modernc.org/sqlite"
+)
+
+// NewSQLite opens (creating if needed) a SQLite database at path and applies
+// the embedded SQLite migrations. Use ":memory:" for a throwaway database.
+func NewSQLite(ctx context.Context, path string) (*sql.DB, error) {
+	dsn := "file:" + path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	conn, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database: %w", err)
+	}
+	// SQLite allows a single writer; serializing through one connection avoids
+	// SQLITE_BUSY errors and keeps ":memory:" databases shared.
+	conn.SetMaxOpenConns(1)
+
+	if err := conn.PingContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to sqlite database: %w", err)
+	}
+	if err := MigrateSQLite(ctx, conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	log.Printf("Opened SQLite database at %s", path)
+	return conn, nil
+}
+
+// MigrateSQLite applies any pending SQLite migrations embedded in the binary.
+func MigrateSQLite(ctx context.Context, conn *sql.DB) error {
+	migrations, err := loadMigrations("migrations/sqlite")
+	if err != nil {
+		return err
+	}
+
+	if _, err := conn.ExecContext(ctx, createMigrationsTable); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	for _, m := range migrations {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin migration %s: %w", m.version, err)
+		}
+
+		res, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO schema_migrations (version) VALUES (?)`, m.version)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %s: %w", m.version, err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			tx.Rollback()
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply migration %s: %w", m.version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %s: %w", m.version, err)
+		}
+		log.Printf("Applied SQLite migration %s", m.version)
+	}
+
+	return nil
+}
@@ -0,0 +1,41 @@
+package domain
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// referencePattern matches a FHIR relative reference such as "Patient/123".
+var referencePattern = regexp.MustCompile(`^([A-Z][A-Za-z]+)/([A-Za-z0-9\-.]{1,64})$`)
+
+// EncounterContext identifies who and which visit a recording belongs to.
+// Each field is an optional FHIR relative reference.
+type EncounterContext struct {
+	PatientReference      string `json:"patient_reference,omitempty"`
+	EncounterReference    string `json:"encounter_reference,omitempty"`
+	PractitionerReference string `json:"practitioner_reference,omitempty"`
+}
+
+// Validate checks that each set reference points at the expected resource type.
+func (e EncounterContext) Validate() error {
+	for _, ref := range []struct{ field, value, resourceType string }{
+		{"patient_reference", e.PatientReference, "Patient"},
+		{"encounter_reference", e.EncounterReference, "Encounter"},
+		{"practitioner_reference", e.PractitionerReference, "Practitioner"},
+	} {
+		if ref.value == "" {
+			continue
+		}
+		m := referencePattern.FindStringSubmatch(ref.value)
+		if m == nil || m[1] != ref.resourceType {
+			return fmt.Errorf("invalid %s %q (expected %s/<id>)", ref.field, ref.value, ref.resourceType)
+		}
+	}
+	return nil
+}
+
+// IsZero reports whether no reference is set.
+func (e EncounterContext) IsZero() bool {
+	return strings.TrimSpace(e.PatientReference+e.EncounterReference+e.PractitionerReference) == ""
+}
@@ -0,0 +1,67 @@
+package domain
+
+import "time"
+
+// JobStatus is the processing stage of a TranscriptionJob.
+type JobStatus string
+
+const (
+	JobQueued       JobStatus = "queued"
+	JobTranscribing JobStatus = "transcribing"
+	JobExtracting   JobStatus = "extracting"
+	JobSaved        JobStatus = "saved"
+	JobFailed       JobStatus = "failed"
+)
+
+// Done reports whether the job has finished, successfully or not.
+func (s JobStatus) Done() bool {
+	return s == JobSaved || s == JobFailed
+}
+
+// JobAudio describes the stored audio of a job.
+type JobAudio struct {
+	Encoding        string `json:"encoding"`
+	SampleRateHertz int    `json:"sample_rate_hertz"`
+	Channels        int    `json:"channels"`
+	LanguageCode    string `json:"language_code"`
+	// AlternativeLanguageCodes are other languages the recording may be in.
+	AlternativeLanguageCodes []string `json:"alternative_language_codes,omitempty"`
+	// Speakers is how speakers are separated and labelled.
+	Speakers SpeakerConfig `json:"speakers"`
+	// Model is the recognition model, e.g. "medical_conversation".
+	Model       string `json:"model,omitempty"`
+	UseEnhanced bool   `json:"use_enhanced,omitempty"`
+	// PhraseSets names the vocabulary phrase sets to boost; empty means
+	// all of them.
+	PhraseSets []string `json:"phrase_sets,omitempty"`
+}
+
+// TranscriptionJob tracks an uploaded recording through transcription,
+// extraction and persistence. WebSocket sessions are recorded as finished
+// jobs when they end, so every encounter can be reprocessed the same way.
+type TranscriptionJob struct {
+	ID        string           `json:"id"`
+	Status    JobStatus        `json:"status"`
+	Source    RecordingSource  `json:"source"`
+	Encounter EncounterContext `json:"encounter"`
+	Audio     JobAudio         `json:"audio"`
+	// RecordingID names the archived copy of the audio, if there is one.
+	RecordingID string `json:"recording_id,omitempty"`
+	// ReprocessOf is the ID of the original job of the encounter when this
+	// job reprocesses it. Version numbers the encounter's outputs, the
+	// original being 1.
+	ReprocessOf string `json:"reprocess_of,omitempty"`
+	Version     int    `json:"version"`
+	// AudioPath is where the audio waits to be transcribed. It is cleared
+	// once the job is done.
+	AudioPath  string `json:"-"`
+	Transcript string `json:"transcript,omitempty"`
+	// Segments is the transcript split at the boundaries the speech
+	// provider finalized.
+	Segments     []TranscriptSegment `json:"segments,omitempty"`
+	Note         *ClinicalNote       `json:"note,omitempty"`
+	ImpressionID string              `json:"impression_id,omitempty"`
+	Error        string              `json:"error,omitempty"`
+	CreatedAt    time.Time           `json:"created_at"`
+	UpdatedAt    time.Time           `json:"updated_at"`
+}
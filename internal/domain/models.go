@@ -1,8 +1,55 @@
 package domain
 
+import "strings"
+
 // ClinicalNote represents the structured clinical data extracted from a conversation.
+// Notes are written in English whatever language the conversation was in.
 type ClinicalNote struct {
 	Symptoms    []string `json:"symptoms"`
 	Medications []string `json:"medications"`
 	HPI         []string `json:"hpi"`
+	// SourceLanguage is the BCP-47 tag of the transcript the note was
+	// extracted from, if known.
+	SourceLanguage string `json:"source_language,omitempty"`
+}
+
+// Empty reports whether the note holds no entries.
+func (n ClinicalNote) Empty() bool {
+	return len(n.Symptoms) == 0 && len(n.Medications) == 0 && len(n.HPI) == 0
+}
+
+// Merge adds the entries of other that n does not have yet, keeping n's
+// order, so a note extracted from the next part of a conversation extends
+// the note of what came before. SourceLanguage is kept unless n has none.
+func (n *ClinicalNote) Merge(other ClinicalNote) {
+	n.Symptoms = mergeList(n.Symptoms, other.Symptoms)
+	n.Medications = mergeList(n.Medications, other.Medications)
+	n.HPI = mergeList(n.HPI, other.HPI)
+	if n.SourceLanguage == "" {
+		n.SourceLanguage = other.SourceLanguage
+	}
+}
+
+func mergeList(list, more []string) []string {
+	if list == nil {
+		// Keep the JSON an array rather than null.
+		list = []string{}
+	}
+	for _, s := range more {
+		if !containsEntry(list, s) {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// containsEntry reports whether list has s. Entries are matched ignoring
+// case and surrounding space, since extractors differ in capitalization.
+func containsEntry(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(strings.TrimSpace(v), strings.TrimSpace(s)) {
+			return true
+		}
+	}
+	return false
 }
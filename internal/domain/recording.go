@@ -0,0 +1,33 @@
+package domain
+
+import "time"
+
+// RecordingSource is how audio reached the server: streamed in a WebSocket
+// session or uploaded.
+type RecordingSource string
+
+const (
+	RecordingFromSession RecordingSource = "session"
+	RecordingFromUpload  RecordingSource = "upload"
+)
+
+// AudioRecording is the archived audio of a streaming session or an upload,
+// kept encrypted so notes can be checked against what was said. Its ID is
+// the ID of the session or transcription job it came from.
+type AudioRecording struct {
+	ID        string           `json:"id"`
+	Source    RecordingSource  `json:"source"`
+	Encounter EncounterContext `json:"encounter"`
+	// Audio describes the audio as received, before any transcoding.
+	Audio JobAudio `json:"audio"`
+	// Size is the length of the audio in bytes and SHA256 the hex digest
+	// of it.
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// DataKey is the key the audio is encrypted with, itself encrypted
+	// with the archive's key.
+	DataKey   []byte    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	// ExpiresAt is when the recording is deleted; zero keeps it forever.
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
@@ -0,0 +1,117 @@
+package domain
+
+import "time"
+
+// ReprocessRequest selects encounters whose notes should be generated again,
+// e.g. after the extraction prompt or model changed. Selectors combine: an
+// encounter must match all of those given, and at least one is required.
+type ReprocessRequest struct {
+	// JobIDs are IDs of the original jobs of the encounters. A WebSocket
+	// session's job ID is its session ID.
+	JobIDs             []string `json:"job_ids,omitempty"`
+	PatientReference   string   `json:"patient_reference,omitempty"`
+	EncounterReference string   `json:"encounter_reference,omitempty"`
+	// From and To bound when the encounters were recorded, inclusive and
+	// exclusive respectively.
+	From time.Time `json:"from,omitzero"`
+	To   time.Time `json:"to,omitzero"`
+	// Retranscribe runs speech recognition again on the archived audio.
+	// Otherwise the latest transcript is extracted again.
+	Retranscribe bool `json:"retranscribe,omitempty"`
+	// DryRun compares the new notes with the current ones without saving
+	// anything.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// Empty reports whether the request selects nothing, which would otherwise
+// mean every encounter.
+func (r ReprocessRequest) Empty() bool {
+	return len(r.JobIDs) == 0 && r.PatientReference == "" && r.EncounterReference == "" && r.From.IsZero() && r.To.IsZero()
+}
+
+// ReprocessResult reports what reprocessing did for one encounter.
+type ReprocessResult struct {
+	// JobID is the encounter's original job. BaseJobID is the job holding
+	// the version the new note is compared with and, unless retranscribing,
+	// extracted from.
+	JobID     string           `json:"job_id"`
+	BaseJobID string           `json:"base_job_id,omitempty"`
+	Encounter EncounterContext `json:"encounter"`
+	// Version is the version the new output has, or would have.
+	Version int `json:"version,omitempty"`
+	// Job is the queued reprocessing job, unless this is a dry run.
+	Job *TranscriptionJob `json:"job,omitempty"`
+	// Transcript is the new transcript of a dry run that retranscribed.
+	Transcript string        `json:"transcript,omitempty"`
+	OldNote    *ClinicalNote `json:"old_note,omitempty"`
+	NewNote    *ClinicalNote `json:"new_note,omitempty"`
+	Diff       *NoteDiff     `json:"diff,omitempty"`
+	// Error says why the encounter could not be reprocessed.
+	Error string `json:"error,omitempty"`
+}
+
+// ListDiff holds the entries of a note list that were added and removed.
+type ListDiff struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// Empty reports whether nothing changed.
+func (d ListDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0
+}
+
+// Change holds a value before and after.
+type Change struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// NoteDiff describes how one ClinicalNote differs from another.
+type NoteDiff struct {
+	Symptoms       ListDiff `json:"symptoms,omitzero"`
+	Medications    ListDiff `json:"medications,omitzero"`
+	HPI            ListDiff `json:"hpi,omitzero"`
+	SourceLanguage *Change  `json:"source_language,omitempty"`
+}
+
+// Empty reports whether the notes are the same.
+func (d NoteDiff) Empty() bool {
+	return d.Symptoms.Empty() && d.Medications.Empty() && d.HPI.Empty() && d.SourceLanguage == nil
+}
+
+// DiffNotes compares two notes, either of which may be nil. Entries are
+// matched ignoring case and surrounding space, since extractors differ in
+// capitalization; order is ignored.
+func DiffNotes(old, new *ClinicalNote) NoteDiff {
+	if old == nil {
+		old = &ClinicalNote{}
+	}
+	if new == nil {
+		new = &ClinicalNote{}
+	}
+	d := NoteDiff{
+		Symptoms:    diffList(old.Symptoms, new.Symptoms),
+		Medications: diffList(old.Medications, new.Medications),
+		HPI:         diffList(old.HPI, new.HPI),
+	}
+	if old.SourceLanguage != new.SourceLanguage {
+		d.SourceLanguage = &Change{Old: old.SourceLanguage, New: new.SourceLanguage}
+	}
+	return d
+}
+
+func diffList(old, new []string) ListDiff {
+	var d ListDiff
+	for _, s := range new {
+		if !containsEntry(old, s) {
+			d.Added = append(d.Added, s)
+		}
+	}
+	for _, s := range old {
+		if !containsEntry(new, s) {
+			d.Removed = append(d.Removed, s)
+		}
+	}
+	return d
+}
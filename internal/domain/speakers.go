@@ -0,0 +1,90 @@
+package domain
+
+import "fmt"
+
+// SpeakerRole is who is talking in a transcript segment.
+type SpeakerRole string
+
+const (
+	RoleClinician SpeakerRole = "clinician"
+	RolePatient   SpeakerRole = "patient"
+	RoleOther     SpeakerRole = "other"
+)
+
+// Valid reports whether r is one of the defined roles.
+func (r SpeakerRole) Valid() bool {
+	switch r {
+	case RoleClinician, RolePatient, RoleOther:
+		return true
+	}
+	return false
+}
+
+// SpeakerRoles assigns roles to speakers. Keys are speaker numbers from 1:
+// diarization speaker tags, or channel numbers when channels are
+// recognized separately.
+type SpeakerRoles map[int]SpeakerRole
+
+// Role returns the role of speaker. Unassigned speakers default to
+// clinician for speaker 1 (who usually opens the encounter), patient for
+// speaker 2 and other for the rest. Speaker 0, unknown, has no role.
+func (r SpeakerRoles) Role(speaker int) SpeakerRole {
+	if speaker <= 0 {
+		return ""
+	}
+	if role, ok := r[speaker]; ok {
+		return role
+	}
+	switch speaker {
+	case 1:
+		return RoleClinician
+	case 2:
+		return RolePatient
+	}
+	return RoleOther
+}
+
+// maxSpeakers bounds speaker counts and numbers.
+const maxSpeakers = 10
+
+// SpeakerConfig controls how the speakers of a recording are told apart
+// and labelled.
+type SpeakerConfig struct {
+	// Diarization asks the speech provider to tell speakers apart by voice.
+	Diarization bool `json:"diarization,omitempty"`
+	// MinSpeakers and MaxSpeakers bound the number of voices diarization
+	// looks for (provider defaults when 0).
+	MinSpeakers int `json:"min_speakers,omitempty"`
+	MaxSpeakers int `json:"max_speakers,omitempty"`
+	// SeparateChannels recognizes each audio channel on its own, for
+	// recordings with one microphone per speaker. Channels then act as
+	// speakers.
+	SeparateChannels bool `json:"separate_channels,omitempty"`
+	// Roles overrides the default role of each speaker.
+	Roles SpeakerRoles `json:"roles,omitempty"`
+}
+
+// Validate checks the configuration against the recording's channel count.
+func (c SpeakerConfig) Validate(channels int) error {
+	if c.SeparateChannels && channels < 2 {
+		return fmt.Errorf("separate_channels needs at least 2 audio channels, got %d", channels)
+	}
+	if c.MinSpeakers < 0 || c.MinSpeakers > maxSpeakers || c.MaxSpeakers < 0 || c.MaxSpeakers > maxSpeakers {
+		return fmt.Errorf("speaker counts must be between 1 and %d", maxSpeakers)
+	}
+	if c.MinSpeakers > 0 && c.MaxSpeakers > 0 && c.MinSpeakers > c.MaxSpeakers {
+		return fmt.Errorf("min_speakers %d exceeds max_speakers %d", c.MinSpeakers, c.MaxSpeakers)
+	}
+	if (c.MinSpeakers > 0 || c.MaxSpeakers > 0) && !c.Diarization {
+		return fmt.Errorf("speaker counts need diarization")
+	}
+	for speaker, role := range c.Roles {
+		if speaker < 1 || speaker > maxSpeakers {
+			return fmt.Errorf("invalid speaker %d in roles (must be 1-%d)", speaker, maxSpeakers)
+		}
+		if !role.Valid() {
+			return fmt.Errorf("invalid role %q for speaker %d (expected clinician, patient or other)", role, speaker)
+		}
+	}
+	return nil
+}
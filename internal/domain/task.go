@@ -0,0 +1,87 @@
+package domain
+
+import "time"
+
+// TaskKind says what a Task does to its job.
+type TaskKind string
+
+const (
+	// TaskJob runs an upload or reprocessing job from wherever it stopped.
+	TaskJob TaskKind = "job"
+	// TaskSessionTurn extracts a speaker turn of a WebSocket session and
+	// merges it into the session's note and impression.
+	TaskSessionTurn TaskKind = "session_turn"
+	// TaskSessionEnd records the transcript of a finished WebSocket session
+	// and completes its impression.
+	TaskSessionEnd TaskKind = "session_end"
+)
+
+// TaskStatus is where a task is in the work queue.
+type TaskStatus string
+
+const (
+	TaskPending TaskStatus = "pending"
+	TaskRunning TaskStatus = "running"
+	TaskDone    TaskStatus = "done"
+	// TaskDead marks a task that failed permanently or ran out of
+	// attempts. It stays in the queue until an admin retries it.
+	TaskDead TaskStatus = "dead"
+)
+
+// Finished reports whether the task will not run again by itself.
+func (s TaskStatus) Finished() bool {
+	return s == TaskDone || s == TaskDead
+}
+
+// Task is a unit of durable work on a transcription job. It is queued in
+// the same transaction as the data it works on, so work is not lost when a
+// process stops. The tasks of one job run one at a time, in the order they
+// were queued.
+type Task struct {
+	ID     int64      `json:"id"`
+	Kind   TaskKind   `json:"kind"`
+	JobID  string     `json:"job_id"`
+	Status TaskStatus `json:"status"`
+	// Turn and End carry the input of session tasks.
+	Turn *SessionTurn `json:"turn,omitempty"`
+	End  *SessionEnd  `json:"end,omitempty"`
+	// Attempts counts how often the task was claimed. A task that fails
+	// on its MaxAttempts-th attempt is dead-lettered.
+	Attempts    int `json:"attempts"`
+	MaxAttempts int `json:"max_attempts"`
+	// VisibleAt is when a pending task may be claimed, or when the lease
+	// of a running task runs out and another worker may take it over.
+	VisibleAt time.Time `json:"visible_at"`
+	// Worker identifies the worker that last claimed the task.
+	Worker string `json:"worker,omitempty"`
+	// ErrorCode and Error describe the last failure. ErrorCode is the
+	// WebSocket protocol error code reported for session tasks.
+	ErrorCode string    `json:"error_code,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SessionTurn is a speaker turn of a WebSocket session awaiting extraction.
+type SessionTurn struct {
+	// TranscriptSeq is the seq of the last transcript.final event of the
+	// turn.
+	TranscriptSeq uint64 `json:"transcript_seq"`
+	Text          string `json:"text"`
+	// Language is the BCP-47 tag the turn was recognized in, and
+	// SourceLanguage that of most of the session so far, recorded on the
+	// note.
+	Language       string `json:"language"`
+	SourceLanguage string `json:"source_language"`
+	// RecordingID is the session's archived recording, if it is being
+	// archived.
+	RecordingID string `json:"recording_id,omitempty"`
+}
+
+// SessionEnd is the outcome of a finished WebSocket session.
+type SessionEnd struct {
+	Segments    []TranscriptSegment `json:"segments,omitempty"`
+	RecordingID string              `json:"recording_id,omitempty"`
+	// Error is why speech recognition stopped, if it failed.
+	Error string `json:"error,omitempty"`
+}
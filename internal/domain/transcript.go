@@ -0,0 +1,92 @@
+package domain
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Word is a recognized word and where it was spoken.
+type Word struct {
+	Text string `json:"word"`
+	// StartMS and EndMS are offsets from the start of the audio, in
+	// milliseconds.
+	StartMS int64 `json:"start_ms"`
+	EndMS   int64 `json:"end_ms"`
+	// Confidence is the provider's estimate that the word is correct (0-1).
+	// 0 means the provider gave no estimate.
+	Confidence float32 `json:"confidence,omitempty"`
+	// LowConfidence marks words a clinician should review.
+	LowConfidence bool `json:"low_confidence,omitempty"`
+	// Speaker is the diarization speaker tag (from 1), or 0 if unknown.
+	Speaker int `json:"speaker,omitempty"`
+}
+
+// TranscriptSegment is one finalized stretch of a transcript. Segments are
+// numbered from 0 in the order they were spoken. Timing and confidence are
+// only set when the speech provider reports them.
+type TranscriptSegment struct {
+	Index int    `json:"index"`
+	Text  string `json:"text"`
+	// StartMS and EndMS span the segment's words, in milliseconds from the
+	// start of the audio.
+	StartMS    int64   `json:"start_ms"`
+	EndMS      int64   `json:"end_ms"`
+	Confidence float32 `json:"confidence,omitempty"`
+	Words      []Word  `json:"words,omitempty"`
+	// Speaker numbers who spoke the segment (see SpeakerRoles), or is 0
+	// when speakers are not separated; Role is their role.
+	Speaker int         `json:"speaker,omitempty"`
+	Role    SpeakerRole `json:"role,omitempty"`
+	// Language is the BCP-47 tag of the language the segment was
+	// recognized in.
+	Language string `json:"language,omitempty"`
+}
+
+// TranscriptText joins the text of segments with spaces.
+func TranscriptText(segments []TranscriptSegment) string {
+	texts := make([]string, len(segments))
+	for i, s := range segments {
+		texts[i] = s.Text
+	}
+	return strings.Join(texts, " ")
+}
+
+// TranscriptLanguage returns the language most of the transcript's words
+// were recognized in, or fallback if no segment records one.
+func TranscriptLanguage(segments []TranscriptSegment, fallback string) string {
+	words := make(map[string]int)
+	best := ""
+	for _, s := range segments {
+		if s.Language == "" {
+			continue
+		}
+		words[s.Language] += len(strings.Fields(s.Text))
+		if best == "" || words[s.Language] > words[best] {
+			best = s.Language
+		}
+	}
+	if best == "" {
+		return fallback
+	}
+	return best
+}
+
+// DialogueLine renders a segment for entity extraction, prefixed with its
+// speaker's role when known ("Patient: I have a headache").
+func DialogueLine(s TranscriptSegment) string {
+	if s.Role == "" {
+		return s.Text
+	}
+	role := []rune(string(s.Role))
+	role[0] = unicode.ToUpper(role[0])
+	return string(role) + ": " + s.Text
+}
+
+// Dialogue renders segments one line each with DialogueLine.
+func Dialogue(segments []TranscriptSegment) string {
+	lines := make([]string, len(segments))
+	for i, s := range segments {
+		lines[i] = DialogueLine(s)
+	}
+	return strings.Join(lines, "\n")
+}
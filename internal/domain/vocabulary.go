@@ -0,0 +1,207 @@
+package domain
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Limits on recognition vocabulary, following Google's speech adaptation
+// quotas.
+const (
+	MaxVocabularyPhrases = 5000
+	maxPhraseLength      = 100
+	maxPhraseBoost       = 20
+)
+
+// vocabularyNamePattern restricts phrase set and custom class names so they
+// can be used in URLs and "${name}" class references.
+var vocabularyNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// classReferencePattern finds custom class references within a phrase.
+var classReferencePattern = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// Phrase is a word or phrase the recognizer should favour. Boost overrides
+// the phrase set's boost when set.
+type Phrase struct {
+	Value string  `json:"value"`
+	Boost float32 `json:"boost,omitempty"`
+}
+
+// PhraseSet is a named list of phrases, such as a formulary or the names of
+// local clinicians. Phrases may refer to a custom class as "${name}".
+type PhraseSet struct {
+	Name    string   `json:"name"`
+	Phrases []Phrase `json:"phrases"`
+	// Boost (0-20) raises the likelihood of the phrases being recognized;
+	// 0 leaves it to the provider.
+	Boost     float32   `json:"boost,omitempty"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+}
+
+// CustomClass is a named list of interchangeable items, such as drug names,
+// that phrases refer to as "${name}".
+type CustomClass struct {
+	Name      string    `json:"name"`
+	Items     []string  `json:"items"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+}
+
+// Vocabulary is the speech adaptation attached to a recognition request.
+type Vocabulary struct {
+	PhraseSets    []PhraseSet   `json:"phrase_sets"`
+	CustomClasses []CustomClass `json:"custom_classes"`
+}
+
+// validateVocabularyName checks a phrase set or custom class name.
+func validateVocabularyName(kind, name string) error {
+	if !vocabularyNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid %s name %q (lower-case letters, digits, '-' and '_', at most 63)", kind, name)
+	}
+	return nil
+}
+
+// Validate checks the phrase set's name, phrases and boosts.
+func (s PhraseSet) Validate() error {
+	if err := validateVocabularyName("phrase set", s.Name); err != nil {
+		return err
+	}
+	if len(s.Phrases) == 0 || len(s.Phrases) > MaxVocabularyPhrases {
+		return fmt.Errorf("phrase set %s must have 1-%d phrases, got %d", s.Name, MaxVocabularyPhrases, len(s.Phrases))
+	}
+	if s.Boost < 0 || s.Boost > maxPhraseBoost {
+		return fmt.Errorf("phrase set %s: boost %g out of range 0-%d", s.Name, s.Boost, maxPhraseBoost)
+	}
+	for _, p := range s.Phrases {
+		if v := strings.TrimSpace(p.Value); v == "" || len(v) > maxPhraseLength {
+			return fmt.Errorf("phrase set %s: phrase %q must have 1-%d characters", s.Name, p.Value, maxPhraseLength)
+		}
+		if p.Boost < 0 || p.Boost > maxPhraseBoost {
+			return fmt.Errorf("phrase set %s: boost %g for %q out of range 0-%d", s.Name, p.Boost, p.Value, maxPhraseBoost)
+		}
+	}
+	return nil
+}
+
+// ClassReferences returns the names of the custom classes the phrase set's
+// phrases refer to, in order of first use.
+func (s PhraseSet) ClassReferences() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, p := range s.Phrases {
+		for _, m := range classReferencePattern.FindAllStringSubmatch(p.Value, -1) {
+			if !seen[m[1]] {
+				seen[m[1]] = true
+				names = append(names, m[1])
+			}
+		}
+	}
+	return names
+}
+
+// Validate checks the custom class's name and items.
+func (c CustomClass) Validate() error {
+	if err := validateVocabularyName("custom class", c.Name); err != nil {
+		return err
+	}
+	if len(c.Items) == 0 || len(c.Items) > MaxVocabularyPhrases {
+		return fmt.Errorf("custom class %s must have 1-%d items, got %d", c.Name, MaxVocabularyPhrases, len(c.Items))
+	}
+	for _, item := range c.Items {
+		if v := strings.TrimSpace(item); v == "" || len(v) > maxPhraseLength {
+			return fmt.Errorf("custom class %s: item %q must have 1-%d characters", c.Name, item, maxPhraseLength)
+		}
+	}
+	return nil
+}
+
+// Validate checks every phrase set and custom class, that phrases only
+// refer to classes in the vocabulary, and that all the phrases fit in one
+// request.
+func (v Vocabulary) Validate() error {
+	classes := make(map[string]bool)
+	for _, c := range v.CustomClasses {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+		classes[c.Name] = true
+	}
+	phrases := 0
+	for _, s := range v.PhraseSets {
+		if err := s.Validate(); err != nil {
+			return err
+		}
+		for _, name := range s.ClassReferences() {
+			if !classes[name] {
+				return fmt.Errorf("phrase set %s refers to unknown custom class %q", s.Name, name)
+			}
+		}
+		phrases += len(s.Phrases)
+	}
+	if phrases > MaxVocabularyPhrases {
+		return fmt.Errorf("vocabulary has %d phrases, more than the %d allowed per request", phrases, MaxVocabularyPhrases)
+	}
+	return nil
+}
+
+// Select returns the named phrase sets, or all of them if names is empty,
+// with the custom classes they refer to. It fails if a name is unknown.
+func (v Vocabulary) Select(names []string) (Vocabulary, error) {
+	sets := make(map[string]PhraseSet, len(v.PhraseSets))
+	for _, s := range v.PhraseSets {
+		sets[s.Name] = s
+	}
+	var selected Vocabulary
+	if len(names) == 0 {
+		selected.PhraseSets = v.PhraseSets
+	}
+	for _, name := range names {
+		s, ok := sets[name]
+		if !ok {
+			return Vocabulary{}, fmt.Errorf("unknown phrase set %q", name)
+		}
+		selected.PhraseSets = append(selected.PhraseSets, s)
+	}
+
+	used := make(map[string]bool)
+	for _, s := range selected.PhraseSets {
+		for _, name := range s.ClassReferences() {
+			used[name] = true
+		}
+	}
+	for _, c := range v.CustomClasses {
+		if used[c.Name] {
+			selected.CustomClasses = append(selected.CustomClasses, c)
+		}
+	}
+	return selected, nil
+}
+
+// Merge returns v with the phrase sets and custom classes of override
+// added, replacing those with the same name.
+func (v Vocabulary) Merge(override Vocabulary) Vocabulary {
+	var merged Vocabulary
+	replaced := make(map[string]bool)
+	for _, s := range override.PhraseSets {
+		replaced[s.Name] = true
+	}
+	for _, s := range v.PhraseSets {
+		if !replaced[s.Name] {
+			merged.PhraseSets = append(merged.PhraseSets, s)
+		}
+	}
+	merged.PhraseSets = append(merged.PhraseSets, override.PhraseSets...)
+
+	replaced = make(map[string]bool)
+	for _, c := range override.CustomClasses {
+		replaced[c.Name] = true
+	}
+	for _, c := range v.CustomClasses {
+		if !replaced[c.Name] {
+			merged.CustomClasses = append(merged.CustomClasses, c)
+		}
+	}
+	merged.CustomClasses = append(merged.CustomClasses, override.CustomClasses...)
+	return merged
+}
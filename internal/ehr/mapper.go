@@ -9,8 +9,29 @@ import (
 	"github.com/samply/golang-fhir-models/fhir-models/fhir"
 )
 
-// MapToFHIR converts a domain ClinicalNote into a FHIR R4 ClinicalImpression.
-func MapToFHIR(note domain.ClinicalNote) (*fhir.ClinicalImpression, error) {
+// SourceLanguageExtensionURL identifies the ClinicalImpression extension
+// holding the BCP-47 tag (valueCode) of the conversation the impression was
+// extracted from. The impression itself is always in English.
+const SourceLanguageExtensionURL = "http://clinical-agent-backend/fhir/StructureDefinition/source-language"
+
+// AudioRecordingExtensionURL identifies the ClinicalImpression extension
+// holding the ID (valueString) of the archived recording the impression was
+// extracted from.
+const AudioRecordingExtensionURL = "http://clinical-agent-backend/fhir/StructureDefinition/audio-recording"
+
+// VersionExtensionURL identifies the ClinicalImpression extension holding
+// the version (valueInteger) of a reprocessed impression. Impressions
+// without it are the first version.
+const VersionExtensionURL = "http://clinical-agent-backend/fhir/StructureDefinition/impression-version"
+
+// JobExtensionURL identifies the ClinicalImpression extension holding the
+// ID (valueString) of the transcription job that produced the impression.
+// A job produces at most one impression.
+const JobExtensionURL = "http://clinical-agent-backend/fhir/StructureDefinition/transcription-job"
+
+// MapToFHIR converts a domain ClinicalNote into a FHIR R4 ClinicalImpression,
+// linking it to the patient, encounter and practitioner in encounter.
+func MapToFHIR(note domain.ClinicalNote, encounter domain.EncounterContext) (*fhir.ClinicalImpression, error) {
 	now := time.Now().Format(time.RFC3339)
 	status := fhir.ClinicalImpressionStatusCompleted
 
@@ -21,6 +42,25 @@ func MapToFHIR(note domain.ClinicalNote) (*fhir.ClinicalImpression, error) {
 		Finding: make([]fhir.ClinicalImpressionFinding, 0),
 	}
 
+	// The note is in English; record the language it was translated from.
+	impression.Language = errorsStringPtr("en")
+	if note.SourceLanguage != "" {
+		impression.Extension = append(impression.Extension, fhir.Extension{
+			Url:       SourceLanguageExtensionURL,
+			ValueCode: errorsStringPtr(note.SourceLanguage),
+		})
+	}
+
+	if encounter.PatientReference != "" {
+		impression.Subject = fhir.Reference{Reference: errorsStringPtr(encounter.PatientReference)}
+	}
+	if encounter.EncounterReference != "" {
+		impression.Encounter = &fhir.Reference{Reference: errorsStringPtr(encounter.EncounterReference)}
+	}
+	if encounter.PractitionerReference != "" {
+		impression.Assessor = &fhir.Reference{Reference: errorsStringPtr(encounter.PractitionerReference)}
+	}
+
 	// Map Symptoms to Findings
 	for _, symptom := range note.Symptoms {
 		item := fhir.Reference{
@@ -49,6 +89,58 @@ func MapToFHIR(note domain.ClinicalNote) (*fhir.ClinicalImpression, error) {
 	return impression, nil
 }
 
+// LinkRecording records on impression the ID of the archived audio it was
+// extracted from.
+func LinkRecording(impression *fhir.ClinicalImpression, recordingID string) {
+	impression.Extension = append(impression.Extension, fhir.Extension{
+		Url:         AudioRecordingExtensionURL,
+		ValueString: errorsStringPtr(recordingID),
+	})
+}
+
+// RecordingID returns the ID of the archived audio linked to impression, or
+// "" if there is none.
+func RecordingID(impression *fhir.ClinicalImpression) string {
+	for _, ext := range impression.Extension {
+		if ext.Url == AudioRecordingExtensionURL && ext.ValueString != nil {
+			return *ext.ValueString
+		}
+	}
+	return ""
+}
+
+// LinkJob records on impression the ID of the transcription job that
+// produced it, so saving it again for the same job is recognized.
+func LinkJob(impression *fhir.ClinicalImpression, jobID string) {
+	impression.Extension = append(impression.Extension, fhir.Extension{
+		Url:         JobExtensionURL,
+		ValueString: errorsStringPtr(jobID),
+	})
+}
+
+// JobID returns the ID of the transcription job linked to impression, or ""
+// if there is none.
+func JobID(impression *fhir.ClinicalImpression) string {
+	for _, ext := range impression.Extension {
+		if ext.Url == JobExtensionURL && ext.ValueString != nil {
+			return *ext.ValueString
+		}
+	}
+	return ""
+}
+
+// LinkVersion marks impression as version of an encounter's note,
+// succeeding the impression with ID previousID if there is one.
+func LinkVersion(impression *fhir.ClinicalImpression, previousID string, version int) {
+	if previousID != "" {
+		impression.Previous = &fhir.Reference{Reference: errorsStringPtr("ClinicalImpression/" + previousID)}
+	}
+	impression.Extension = append(impression.Extension, fhir.Extension{
+		Url:          VersionExtensionURL,
+		ValueInteger: &version,
+	})
+}
+
 func errorsStringPtr(s string) *string {
 	return &s
 }
@@ -0,0 +1,250 @@
+package ingestion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+
+	"clinical-agent-backend/internal/archive"
+	"clinical-agent-backend/internal/audio"
+	"clinical-agent-backend/internal/domain"
+	"clinical-agent-backend/internal/intelligence"
+	"clinical-agent-backend/internal/repository"
+)
+
+// WithArchive keeps an encrypted copy of the audio of every WebSocket
+// session and upload in a, linked from the impressions extracted from it.
+// By default audio is discarded once transcribed.
+func WithArchive(a *archive.Archive) Option {
+	return func(h *Handler) { h.archive = a }
+}
+
+// sessionRecording archives a WebSocket session's audio as it arrives.
+// Archiving must not interrupt a consultation, so a failure is logged and
+// the rest of the audio goes unrecorded.
+type sessionRecording struct {
+	id     string
+	w      *archive.Writer
+	failed atomic.Bool
+}
+
+// startRecording starts archiving the session's audio, or returns nil if
+// there is no archive or archiving cannot start.
+func (h *Handler) startRecording(session *wsSession) *sessionRecording {
+	if h.archive == nil {
+		return nil
+	}
+	w, err := h.archive.Create(context.Background(), domain.AudioRecording{
+		ID:        session.id,
+		Source:    domain.RecordingFromSession,
+		Encounter: session.encounter,
+		Audio:     jobAudio(session.cfg),
+	})
+	if err != nil {
+		log.Printf("Session %s: failed to start archiving audio: %v", session.id, err)
+		return nil
+	}
+	return &sessionRecording{id: session.id, w: w}
+}
+
+// write archives audio. Calls must not overlap.
+func (r *sessionRecording) write(data []byte) {
+	if r == nil || r.failed.Load() {
+		return
+	}
+	if _, err := r.w.Write(data); err != nil {
+		log.Printf("Session %s: failed to archive audio, the rest is not recorded: %v", r.id, err)
+		r.failed.Store(true)
+		r.w.Abort()
+	}
+}
+
+// finish stores the recording once the audio has ended.
+func (r *sessionRecording) finish() {
+	if r == nil || r.failed.Load() {
+		return
+	}
+	rec, err := r.w.Close(context.Background())
+	if err != nil {
+		log.Printf("Session %s: %v", r.id, err)
+		r.failed.Store(true)
+		return
+	}
+	log.Printf("Session %s: archived %d bytes of audio (sha256 %s)", r.id, rec.Size, rec.SHA256)
+}
+
+// abort discards a recording whose session never started.
+func (r *sessionRecording) abort() {
+	if r != nil && !r.failed.Swap(true) {
+		r.w.Abort()
+	}
+}
+
+// recordingID returns the ID impressions should link to, or "" if the
+// audio is not being archived.
+func (r *sessionRecording) recordingID() string {
+	if r == nil || r.failed.Load() {
+		return ""
+	}
+	return r.id
+}
+
+// archiveUpload copies audioStream into the archive as recording id while
+// passing it on to dst.
+func (h *Handler) archiveUpload(ctx context.Context, id string, cfg intelligence.StreamConfig, encounter domain.EncounterContext, dst io.Writer, audioStream io.Reader) error {
+	w, err := h.archive.Create(ctx, domain.AudioRecording{
+		ID:        id,
+		Source:    domain.RecordingFromUpload,
+		Encounter: encounter,
+		Audio:     jobAudio(cfg),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.MultiWriter(dst, w), audioStream); err != nil {
+		w.Abort()
+		return err
+	}
+	_, err = w.Close(ctx)
+	return err
+}
+
+// HandleListRecordings handles GET /admin/recordings, optionally filtered by
+// the patient_reference and encounter_reference query parameters.
+func (h *Handler) HandleListRecordings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorizeAdmin(w, r) || !h.archiveEnabled(w) {
+		return
+	}
+
+	query := r.URL.Query()
+	recordings, err := h.archive.Recordings(r.Context(), repository.RecordingFilter{
+		PatientReference:   query.Get("patient_reference"),
+		EncounterReference: query.Get("encounter_reference"),
+	})
+	if err != nil {
+		log.Printf("Failed to fetch recordings: %v", err)
+		http.Error(w, "Failed to fetch recordings", http.StatusInternalServerError)
+		return
+	}
+	if recordings == nil {
+		recordings = []*domain.AudioRecording{}
+	}
+	writeJSON(w, http.StatusOK, recordings)
+}
+
+// HandleGetRecording handles GET /admin/recordings/{id}, returning the
+// recording's metadata.
+func (h *Handler) HandleGetRecording(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorizeAdmin(w, r) || !h.archiveEnabled(w) {
+		return
+	}
+
+	rec, err := h.archive.Recording(r.Context(), r.PathValue("id"))
+	if errors.Is(err, repository.ErrNotFound) {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to fetch recording: %v", err)
+		http.Error(w, "Failed to fetch recording", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, rec)
+}
+
+// HandleRecordingAudio handles GET /admin/recordings/{id}/audio, streaming
+// the decrypted audio. Range requests are supported. LINEAR16 and MULAW
+// audio is served as WAV.
+func (h *Handler) HandleRecordingAudio(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorizeAdmin(w, r) || !h.archiveEnabled(w) {
+		return
+	}
+
+	rec, reader, err := h.archive.Open(r.Context(), r.PathValue("id"))
+	if errors.Is(err, repository.ErrNotFound) {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to open recording: %v", err)
+		http.Error(w, "Failed to open recording", http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+	log.Printf("Recording %s requested by %s (range %q)", rec.ID, r.RemoteAddr, r.Header.Get("Range"))
+
+	contentType, ext, header := recordingContent(rec)
+	content := io.NewSectionReader(prefixedReader{prefix: header, r: reader}, 0, int64(len(header))+rec.Size)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", rec.ID+ext))
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Set("ETag", `"`+rec.SHA256+`"`)
+	w.Header().Set("X-Audio-SHA256", rec.SHA256)
+	http.ServeContent(w, r, "", rec.CreatedAt, content)
+}
+
+// archiveEnabled answers the request if there is no archive.
+func (h *Handler) archiveEnabled(w http.ResponseWriter) bool {
+	if h.archive == nil {
+		http.Error(w, "audio archiving is disabled", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// recordingContent returns the content type and file extension to serve a
+// recording's audio with, and the header to put before it. Raw audio gets a
+// WAV header so it plays.
+func recordingContent(rec *domain.AudioRecording) (contentType, ext string, header []byte) {
+	wav := audio.WAVHeader{SampleRate: rec.Audio.SampleRateHertz, Channels: rec.Audio.Channels, DataSize: rec.Size}
+	switch rec.Audio.Encoding {
+	case intelligence.EncodingLinear16:
+		return "audio/wav", ".wav", audio.EncodeWAVHeader(wav)
+	case intelligence.EncodingMulaw:
+		wav.MuLaw = true
+		return "audio/wav", ".wav", audio.EncodeWAVHeader(wav)
+	case intelligence.EncodingFLAC:
+		return "audio/flac", ".flac", nil
+	case intelligence.EncodingOggOpus:
+		return "audio/ogg", ".ogg", nil
+	case intelligence.EncodingWebmOpus:
+		return "audio/webm", ".webm", nil
+	case intelligence.EncodingMP4AAC:
+		return "audio/mp4", ".m4a", nil
+	}
+	return "application/octet-stream", "", nil
+}
+
+// prefixedReader reads prefix followed by the contents of r.
+type prefixedReader struct {
+	prefix []byte
+	r      io.ReaderAt
+}
+
+func (p prefixedReader) ReadAt(b []byte, off int64) (int, error) {
+	n := 0
+	if off < int64(len(p.prefix)) {
+		n = copy(b, p.prefix[off:])
+		if n == len(b) {
+			return n, nil
+		}
+	}
+	m, err := p.r.ReadAt(b[n:], max(0, off-int64(len(p.prefix))))
+	return n + m, err
+}
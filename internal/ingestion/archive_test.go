@@ -0,0 +1,136 @@
+package ingestion
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"clinical-agent-backend/internal/archive"
+	"clinical-agent-backend/internal/audio"
+	"clinical-agent-backend/internal/domain"
+	"clinical-agent-backend/internal/ehr"
+	"clinical-agent-backend/internal/protocol"
+	"clinical-agent-backend/internal/repository"
+
+	"github.com/gorilla/websocket"
+)
+
+// withTestArchive archives the handler's audio in a temporary directory and
+// enables the admin API with token "secret".
+func withTestArchive(t *testing.T, handler *Handler) *http.ServeMux {
+	t.Helper()
+	store, err := archive.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v", err)
+	}
+	a, err := archive.New(store, bytes.Repeat([]byte{1}, archive.KeySize), repository.NewMemoryRecordingRepository(), 0)
+	if err != nil {
+		t.Fatalf("archive.New failed: %v", err)
+	}
+	WithArchive(a)(handler)
+	WithAdminToken("secret")(handler)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/recordings", handler.HandleListRecordings)
+	mux.HandleFunc("/admin/recordings/{id}", handler.HandleGetRecording)
+	mux.HandleFunc("/admin/recordings/{id}/audio", handler.HandleRecordingAudio)
+	return mux
+}
+
+func TestServeWS_ArchivesAudio(t *testing.T) {
+	handler, repo := newTestHandler(t, "Patient reports a headache.")
+	mux := withTestArchive(t, handler)
+	conn := dialWS(t, handler)
+
+	sent := pcmAudio(500*time.Millisecond, true)
+	sendControl(t, conn, protocol.TypeStart, protocol.StartPayload{
+		Audio:     protocol.AudioConfig{Encoding: "LINEAR16", SampleRateHertz: 16000, LanguageCode: "en-US"},
+		Encounter: domain.EncounterContext{PatientReference: "Patient/123"},
+	})
+	conn.WriteMessage(websocket.BinaryMessage, sent[:6000])
+	conn.WriteMessage(websocket.BinaryMessage, sent[6000:])
+	sendControl(t, conn, protocol.TypeEnd, nil)
+
+	events := readEvents(t, conn)
+	var started protocol.SessionStartedPayload
+	if len(events) == 0 || events[0].DecodePayload(&started) != nil || started.RecordingID != started.SessionID {
+		t.Fatalf("expected session.started to name the recording, got %+v", started)
+	}
+	impressions, _ := repo.FindAll(context.Background())
+	if len(impressions) != 1 || ehr.RecordingID(impressions[0]) != started.SessionID {
+		t.Fatalf("expected one impression linked to the recording, got %d", len(impressions))
+	}
+
+	if rec := adminRequest(mux, http.MethodGet, "/admin/recordings/"+started.SessionID+"/audio", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a token, got %d", rec.Code)
+	}
+
+	rec := adminRequest(mux, http.MethodGet, "/admin/recordings?patient_reference=Patient/123", "secret", "")
+	var recordings []domain.AudioRecording
+	json.Unmarshal(rec.Body.Bytes(), &recordings)
+	sum := sha256.Sum256(sent)
+	if len(recordings) != 1 || recordings[0].Source != domain.RecordingFromSession ||
+		recordings[0].Size != int64(len(sent)) || recordings[0].SHA256 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("unexpected recordings: %s", rec.Body.String())
+	}
+
+	rec = adminRequest(mux, http.MethodGet, "/admin/recordings/"+started.SessionID+"/audio", "secret", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "audio/wav" {
+		t.Fatalf("expected a WAV download, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	body := bytes.NewReader(rec.Body.Bytes())
+	header, err := audio.ReadWAVHeader(body)
+	rest, _ := io.ReadAll(body)
+	if err != nil || header.SampleRate != 16000 || header.DataSize != int64(len(sent)) || !bytes.Equal(rest, sent) {
+		t.Errorf("expected the session audio behind a WAV header, got %+v (%v)", header, err)
+	}
+
+	// Ranges count the WAV header.
+	req := httptest.NewRequest(http.MethodGet, "/admin/recordings/"+started.SessionID+"/audio", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Range", "bytes=1044-1143")
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPartialContent || !bytes.Equal(rec.Body.Bytes(), sent[1000:1100]) {
+		t.Errorf("expected 100 bytes of partial content, got %d with %d bytes", rec.Code, rec.Body.Len())
+	}
+}
+
+func TestHandleUpload_ArchivesAudio(t *testing.T) {
+	handler, repo := newTestHandler(t, "Patient reports a headache.")
+	mux := withTestArchive(t, handler)
+
+	raw, err := os.ReadFile("../../sample.wav")
+	if err != nil {
+		t.Fatalf("failed to read sample.wav: %v", err)
+	}
+	job := waitForJob(t, handler, decodeJob(t, postUpload(t, handler, "sample.wav", raw)).ID)
+	if job.Status != domain.JobSaved {
+		t.Fatalf("expected job to be saved, got %s (%s)", job.Status, job.Error)
+	}
+	impression, err := repo.FindByID(context.Background(), job.ImpressionID)
+	if err != nil || ehr.RecordingID(impression) != job.ID {
+		t.Fatalf("expected the impression to link to recording %s (%v)", job.ID, err)
+	}
+
+	rec := adminRequest(mux, http.MethodGet, "/admin/recordings/"+job.ID, "secret", "")
+	var recording domain.AudioRecording
+	if err := json.Unmarshal(rec.Body.Bytes(), &recording); err != nil || recording.Source != domain.RecordingFromUpload {
+		t.Fatalf("unexpected recording: %s", rec.Body.String())
+	}
+	rec = adminRequest(mux, http.MethodGet, "/admin/recordings/"+job.ID+"/audio", "secret", "")
+	if rec.Code != http.StatusOK || !bytes.HasSuffix(raw, rec.Body.Bytes()[44:]) {
+		t.Errorf("expected the uploaded samples behind a WAV header, got %d with %d bytes", rec.Code, rec.Body.Len())
+	}
+	if rec := adminRequest(mux, http.MethodGet, "/admin/recordings/missing/audio", "secret", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown recording, got %d", rec.Code)
+	}
+}
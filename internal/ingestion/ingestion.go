@@ -2,15 +2,25 @@ package ingestion
 
 import (
 	"context"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"sync/atomic"
-
-	"clinical-agent-backend/internal/ehr"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"clinical-agent-backend/internal/archive"
+	"clinical-agent-backend/internal/audio"
+	"clinical-agent-backend/internal/domain"
 	"clinical-agent-backend/internal/intelligence"
+	"clinical-agent-backend/internal/protocol"
 	"clinical-agent-backend/internal/repository"
 
 	"github.com/gorilla/websocket"
@@ -24,21 +34,109 @@ var upgrader = websocket.Upgrader{
 
 // Handler manages WebSocket connections for audio ingestion.
 type Handler struct {
-	sttClient *intelligence.STTClient
-	llmClient *intelligence.LLMClient
-	repo      *repository.ClinicalImpressionRepository
+	sttClient intelligence.Transcriber
+	extractor intelligence.EntityExtractor
+	repo      repository.ClinicalImpressionRepository
+
+	requireStart bool
+	sessions     *sessionManager
+	// vad is the voice activity detection applied to sessions by default,
+	// or nil to leave it off unless a session asks for it.
+	vad *audio.VADConfig
+
+	// Upload transcription jobs; see jobs.go.
+	jobs     repository.TranscriptionJobRepository
+	spoolDir string
+
+	// Work queue workers; see tasks.go.
+	workerID     string
+	workReady    chan struct{}
+	taskAttempts int
+	taskBackoff  time.Duration
+	workers      sync.WaitGroup
+
+	// Graceful shutdown; see shutdown.go. Workers and sessions run their
+	// tasks under tasks, which is cancelled when Shutdown stops waiting.
+	draining       chan struct{}
+	drainOnce      sync.Once
+	tasks          context.Context
+	interruptTasks context.CancelFunc
+
+	// Resumable uploads; see uploads.go.
+	maxUploadSize int64
+	uploadsMu     sync.Mutex
+	uploadsBusy   map[string]bool
+
+	// Recognition vocabulary and its admin API; see vocabulary.go.
+	vocabulary     repository.VocabularyRepository
+	baseVocabulary domain.Vocabulary
+	adminToken     string
+
+	// Audio archive, or nil to discard audio; see archive.go.
+	archive *archive.Archive
+}
+
+// Option configures optional Handler behaviour.
+type Option func(*Handler)
+
+// WithRequireStart rejects WebSocket sessions whose first frame is not a
+// start message. By default, sessions that open with audio use the
+// default stream configuration.
+func WithRequireStart(require bool) Option {
+	return func(h *Handler) { h.requireStart = require }
+}
+
+// WithResumeGracePeriod sets how long a WebSocket session is kept after its
+// connection drops, waiting for the client to reconnect. The default is two
+// minutes.
+func WithResumeGracePeriod(d time.Duration) Option {
+	return func(h *Handler) { h.sessions.grace = d }
+}
+
+// WithVAD turns on voice activity detection for WebSocket sessions with
+// LINEAR16 or MULAW audio, unless they opt out. Long silences are then not
+// sent to STT, and transcripts are extracted a speaker turn at a time.
+func WithVAD(cfg audio.VADConfig) Option {
+	return func(h *Handler) { h.vad = &cfg }
 }
 
 // NewHandler creates a new Ingestion Handler.
-func NewHandler(stt *intelligence.STTClient, llm *intelligence.LLMClient, repo *repository.ClinicalImpressionRepository) *Handler {
-	return &Handler{
+func NewHandler(stt intelligence.Transcriber, extractor intelligence.EntityExtractor, repo repository.ClinicalImpressionRepository, opts ...Option) *Handler {
+	h := &Handler{
 		sttClient: stt,
-		llmClient: llm,
+		extractor: extractor,
 		repo:      repo,
+		sessions:  newSessionManager(defaultResumeGrace),
+
+		jobs:     repository.NewMemoryJobRepository(),
+		spoolDir: filepath.Join(os.TempDir(), "clinical-agent-jobs"),
+
+		workerID:     newWorkerID(),
+		workReady:    make(chan struct{}, 1),
+		taskAttempts: defaultTaskAttempts,
+		taskBackoff:  defaultTaskBackoff,
+
+		draining: make(chan struct{}),
+
+		maxUploadSize: defaultMaxUploadSize,
+		uploadsBusy:   make(map[string]bool),
+
+		vocabulary: repository.NewMemoryVocabularyRepository(),
+	}
+	h.tasks, h.interruptTasks = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(h)
 	}
+	return h
 }
 
 // ServeWS handles incoming WebSocket connections.
+//
+// Clients speak the JSON protocol in internal/protocol: text frames carry
+// control messages and server events, binary frames carry audio. A session
+// outlives its connection: the STT stream and extraction keep running if
+// the client drops, and a new connection may resume the session with the
+// token issued at start.
 func (h *Handler) ServeWS(w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -49,125 +147,462 @@ func (h *Handler) ServeWS(w http.ResponseWriter, r *http.Request) {
 
 	log.Println("Client connected for audio ingestion")
 
-	// Create a pipe to stream audio from WebSocket to STT
-	pr, pw := io.Pipe()
+	// No audio is accepted until the session parameters are validated.
+	session, firstAudio, ok := h.handshake(conn)
+	if !ok {
+		return
+	}
+	if len(firstAudio) > 0 && !session.writeAudio(0, firstAudio) {
+		return
+	}
+	h.readLoop(session, conn)
+}
+
+// handshake reads the first frame of a connection and binds it to a session.
+// A start message is validated and opens a new session; a reconnect message
+// reattaches the connection to an existing one and replays missed events.
+// A binary frame opens a session with the default configuration unless a
+// start message is required, and is returned so it can be forwarded to STT.
+// On failure the client is told why and the connection is closed.
+func (h *Handler) handshake(conn *websocket.Conn) (*wsSession, []byte, bool) {
+	messageType, data, err := conn.ReadMessage()
+	if err != nil {
+		log.Printf("Websocket read error before start: %v", err)
+		return nil, nil, false
+	}
 
-	// Atomic flag to signal shutdown
-	var isClosing int32
+	reject := func(code, message string) (*wsSession, []byte, bool) {
+		rejectConn(conn, code, message)
+		return nil, nil, false
+	}
 
-	// Goroutine to read from WebSocket and write to Pipe
-	go func() {
-		defer pw.Close()
-		for {
-			messageType, data, err := conn.ReadMessage()
-			if err != nil {
-				// If we are closing, this error is expected
-				if atomic.LoadInt32(&isClosing) == 1 {
-					return
-				}
-				// Filter out "close 1000 (normal)" or similar non-error closes if needed,
-				// but primarily we want to suppress the "use of closed network connection"
-				// which happens when the server closes the connection due to STT timeout.
-				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
-					log.Printf("Websocket closed by client: %v", err)
-					return
-				}
-				log.Printf("Websocket read error: %v", err)
-				return
-			}
-			if messageType == websocket.TextMessage {
-				if string(data) == "EOF" {
-					log.Println("Received EOF from client, finishing audio stream")
-					// Send Close frame to acknowledge clean shutdown
-					closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "Client initiated EOF")
-					if werr := conn.WriteMessage(websocket.CloseMessage, closeMsg); werr != nil {
-						log.Printf("Failed to write close message on EOF: %v", werr)
-					}
-					return
-				}
-			}
-			if messageType == websocket.BinaryMessage {
-				if _, err := pw.Write(data); err != nil {
-					log.Printf("Pipe write error: %v", err)
-					return
-				}
-			}
+	// A draining server still lets clients reconnect to its sessions, to
+	// collect their last results, but starts no new ones.
+	const drainingMessage = "the server is shutting down; start the session on another server"
+	if messageType == websocket.BinaryMessage {
+		if h.isDraining() {
+			return reject(protocol.ErrCodeServerDraining, drainingMessage)
 		}
-	}()
+		if h.requireStart {
+			return reject(protocol.ErrCodeStartRequired, "a start message is required before audio")
+		}
+		log.Println("Client sent audio without start; using default stream configuration")
+		session := newWSSession(conn)
+		h.setupVAD(session, nil)
+		session.recording = h.startRecording(session)
+		h.startSession(session)
+		return session, data, true
+	}
 
-	// Stream audio to STT
-	transcripts, errs := h.sttClient.StreamTranscribe(r.Context(), pr)
+	env, err := protocol.Decode(data)
+	if err != nil {
+		return reject(decodeErrorCode(err), err.Error())
+	}
+	switch env.Type {
+	case protocol.TypeStart:
+		if h.isDraining() {
+			return reject(protocol.ErrCodeServerDraining, drainingMessage)
+		}
+	case protocol.TypeReconnect:
+		return h.reconnect(conn, env)
+	default:
+		return reject(protocol.ErrCodeStartRequired, fmt.Sprintf("expected start message, got %q", env.Type))
+	}
 
-	// Process transcripts
-	go func() {
-		for transcript := range transcripts {
-			log.Printf("Transcript: %s", transcript)
+	var payload protocol.StartPayload
+	if err := env.DecodePayload(&payload); err != nil {
+		return reject(protocol.ErrCodeBadMessage, err.Error())
+	}
 
-			// Send transcript back to client
-			if err := conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("Transcript: %s", transcript))); err != nil {
-				log.Printf("Websocket write error: %v", err)
-			}
+	cfg := intelligence.StreamConfig{
+		Encoding:                 payload.Audio.Encoding,
+		SampleRateHertz:          payload.Audio.SampleRateHertz,
+		Channels:                 payload.Audio.Channels,
+		LanguageCode:             payload.Audio.LanguageCode,
+		AlternativeLanguageCodes: payload.Audio.AlternativeLanguageCodes,
+		Model:                    payload.Recognition.Model,
+		UseEnhanced:              payload.Recognition.UseEnhanced,
+		PhraseSets:               payload.Recognition.PhraseSets,
+		Speakers:                 payload.Speakers,
+	}.WithDefaults()
+	if err := h.checkStreamConfig(context.Background(), &cfg); err != nil {
+		return reject(protocol.ErrCodeInvalidConfig, err.Error())
+	}
+	if err := payload.Encounter.Validate(); err != nil {
+		return reject(protocol.ErrCodeInvalidConfig, err.Error())
+	}
 
-			// Async Entity Extraction (Fire and Forget for now)
-			go func(text string) {
-				note, err := h.llmClient.ExtractEntities(context.Background(), text)
-				if err != nil {
-					log.Printf("Entity extraction failed: %v", err)
-					return
-				}
-				log.Printf("Extracted Clinical Note: %+v", note)
+	session := newWSSession(conn)
+	session.cfg = cfg
+	session.transcript = intelligence.TranscriptAssembler{Roles: cfg.Speakers.Roles, Language: cfg.LanguageCode}
+	session.encounter = payload.Encounter
+	session.sequencedAudio = payload.SequencedAudio
+	if err := h.setupVAD(session, payload.VAD); err != nil {
+		return reject(protocol.ErrCodeInvalidConfig, err.Error())
+	}
+	if payload.InterimResults != nil {
+		session.interimResults.Store(*payload.InterimResults)
+	}
+	session.recording = h.startRecording(session)
+
+	if _, err := session.send(protocol.TypeSessionStarted, protocol.SessionStartedPayload{
+		SessionID:       session.id,
+		ProtocolVersion: protocol.Version,
+		Audio: protocol.AudioConfig{
+			Encoding:                 cfg.Encoding,
+			SampleRateHertz:          cfg.SampleRateHertz,
+			Channels:                 cfg.Channels,
+			LanguageCode:             cfg.LanguageCode,
+			AlternativeLanguageCodes: cfg.AlternativeLanguageCodes,
+		},
+		Speakers:           cfg.Speakers,
+		Recognition:        recognitionPayload(cfg),
+		VAD:                session.vad != nil,
+		RecordingID:        session.recording.recordingID(),
+		ResumeToken:        session.token,
+		ResumeGraceSeconds: int(h.sessions.grace / time.Second),
+	}); err != nil {
+		log.Printf("Websocket write error: %v", err)
+		session.recording.abort()
+		return nil, nil, false
+	}
+	log.Printf("Session %s started: %s %d Hz x%d %s, patient=%q encounter=%q",
+		session.id, cfg.Encoding, cfg.SampleRateHertz, cfg.Channels, cfg.LanguageCode,
+		session.encounter.PatientReference, session.encounter.EncounterReference)
+	h.startSession(session)
+	return session, nil, true
+}
 
-				// Map to FHIR
-				fhirResource, err := ehr.MapToFHIR(*note)
-				if err != nil {
-					log.Printf("FHIR mapping failed: %v", err)
-					return
-				}
+// turnText renders a speaker turn as one line of dialogue.
+func turnText(turn []domain.TranscriptSegment) string {
+	texts := make([]string, len(turn))
+	for i, segment := range turn {
+		texts[i] = segment.Text
+	}
+	return domain.DialogueLine(domain.TranscriptSegment{Text: strings.Join(texts, " "), Role: turn[0].Role})
+}
 
-				// Serialize to JSON for logging
-				fhirJSON, _ := json.MarshalIndent(fhirResource, "", "  ")
-				log.Printf("Generated FHIR ClinicalImpression:\n%s", string(fhirJSON))
+// checkStreamConfig attaches the requested vocabulary to cfg, validates it
+// and checks that the transcriber can handle its encoding, natively or by
+// transcoding.
+func (h *Handler) checkStreamConfig(ctx context.Context, cfg *intelligence.StreamConfig) error {
+	if err := h.attachVocabulary(ctx, cfg); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	return h.checkEncoding(cfg.Encoding)
+}
 
-				// Save to Database
-				if err := h.repo.Save(context.Background(), fhirResource); err != nil {
-					log.Printf("Failed to save clinical impression to DB: %v", err)
-					return
-				}
-				log.Println("Successfully saved Clinical Impression to DB")
+// checkEncoding checks that the transcriber can handle encoding, natively
+// or by transcoding.
+func (h *Handler) checkEncoding(encoding string) error {
+	if es, ok := h.sttClient.(intelligence.EncodingSupport); ok && !es.SupportsEncoding(encoding) {
+		return fmt.Errorf("encoding %s is not supported by this server's speech provider", encoding)
+	}
+	return nil
+}
 
-			}(transcript)
+// setupVAD turns on voice activity detection for the session if it asks for
+// it, or if the server enables it by default and the session does not opt
+// out. Only raw audio can be analyzed: a session asking for detection on
+// other encodings is an error, while the server default skips them.
+func (h *Handler) setupVAD(session *wsSession, requested *bool) error {
+	cfg := session.cfg
+	raw := cfg.Encoding == intelligence.EncodingLinear16 || cfg.Encoding == intelligence.EncodingMulaw
+	vad := h.vad
+	if requested != nil {
+		if !*requested {
+			return nil
 		}
+		if !raw {
+			return fmt.Errorf("voice activity detection needs LINEAR16 or MULAW audio, not %s", cfg.Encoding)
+		}
+		if vad == nil {
+			vad = &audio.VADConfig{}
+		}
+	}
+	if vad == nil || !raw {
+		return nil
+	}
+	format := audio.Format{SampleRate: cfg.SampleRateHertz, Channels: cfg.Channels}
+	session.vad = audio.NewVAD(*vad, format, cfg.Encoding == intelligence.EncodingMulaw)
+	session.speech = make(chan audio.VADEvent, speechEventBuffer)
+	return nil
+}
+
+// reconnect attaches conn to the session named by a reconnect message. If the
+// session has already finished, its remaining events are replayed and the
+// connection is closed.
+func (h *Handler) reconnect(conn *websocket.Conn, env *protocol.Envelope) (*wsSession, []byte, bool) {
+	var payload protocol.ReconnectPayload
+	if err := env.DecodePayload(&payload); err != nil {
+		rejectConn(conn, protocol.ErrCodeBadMessage, err.Error())
+		return nil, nil, false
+	}
+	session := h.sessions.get(payload.ResumeToken)
+	if session == nil {
+		rejectConn(conn, protocol.ErrCodeSessionNotFound, "unknown or expired resume token")
+		return nil, nil, false
+	}
+	log.Printf("Session %s resumed from event %d", session.id, payload.LastEventSeq)
+	if !session.attach(conn, payload.LastEventSeq, session.resumedPayload()) {
+		return nil, nil, false
+	}
+	return session, nil, true
+}
+
+// startSession registers session for reconnects and starts its STT stream
+// and extraction pipeline.
+func (h *Handler) startSession(session *wsSession) {
+	pr, pw := io.Pipe()
+	session.audio = pw
+	draining := !h.sessions.add(session)
+	go h.runSession(session, pr)
+	if draining {
+		// The server started shutting down during the handshake.
+		h.drainSession(session)
+	}
+}
+
+// decodeErrorCode returns the error code reported for a control message
+// protocol.Decode rejected: a message of another protocol version, or a
+// malformed one.
+func decodeErrorCode(err error) string {
+	var verr *protocol.VersionError
+	if errors.As(err, &verr) {
+		return protocol.ErrCodeUnsupportedVersion
+	}
+	return protocol.ErrCodeBadMessage
+}
+
+// runSession transcribes the session's audio until it ends, queueing final
+// transcripts for extraction, then closes the session once every transcript
+// has been processed. The session stays resumable for the grace period
+// afterwards so a client that dropped near the end can collect its results.
+func (h *Handler) runSession(session *wsSession, pr *io.PipeReader) {
+	// Stream audio to STT. The session, not the connection, bounds the
+	// stream: audio ends with an end message or when the grace period for
+	// a dropped connection expires.
+	transcripts, errs := h.sttClient.StreamTranscribe(context.Background(), session.cfg, pr)
+
+	// Final transcripts are extracted in order on a single goroutine so
+	// results reach the client in the same order as their transcripts.
+	turns := make(chan domain.SessionTurn, extractionQueueSize)
+	extractionDone := make(chan struct{})
+	go func() {
+		defer close(extractionDone)
+		h.runExtraction(h.tasks, session, turns)
 	}()
 
-	// Handle errors
-	// Since we only expect one error or end of stream from SttClient, we can just read once.
-	if err := <-errs; err != nil {
-		log.Printf("STT Stream ended: %v", err)
-		// Signal shutdown to reader goroutine
-		atomic.StoreInt32(&isClosing, 1)
-
-		// Send error to client if possible (might fail if connection is already unstable)
-		// We send a text message first to explain the error
-		if werr := conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("Error: %v", err))); werr != nil {
-			log.Printf("Failed to write error message: %v", werr)
+	// Assemble the transcript. Committed segments are collected into
+	// speaker turns, and each turn is extracted once it ends: when another
+	// speaker takes over, when the VAD hears the utterance end, or, without
+	// VAD, with every final result.
+	var turn []domain.TranscriptSegment
+	var turnSeq uint64
+	endTurn := func() {
+		if len(turn) == 0 {
 			return
 		}
+		session.transcriptMu.Lock()
+		sourceLanguage := domain.TranscriptLanguage(session.transcript.Segments(), session.cfg.LanguageCode)
+		session.transcriptMu.Unlock()
+		session.pending.Add(1)
+		turns <- domain.SessionTurn{
+			TranscriptSeq:  turnSeq,
+			Text:           turnText(turn),
+			Language:       domain.TranscriptLanguage(turn, session.cfg.LanguageCode),
+			SourceLanguage: sourceLanguage,
+			RecordingID:    session.recording.recordingID(),
+		}
+		turn = nil
+	}
+	speaking := false
+	for transcripts != nil {
+		var result intelligence.TranscriptResult
+		select {
+		case ev := <-session.speech:
+			speaking = ev.Type == audio.SpeechStart
+			if !speaking {
+				endTurn()
+			}
+			continue
+		case r, ok := <-transcripts:
+			if !ok {
+				transcripts = nil
+				continue
+			}
+			result = r
+		}
+		log.Printf("Transcript: %s", result.Text)
+
+		segments, next := session.addResult(session.receivedTime(result))
+		if !result.IsFinal {
+			if session.interimResults.Load() {
+				session.sendEvent(protocol.TypeTranscriptInterim, protocol.TranscriptPayload{
+					Text:      result.Text,
+					Segment:   next,
+					Stability: result.Stability,
+				})
+			}
+			continue
+		}
 
-		// Send Close frame to initiate clean shutdown
-		closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "STT stream ended")
-		if werr := conn.WriteMessage(websocket.CloseMessage, closeMsg); werr != nil {
-			log.Printf("Failed to write close message: %v", werr)
+		// Send each transcript back to the client and add it to the
+		// current turn.
+		for _, segment := range segments {
+			if len(turn) > 0 && segment.Speaker != turn[0].Speaker {
+				endTurn()
+			}
+			turnSeq = session.sendEvent(protocol.TypeTranscriptFinal, protocol.TranscriptPayload{
+				Text:       segment.Text,
+				Segment:    segment.Index,
+				Stability:  1,
+				StartMS:    segment.StartMS,
+				EndMS:      segment.EndMS,
+				Confidence: segment.Confidence,
+				Words:      segment.Words,
+				Speaker:    segment.Speaker,
+				Role:       segment.Role,
+				Language:   segment.Language,
+			})
+			turn = append(turn, segment)
+		}
+		if !speaking {
+			endTurn()
 		}
 	}
+	endTurn()
+
+	// Unblock the reader if it is still writing audio nobody will consume,
+	// and end the audio in case STT stopped first so the recording is
+	// stored.
+	pr.Close()
+	session.endAudio()
+
+	reason, closeCode := "end of stream", websocket.CloseNormalClosure
+	if h.isDraining() {
+		reason, closeCode = "server shutting down", websocket.CloseGoingAway
+	}
+	sttErr := <-errs
+	if sttErr != nil {
+		log.Printf("STT Stream ended: %v", sttErr)
+		session.sendError(protocol.ErrCodeSTTFailed, sttErr.Error(), 0)
+		reason = "STT stream ended"
+	}
 
+	// Deliver results for every transcript before closing the session.
+	close(turns)
+	<-extractionDone
+	h.finishSession(h.tasks, session, sttErr)
+	session.close(closeCode, reason)
+	h.sessions.finished()
+	time.AfterFunc(h.sessions.grace, func() { h.sessions.remove(session) })
 }
 
-// HandleUpload handles HTTP POST requests for audio files.
+// readLoop reads frames from conn, forwarding audio to the session and
+// applying control messages, until the connection fails or the session
+// closes it. A connection lost before the session finishes is detached and
+// the session waits for the client to reconnect.
+func (h *Handler) readLoop(session *wsSession, conn *websocket.Conn) {
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			if !session.isAttached(conn) {
+				// The session closed or replaced this connection.
+				return
+			}
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("Websocket closed by client: %v", err)
+			} else {
+				log.Printf("Websocket read error: %v", err)
+			}
+			session.detach(conn, h.sessions.grace, func() {
+				log.Printf("Session %s was not resumed; finishing it", session.id)
+				h.sessions.remove(session)
+				session.endAudio()
+			})
+			return
+		}
+
+		switch messageType {
+		case websocket.TextMessage:
+			env, err := protocol.Decode(data)
+			if err != nil {
+				session.sendError(decodeErrorCode(err), err.Error(), 0)
+				continue
+			}
+			if done := h.handleControl(session, env); done {
+				// Keep reading so acks are still honoured; the session
+				// closes the connection once its results are delivered.
+				session.endAudio()
+			}
+		case websocket.BinaryMessage:
+			if session.paused.Load() {
+				continue
+			}
+			var audioSeq uint64
+			if session.sequencedAudio {
+				if len(data) < audioSeqSize {
+					session.sendError(protocol.ErrCodeBadMessage, "audio frame is missing its sequence number", 0)
+					continue
+				}
+				audioSeq = binary.BigEndian.Uint64(data)
+				data = data[audioSeqSize:]
+			}
+			session.writeAudio(audioSeq, data)
+		}
+	}
+}
+
+// handleControl applies a client control message. It reports whether the
+// client has ended the audio stream.
+func (h *Handler) handleControl(session *wsSession, env *protocol.Envelope) bool {
+	switch env.Type {
+	case protocol.TypeStart, protocol.TypeReconnect:
+		session.sendError(protocol.ErrCodeBadMessage, "session already started", 0)
+	case protocol.TypeAck:
+		var payload protocol.AckPayload
+		if err := env.DecodePayload(&payload); err != nil {
+			session.sendError(protocol.ErrCodeBadMessage, err.Error(), 0)
+			return false
+		}
+		session.acknowledge(payload.Seq)
+	case protocol.TypeConfig:
+		var payload protocol.ConfigPayload
+		if err := env.DecodePayload(&payload); err != nil {
+			session.sendError(protocol.ErrCodeBadMessage, err.Error(), 0)
+			return false
+		}
+		if payload.InterimResults != nil {
+			session.interimResults.Store(*payload.InterimResults)
+		}
+	case protocol.TypePause:
+		session.paused.Store(true)
+	case protocol.TypeResume:
+		session.paused.Store(false)
+	case protocol.TypeEnd:
+		log.Println("Received end from client, finishing audio stream")
+		return true
+	default:
+		session.sendError(protocol.ErrCodeBadMessage, fmt.Sprintf("unknown message type %q", env.Type), 0)
+	}
+	return false
+}
+
+// HandleUpload handles HTTP POST requests for audio files. The audio is
+// stored and queued as a transcription job; the response is the job, whose
+// progress can be followed at /jobs/{id}.
 func (h *Handler) HandleUpload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if h.refuseWhileDraining(w) {
+		return
+	}
 
 	// 10MB max memory
 	if err := r.ParseMultipartForm(10 << 20); err != nil {
@@ -182,78 +617,178 @@ func (h *Handler) HandleUpload(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	log.Println("Received audio upload, starting transcription...")
+	cfg, audioStream, err := uploadAudio(r.FormValue, file)
+	if err != nil {
+		http.Error(w, err.Error(), uploadErrorStatus(err))
+		return
+	}
+	if err := h.checkStreamConfig(r.Context(), &cfg); err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
 
-	// Stream audio to STT
-	// Note: Google STT StreamTranscribe expects raw bytes (LINEAR16 usually).
-	// If the file is WAV, it has a header. StreamTranscribe might handle it if we skip header?
-	// Or STT API is tolerant.
-	transcripts, errs := h.sttClient.StreamTranscribe(r.Context(), file)
+	encounter := uploadEncounter(r.FormValue)
+	if err := encounter.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	var fullTranscript string
+	job, err := h.createJob(r.Context(), cfg, encounter, audioStream)
+	if err != nil {
+		log.Printf("Failed to create transcription job: %v", err)
+		http.Error(w, "Failed to store upload", http.StatusInternalServerError)
+		return
+	}
+	h.wakeWorkers()
+	log.Printf("Received audio upload, queued as job %s", job.ID)
 
-	// Process transcripts
-	done := make(chan bool)
-	go func() {
-		defer close(done)
-		for t := range transcripts {
-			// Accumulate final results?
-			// Actually stream returns interim too.
-			// Let's just keep appending or replacing?
-			// Ideally we want the final result.
-			// Cloud Speech returns multiple results.
-			// Simply concatenating is okay for now.
-			log.Printf("Partial Transcript: %s", t)
-			fullTranscript = t // In our simplified client, we only get the latest transcript chunk?
-			// Wait, StreamTranscribe returns chunk texts.
-			// We should probably accumulate.
-			// However, the channel yields distinct phrases.
-		}
-	}()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
 
-	// Wait for stream end
-	if err := <-errs; err != nil {
-		if err != io.EOF {
-			log.Printf("STT Error: %v", err)
-			http.Error(w, "Transcription failed: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
+// uploadAudio builds the stream config for an uploaded file and returns the
+// audio to transcribe. WAV files are configured from their header, which is
+// stripped. Other files use the optional encoding, sample_rate_hertz and
+// channels form fields, falling back to the container detected from the
+// file's header; headerless files are taken to be LINEAR16. language_code
+// applies to every file.
+func uploadAudio(field func(string) string, file io.ReadSeeker) (intelligence.StreamConfig, io.Reader, error) {
+	cfg, err := uploadConfig(field)
+	if err != nil {
+		return cfg, nil, err
 	}
-	<-done // Wait for consumer to finish
 
-	log.Printf("Final Full Transcript: %s", fullTranscript)
+	header := make([]byte, audio.SniffLen)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return cfg, nil, fmt.Errorf("failed to read audio file: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return cfg, nil, fmt.Errorf("failed to read audio file: %w", err)
+	}
+	container := audio.Sniff(header[:n])
 
-	// Async Entity Extraction
-	go func(text string) {
-		if text == "" {
-			return
-		}
-		note, err := h.llmClient.ExtractEntities(context.Background(), text)
+	if container == audio.ContainerWAV {
+		wav, err := audio.ReadWAVHeader(file)
 		if err != nil {
-			log.Printf("Entity extraction failed: %v", err)
-			return
+			return cfg, nil, err
+		}
+		cfg.Encoding = intelligence.EncodingLinear16
+		if wav.MuLaw {
+			cfg.Encoding = intelligence.EncodingMulaw
 		}
-		log.Printf("Extracted Clinical Note: %+v", note)
+		cfg.SampleRateHertz = wav.SampleRate
+		cfg.Channels = wav.Channels
+		return cfg.WithDefaults(), audio.DecodeWAV(file, wav), nil
+	}
 
-		fhirResource, err := ehr.MapToFHIR(*note)
+	if cfg.Encoding == "" {
+		cfg.Encoding = intelligence.EncodingForContainer(container)
+		// Browsers and phones record Opus at 48 kHz.
+		if (cfg.Encoding == intelligence.EncodingOggOpus || cfg.Encoding == intelligence.EncodingWebmOpus) && cfg.SampleRateHertz == 0 {
+			cfg.SampleRateHertz = 48000
+		}
+	}
+	return cfg.WithDefaults(), file, nil
+}
+
+// uploadConfig reads the audio settings of an upload from its form fields
+// (or resumable upload metadata).
+func uploadConfig(field func(string) string) (intelligence.StreamConfig, error) {
+	cfg := intelligence.StreamConfig{
+		Encoding:     field("encoding"),
+		LanguageCode: field("language_code"),
+		Model:        field("model"),
+	}
+	for name, dst := range map[string]*int{
+		"sample_rate_hertz": &cfg.SampleRateHertz,
+		"channels":          &cfg.Channels,
+		"min_speakers":      &cfg.Speakers.MinSpeakers,
+		"max_speakers":      &cfg.Speakers.MaxSpeakers,
+	} {
+		if v := field(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*dst = n
+		}
+	}
+	for name, dst := range map[string]*bool{
+		"diarization":       &cfg.Speakers.Diarization,
+		"separate_channels": &cfg.Speakers.SeparateChannels,
+		"use_enhanced":      &cfg.UseEnhanced,
+	} {
+		if v := field(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*dst = b
+		}
+	}
+	cfg.AlternativeLanguageCodes = splitList(field("alternative_language_codes"))
+	cfg.PhraseSets = splitList(field("phrase_sets"))
+	if v := field("speaker_roles"); v != "" {
+		roles, err := parseSpeakerRoles(v)
 		if err != nil {
-			log.Printf("FHIR mapping failed: %v", err)
-			return
+			return cfg, err
 		}
+		cfg.Speakers.Roles = roles
+	}
+	return cfg, nil
+}
 
-		if err := h.repo.Save(context.Background(), fhirResource); err != nil {
-			log.Printf("Failed to save clinical impression to DB: %v", err)
-			return
+// splitList splits a comma-separated upload field.
+func splitList(v string) []string {
+	if v == "" {
+		return nil
+	}
+	var items []string
+	for item := range strings.SplitSeq(v, ",") {
+		items = append(items, strings.TrimSpace(item))
+	}
+	return items
+}
+
+// parseSpeakerRoles parses the speaker_roles upload field, a
+// comma-separated list of speaker=role pairs such as "1=patient,2=clinician".
+func parseSpeakerRoles(v string) (domain.SpeakerRoles, error) {
+	roles := make(domain.SpeakerRoles)
+	for pair := range strings.SplitSeq(v, ",") {
+		speaker, role, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		n, err := strconv.Atoi(strings.TrimSpace(speaker))
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid speaker_roles entry %q (expected speaker=role)", pair)
+		}
+		roles[n] = domain.SpeakerRole(strings.TrimSpace(role))
+		if !roles[n].Valid() {
+			return nil, fmt.Errorf("invalid role %q in speaker_roles (expected clinician, patient or other)", role)
 		}
-		log.Println("Successfully saved Clinical Impression to DB")
-	}(fullTranscript)
+	}
+	return roles, nil
+}
 
-	// Return JSON response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status":     "success",
-		"transcript": fullTranscript,
-	})
+// uploadEncounter reads the encounter references of an upload.
+func uploadEncounter(field func(string) string) domain.EncounterContext {
+	return domain.EncounterContext{
+		PatientReference:      field("patient_reference"),
+		EncounterReference:    field("encounter_reference"),
+		PractitionerReference: field("practitioner_reference"),
+	}
+}
+
+// uploadErrorStatus maps an uploadAudio error to an HTTP status.
+func uploadErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, audio.ErrMalformedWAV):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, audio.ErrUnsupportedWAV):
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusBadRequest
 }
 
 // HandleGetImpressions handles HTTP GET requests for clinical impressions.
@@ -0,0 +1,1116 @@
+package ingestion
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"clinical-agent-backend/internal/audio"
+	"clinical-agent-backend/internal/domain"
+	"clinical-agent-backend/internal/ehr"
+	"clinical-agent-backend/internal/intelligence"
+	"clinical-agent-backend/internal/protocol"
+	"clinical-agent-backend/internal/repository"
+
+	"github.com/gorilla/websocket"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+)
+
+func newTestHandler(t *testing.T, script ...string) (*Handler, *repository.MemoryRepository) {
+	t.Helper()
+	extractor, err := intelligence.NewRuleExtractor()
+	if err != nil {
+		t.Fatalf("failed to create rule extractor: %v", err)
+	}
+	repo := repository.NewMemoryRepository()
+	handler := NewHandler(intelligence.NewLocalTranscriber(script), extractor, repo, WithJobSpoolDir(t.TempDir()))
+	handler.StartJobWorkers(t.Context(), 1)
+	return handler, repo
+}
+
+// waitForJob polls the handler's job repository until job id is done.
+func waitForJob(t *testing.T, handler *Handler, id string) *domain.TranscriptionJob {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := handler.jobs.FindByID(context.Background(), id)
+		if err != nil {
+			t.Fatalf("FindByID failed: %v", err)
+		}
+		if job.Status.Done() {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for job %s", id)
+	return nil
+}
+
+// decodeJob reads the job returned by HandleUpload, which must be accepted.
+func decodeJob(t *testing.T, rec *httptest.ResponseRecorder) *domain.TranscriptionJob {
+	t.Helper()
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var job domain.TranscriptionJob
+	if err := json.Unmarshal(rec.Body.Bytes(), &job); err != nil {
+		t.Fatalf("failed to decode job: %v", err)
+	}
+	if job.ID == "" || rec.Header().Get("Location") != "/jobs/"+job.ID {
+		t.Fatalf("expected a job ID and Location header, got %+v (Location %q)", job, rec.Header().Get("Location"))
+	}
+	return &job
+}
+
+// waitForImpressions polls repo until it holds n impressions or times out.
+func waitForImpressions(t *testing.T, repo repository.ClinicalImpressionRepository, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		all, err := repo.FindAll(context.Background())
+		if err != nil {
+			t.Fatalf("FindAll failed: %v", err)
+		}
+		if len(all) >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d impressions", n)
+}
+
+func TestHandleUpload(t *testing.T) {
+	handler, repo := newTestHandler(t, "Patient reports a headache and took ibuprofen.")
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("audio", "clip.raw")
+	if err != nil {
+		t.Fatalf("failed to create form file: %v", err)
+	}
+	part.Write(make([]byte, 16000))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload-audio", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	handler.HandleUpload(rec, req)
+
+	job := decodeJob(t, rec)
+	if job.Status != domain.JobQueued {
+		t.Errorf("expected a queued job, got %s", job.Status)
+	}
+
+	job = waitForJob(t, handler, job.ID)
+	if job.Status != domain.JobSaved {
+		t.Fatalf("expected job to be saved, got %s (%s)", job.Status, job.Error)
+	}
+	if job.Transcript != "Patient reports a headache and took ibuprofen." {
+		t.Errorf("unexpected transcript: %q", job.Transcript)
+	}
+	if len(job.Segments) != 1 || job.Segments[0].Text != job.Transcript {
+		t.Errorf("expected one segment holding the transcript, got %+v", job.Segments)
+	}
+	if job.Note == nil || !slices.Contains(job.Note.Symptoms, "headache") {
+		t.Errorf("expected the note to list headache, got %+v", job.Note)
+	}
+	if job.ImpressionID == "" {
+		t.Error("expected an impression ID")
+	}
+
+	waitForImpressions(t, repo, 1)
+
+	rec = httptest.NewRecorder()
+	handler.HandleGetImpressions(rec, httptest.NewRequest(http.MethodGet, "/impressions", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if !bytes.Contains(rec.Body.Bytes(), []byte("headache")) {
+		t.Errorf("expected impression to mention headache, got %s", rec.Body.String())
+	}
+}
+
+// captureTranscriber records the config and audio passed to the batch
+// recognition methods, and which of them was used. fromDisk reports whether
+// RecognizeLong read the recording straight from a file.
+type captureTranscriber struct {
+	*intelligence.LocalTranscriber
+	cfg      intelligence.StreamConfig
+	audio    []byte
+	method   string
+	fromDisk bool
+}
+
+func (c *captureTranscriber) Recognize(ctx context.Context, cfg intelligence.StreamConfig, clip []byte) ([]intelligence.TranscriptResult, error) {
+	c.cfg, c.audio, c.method = cfg, clip, "Recognize"
+	return c.LocalTranscriber.Recognize(ctx, cfg, clip)
+}
+
+func (c *captureTranscriber) RecognizeLong(ctx context.Context, cfg intelligence.StreamConfig, recording io.Reader) ([]intelligence.TranscriptResult, error) {
+	_, c.fromDisk = recording.(*os.File)
+	audio, err := io.ReadAll(recording)
+	if err != nil {
+		return nil, err
+	}
+	c.cfg, c.audio, c.method = cfg, audio, "RecognizeLong"
+	return c.LocalTranscriber.Recognize(ctx, cfg, audio)
+}
+
+// postUpload sends file to handler.HandleUpload as the audio form field.
+func postUpload(t *testing.T, handler *Handler, name string, file []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("audio", name)
+	if err != nil {
+		t.Fatalf("failed to create form file: %v", err)
+	}
+	part.Write(file)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload-audio", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	handler.HandleUpload(rec, req)
+	return rec
+}
+
+func TestHandleUpload_WAV(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	capture := &captureTranscriber{LocalTranscriber: intelligence.NewLocalTranscriber(nil)}
+	handler.sttClient = capture
+
+	raw, err := os.ReadFile("../../sample.wav")
+	if err != nil {
+		t.Fatalf("failed to read sample.wav: %v", err)
+	}
+	job := decodeJob(t, postUpload(t, handler, "sample.wav", raw))
+	if job.Audio.Encoding != intelligence.EncodingLinear16 || job.Audio.SampleRateHertz != 16000 || job.Audio.Channels != 1 {
+		t.Errorf("unexpected job audio: %+v", job.Audio)
+	}
+	waitForJob(t, handler, job.ID)
+
+	if capture.cfg.Encoding != intelligence.EncodingLinear16 || capture.cfg.SampleRateHertz != 16000 || capture.cfg.Channels != 1 {
+		t.Errorf("unexpected recognition config: %+v", capture.cfg)
+	}
+	if capture.method != "Recognize" {
+		t.Errorf("expected a short clip to use Recognize, got %s", capture.method)
+	}
+	if bytes.HasPrefix(capture.audio, []byte("RIFF")) || !bytes.HasSuffix(raw, capture.audio) {
+		t.Error("expected exactly the sample data, with the WAV header stripped")
+	}
+	if len(capture.audio) == 0 || len(raw)-len(capture.audio) > 100 {
+		t.Errorf("unexpected amount of audio: %d of %d bytes", len(capture.audio), len(raw))
+	}
+}
+
+func TestHandleUpload_LongRecordingUsesLongRunning(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	capture := &captureTranscriber{LocalTranscriber: intelligence.NewLocalTranscriber(nil)}
+	handler.sttClient = capture
+
+	// A minute of 16 kHz mono LINEAR16, past the synchronous limit.
+	job := decodeJob(t, postUpload(t, handler, "long.raw", make([]byte, 60*32000)))
+	waitForJob(t, handler, job.ID)
+	if capture.method != "RecognizeLong" {
+		t.Errorf("expected a long recording to use RecognizeLong, got %s", capture.method)
+	}
+	if !capture.fromDisk {
+		t.Error("expected the recording to be streamed from the spool, not read into memory")
+	}
+	if len(capture.audio) != 60*32000 {
+		t.Errorf("expected the whole recording, got %d bytes", len(capture.audio))
+	}
+}
+
+func TestHandleUpload_ResumesJobsAfterRestart(t *testing.T) {
+	extractor, err := intelligence.NewRuleExtractor()
+	if err != nil {
+		t.Fatalf("failed to create rule extractor: %v", err)
+	}
+	jobs := repository.NewMemoryJobRepository()
+	spool := t.TempDir()
+	newServer := func() *Handler {
+		return NewHandler(intelligence.NewLocalTranscriber([]string{"fever and cough"}), extractor,
+			repository.NewMemoryRepository(), WithJobRepository(jobs), WithJobSpoolDir(spool))
+	}
+
+	// The first server accepts the upload but stops before running it.
+	job := decodeJob(t, postUpload(t, newServer(), "clip.raw", make([]byte, 16000)))
+
+	restarted := newServer()
+	restarted.StartJobWorkers(t.Context(), 1)
+	job = waitForJob(t, restarted, job.ID)
+	if job.Status != domain.JobSaved || job.Transcript != "fever and cough" {
+		t.Fatalf("expected the job to finish after restart, got %+v", job)
+	}
+	if entries, _ := os.ReadDir(spool); len(entries) != 0 {
+		t.Errorf("expected spooled audio to be removed, found %d files", len(entries))
+	}
+}
+
+func TestHandleJobs(t *testing.T) {
+	handler, _ := newTestHandler(t, "shortness of breath")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs", handler.HandleListJobs)
+	mux.HandleFunc("/jobs/{id}", handler.HandleGetJob)
+
+	upload := func(patient string) *domain.TranscriptionJob {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		form.WriteField("patient_reference", patient)
+		part, _ := form.CreateFormFile("audio", "clip.raw")
+		part.Write(make([]byte, 16000))
+		form.Close()
+		req := httptest.NewRequest(http.MethodPost, "/upload-audio", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		rec := httptest.NewRecorder()
+		handler.HandleUpload(rec, req)
+		return waitForJob(t, handler, decodeJob(t, rec).ID)
+	}
+	first := upload("Patient/1")
+	upload("Patient/2")
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/"+first.ID, nil))
+	var got domain.TranscriptionJob
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || got.ID != first.ID || got.ImpressionID != first.ImpressionID {
+		t.Errorf("GET /jobs/{id}: got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing job, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs?patient_reference=Patient/1", nil))
+	var list []domain.TranscriptionJob
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || len(list) != 1 || list[0].ID != first.ID {
+		t.Errorf("GET /jobs?patient_reference: got %s", rec.Body.String())
+	}
+}
+
+func TestHandleUpload_RejectsBadWAV(t *testing.T) {
+	handler, _ := newTestHandler(t)
+
+	// Truncated after the RIFF header: malformed.
+	if rec := postUpload(t, handler, "bad.wav", []byte("RIFF\x24\x00\x00\x00WAVEfmt ")); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("truncated WAV: expected 422, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// IEEE float samples: well-formed but unsupported.
+	float := []byte("RIFF\x2c\x00\x00\x00WAVEfmt \x10\x00\x00\x00\x03\x00\x01\x00\x80\x3e\x00\x00\x00\xfa\x00\x00\x04\x00\x20\x00data\x04\x00\x00\x00\x00\x00\x00\x00")
+	rec := postUpload(t, handler, "float.wav", float)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("float WAV: expected 415, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "format tag 0x0003") {
+		t.Errorf("expected the format tag in the error, got %q", rec.Body.String())
+	}
+}
+
+func TestHandleUpload_UnsupportedEncoding(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	handler.sttClient = intelligence.NewTranscodingTranscriber(handler.sttClient, audio.FFmpeg{Path: "/nonexistent/ffmpeg"})
+
+	rec := postUpload(t, handler, "clip.ogg", append([]byte("OggS"), make([]byte, 1000)...))
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected 415, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "OGG_OPUS") {
+		t.Errorf("expected the detected encoding in the error, got %q", rec.Body.String())
+	}
+}
+
+// dialWS connects a test client to handler.ServeWS.
+func dialWS(t *testing.T, handler *Handler) *websocket.Conn {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(handler.ServeWS))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func sendControl(t *testing.T, conn *websocket.Conn, msgType string, payload any) {
+	t.Helper()
+	frame, err := protocol.Encode(msgType, 0, payload)
+	if err != nil {
+		t.Fatalf("failed to encode %s: %v", msgType, err)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+		t.Fatalf("failed to write %s: %v", msgType, err)
+	}
+}
+
+// readEvents collects server events until the connection closes.
+func readEvents(t *testing.T, conn *websocket.Conn) []*protocol.Envelope {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var events []*protocol.Envelope
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return events
+		}
+		env, err := protocol.Decode(data)
+		if err != nil {
+			t.Fatalf("failed to decode server event: %v", err)
+		}
+		events = append(events, env)
+	}
+}
+
+func TestServeWS_Protocol(t *testing.T) {
+	handler, repo := newTestHandler(t, "first phrase", "second phrase")
+	conn := dialWS(t, handler)
+
+	sendControl(t, conn, protocol.TypeStart, protocol.StartPayload{
+		Audio:     protocol.AudioConfig{Encoding: "LINEAR16", SampleRateHertz: 16000, LanguageCode: "en-US"},
+		Encounter: domain.EncounterContext{PatientReference: "Patient/123", EncounterReference: "Encounter/456"},
+	})
+	conn.WriteMessage(websocket.BinaryMessage, make([]byte, 40000))
+	sendControl(t, conn, "bogus", nil)
+	sendControl(t, conn, protocol.TypeEnd, nil)
+
+	var types []string
+	var finals []string
+	impressionIDs := map[string]bool{}
+	var closed protocol.SessionClosedPayload
+	for i, env := range readEvents(t, conn) {
+		if env.Seq != uint64(i+1) {
+			t.Errorf("event %d: expected seq %d, got %d", i, i+1, env.Seq)
+		}
+		types = append(types, env.Type)
+		switch env.Type {
+		case protocol.TypeTranscriptFinal:
+			var payload protocol.TranscriptPayload
+			if err := env.DecodePayload(&payload); err != nil {
+				t.Fatalf("failed to decode transcript: %v", err)
+			}
+			finals = append(finals, payload.Text)
+		case protocol.TypeImpression:
+			var payload protocol.ImpressionPayload
+			env.DecodePayload(&payload)
+			impressionIDs[payload.ID] = true
+		case protocol.TypeSessionClosed:
+			env.DecodePayload(&closed)
+		}
+	}
+
+	if len(types) == 0 || types[0] != protocol.TypeSessionStarted {
+		t.Fatalf("expected session.started first, got %v", types)
+	}
+	if types[len(types)-1] != protocol.TypeSessionClosed {
+		t.Errorf("expected session.closed last, got %v", types)
+	}
+	if !slices.Contains(types, protocol.TypeError) {
+		t.Errorf("expected error event for unknown message type, got %v", types)
+	}
+	if want := []string{"first phrase", "second phrase"}; !slices.Equal(finals, want) {
+		t.Errorf("expected final transcripts %v, got %v", want, finals)
+	}
+
+	// Both turns update the encounter's one impression, which is completed
+	// when the session ends.
+	impressions, _ := repo.FindAll(context.Background())
+	if len(impressions) != 1 {
+		t.Fatalf("expected 1 impression saved before session.closed, got %d", len(impressions))
+	}
+	impression := impressions[0]
+	if len(impressionIDs) != 1 || !impressionIDs[*impression.Id] || closed.ImpressionID != *impression.Id {
+		t.Errorf("expected every impression event and session.closed to carry %s, got %v and %q", *impression.Id, impressionIDs, closed.ImpressionID)
+	}
+	if impression.Status != fhir.ClinicalImpressionStatusCompleted {
+		t.Errorf("expected a completed impression, got %v", impression.Status)
+	}
+	if impression.Subject.Reference == nil || *impression.Subject.Reference != "Patient/123" {
+		t.Errorf("expected subject Patient/123, got %+v", impression.Subject)
+	}
+	if impression.Encounter == nil || *impression.Encounter.Reference != "Encounter/456" {
+		t.Errorf("expected encounter Encounter/456, got %+v", impression.Encounter)
+	}
+}
+
+// scriptedTranscriber drains the audio and then emits a fixed sequence of
+// streaming results.
+type scriptedTranscriber struct {
+	*intelligence.LocalTranscriber
+	results []intelligence.TranscriptResult
+}
+
+func (s *scriptedTranscriber) StreamTranscribe(ctx context.Context, cfg intelligence.StreamConfig, audioStream io.Reader) (<-chan intelligence.TranscriptResult, <-chan error) {
+	results := make(chan intelligence.TranscriptResult)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(results)
+		io.Copy(io.Discard, audioStream)
+		for _, r := range s.results {
+			results <- r
+		}
+	}()
+	return results, errs
+}
+
+func TestServeWS_AssemblesTranscript(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	handler.sttClient = &scriptedTranscriber{LocalTranscriber: intelligence.NewLocalTranscriber(nil), results: []intelligence.TranscriptResult{
+		{Text: "patient", Stability: 0.3},
+		{Text: "patient reports", Stability: 0.7},
+		{Text: "patient reports a headache", IsFinal: true, Stability: 1},
+		{Text: " ", IsFinal: true},
+		{Text: "since", Stability: 0.5},
+		{Text: "since Monday", IsFinal: true, Stability: 1},
+	}}
+	conn := dialWS(t, handler)
+
+	sendControl(t, conn, protocol.TypeStart, protocol.StartPayload{})
+	sendControl(t, conn, protocol.TypeEnd, nil)
+
+	var got []string
+	for _, env := range readEvents(t, conn) {
+		if env.Type != protocol.TypeTranscriptInterim && env.Type != protocol.TypeTranscriptFinal {
+			continue
+		}
+		var payload protocol.TranscriptPayload
+		if err := env.DecodePayload(&payload); err != nil {
+			t.Fatalf("failed to decode transcript: %v", err)
+		}
+		got = append(got, fmt.Sprintf("%s %d %s", env.Type, payload.Segment, payload.Text))
+	}
+	want := []string{
+		"transcript.interim 0 patient",
+		"transcript.interim 0 patient reports",
+		"transcript.final 0 patient reports a headache",
+		"transcript.interim 1 since",
+		"transcript.final 1 since Monday",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("unexpected transcript events:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestServeWS_AttributesSpeakers(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	handler.sttClient = &scriptedTranscriber{LocalTranscriber: intelligence.NewLocalTranscriber(nil), results: []intelligence.TranscriptResult{
+		{Text: "any chest pain? just a headache", IsFinal: true, Words: []domain.Word{
+			{Text: "any", Speaker: 1}, {Text: "chest", Speaker: 1}, {Text: "pain?", Speaker: 1},
+			{Text: "just", Speaker: 2}, {Text: "a", Speaker: 2}, {Text: "headache", Speaker: 2},
+		}},
+	}}
+	conn := dialWS(t, handler)
+
+	sendControl(t, conn, protocol.TypeStart, protocol.StartPayload{
+		Speakers: domain.SpeakerConfig{Diarization: true, MaxSpeakers: 2},
+	})
+	sendControl(t, conn, protocol.TypeEnd, nil)
+
+	var got []string
+	var symptoms []string
+	for _, env := range readEvents(t, conn) {
+		switch env.Type {
+		case protocol.TypeTranscriptFinal:
+			var payload protocol.TranscriptPayload
+			if err := env.DecodePayload(&payload); err != nil {
+				t.Fatalf("failed to decode transcript: %v", err)
+			}
+			got = append(got, fmt.Sprintf("%d %d %s: %s", payload.Segment, payload.Speaker, payload.Role, payload.Text))
+		case protocol.TypeNote:
+			var payload protocol.NotePayload
+			if err := env.DecodePayload(&payload); err != nil {
+				t.Fatalf("failed to decode note: %v", err)
+			}
+			symptoms = append(symptoms, payload.Note.Symptoms...)
+		}
+	}
+	want := []string{"0 1 clinician: any chest pain?", "1 2 patient: just a headache"}
+	if !slices.Equal(got, want) {
+		t.Errorf("unexpected transcripts:\n got %q\nwant %q", got, want)
+	}
+	if !slices.Equal(symptoms, []string{"headache"}) {
+		t.Errorf("expected only the patient's symptom to be extracted, got %v", symptoms)
+	}
+}
+
+func TestServeWS_DetectsLanguage(t *testing.T) {
+	handler, repo := newTestHandler(t)
+	handler.sttClient = &scriptedTranscriber{LocalTranscriber: intelligence.NewLocalTranscriber(nil), results: []intelligence.TranscriptResult{
+		{Text: "tengo fiebre desde ayer", IsFinal: true, LanguageCode: "es-US"},
+	}}
+	conn := dialWS(t, handler)
+
+	sendControl(t, conn, protocol.TypeStart, protocol.StartPayload{
+		Audio: protocol.AudioConfig{LanguageCode: "en-US", AlternativeLanguageCodes: []string{"es-US"}},
+	})
+	sendControl(t, conn, protocol.TypeEnd, nil)
+
+	var language string
+	var note domain.ClinicalNote
+	for _, env := range readEvents(t, conn) {
+		switch env.Type {
+		case protocol.TypeTranscriptFinal:
+			var payload protocol.TranscriptPayload
+			if err := env.DecodePayload(&payload); err != nil {
+				t.Fatalf("failed to decode transcript: %v", err)
+			}
+			language = payload.Language
+		case protocol.TypeNote:
+			var payload protocol.NotePayload
+			if err := env.DecodePayload(&payload); err != nil {
+				t.Fatalf("failed to decode note: %v", err)
+			}
+			note = payload.Note
+		}
+	}
+	if language != "es-US" {
+		t.Errorf("expected the transcript to report es-US, got %q", language)
+	}
+	if !slices.Equal(note.Symptoms, []string{"fever"}) || note.SourceLanguage != "es-US" {
+		t.Errorf("expected an English note from Spanish, got %+v", note)
+	}
+
+	waitForImpressions(t, repo, 1)
+	impressions, _ := repo.FindAll(context.Background())
+	impression := impressions[0]
+	if impression.Language == nil || *impression.Language != "en" || len(impression.Extension) != 2 ||
+		impression.Extension[0].ValueCode == nil || *impression.Extension[0].ValueCode != "es-US" ||
+		ehr.JobID(impression) == "" {
+		t.Errorf("expected an English impression recording its Spanish source, got language %v extensions %+v", impression.Language, impression.Extension)
+	}
+}
+
+func TestServeWS_RejectsUnsupportedConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		start   func(conn *websocket.Conn)
+		errCode string
+	}{
+		{
+			name: "unsupported encoding",
+			start: func(conn *websocket.Conn) {
+				sendControl(t, conn, protocol.TypeStart, protocol.StartPayload{
+					Audio: protocol.AudioConfig{Encoding: "MP3"},
+				})
+			},
+			errCode: protocol.ErrCodeInvalidConfig,
+		},
+		{
+			name: "bad patient reference",
+			start: func(conn *websocket.Conn) {
+				sendControl(t, conn, protocol.TypeStart, protocol.StartPayload{
+					Encounter: domain.EncounterContext{PatientReference: "Encounter/1"},
+				})
+			},
+			errCode: protocol.ErrCodeInvalidConfig,
+		},
+		{
+			name: "separate channels on mono audio",
+			start: func(conn *websocket.Conn) {
+				sendControl(t, conn, protocol.TypeStart, protocol.StartPayload{
+					Speakers: domain.SpeakerConfig{SeparateChannels: true},
+				})
+			},
+			errCode: protocol.ErrCodeInvalidConfig,
+		},
+		{
+			name: "too many alternative languages",
+			start: func(conn *websocket.Conn) {
+				sendControl(t, conn, protocol.TypeStart, protocol.StartPayload{
+					Audio: protocol.AudioConfig{AlternativeLanguageCodes: []string{"es-US", "fr-FR", "de-DE", "it-IT"}},
+				})
+			},
+			errCode: protocol.ErrCodeInvalidConfig,
+		},
+		{
+			name: "unknown phrase set",
+			start: func(conn *websocket.Conn) {
+				sendControl(t, conn, protocol.TypeStart, protocol.StartPayload{
+					Recognition: protocol.RecognitionConfig{PhraseSets: []string{"formulary"}},
+				})
+			},
+			errCode: protocol.ErrCodeInvalidConfig,
+		},
+		{
+			name: "medical model in Spanish",
+			start: func(conn *websocket.Conn) {
+				sendControl(t, conn, protocol.TypeStart, protocol.StartPayload{
+					Audio:       protocol.AudioConfig{LanguageCode: "es-US"},
+					Recognition: protocol.RecognitionConfig{Model: intelligence.ModelMedicalConversation},
+				})
+			},
+			errCode: protocol.ErrCodeInvalidConfig,
+		},
+		{
+			name: "voice activity detection on Opus",
+			start: func(conn *websocket.Conn) {
+				vad := true
+				sendControl(t, conn, protocol.TypeStart, protocol.StartPayload{
+					Audio: protocol.AudioConfig{Encoding: intelligence.EncodingOggOpus},
+					VAD:   &vad,
+				})
+			},
+			errCode: protocol.ErrCodeInvalidConfig,
+		},
+		{
+			name: "audio before start",
+			start: func(conn *websocket.Conn) {
+				conn.WriteMessage(websocket.BinaryMessage, make([]byte, 100))
+			},
+			errCode: protocol.ErrCodeStartRequired,
+		},
+		{
+			name: "start from another protocol version",
+			start: func(conn *websocket.Conn) {
+				conn.WriteMessage(websocket.TextMessage, []byte(`{"v":2,"type":"start","seq":0,"payload":{}}`))
+			},
+			errCode: protocol.ErrCodeUnsupportedVersion,
+		},
+		{
+			name: "malformed first frame",
+			start: func(conn *websocket.Conn) {
+				conn.WriteMessage(websocket.TextMessage, []byte(`{"v":1,"type":`))
+			},
+			errCode: protocol.ErrCodeBadMessage,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, _ := newTestHandler(t)
+			handler.requireStart = true
+			conn := dialWS(t, handler)
+
+			tt.start(conn)
+			events := readEvents(t, conn)
+
+			if len(events) != 2 || events[0].Type != protocol.TypeError || events[1].Type != protocol.TypeSessionClosed {
+				t.Fatalf("expected error then session.closed, got %+v", events)
+			}
+			var payload protocol.ErrorPayload
+			if err := events[0].DecodePayload(&payload); err != nil {
+				t.Fatalf("failed to decode error: %v", err)
+			}
+			if payload.Code != tt.errCode {
+				t.Errorf("expected code %s, got %s (%s)", tt.errCode, payload.Code, payload.Message)
+			}
+		})
+	}
+}
+
+// failingRepo rejects every write.
+type failingRepo struct {
+	repository.ClinicalImpressionRepository
+}
+
+func (failingRepo) Save(ctx context.Context, impression *fhir.ClinicalImpression) error {
+	return errors.New("database unavailable")
+}
+
+func (failingRepo) Update(ctx context.Context, impression *fhir.ClinicalImpression) error {
+	return errors.New("database unavailable")
+}
+
+func TestServeWS_PushesExtractionResults(t *testing.T) {
+	extractor, _ := intelligence.NewRuleExtractor()
+
+	t.Run("saved", func(t *testing.T) {
+		handler, _ := newTestHandler(t, "I have a headache.", "I took aspirin.")
+		conn := dialWS(t, handler)
+		sendControl(t, conn, protocol.TypeStart, protocol.StartPayload{})
+		conn.WriteMessage(websocket.BinaryMessage, make([]byte, 64000))
+		sendControl(t, conn, protocol.TypeEnd, nil)
+
+		// Results may interleave with later transcripts, but each must follow
+		// its own transcript and arrive in transcript order.
+		seen := map[uint64]bool{}
+		var transcripts, notes, impressions []uint64
+		var last domain.ClinicalNote
+		for _, env := range readEvents(t, conn) {
+			switch env.Type {
+			case protocol.TypeTranscriptFinal:
+				seen[env.Seq] = true
+				transcripts = append(transcripts, env.Seq)
+			case protocol.TypeNote:
+				var payload protocol.NotePayload
+				env.DecodePayload(&payload)
+				if !seen[payload.TranscriptSeq] {
+					t.Errorf("note for transcript %d arrived before the transcript", payload.TranscriptSeq)
+				}
+				notes = append(notes, payload.TranscriptSeq)
+				last = payload.Note
+			case protocol.TypeImpression:
+				var payload protocol.ImpressionPayload
+				env.DecodePayload(&payload)
+				if payload.ID == "" {
+					t.Errorf("impression event without ID")
+				}
+				if !slices.Contains(notes, payload.TranscriptSeq) {
+					t.Errorf("impression for transcript %d arrived before its note", payload.TranscriptSeq)
+				}
+				impressions = append(impressions, payload.TranscriptSeq)
+			}
+		}
+
+		if len(transcripts) != 2 {
+			t.Fatalf("expected 2 final transcripts, got %d", len(transcripts))
+		}
+		if !slices.Equal(notes, transcripts) || !slices.Equal(impressions, transcripts) {
+			t.Errorf("expected notes and impressions for %v in order, got notes=%v impressions=%v", transcripts, notes, impressions)
+		}
+		// Each note is the running note of the encounter so far.
+		if !slices.Contains(last.Symptoms, "headache") || !slices.Contains(last.Medications, "aspirin") || len(last.HPI) != 2 {
+			t.Errorf("expected the last note to merge both turns, got %+v", last)
+		}
+	})
+
+	t.Run("persistence failure", func(t *testing.T) {
+		handler := NewHandler(intelligence.NewLocalTranscriber([]string{"I have a cough."}), extractor, failingRepo{},
+			WithTaskRetries(2, time.Millisecond))
+		conn := dialWS(t, handler)
+		sendControl(t, conn, protocol.TypeStart, protocol.StartPayload{})
+		conn.WriteMessage(websocket.BinaryMessage, make([]byte, 100))
+		sendControl(t, conn, protocol.TypeEnd, nil)
+
+		var transcriptSeq uint64
+		var got *protocol.ErrorPayload
+		var finalized bool
+		for _, env := range readEvents(t, conn) {
+			switch env.Type {
+			case protocol.TypeTranscriptFinal:
+				transcriptSeq = env.Seq
+			case protocol.TypeError:
+				if got != nil {
+					// Ending the session retries the save.
+					finalized = true
+					continue
+				}
+				got = &protocol.ErrorPayload{}
+				env.DecodePayload(got)
+			}
+		}
+		if got == nil {
+			t.Fatal("expected an error event")
+		}
+		if got.Code != protocol.ErrCodePersistenceFailed || got.TranscriptSeq != transcriptSeq {
+			t.Errorf("expected persistence_failed for transcript %d, got %+v", transcriptSeq, got)
+		}
+		if !finalized {
+			t.Error("expected the save to be retried when the session ended")
+		}
+	})
+}
+
+// sequencedFrame prefixes audio with its sequence number.
+func sequencedFrame(seq uint64, audio []byte) []byte {
+	frame := make([]byte, audioSeqSize, audioSeqSize+len(audio))
+	binary.BigEndian.PutUint64(frame, seq)
+	return append(frame, audio...)
+}
+
+// readUntil reads server events until one of type msgType arrives.
+func readUntil(t *testing.T, conn *websocket.Conn, msgType string) []*protocol.Envelope {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var events []*protocol.Envelope
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("connection failed waiting for %s: %v", msgType, err)
+		}
+		env, err := protocol.Decode(data)
+		if err != nil {
+			t.Fatalf("failed to decode server event: %v", err)
+		}
+		events = append(events, env)
+		if env.Type == msgType {
+			return events
+		}
+	}
+}
+
+func TestServeWS_ResumesAfterDisconnect(t *testing.T) {
+	handler, repo := newTestHandler(t, "first phrase", "second phrase")
+	server := httptest.NewServer(http.HandlerFunc(handler.ServeWS))
+	t.Cleanup(server.Close)
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	sendControl(t, conn, protocol.TypeStart, protocol.StartPayload{
+		Encounter: domain.EncounterContext{
+			PatientReference:   "Patient/123",
+			EncounterReference: "Encounter/456",
+		},
+		SequencedAudio: true,
+	})
+	events := readUntil(t, conn, protocol.TypeSessionStarted)
+	var started protocol.SessionStartedPayload
+	if err := events[len(events)-1].DecodePayload(&started); err != nil {
+		t.Fatalf("failed to decode session.started: %v", err)
+	}
+	if started.ResumeToken == "" {
+		t.Fatal("expected a resume token")
+	}
+	startedSeq := events[len(events)-1].Seq
+
+	audio := make([]byte, 32000)
+	conn.WriteMessage(websocket.BinaryMessage, sequencedFrame(1, audio))
+	readUntil(t, conn, protocol.TypeTranscriptFinal)
+
+	// Drop the connection without ending the session.
+	conn.Close()
+
+	conn, _, err = websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("failed to redial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	sendControl(t, conn, protocol.TypeReconnect, protocol.ReconnectPayload{
+		ResumeToken:  started.ResumeToken,
+		LastEventSeq: startedSeq,
+	})
+
+	events = readUntil(t, conn, protocol.TypeSessionResumed)
+	var resumed protocol.SessionResumedPayload
+	if err := events[0].DecodePayload(&resumed); err != nil {
+		t.Fatalf("failed to decode session.resumed: %v", err)
+	}
+	if resumed.SessionID != started.SessionID || resumed.LastAudioSeq != 1 {
+		t.Errorf("unexpected session.resumed: %+v", resumed)
+	}
+	if !slices.Equal(resumed.Transcript, []string{"first phrase"}) {
+		t.Errorf("unexpected transcript so far: %v", resumed.Transcript)
+	}
+
+	// Resend the acknowledged frame as a client would after a blip; the
+	// server must drop it rather than transcribe it twice.
+	conn.WriteMessage(websocket.BinaryMessage, sequencedFrame(1, audio))
+	conn.WriteMessage(websocket.BinaryMessage, sequencedFrame(2, audio))
+	sendControl(t, conn, protocol.TypeEnd, nil)
+
+	var finals []string
+	seen := map[uint64]bool{}
+	for _, env := range readEvents(t, conn) {
+		if env.Seq <= startedSeq || seen[env.Seq] {
+			t.Errorf("event %d (%s) delivered twice or before the last acknowledged event", env.Seq, env.Type)
+		}
+		seen[env.Seq] = true
+		if env.Type == protocol.TypeTranscriptFinal {
+			var tp protocol.TranscriptPayload
+			env.DecodePayload(&tp)
+			finals = append(finals, tp.Text)
+		}
+	}
+	if !slices.Equal(finals, []string{"first phrase", "second phrase"}) {
+		t.Errorf("unexpected final transcripts: %v", finals)
+	}
+	waitForImpressions(t, repo, 1)
+}
+
+func TestServeWS_ReconnectUnknownToken(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	conn := dialWS(t, handler)
+
+	sendControl(t, conn, protocol.TypeReconnect, protocol.ReconnectPayload{ResumeToken: "nope"})
+	events := readEvents(t, conn)
+	if len(events) == 0 || events[0].Type != protocol.TypeError {
+		t.Fatalf("expected an error event, got %v", events)
+	}
+	var payload protocol.ErrorPayload
+	events[0].DecodePayload(&payload)
+	if payload.Code != protocol.ErrCodeSessionNotFound {
+		t.Errorf("expected %s, got %s", protocol.ErrCodeSessionNotFound, payload.Code)
+	}
+}
+
+// thresholdTranscriber emits its results once it has received after bytes
+// of audio, and counts the audio it receives.
+type thresholdTranscriber struct {
+	*intelligence.LocalTranscriber
+	after    int
+	results  []intelligence.TranscriptResult
+	received atomic.Int64
+}
+
+func (s *thresholdTranscriber) StreamTranscribe(ctx context.Context, cfg intelligence.StreamConfig, audioStream io.Reader) (<-chan intelligence.TranscriptResult, <-chan error) {
+	results := make(chan intelligence.TranscriptResult)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(results)
+		buf := make([]byte, 4096)
+		emitted := false
+		for {
+			n, err := audioStream.Read(buf)
+			if s.received.Add(int64(n)) >= int64(s.after) && !emitted {
+				emitted = true
+				for _, r := range s.results {
+					results <- r
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return results, errs
+}
+
+// pcmAudio returns d of 16 kHz mono LINEAR16 audio: a loud square wave, or
+// silence.
+func pcmAudio(d time.Duration, loud bool) []byte {
+	samples := int(d * 16000 / time.Second)
+	buf := make([]byte, 2*samples)
+	for i := range samples {
+		var v int16
+		if loud {
+			v = 8000
+			if i%40 < 20 {
+				v = -8000
+			}
+		}
+		binary.LittleEndian.PutUint16(buf[2*i:], uint16(v))
+	}
+	return buf
+}
+
+func TestServeWS_VoiceActivity(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	// Before silence is dropped: 2s of silence, 300ms of pre-roll and the
+	// 1s utterance.
+	stt := &thresholdTranscriber{LocalTranscriber: intelligence.NewLocalTranscriber(nil), after: len(pcmAudio(3300*time.Millisecond, false)), results: []intelligence.TranscriptResult{
+		{Text: "patient reports", IsFinal: true, Words: []domain.Word{{Text: "patient", StartMS: 2300, EndMS: 2700}, {Text: "reports", StartMS: 2700, EndMS: 3000}}},
+		{Text: "a headache", IsFinal: true},
+	}}
+	handler.sttClient = stt
+	WithVAD(audio.VADConfig{})(handler)
+	conn := dialWS(t, handler)
+
+	sendControl(t, conn, protocol.TypeStart, protocol.StartPayload{})
+	sent := 0
+	send := func(d time.Duration, loud bool) {
+		data := pcmAudio(d, loud)
+		for chunk := range slices.Chunk(data, 3200) {
+			if err := conn.WriteMessage(websocket.BinaryMessage, chunk); err != nil {
+				t.Fatalf("failed to write audio: %v", err)
+			}
+		}
+		sent += len(data)
+	}
+	send(3*time.Second, false)
+	send(time.Second, true)
+	// Both finals arrive while the patient is still speaking.
+	events := readUntil(t, conn, protocol.TypeTranscriptFinal)
+	events = append(events, readUntil(t, conn, protocol.TypeTranscriptFinal)...)
+	send(3*time.Second, false)
+	sendControl(t, conn, protocol.TypeEnd, nil)
+	events = append(events, readEvents(t, conn)...)
+
+	var started protocol.SessionStartedPayload
+	events[0].DecodePayload(&started)
+	if !started.VAD {
+		t.Error("expected session.started to report voice activity detection")
+	}
+	var got []string
+	var notes []protocol.NotePayload
+	var firstWord domain.Word
+	for _, env := range events {
+		switch env.Type {
+		case protocol.TypeSpeechStarted, protocol.TypeSpeechEnded:
+			var payload protocol.SpeechPayload
+			env.DecodePayload(&payload)
+			got = append(got, fmt.Sprintf("%s %d", env.Type, payload.OffsetMS))
+		case protocol.TypeTranscriptFinal:
+			var payload protocol.TranscriptPayload
+			env.DecodePayload(&payload)
+			if len(payload.Words) > 0 {
+				firstWord = payload.Words[0]
+			}
+		case protocol.TypeNote:
+			var payload protocol.NotePayload
+			env.DecodePayload(&payload)
+			notes = append(notes, payload)
+		}
+	}
+	if want := []string{"speech.started 3000", "speech.ended 4000"}; !slices.Equal(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if firstWord.StartMS != 3000 {
+		t.Errorf("expected the first word at 3000ms of session audio, got %+v", firstWord)
+	}
+	if len(notes) != 1 || len(notes[0].Note.Symptoms) != 1 || notes[0].Note.Symptoms[0] != "headache" {
+		t.Errorf("expected one note for the whole turn, got %+v", notes)
+	}
+	if received := stt.received.Load(); received >= int64(sent) {
+		t.Errorf("expected silence to be dropped, STT received %d of %d bytes", received, sent)
+	}
+}
+
+// chattyTranscriber sends a final and an interim result for every read of
+// audio, before reading more.
+type chattyTranscriber struct {
+	*intelligence.LocalTranscriber
+}
+
+func (s *chattyTranscriber) StreamTranscribe(ctx context.Context, cfg intelligence.StreamConfig, audioStream io.Reader) (<-chan intelligence.TranscriptResult, <-chan error) {
+	results := make(chan intelligence.TranscriptResult)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(results)
+		buf := make([]byte, 3200)
+		for {
+			if _, err := audioStream.Read(buf); err != nil {
+				return
+			}
+			results <- intelligence.TranscriptResult{Text: "patient reports", IsFinal: true}
+			results <- intelligence.TranscriptResult{Text: "a head", Stability: 0.5}
+		}
+	}()
+	return results, errs
+}
+
+func TestServeWS_SeveralResultsPerRead(t *testing.T) {
+	for _, vad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("vad=%v", vad), func(t *testing.T) {
+			handler, _ := newTestHandler(t)
+			handler.sttClient = &chattyTranscriber{LocalTranscriber: intelligence.NewLocalTranscriber(nil)}
+			conn := dialWS(t, handler)
+
+			sendControl(t, conn, protocol.TypeStart, protocol.StartPayload{VAD: &vad})
+			// Each frame takes several reads, so results arrive while the
+			// frame is still being written to STT.
+			for range 3 {
+				conn.WriteMessage(websocket.BinaryMessage, pcmAudio(time.Second, true))
+			}
+			sendControl(t, conn, protocol.TypeEnd, nil)
+
+			events := readEvents(t, conn)
+			if len(events) == 0 || events[len(events)-1].Type != protocol.TypeSessionClosed {
+				t.Fatalf("expected the session to close, got %d events", len(events))
+			}
+		})
+	}
+}
@@ -0,0 +1,352 @@
+package ingestion
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"clinical-agent-backend/internal/domain"
+	"clinical-agent-backend/internal/ehr"
+	"clinical-agent-backend/internal/intelligence"
+	"clinical-agent-backend/internal/protocol"
+	"clinical-agent-backend/internal/repository"
+)
+
+// WithJobRepository stores upload transcription jobs in repo. By default
+// jobs are kept in memory and lost on restart.
+func WithJobRepository(repo repository.TranscriptionJobRepository) Option {
+	return func(h *Handler) { h.jobs = repo }
+}
+
+// WithJobSpoolDir stores uploaded audio in dir until its job is done. The
+// directory must survive restarts for jobs to. By default a directory under
+// os.TempDir is used.
+func WithJobSpoolDir(dir string) Option {
+	return func(h *Handler) { h.spoolDir = dir }
+}
+
+// createJob spools the uploaded audio and records a queued job for it,
+// with the task that runs it.
+func (h *Handler) createJob(ctx context.Context, cfg intelligence.StreamConfig, encounter domain.EncounterContext, audioStream io.Reader) (*domain.TranscriptionJob, error) {
+	if err := os.MkdirAll(h.spoolDir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	now := time.Now().UTC()
+	job := &domain.TranscriptionJob{
+		ID:        newSessionID(),
+		Status:    domain.JobQueued,
+		Source:    domain.RecordingFromUpload,
+		Encounter: encounter,
+		Audio:     jobAudio(cfg),
+		Version:   1,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	job.AudioPath = filepath.Join(h.spoolDir, job.ID+".audio")
+
+	f, err := os.OpenFile(job.AudioPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to spool audio: %w", err)
+	}
+	if h.archive != nil {
+		err = h.archiveUpload(ctx, job.ID, cfg, encounter, f, audioStream)
+		job.RecordingID = job.ID
+	} else {
+		_, err = io.Copy(f, audioStream)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(job.AudioPath)
+		return nil, fmt.Errorf("failed to spool audio: %w", err)
+	}
+
+	if err := h.jobs.Create(ctx, job, h.newTask(domain.TaskJob, job.ID)); err != nil {
+		os.Remove(job.AudioPath)
+		if h.archive != nil {
+			h.archive.Delete(ctx, job.ID)
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+// processJob runs a job from wherever it stopped: transcription, then
+// extraction, FHIR mapping and persistence. A job that failed is run again,
+// from its transcript if it has one.
+func (h *Handler) processJob(ctx context.Context, id string) error {
+	job, err := h.jobs.FindByID(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return permanentFailure(protocol.ErrCodePersistenceFailed, err)
+	}
+	if err != nil {
+		return failure(protocol.ErrCodePersistenceFailed, fmt.Errorf("failed to load job: %w", err))
+	}
+	if job.Status == domain.JobSaved {
+		return nil
+	}
+
+	if job.Transcript == "" && job.Status != domain.JobExtracting {
+		h.setJobStatus(ctx, job, domain.JobTranscribing)
+		results, err := h.transcribeJob(ctx, job)
+		if errors.Is(err, errNotArchived) || errors.Is(err, intelligence.ErrAudioTooLarge) {
+			return permanentFailure(protocol.ErrCodeSTTFailed, fmt.Errorf("transcription failed: %w", err))
+		}
+		if err != nil {
+			return failure(protocol.ErrCodeSTTFailed, fmt.Errorf("transcription failed: %w", err))
+		}
+		job.Segments = intelligence.AssembleTranscript(results, jobStreamConfig(job))
+		job.Transcript = domain.TranscriptText(job.Segments)
+	}
+	if job.Status != domain.JobExtracting {
+		h.setJobStatus(ctx, job, domain.JobExtracting)
+	}
+
+	if strings.TrimSpace(job.Transcript) == "" {
+		return permanentFailure(protocol.ErrCodeSTTFailed, errors.New("no speech was recognized"))
+	}
+
+	note, err := h.extractJob(ctx, job)
+	if err != nil {
+		return failure(protocol.ErrCodeExtractionFailed, fmt.Errorf("entity extraction failed: %w", err))
+	}
+	job.Note = note
+	log.Printf("Job %s: extracted Clinical Note: %+v", job.ID, note)
+
+	fhirResource, err := ehr.MapToFHIR(*note, job.Encounter)
+	if err != nil {
+		return permanentFailure(protocol.ErrCodeMappingFailed, fmt.Errorf("FHIR mapping failed: %w", err))
+	}
+	if job.RecordingID != "" {
+		ehr.LinkRecording(fhirResource, job.RecordingID)
+	}
+	if job.ReprocessOf != "" {
+		ehr.LinkVersion(fhirResource, h.previousImpressionID(ctx, job), job.Version)
+	}
+	// Linked to the job, a retry after a save whose job update was lost
+	// finds the saved impression instead of adding another.
+	ehr.LinkJob(fhirResource, job.ID)
+	if err := h.repo.Save(ctx, fhirResource); err != nil {
+		return failure(protocol.ErrCodePersistenceFailed, fmt.Errorf("failed to save clinical impression: %w", err))
+	}
+	job.ImpressionID = *fhirResource.Id
+	job.Error = ""
+	h.finishJob(ctx, job, domain.JobSaved)
+	log.Printf("Job %s saved as Clinical Impression %s", job.ID, job.ImpressionID)
+	return nil
+}
+
+// extractJob extracts the clinical note from the job's transcript.
+func (h *Handler) extractJob(ctx context.Context, job *domain.TranscriptionJob) (*domain.ClinicalNote, error) {
+	language := domain.TranscriptLanguage(job.Segments, job.Audio.LanguageCode)
+	note, err := h.extractor.ExtractEntities(ctx, extractionText(job), language)
+	if err != nil {
+		return nil, err
+	}
+	note.SourceLanguage = language
+	return note, nil
+}
+
+// extractionText is the text entities are extracted from: the
+// speaker-attributed dialogue when segments have roles, otherwise the plain
+// transcript.
+func extractionText(job *domain.TranscriptionJob) string {
+	for _, segment := range job.Segments {
+		if segment.Role != "" {
+			return domain.Dialogue(job.Segments)
+		}
+	}
+	return job.Transcript
+}
+
+// jobAudio records the recognition settings audio was uploaded with.
+func jobAudio(cfg intelligence.StreamConfig) domain.JobAudio {
+	return domain.JobAudio{
+		Encoding:                 cfg.Encoding,
+		SampleRateHertz:          cfg.SampleRateHertz,
+		Channels:                 cfg.Channels,
+		LanguageCode:             cfg.LanguageCode,
+		AlternativeLanguageCodes: cfg.AlternativeLanguageCodes,
+		Speakers:                 cfg.Speakers,
+		Model:                    cfg.Model,
+		UseEnhanced:              cfg.UseEnhanced,
+		PhraseSets:               cfg.PhraseSets,
+	}
+}
+
+// jobStreamConfig returns the recognition settings the job was uploaded with.
+func jobStreamConfig(job *domain.TranscriptionJob) intelligence.StreamConfig {
+	return intelligence.StreamConfig{
+		Encoding:                 job.Audio.Encoding,
+		SampleRateHertz:          job.Audio.SampleRateHertz,
+		Channels:                 job.Audio.Channels,
+		LanguageCode:             job.Audio.LanguageCode,
+		AlternativeLanguageCodes: job.Audio.AlternativeLanguageCodes,
+		Model:                    job.Audio.Model,
+		UseEnhanced:              job.Audio.UseEnhanced,
+		PhraseSets:               job.Audio.PhraseSets,
+		Speakers:                 job.Audio.Speakers,
+	}.WithDefaults()
+}
+
+// errNotArchived is returned by readJobAudio for a job with neither spooled
+// nor archived audio.
+var errNotArchived = errors.New("the audio was not archived")
+
+// readJobAudio opens the audio to transcribe and returns it with its size:
+// the spooled upload, or for a reprocessing job the archived recording. An
+// upload spooled on another node is read from the archive if it was
+// archived.
+func (h *Handler) readJobAudio(ctx context.Context, job *domain.TranscriptionJob) (io.ReadCloser, int64, error) {
+	if job.AudioPath != "" {
+		f, err := os.Open(job.AudioPath)
+		if err == nil {
+			info, err := f.Stat()
+			if err != nil {
+				f.Close()
+				return nil, 0, fmt.Errorf("spooled audio unavailable: %w", err)
+			}
+			return f, info.Size(), nil
+		}
+		if job.RecordingID == "" || h.archive == nil {
+			return nil, 0, fmt.Errorf("spooled audio unavailable: %w", err)
+		}
+	}
+	if job.RecordingID == "" || h.archive == nil {
+		return nil, 0, errNotArchived
+	}
+	recording, size, err := h.archive.Stream(ctx, job.RecordingID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("archived audio unavailable: %w", err)
+	}
+	return recording, size, nil
+}
+
+// transcribeJob transcribes the job's audio as a batch: clips short enough
+// for a synchronous request are read whole and use Recognize, everything
+// else (including compressed audio of unknown length) is streamed from disk
+// through RecognizeLong, so a long recording is never held in memory.
+func (h *Handler) transcribeJob(ctx context.Context, job *domain.TranscriptionJob) ([]intelligence.TranscriptResult, error) {
+	recording, size, err := h.readJobAudio(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	defer recording.Close()
+
+	// The vocabulary is looked up again so edits made while the job was
+	// queued apply.
+	cfg := jobStreamConfig(job)
+	if err := h.attachVocabulary(ctx, &cfg); err != nil {
+		return nil, err
+	}
+	if d, ok := cfg.Duration(size); ok && d <= intelligence.MaxSyncRecognizeDuration {
+		clip, err := io.ReadAll(recording)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audio: %w", err)
+		}
+		return h.sttClient.Recognize(ctx, cfg, clip)
+	}
+	log.Printf("Job %s: using long-running recognition for %d bytes of %s audio", job.ID, size, cfg.Encoding)
+	return h.sttClient.RecognizeLong(ctx, cfg, recording)
+}
+
+// setJobStatus records progress. Failing to record it is logged but does
+// not stop the job; the final state is what matters.
+func (h *Handler) setJobStatus(ctx context.Context, job *domain.TranscriptionJob, status domain.JobStatus) {
+	job.Status = status
+	job.UpdatedAt = time.Now().UTC()
+	if err := h.jobs.Update(ctx, job); err != nil {
+		log.Printf("Failed to update job %s to %s: %v", job.ID, status, err)
+	}
+}
+
+// failJob records why a job failed.
+func (h *Handler) failJob(ctx context.Context, job *domain.TranscriptionJob, err error) {
+	log.Printf("Job %s failed: %v", job.ID, err)
+	job.Error = err.Error()
+	h.finishJob(ctx, job, domain.JobFailed)
+}
+
+// finishJob moves a job to a final state and discards its spooled audio.
+func (h *Handler) finishJob(ctx context.Context, job *domain.TranscriptionJob, status domain.JobStatus) {
+	if ctx.Err() != nil {
+		// Shutting down: the job's task is taken over once its lease
+		// runs out.
+		return
+	}
+	if job.AudioPath != "" {
+		if err := os.Remove(job.AudioPath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove spooled audio for job %s: %v", job.ID, err)
+		}
+		job.AudioPath = ""
+	}
+	h.setJobStatus(ctx, job, status)
+}
+
+// HandleGetJob handles GET /jobs/{id}, returning the job's status,
+// transcript, note and impression ID.
+func (h *Handler) HandleGetJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	job, err := h.jobs.FindByID(r.Context(), r.PathValue("id"))
+	if errors.Is(err, repository.ErrNotFound) {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to fetch job: %v", err)
+		http.Error(w, "Failed to fetch job", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		log.Printf("Failed to encode job: %v", err)
+	}
+}
+
+// HandleListJobs handles GET /jobs, optionally filtered by the
+// patient_reference, encounter_reference and status query parameters.
+func (h *Handler) HandleListJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := repository.JobFilter{
+		PatientReference:   query.Get("patient_reference"),
+		EncounterReference: query.Get("encounter_reference"),
+	}
+	for _, status := range query["status"] {
+		filter.Statuses = append(filter.Statuses, domain.JobStatus(status))
+	}
+
+	jobs, err := h.jobs.Find(r.Context(), filter)
+	if err != nil {
+		log.Printf("Failed to fetch jobs: %v", err)
+		http.Error(w, "Failed to fetch jobs", http.StatusInternalServerError)
+		return
+	}
+	if jobs == nil {
+		jobs = []*domain.TranscriptionJob{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(jobs); err != nil {
+		log.Printf("Failed to encode jobs: %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package ingestion
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"clinical-agent-backend/internal/domain"
+	"clinical-agent-backend/internal/protocol"
+)
+
+// extractionQueueSize bounds how many speaker turns may wait for
+// extraction before the transcript loop blocks.
+const extractionQueueSize = 64
+
+// runExtraction processes speaker turns one at a time, in the order they
+// were received, and pushes the running note, the persisted impression ID or
+// the failing stage back to the client before moving on to the next
+// turn.
+func (h *Handler) runExtraction(ctx context.Context, session *wsSession, turns <-chan domain.SessionTurn) {
+	for turn := range turns {
+		h.processTranscript(ctx, session, turn)
+		session.pending.Add(-1)
+	}
+}
+
+// processTranscript queues a speaker turn for extraction into the session's
+// running note and impression, runs it, and reports the outcome to the
+// client.
+func (h *Handler) processTranscript(ctx context.Context, session *wsSession, turn domain.SessionTurn) {
+	task := h.newTask(domain.TaskSessionTurn, session.id)
+	task.Turn = &turn
+	task = h.runSessionTask(ctx, session, task)
+	if !reportSessionTask(session, task, turn.TranscriptSeq) {
+		return
+	}
+	job, err := h.jobs.FindByID(ctx, session.id)
+	if err != nil || job.Note == nil {
+		log.Printf("Session %s: failed to load the running note: %v", session.id, err)
+		session.sendError(protocol.ErrCodePersistenceFailed, "failed to load the running note", turn.TranscriptSeq)
+		return
+	}
+	session.impressionID = job.ImpressionID
+	session.sendEvent(protocol.TypeNote, protocol.NotePayload{
+		TranscriptSeq: turn.TranscriptSeq,
+		Note:          *job.Note,
+	})
+	session.sendEvent(protocol.TypeImpression, protocol.ImpressionPayload{
+		TranscriptSeq: turn.TranscriptSeq,
+		ID:            session.impressionID,
+	})
+}
+
+// finishSession queues the end of the session, which records its transcript
+// on the session job and completes its impression, and runs it. Sessions
+// that neither transcribed nor recorded anything leave no job.
+func (h *Handler) finishSession(ctx context.Context, session *wsSession, sttErr error) {
+	session.transcriptMu.Lock()
+	segments := session.transcript.Segments()
+	session.transcriptMu.Unlock()
+	end := &domain.SessionEnd{Segments: segments, RecordingID: session.recording.recordingID()}
+	if sttErr != nil {
+		end.Error = sttErr.Error()
+	}
+	if !session.jobCreated && len(segments) == 0 && end.RecordingID == "" {
+		return
+	}
+
+	task := h.newTask(domain.TaskSessionEnd, session.id)
+	task.End = end
+	task = h.runSessionTask(ctx, session, task)
+	if !reportSessionTask(session, task, 0) {
+		return
+	}
+	if job, err := h.jobs.FindByID(ctx, session.id); err == nil {
+		session.impressionID = job.ImpressionID
+	}
+}
+
+// reportSessionTask tells the client why a session task did not finish,
+// against transcriptSeq. It reports whether the task is done.
+func reportSessionTask(session *wsSession, task *domain.Task, transcriptSeq uint64) bool {
+	switch {
+	case task == nil:
+		session.sendError(protocol.ErrCodePersistenceFailed, "failed to queue the session's work", transcriptSeq)
+	case task.Status == domain.TaskDead:
+		session.sendError(task.ErrorCode, task.Error, transcriptSeq)
+	case task.Status != domain.TaskDone:
+		log.Printf("Session %s: task %d is still unfinished; leaving it to the workers", session.id, task.ID)
+		code := task.ErrorCode
+		if code == "" {
+			code = protocol.ErrCodePersistenceFailed
+		}
+		session.sendError(code, fmt.Sprintf("unfinished after %s; the server keeps retrying", sessionTaskWait), transcriptSeq)
+	default:
+		return true
+	}
+	return false
+}
+
+// runSessionTask queues a task of the session and runs it on the session's
+// own goroutine, so its results reach the client without waiting for a
+// worker. Only the first task is created in the same transaction as the
+// session's job; later tasks are queued on their own, and one that cannot
+// be queued is reported to the client and dropped. Failed attempts are
+// retried here after their backoff; a task still unfinished after
+// sessionTaskWait, or when ctx is cancelled, is left to the workers. It
+// returns the task as last seen, or nil if it could not be queued.
+func (h *Handler) runSessionTask(ctx context.Context, session *wsSession, task *domain.Task) *domain.Task {
+	queue := h.jobs.Queue()
+	// The task is claimed as it is queued, unless an earlier task of the
+	// session is unfinished: the queue then runs them in order.
+	claimed := session.lastTask == nil || session.lastTask.Status.Finished()
+	if claimed {
+		task.Status = domain.TaskRunning
+		task.Attempts = 1
+		task.Worker = h.workerID
+		task.VisibleAt = time.Now().UTC().Add(taskLease)
+	}
+	var err error
+	if session.jobCreated {
+		err = queue.Enqueue(ctx, task)
+	} else if err = h.jobs.Create(ctx, h.sessionJob(session), task); err == nil {
+		session.jobCreated = true
+	}
+	if err != nil {
+		log.Printf("Session %s: failed to queue %s task: %v", session.id, task.Kind, err)
+		return nil
+	}
+	session.lastTask = task
+
+	deadline := time.Now().Add(sessionTaskWait)
+	for {
+		if claimed {
+			h.runTask(ctx, task)
+			claimed = false
+		}
+		if task.Status.Finished() || ctx.Err() != nil || time.Now().After(deadline) {
+			return task
+		}
+		// Wait for the retry, or for the worker that holds the task.
+		wait := time.NewTimer(max(min(time.Until(task.VisibleAt), taskPollInterval), 0))
+		select {
+		case <-ctx.Done():
+			wait.Stop()
+			return task
+		case <-wait.C:
+		}
+		next, err := queue.ClaimByID(ctx, task.ID, h.workerID, taskLease)
+		if err == nil && next != nil {
+			*task = *next
+			claimed = true
+			continue
+		}
+		if err == nil {
+			next, err = queue.FindByID(ctx, task.ID)
+		}
+		if err != nil {
+			log.Printf("Session %s: failed to check task %d: %v", session.id, task.ID, err)
+			continue
+		}
+		*task = *next
+	}
+}
+
+// sessionJob returns the job a session's transcript, running note and
+// impression are recorded on, so the encounter can be reprocessed.
+func (h *Handler) sessionJob(session *wsSession) *domain.TranscriptionJob {
+	return &domain.TranscriptionJob{
+		ID:          session.id,
+		Status:      domain.JobTranscribing,
+		Source:      domain.RecordingFromSession,
+		Encounter:   session.encounter,
+		Audio:       jobAudio(session.cfg),
+		RecordingID: session.recording.recordingID(),
+		Version:     1,
+		CreatedAt:   session.started,
+		UpdatedAt:   time.Now().UTC(),
+	}
+}
@@ -0,0 +1,231 @@
+package ingestion
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"clinical-agent-backend/internal/domain"
+	"clinical-agent-backend/internal/ehr"
+	"clinical-agent-backend/internal/intelligence"
+	"clinical-agent-backend/internal/repository"
+)
+
+// maxReprocessEncounters bounds how many encounters one request may select.
+const maxReprocessEncounters = 500
+
+// maxDryRunEncounters bounds how many encounters a dry run may select, since
+// it runs while the client waits.
+const maxDryRunEncounters = 20
+
+// maxReprocessBody bounds the size of a reprocess request.
+const maxReprocessBody = 64 << 10
+
+// ErrBadReprocessRequest is returned by Reprocess for requests that select
+// nothing or too much.
+var ErrBadReprocessRequest = errors.New("invalid reprocess request")
+
+// Reprocess generates the notes of the encounters req selects again, as new
+// versions next to the current ones. Each encounter gets a reprocessing job
+// run by the job workers, unless req is a dry run: then the new notes are
+// produced right away and compared with the current ones, and nothing is
+// saved. An encounter that cannot be reprocessed gets a result with an
+// error rather than failing the rest.
+func (h *Handler) Reprocess(ctx context.Context, req domain.ReprocessRequest) ([]domain.ReprocessResult, error) {
+	if req.Empty() {
+		return nil, fmt.Errorf("%w: select encounters by job ID, patient, encounter or date", ErrBadReprocessRequest)
+	}
+	originals, err := h.jobs.Find(ctx, repository.JobFilter{
+		IDs:                req.JobIDs,
+		PatientReference:   req.PatientReference,
+		EncounterReference: req.EncounterReference,
+		CreatedFrom:        req.From,
+		CreatedTo:          req.To,
+		Originals:          true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	limit := maxReprocessEncounters
+	if req.DryRun {
+		limit = maxDryRunEncounters
+	}
+	if len(originals) > limit {
+		return nil, fmt.Errorf("%w: %d encounters selected, at most %d allowed", ErrBadReprocessRequest, len(originals), limit)
+	}
+
+	results := make([]domain.ReprocessResult, 0, len(originals))
+	for _, original := range originals {
+		result := domain.ReprocessResult{JobID: original.ID, Encounter: original.Encounter}
+		if err := h.reprocessEncounter(ctx, original, req, &result); err != nil {
+			log.Printf("Reprocessing job %s: %v", original.ID, err)
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// reprocessEncounter reprocesses the encounter of original job, filling in
+// result.
+func (h *Handler) reprocessEncounter(ctx context.Context, original *domain.TranscriptionJob, req domain.ReprocessRequest, result *domain.ReprocessResult) error {
+	versions, err := h.encounterVersions(ctx, original)
+	if err != nil {
+		return err
+	}
+	// The base is the current version: the latest saved one, or the
+	// original if none was saved.
+	base := original
+	next := 1
+	for _, v := range versions {
+		if !v.Status.Done() && !req.DryRun {
+			return fmt.Errorf("job %s is still processing this encounter", v.ID)
+		}
+		if v.Status == domain.JobSaved && (base.Status != domain.JobSaved || v.Version > base.Version) {
+			base = v
+		}
+		next = max(next, v.Version+1)
+	}
+	result.BaseJobID = base.ID
+	result.OldNote = base.Note
+	result.Version = next
+
+	now := time.Now().UTC()
+	job := &domain.TranscriptionJob{
+		ID:          newSessionID(),
+		Status:      domain.JobQueued,
+		Source:      original.Source,
+		Encounter:   original.Encounter,
+		Audio:       original.Audio,
+		RecordingID: original.RecordingID,
+		ReprocessOf: original.ID,
+		Version:     next,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if req.Retranscribe {
+		if job.RecordingID == "" || h.archive == nil {
+			return errors.New("the encounter's audio was not archived, so it cannot be transcribed again")
+		}
+	} else {
+		if strings.TrimSpace(base.Transcript) == "" {
+			return errors.New("the encounter has no transcript to extract from")
+		}
+		job.Status = domain.JobExtracting
+		job.Transcript = base.Transcript
+		job.Segments = base.Segments
+	}
+
+	if req.DryRun {
+		return h.dryRun(ctx, job, result)
+	}
+	if err := h.jobs.Create(ctx, job, h.newTask(domain.TaskJob, job.ID)); err != nil {
+		return err
+	}
+	h.wakeWorkers()
+	log.Printf("Job %s reprocesses job %s as version %d", job.ID, original.ID, job.Version)
+	result.Job = job
+	return nil
+}
+
+// dryRun produces the note job would, without saving it, and compares it
+// with result.OldNote.
+func (h *Handler) dryRun(ctx context.Context, job *domain.TranscriptionJob, result *domain.ReprocessResult) error {
+	if job.Status != domain.JobExtracting {
+		results, err := h.transcribeJob(ctx, job)
+		if err != nil {
+			return fmt.Errorf("transcription failed: %w", err)
+		}
+		job.Segments = intelligence.AssembleTranscript(results, jobStreamConfig(job))
+		job.Transcript = domain.TranscriptText(job.Segments)
+		result.Transcript = job.Transcript
+		if strings.TrimSpace(job.Transcript) == "" {
+			return errors.New("no speech was recognized")
+		}
+	}
+	note, err := h.extractJob(ctx, job)
+	if err != nil {
+		return fmt.Errorf("entity extraction failed: %w", err)
+	}
+	if _, err := ehr.MapToFHIR(*note, job.Encounter); err != nil {
+		return fmt.Errorf("FHIR mapping failed: %w", err)
+	}
+	diff := domain.DiffNotes(result.OldNote, note)
+	result.NewNote = note
+	result.Diff = &diff
+	return nil
+}
+
+// encounterVersions returns the original job of an encounter and every job
+// reprocessing it.
+func (h *Handler) encounterVersions(ctx context.Context, original *domain.TranscriptionJob) ([]*domain.TranscriptionJob, error) {
+	versions, err := h.jobs.Find(ctx, repository.JobFilter{ReprocessOf: original.ID})
+	if err != nil {
+		return nil, err
+	}
+	return append(versions, original), nil
+}
+
+// previousImpressionID returns the impression a reprocessing job's output
+// succeeds: that of the latest saved earlier version of the encounter, or
+// "" if there is none.
+func (h *Handler) previousImpressionID(ctx context.Context, job *domain.TranscriptionJob) string {
+	original, err := h.jobs.FindByID(ctx, job.ReprocessOf)
+	if err != nil {
+		log.Printf("Job %s: failed to load original job %s: %v", job.ID, job.ReprocessOf, err)
+		return ""
+	}
+	versions, err := h.encounterVersions(ctx, original)
+	if err != nil {
+		log.Printf("Job %s: failed to load earlier versions: %v", job.ID, err)
+		return ""
+	}
+	slices.SortFunc(versions, func(a, b *domain.TranscriptionJob) int { return b.Version - a.Version })
+	for _, v := range versions {
+		if v.Version < job.Version && v.Status == domain.JobSaved && v.ImpressionID != "" {
+			return v.ImpressionID
+		}
+	}
+	return ""
+}
+
+// HandleReprocess handles POST /admin/reprocess, whose body is a
+// domain.ReprocessRequest. The response lists a domain.ReprocessResult per
+// selected encounter: 202 once reprocessing jobs are queued, 200 for a dry
+// run.
+func (h *Handler) HandleReprocess(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+
+	var req domain.ReprocessRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxReprocessBody)).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	results, err := h.Reprocess(r.Context(), req)
+	if errors.Is(err, ErrBadReprocessRequest) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to reprocess: %v", err)
+		http.Error(w, "Failed to reprocess", http.StatusInternalServerError)
+		return
+	}
+	status := http.StatusAccepted
+	if req.DryRun {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, results)
+}
@@ -0,0 +1,136 @@
+package ingestion
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"clinical-agent-backend/internal/domain"
+	"clinical-agent-backend/internal/ehr"
+	"clinical-agent-backend/internal/intelligence"
+	"clinical-agent-backend/internal/protocol"
+	"clinical-agent-backend/internal/repository"
+
+	"github.com/gorilla/websocket"
+)
+
+// stubExtractor returns a fixed note, standing in for a changed prompt.
+type stubExtractor struct {
+	note domain.ClinicalNote
+}
+
+func (s stubExtractor) ExtractEntities(ctx context.Context, text, language string) (*domain.ClinicalNote, error) {
+	note := s.note
+	return &note, nil
+}
+
+func reprocessRequest(t *testing.T, handler *Handler, body string, status int) []domain.ReprocessResult {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/reprocess", handler.HandleReprocess)
+	rec := adminRequest(mux, http.MethodPost, "/admin/reprocess", "secret", body)
+	if rec.Code != status {
+		t.Fatalf("%s: expected %d, got %d: %s", body, status, rec.Code, rec.Body.String())
+	}
+	var results []domain.ReprocessResult
+	if status < 300 {
+		if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+			t.Fatalf("failed to decode results: %v", err)
+		}
+	}
+	return results
+}
+
+func TestReprocess_Extraction(t *testing.T) {
+	handler, repo := newTestHandler(t, "Patient reports a headache.")
+	WithAdminToken("secret")(handler)
+	original := waitForJob(t, handler, decodeJob(t, postUpload(t, handler, "clip.raw", make([]byte, 16000))).ID)
+	if original.Status != domain.JobSaved || original.Version != 1 || original.Source != domain.RecordingFromUpload {
+		t.Fatalf("unexpected original job: %+v", original)
+	}
+	handler.extractor = stubExtractor{domain.ClinicalNote{Symptoms: []string{"Headache", "nausea"}, HPI: []string{"since Monday"}}}
+
+	reprocessRequest(t, handler, `{}`, http.StatusBadRequest)
+	results := reprocessRequest(t, handler, `{"patient_reference": "", "job_ids": ["`+original.ID+`"], "dry_run": true}`, http.StatusOK)
+	if len(results) != 1 || results[0].Diff == nil || results[0].Error != "" {
+		t.Fatalf("expected one diff, got %+v", results)
+	}
+	diff := results[0].Diff
+	if !slices.Equal(diff.Symptoms.Added, []string{"nausea"}) || len(diff.Symptoms.Removed) != 0 ||
+		!slices.Equal(diff.HPI.Added, []string{"since Monday"}) || !slices.Equal(diff.HPI.Removed, []string{"Patient reports a headache."}) ||
+		diff.SourceLanguage != nil {
+		t.Errorf("unexpected diff: %+v", diff)
+	}
+	if jobs, _ := handler.jobs.Find(context.Background(), repository.JobFilter{}); len(jobs) != 1 {
+		t.Errorf("expected a dry run to create no jobs, got %d", len(jobs))
+	}
+
+	results = reprocessRequest(t, handler, `{"job_ids": ["`+original.ID+`"], "retranscribe": true}`, http.StatusAccepted)
+	if len(results) != 1 || results[0].Error == "" {
+		t.Errorf("expected retranscribing without an archive to fail, got %+v", results)
+	}
+
+	results = reprocessRequest(t, handler, `{"job_ids": ["`+original.ID+`"]}`, http.StatusAccepted)
+	if len(results) != 1 || results[0].Job == nil || results[0].Version != 2 {
+		t.Fatalf("expected a reprocessing job, got %+v", results)
+	}
+	job := waitForJob(t, handler, results[0].Job.ID)
+	if job.Status != domain.JobSaved || job.ReprocessOf != original.ID || job.Version != 2 || job.Transcript != original.Transcript {
+		t.Fatalf("unexpected reprocessing job: %+v", job)
+	}
+	impression, err := repo.FindByID(context.Background(), job.ImpressionID)
+	if err != nil {
+		t.Fatalf("FindByID failed: %v", err)
+	}
+	if impression.Previous == nil || *impression.Previous.Reference != "ClinicalImpression/"+original.ImpressionID {
+		t.Errorf("expected the new impression to follow %s, got %+v", original.ImpressionID, impression.Previous)
+	}
+	if _, err := repo.FindByID(context.Background(), original.ImpressionID); err != nil {
+		t.Errorf("expected the original impression to be kept: %v", err)
+	}
+
+	// The next dry run compares with the new version.
+	results = reprocessRequest(t, handler, `{"job_ids": ["`+original.ID+`"], "dry_run": true}`, http.StatusOK)
+	if len(results) != 1 || results[0].BaseJobID != job.ID || results[0].Version != 3 || !results[0].Diff.Empty() {
+		t.Errorf("expected no change from version 2, got %+v", results)
+	}
+}
+
+func TestReprocess_RetranscribesSession(t *testing.T) {
+	handler, repo := newTestHandler(t, "Patient reports a headache.")
+	withTestArchive(t, handler)
+	conn := dialWS(t, handler)
+	sendControl(t, conn, protocol.TypeStart, protocol.StartPayload{
+		Audio:     protocol.AudioConfig{Encoding: "LINEAR16", SampleRateHertz: 16000, LanguageCode: "en-US"},
+		Encounter: domain.EncounterContext{PatientReference: "Patient/123", EncounterReference: "Encounter/456"},
+	})
+	conn.WriteMessage(websocket.BinaryMessage, pcmAudio(500*time.Millisecond, true))
+	sendControl(t, conn, protocol.TypeEnd, nil)
+	readEvents(t, conn)
+
+	sessions, _ := handler.jobs.Find(context.Background(), repository.JobFilter{})
+	if len(sessions) != 1 || sessions[0].Source != domain.RecordingFromSession || sessions[0].RecordingID != sessions[0].ID ||
+		sessions[0].Transcript != "Patient reports a headache." || sessions[0].ImpressionID == "" {
+		t.Fatalf("expected the session to be recorded as a job, got %+v", sessions)
+	}
+
+	handler.sttClient = intelligence.NewLocalTranscriber([]string{"Patient reports a fever."})
+	results := reprocessRequest(t, handler, `{"encounter_reference": "Encounter/456", "retranscribe": true}`, http.StatusAccepted)
+	if len(results) != 1 || results[0].Job == nil {
+		t.Fatalf("expected a reprocessing job, got %+v", results)
+	}
+	job := waitForJob(t, handler, results[0].Job.ID)
+	if job.Status != domain.JobSaved || job.Transcript != "Patient reports a fever." || job.Note == nil || !slices.Contains(job.Note.Symptoms, "fever") {
+		t.Fatalf("expected the archived audio to be transcribed again, got %+v", job)
+	}
+	impression, err := repo.FindByID(context.Background(), job.ImpressionID)
+	if err != nil || ehr.RecordingID(impression) != sessions[0].ID {
+		t.Fatalf("expected the new impression to link to the recording (%v)", err)
+	}
+	if impression.Previous == nil || *impression.Previous.Reference != "ClinicalImpression/"+sessions[0].ImpressionID {
+		t.Errorf("expected the new impression to follow the session's, got %+v", impression.Previous)
+	}
+}
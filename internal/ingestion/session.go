@@ -0,0 +1,505 @@
+package ingestion
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"clinical-agent-backend/internal/audio"
+	"clinical-agent-backend/internal/domain"
+	"clinical-agent-backend/internal/intelligence"
+	"clinical-agent-backend/internal/protocol"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxBufferedEvents bounds the per-session replay buffer. Clients that
+// acknowledge events keep it well below this.
+const maxBufferedEvents = 1000
+
+// audioSeqSize is the length of the sequence number prefixed to audio
+// frames when a session uses sequenced audio.
+const audioSeqSize = 8
+
+// speechEventBuffer is how many utterance boundaries may wait for
+// runSession.
+const speechEventBuffer = 16
+
+// defaultResumeGrace is how long a session outlives its connection.
+const defaultResumeGrace = 2 * time.Minute
+
+// bufferedEvent is a sent server event kept for replay after a reconnect.
+type bufferedEvent struct {
+	seq   uint64
+	frame []byte
+}
+
+// wsSession holds the state of an /ws/audio session. It outlives any single
+// connection: if the client drops, the STT stream and extraction pipeline
+// keep running and the client may reconnect with the resume token within
+// the grace period, receiving every event it missed.
+type wsSession struct {
+	id      string
+	token   string
+	started time.Time
+
+	// cfg, encounter and sequencedAudio are fixed by the start handshake.
+	cfg            intelligence.StreamConfig
+	encounter      domain.EncounterContext
+	sequencedAudio bool
+
+	// audio feeds the STT stream; it is closed when the audio ends.
+	// audioMu guards the audio state, but is never held while writing to
+	// audio: the write blocks until STT reads, and STT may be waiting for
+	// runSession.
+	audio       *io.PipeWriter
+	audioMu     sync.Mutex
+	audioClosed bool
+	// lastAudioSeq is the highest sequenced audio frame accepted. It is
+	// written under audioMu but read without it, so a reconnecting client
+	// is not held up by a pending write to STT.
+	lastAudioSeq atomic.Uint64
+	// vad, when voice activity detection is on, marks utterances and drops
+	// long silences before they reach STT. Utterance boundaries are sent to
+	// the client and, through speech, to runSession.
+	vad    *audio.VAD
+	speech chan audio.VADEvent
+	// recording archives the audio as received, or is nil when the
+	// server does not archive audio.
+	recording *sessionRecording
+
+	// mu guards the connection, the event sequence and the replay buffer.
+	// gorilla/websocket allows one concurrent writer, so writes hold it too.
+	mu     sync.Mutex
+	conn   *websocket.Conn
+	seq    uint64
+	events []bufferedEvent
+	expiry *time.Timer
+	closed bool
+
+	// transcript assembles the session's results; its committed segments
+	// are replayed to reconnecting clients.
+	transcriptMu sync.Mutex
+	transcript   intelligence.TranscriptAssembler
+	pending      atomic.Int64
+
+	// jobCreated records that the session's job was stored with its first
+	// task, lastTask is the session's latest queued task, and impressionID
+	// the session's impression once one was saved. Only the extraction
+	// goroutine uses them until it has finished.
+	jobCreated   bool
+	lastTask     *domain.Task
+	impressionID string
+
+	paused         atomic.Bool
+	interimResults atomic.Bool
+
+	// done is closed once session.closed has been sent.
+	done chan struct{}
+}
+
+func newWSSession(conn *websocket.Conn) *wsSession {
+	s := &wsSession{
+		id:      newSessionID(),
+		token:   newSessionID(),
+		started: time.Now().UTC(),
+		conn:    conn,
+		cfg:     intelligence.DefaultStreamConfig(),
+		done:    make(chan struct{}),
+	}
+	s.transcript.Language = s.cfg.LanguageCode
+	s.interimResults.Store(true)
+	return s
+}
+
+// newSessionID returns a random 128-bit hex identifier.
+func newSessionID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic("crypto/rand failed: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
+
+// send records a server event for replay and writes it to the connection,
+// if one is attached. It returns the sequence number assigned to the event;
+// a failed write detaches the connection but is not an error for the session.
+func (s *wsSession) send(msgType string, payload any) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	frame, err := protocol.Encode(msgType, s.seq+1, payload)
+	if err != nil {
+		return 0, err
+	}
+	s.seq++
+	s.events = append(s.events, bufferedEvent{seq: s.seq, frame: frame})
+	if len(s.events) > maxBufferedEvents {
+		s.events = s.events[len(s.events)-maxBufferedEvents:]
+	}
+
+	if s.conn == nil {
+		return s.seq, nil
+	}
+	if err := s.conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+		log.Printf("Websocket write error (%s), detaching session %s: %v", msgType, s.id, err)
+		s.conn.Close()
+		return s.seq, err
+	}
+	return s.seq, nil
+}
+
+// sendEvent writes a server event, logging on failure, and returns its
+// sequence number.
+func (s *wsSession) sendEvent(msgType string, payload any) uint64 {
+	seq, err := s.send(msgType, payload)
+	if err != nil && seq == 0 {
+		log.Printf("Failed to encode %s event: %v", msgType, err)
+	}
+	return seq
+}
+
+// sendError reports a failure to the client.
+func (s *wsSession) sendError(code, message string, transcriptSeq uint64) {
+	s.sendEvent(protocol.TypeError, protocol.ErrorPayload{
+		Code:          code,
+		Message:       message,
+		TranscriptSeq: transcriptSeq,
+	})
+}
+
+// writeDirect sends a frame to conn without recording it, for responses to
+// connections that never joined a session.
+func writeDirect(conn *websocket.Conn, msgType string, payload any) {
+	frame, err := protocol.Encode(msgType, 0, payload)
+	if err != nil {
+		log.Printf("Failed to encode %s: %v", msgType, err)
+		return
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+		log.Printf("Failed to write %s: %v", msgType, err)
+	}
+}
+
+// rejectConn tells a client why its connection is refused and closes it.
+func rejectConn(conn *websocket.Conn, code, message string) {
+	log.Printf("Rejecting session: %s", message)
+	writeDirect(conn, protocol.TypeError, protocol.ErrorPayload{Code: code, Message: message})
+	writeDirect(conn, protocol.TypeSessionClosed, protocol.SessionClosedPayload{Reason: message})
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, message))
+}
+
+// acknowledge drops buffered events up to seq.
+func (s *wsSession) acknowledge(seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := 0
+	for i < len(s.events) && s.events[i].seq <= seq {
+		i++
+	}
+	s.events = s.events[i:]
+}
+
+// attach makes conn the session's connection, replaying events after
+// lastEventSeq. Any previous connection is closed. If the session has
+// already finished, the remaining events are replayed, conn is closed and
+// attach reports false.
+func (s *wsSession) attach(conn *websocket.Conn, lastEventSeq uint64, resumed protocol.SessionResumedPayload) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		if s.expiry != nil {
+			s.expiry.Stop()
+			s.expiry = nil
+		}
+		if s.conn != nil && s.conn != conn {
+			s.conn.Close()
+		}
+		s.conn = conn
+	}
+
+	// session.resumed is not buffered: it only concerns this connection.
+	frame, err := protocol.Encode(protocol.TypeSessionResumed, 0, resumed)
+	if err == nil {
+		err = conn.WriteMessage(websocket.TextMessage, frame)
+	}
+	if err != nil {
+		log.Printf("Failed to write session.resumed: %v", err)
+		return !s.closed
+	}
+
+	if len(s.events) > 0 && s.events[0].seq > lastEventSeq+1 {
+		gap, _ := protocol.Encode(protocol.TypeError, 0, protocol.ErrorPayload{
+			Code:    protocol.ErrCodeReplayGap,
+			Message: "some events are no longer available for replay",
+		})
+		conn.WriteMessage(websocket.TextMessage, gap)
+	}
+	for _, ev := range s.events {
+		if ev.seq <= lastEventSeq {
+			continue
+		}
+		if err := conn.WriteMessage(websocket.TextMessage, ev.frame); err != nil {
+			log.Printf("Failed to replay event %d: %v", ev.seq, err)
+			break
+		}
+	}
+
+	if s.closed {
+		closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "session closed")
+		conn.WriteMessage(websocket.CloseMessage, closeMsg)
+		return false
+	}
+	return true
+}
+
+// detach forgets conn if it is still the session's connection and starts
+// the grace period after which onExpire is called.
+func (s *wsSession) detach(conn *websocket.Conn, grace time.Duration, onExpire func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn != conn || s.closed {
+		return
+	}
+	s.conn = nil
+	log.Printf("Session %s detached; holding for %s", s.id, grace)
+	s.expiry = time.AfterFunc(grace, onExpire)
+}
+
+// isAttached reports whether conn is the session's current connection.
+func (s *wsSession) isAttached(conn *websocket.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn == conn
+}
+
+// writeAudio forwards an audio frame to STT, dropping sequenced frames
+// that were already received. It reports false once the audio has ended.
+func (s *wsSession) writeAudio(seq uint64, data []byte) bool {
+	data, ok := s.acceptAudio(seq, data)
+	if !ok || len(data) == 0 {
+		return ok
+	}
+	if _, err := s.audio.Write(data); err != nil {
+		log.Printf("Pipe write error: %v", err)
+		s.audioMu.Lock()
+		s.audioClosed = true
+		s.audioMu.Unlock()
+		return false
+	}
+	return true
+}
+
+// acceptAudio records an audio frame and returns the audio to forward to
+// STT. It reports false once the audio has ended.
+func (s *wsSession) acceptAudio(seq uint64, data []byte) ([]byte, bool) {
+	s.audioMu.Lock()
+	defer s.audioMu.Unlock()
+
+	if s.audioClosed {
+		return nil, false
+	}
+	if s.sequencedAudio {
+		last := s.lastAudioSeq.Load()
+		if seq <= last {
+			return nil, true // duplicate resent after a reconnect
+		}
+		if seq > last+1 {
+			log.Printf("Session %s: audio frames %d-%d missing", s.id, last+1, seq-1)
+		}
+		s.lastAudioSeq.Store(seq)
+	}
+	s.recording.write(data)
+	if s.vad != nil {
+		var events []audio.VADEvent
+		data, events = s.vad.Process(data)
+		s.notifySpeech(events)
+	}
+	return data, true
+}
+
+// endAudio signals the end of the audio stream to STT.
+func (s *wsSession) endAudio() {
+	s.audioMu.Lock()
+	if s.audioClosed {
+		s.audioMu.Unlock()
+		return
+	}
+	var data []byte
+	if s.vad != nil {
+		var events []audio.VADEvent
+		data, events = s.vad.Flush()
+		s.notifySpeech(events)
+	}
+	// The recording is stored before STT sees the end, so it exists by the
+	// time the session closes.
+	s.recording.finish()
+	s.audioClosed = true
+	s.audioMu.Unlock()
+
+	if len(data) > 0 {
+		s.audio.Write(data)
+	}
+	s.audio.Close()
+}
+
+// notifySpeech reports utterance boundaries to the client and to
+// runSession. runSession only uses them to end turns early, so a boundary
+// it is too busy to take is not waited for.
+func (s *wsSession) notifySpeech(events []audio.VADEvent) {
+	for _, ev := range events {
+		msgType := protocol.TypeSpeechStarted
+		if ev.Type == audio.SpeechEnd {
+			msgType = protocol.TypeSpeechEnded
+		}
+		s.sendEvent(msgType, protocol.SpeechPayload{OffsetMS: ev.At.Milliseconds()})
+		select {
+		case s.speech <- ev:
+		default:
+			log.Printf("Session %s: dropped utterance boundary at %s", s.id, ev.At)
+		}
+	}
+}
+
+// receivedTime shifts the word offsets of result, which count only the
+// audio sent to STT, back onto the session's audio by adding the silence
+// the VAD dropped. It does not take audioMu: the VAD guards its record of
+// dropped audio itself.
+func (s *wsSession) receivedTime(result intelligence.TranscriptResult) intelligence.TranscriptResult {
+	if s.vad == nil {
+		return result
+	}
+	for i, w := range result.Words {
+		result.Words[i].StartMS = s.vad.ReceivedOffset(time.Duration(w.StartMS) * time.Millisecond).Milliseconds()
+		result.Words[i].EndMS = s.vad.ReceivedOffset(time.Duration(w.EndMS) * time.Millisecond).Milliseconds()
+	}
+	return result
+}
+
+// audioSeq returns the highest sequenced audio frame accepted.
+func (s *wsSession) audioSeq() uint64 {
+	return s.lastAudioSeq.Load()
+}
+
+// addResult applies an STT result to the session transcript. It returns the
+// segments a final result committed and the index of the segment the next
+// result belongs to.
+func (s *wsSession) addResult(result intelligence.TranscriptResult) ([]domain.TranscriptSegment, int) {
+	s.transcriptMu.Lock()
+	defer s.transcriptMu.Unlock()
+	segments := s.transcript.Add(result)
+	return segments, s.transcript.Next()
+}
+
+// resumedPayload describes the session's progress to a reconnecting client.
+func (s *wsSession) resumedPayload() protocol.SessionResumedPayload {
+	s.transcriptMu.Lock()
+	segments := s.transcript.Segments()
+	s.transcriptMu.Unlock()
+	transcript := make([]string, len(segments))
+	for i, segment := range segments {
+		transcript[i] = segment.Text
+	}
+	return protocol.SessionResumedPayload{
+		SessionID:          s.id,
+		LastAudioSeq:       s.audioSeq(),
+		Transcript:         transcript,
+		PendingExtractions: int(s.pending.Load()),
+	}
+}
+
+// close sends session.closed followed by a close frame with closeCode, and
+// closes the connection. Later sends are only buffered.
+func (s *wsSession) close(closeCode int, reason string) {
+	s.sendEvent(protocol.TypeSessionClosed, protocol.SessionClosedPayload{Reason: reason, ImpressionID: s.impressionID})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.expiry != nil {
+		s.expiry.Stop()
+	}
+	if s.conn != nil {
+		closeMsg := websocket.FormatCloseMessage(closeCode, reason)
+		if err := s.conn.WriteMessage(websocket.CloseMessage, closeMsg); err != nil {
+			log.Printf("Failed to write close message: %v", err)
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	close(s.done)
+}
+
+// sessionManager tracks live sessions by resume token, and counts the
+// sessions still running so a shutdown can wait for them.
+type sessionManager struct {
+	mu       sync.Mutex
+	sessions map[string]*wsSession
+	grace    time.Duration
+	running  int
+	draining bool
+	// idle is closed once draining and no session is running.
+	idle chan struct{}
+}
+
+func newSessionManager(grace time.Duration) *sessionManager {
+	return &sessionManager{sessions: make(map[string]*wsSession), grace: grace, idle: make(chan struct{})}
+}
+
+// add registers a running session. It reports false if the manager is
+// draining, in which case the session must be drained too.
+func (m *sessionManager) add(s *wsSession) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[s.token] = s
+	m.running++
+	return !m.draining
+}
+
+// finished records that a session added with add has closed.
+func (m *sessionManager) finished() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.running--
+	if m.draining && m.running == 0 {
+		close(m.idle)
+	}
+}
+
+// drain marks the manager as draining and returns its sessions, and a
+// channel closed once none of them, nor any added later, is running.
+func (m *sessionManager) drain() ([]*wsSession, <-chan struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.draining {
+		m.draining = true
+		if m.running == 0 {
+			close(m.idle)
+		}
+	}
+	sessions := make([]*wsSession, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		sessions = append(sessions, s)
+	}
+	return sessions, m.idle
+}
+
+func (m *sessionManager) get(token string) *wsSession {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sessions[token]
+}
+
+func (m *sessionManager) remove(s *wsSession) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sessions[s.token] == s {
+		delete(m.sessions, s.token)
+	}
+}
@@ -0,0 +1,87 @@
+package ingestion
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"clinical-agent-backend/internal/protocol"
+)
+
+// isDraining reports whether the handler has started shutting down.
+func (h *Handler) isDraining() bool {
+	select {
+	case <-h.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// Shutdown drains the handler before the server stops. New sessions and
+// uploads are refused and workers stop claiming tasks. Connected sessions
+// are told to continue on another server and their audio is ended, so what
+// was said so far is transcribed, extracted and saved before they close.
+// Shutdown waits for the sessions and for the tasks workers are running
+// until ctx is done; it then interrupts the workers and sessions, which
+// hand their tasks back to the queue for another server, and returns ctx's
+// error.
+func (h *Handler) Shutdown(ctx context.Context) error {
+	h.drainOnce.Do(func() { close(h.draining) })
+	sessions, idle := h.sessions.drain()
+	log.Printf("Draining %d sessions", len(sessions))
+	for _, session := range sessions {
+		h.drainSession(session)
+	}
+
+	workersDone := make(chan struct{})
+	go func() {
+		h.workers.Wait()
+		close(workersDone)
+	}()
+	for _, done := range []<-chan struct{}{idle, workersDone} {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			h.interruptTasks()
+			<-workersDone
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// drainSession tells a running session's client that the server is
+// shutting down and ends the session's audio.
+func (h *Handler) drainSession(session *wsSession) {
+	select {
+	case <-session.done:
+		return
+	default:
+	}
+	session.sendError(protocol.ErrCodeServerDraining, "the server is shutting down; start a new session on another server to continue", 0)
+	session.endAudio()
+}
+
+// refuseWhileDraining answers 503 once the handler is shutting down and
+// reports whether it did. Uploads are refused because their audio is
+// spooled on this server.
+func (h *Handler) refuseWhileDraining(w http.ResponseWriter) bool {
+	if !h.isDraining() {
+		return false
+	}
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+	return true
+}
+
+// HandleReady answers readiness probes: 200 while the server takes new
+// sessions and uploads, 503 once it is shutting down.
+func (h *Handler) HandleReady(w http.ResponseWriter, r *http.Request) {
+	if h.isDraining() {
+		http.Error(w, "Shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
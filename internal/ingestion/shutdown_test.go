@@ -0,0 +1,188 @@
+package ingestion
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"clinical-agent-backend/internal/domain"
+	"clinical-agent-backend/internal/intelligence"
+	"clinical-agent-backend/internal/protocol"
+	"clinical-agent-backend/internal/repository"
+
+	"github.com/gorilla/websocket"
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+)
+
+func TestShutdown_DrainsSessions(t *testing.T) {
+	handler, repo := newTestHandler(t, "Patient reports a headache.")
+	conn := dialWS(t, handler)
+	sendControl(t, conn, protocol.TypeStart, protocol.StartPayload{
+		Audio: protocol.AudioConfig{Encoding: "LINEAR16", SampleRateHertz: 16000, LanguageCode: "en-US"},
+	})
+	conn.WriteMessage(websocket.BinaryMessage, make([]byte, 32000))
+	readUntil(t, conn, protocol.TypeTranscriptFinal)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := handler.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	var draining bool
+	var closed protocol.SessionClosedPayload
+	for _, env := range readEvents(t, conn) {
+		switch env.Type {
+		case protocol.TypeError:
+			var payload protocol.ErrorPayload
+			env.DecodePayload(&payload)
+			draining = draining || payload.Code == protocol.ErrCodeServerDraining
+		case protocol.TypeSessionClosed:
+			env.DecodePayload(&closed)
+		}
+	}
+	if !draining || closed.Reason != "server shutting down" {
+		t.Errorf("expected the client to be told of the shutdown, got draining=%v and %+v", draining, closed)
+	}
+	impressions, _ := repo.FindAll(context.Background())
+	if len(impressions) != 1 || *impressions[0].Id != closed.ImpressionID || impressions[0].Status != fhir.ClinicalImpressionStatusCompleted {
+		t.Errorf("expected the session's impression to be completed before it closed, got %d impressions and %+v", len(impressions), closed)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.HandleReady(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected /ready to fail while draining, got %d", rec.Code)
+	}
+	if rec := postUpload(t, handler, "clip.raw", make([]byte, 16000)); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected uploads to be refused while draining, got %d", rec.Code)
+	}
+
+	conn = dialWS(t, handler)
+	sendControl(t, conn, protocol.TypeStart, protocol.StartPayload{})
+	events := readEvents(t, conn)
+	var payload protocol.ErrorPayload
+	if len(events) > 0 {
+		events[0].DecodePayload(&payload)
+	}
+	if payload.Code != protocol.ErrCodeServerDraining {
+		t.Errorf("expected new sessions to be refused with %s, got %+v", protocol.ErrCodeServerDraining, events)
+	}
+}
+
+// blockingExtractor signals started and blocks until its context is done.
+type blockingExtractor struct {
+	intelligence.EntityExtractor
+	started chan struct{}
+}
+
+func (e *blockingExtractor) ExtractEntities(ctx context.Context, text, language string) (*domain.ClinicalNote, error) {
+	close(e.started)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestShutdown_HandsBackInterruptedTasks(t *testing.T) {
+	handler, _ := newTestHandler(t, "Patient reports a headache.")
+	extractor := &blockingExtractor{EntityExtractor: handler.extractor, started: make(chan struct{})}
+	handler.extractor = extractor
+
+	job := decodeJob(t, postUpload(t, handler, "clip.raw", make([]byte, 16000)))
+	select {
+	case <-extractor.started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for extraction to start")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := handler.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the drain to run out of time, got %v", err)
+	}
+	tasks, err := handler.jobs.Queue().Find(context.Background(), repository.TaskFilter{JobID: job.ID})
+	if err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	if len(tasks) != 1 || tasks[0].Status != domain.TaskPending || tasks[0].Attempts != 0 || tasks[0].VisibleAt.After(time.Now()) {
+		t.Fatalf("expected the task to be handed back ready to run, with its attempt given back, got %+v", tasks)
+	}
+}
+
+func TestShutdown_InterruptedLastAttemptIsNotLost(t *testing.T) {
+	extractor, err := intelligence.NewRuleExtractor()
+	if err != nil {
+		t.Fatalf("failed to create rule extractor: %v", err)
+	}
+	jobs := repository.NewMemoryJobRepository()
+	spool := t.TempDir()
+	newServer := func(extractor intelligence.EntityExtractor) *Handler {
+		return NewHandler(intelligence.NewLocalTranscriber([]string{"Patient reports a headache."}), extractor,
+			repository.NewMemoryRepository(), WithJobRepository(jobs), WithJobSpoolDir(spool), WithTaskRetries(1, time.Millisecond))
+	}
+
+	// The only attempt the task has is interrupted by a shutdown.
+	blocking := &blockingExtractor{EntityExtractor: extractor, started: make(chan struct{})}
+	draining := newServer(blocking)
+	draining.StartJobWorkers(t.Context(), 1)
+	job := decodeJob(t, postUpload(t, draining, "clip.raw", make([]byte, 16000)))
+	select {
+	case <-blocking.started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for extraction to start")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := draining.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the drain to run out of time, got %v", err)
+	}
+
+	next := newServer(extractor)
+	next.StartJobWorkers(t.Context(), 1)
+	if job = waitForJob(t, next, job.ID); job.Status != domain.JobSaved {
+		t.Fatalf("expected the next server to finish the job, got %+v", job)
+	}
+	tasks, _ := jobs.Queue().Find(context.Background(), repository.TaskFilter{JobID: job.ID})
+	if len(tasks) != 1 || tasks[0].Status != domain.TaskDone || tasks[0].Attempts != 1 {
+		t.Errorf("expected the task done on its one attempt, got %+v", tasks)
+	}
+}
+
+func TestShutdown_InterruptsSessionTasks(t *testing.T) {
+	handler, _ := newTestHandler(t, "Patient reports a headache.")
+	extractor := &blockingExtractor{EntityExtractor: handler.extractor, started: make(chan struct{})}
+	handler.extractor = extractor
+
+	conn := dialWS(t, handler)
+	sendControl(t, conn, protocol.TypeStart, protocol.StartPayload{
+		Audio: protocol.AudioConfig{Encoding: "LINEAR16", SampleRateHertz: 16000, LanguageCode: "en-US"},
+	})
+	conn.WriteMessage(websocket.BinaryMessage, make([]byte, 32000))
+	select {
+	case <-extractor.started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for extraction to start")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := handler.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the drain to run out of time, got %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		tasks, err := handler.jobs.Queue().Find(context.Background(), repository.TaskFilter{Statuses: []domain.TaskStatus{domain.TaskPending}})
+		if err != nil {
+			t.Fatalf("Find failed: %v", err)
+		}
+		if len(tasks) > 0 && tasks[len(tasks)-1].Kind == domain.TaskSessionTurn {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the session's turn to be handed back, got %+v", tasks)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
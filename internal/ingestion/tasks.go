@@ -0,0 +1,448 @@
+package ingestion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"clinical-agent-backend/internal/domain"
+	"clinical-agent-backend/internal/ehr"
+	"clinical-agent-backend/internal/protocol"
+	"clinical-agent-backend/internal/repository"
+
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+)
+
+const (
+	// defaultTaskAttempts is how often a task is tried before it is
+	// dead-lettered.
+	defaultTaskAttempts = 5
+	// defaultTaskBackoff is the wait before the first retry. It doubles
+	// with every attempt, up to maxTaskBackoff.
+	defaultTaskBackoff = 2 * time.Second
+	maxTaskBackoff     = 5 * time.Minute
+	// taskLease is how long a worker holds a task before another worker may
+	// take it over. Workers renew it every quarter lease while they run the
+	// task, so it only runs out when a worker stops.
+	taskLease = 2 * time.Minute
+	// taskPollInterval is how often idle workers look for ready tasks.
+	taskPollInterval = time.Second
+	// sessionTaskWait bounds how long a session waits for one of its tasks,
+	// including retries, before moving on and leaving it to the workers.
+	sessionTaskWait = 2 * time.Minute
+	// taskReleaseTimeout bounds recording the outcome of a task whose
+	// worker is stopping.
+	taskReleaseTimeout = 5 * time.Second
+)
+
+// WithTaskRetries sets how many times a queued task is attempted before it
+// is dead-lettered, and the wait before its first retry, which doubles with
+// each attempt. The defaults, kept for zero values, are 5 attempts and 2
+// seconds.
+func WithTaskRetries(attempts int, backoff time.Duration) Option {
+	return func(h *Handler) {
+		if attempts > 0 {
+			h.taskAttempts = attempts
+		}
+		if backoff > 0 {
+			h.taskBackoff = backoff
+		}
+	}
+}
+
+// newWorkerID identifies this process's workers in the work queue.
+func newWorkerID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s/%d/%s", host, os.Getpid(), newSessionID()[:8])
+}
+
+// taskError is a failed task's error with the protocol error code of the
+// stage that failed. Permanent failures are dead-lettered without retrying.
+type taskError struct {
+	code      string
+	permanent bool
+	err       error
+}
+
+func (e *taskError) Error() string { return e.err.Error() }
+func (e *taskError) Unwrap() error { return e.err }
+
+// failure marks err as a failure of the stage with code that may succeed
+// when retried.
+func failure(code string, err error) error {
+	return &taskError{code: code, err: err}
+}
+
+// permanentFailure marks err as a failure of the stage with code that
+// retrying will not fix.
+func permanentFailure(code string, err error) error {
+	return &taskError{code: code, permanent: true, err: err}
+}
+
+// newTask returns a pending task of kind on job id.
+func (h *Handler) newTask(kind domain.TaskKind, jobID string) *domain.Task {
+	now := time.Now().UTC()
+	return &domain.Task{
+		Kind:        kind,
+		JobID:       jobID,
+		Status:      domain.TaskPending,
+		MaxAttempts: h.taskAttempts,
+		VisibleAt:   now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+}
+
+// wakeWorkers tells an idle worker that a task was queued, rather than
+// leaving it to find the task on its next poll.
+func (h *Handler) wakeWorkers() {
+	select {
+	case h.workReady <- struct{}{}:
+	default:
+	}
+}
+
+// StartJobWorkers starts n workers running queued tasks until ctx is
+// cancelled or the handler shuts down. Tasks left by a previous run, or by a
+// worker on another node that stopped, are taken over once their lease runs
+// out. With no workers, sessions still run their own tasks, while uploads
+// wait for a node that has workers. It is called once.
+func (h *Handler) StartJobWorkers(ctx context.Context, n int) {
+	ctx, stop := context.WithCancel(ctx)
+	context.AfterFunc(h.tasks, stop)
+	h.workers.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer h.workers.Done()
+			h.taskWorker(ctx)
+		}()
+	}
+	go func() {
+		ticker := time.NewTicker(uploadSweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				h.expireUploads()
+			}
+		}
+	}()
+}
+
+// taskWorker claims and runs tasks until ctx is cancelled or the handler
+// starts draining.
+func (h *Handler) taskWorker(ctx context.Context) {
+	queue := h.jobs.Queue()
+	for !h.isDraining() {
+		task, err := queue.Claim(ctx, h.workerID, taskLease)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to claim a task: %v", err)
+		}
+		if task != nil {
+			h.runTask(ctx, task)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.draining:
+			return
+		case <-h.workReady:
+		case <-time.After(taskPollInterval):
+		}
+	}
+}
+
+// runTask runs a task this worker has claimed and records the outcome in
+// the queue and in task: done, pending again until a retry, or dead. A task
+// interrupted by ctx is handed back to the queue to run again at once,
+// keeping the error of its previous attempt.
+func (h *Handler) runTask(ctx context.Context, task *domain.Task) {
+	queue := h.jobs.Queue()
+	var err error
+	if task.Attempts > task.MaxAttempts {
+		// The worker holding the last attempt stopped.
+		err = permanentFailure(task.ErrorCode, fmt.Errorf("abandoned after %d attempts", task.MaxAttempts))
+	} else {
+		err = h.processTaskWithLease(ctx, task)
+	}
+	if ctx.Err() != nil {
+		// The worker is stopping; record the outcome regardless.
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.WithoutCancel(ctx), taskReleaseTimeout)
+		defer cancel()
+		if err != nil {
+			// The attempt is given back: a drain must not use up a task's
+			// last attempt.
+			log.Printf("Task %d (%s on job %s) interrupted; handing it back", task.ID, task.Kind, task.JobID)
+			task.Status, task.Attempts, task.VisibleAt = domain.TaskPending, task.Attempts-1, time.Now()
+			if err := queue.Release(ctx, task.ID, h.workerID); err != nil {
+				log.Printf("Failed to hand back task %d: %v", task.ID, err)
+			}
+			return
+		}
+	}
+
+	var te *taskError
+	code := ""
+	if errors.As(err, &te) {
+		code = te.code
+	}
+	switch {
+	case err == nil:
+		task.Status = domain.TaskDone
+		err = queue.Complete(ctx, task.ID, h.workerID)
+	case te != nil && te.permanent || task.Attempts >= task.MaxAttempts:
+		log.Printf("Task %d (%s on job %s) failed for good: %v", task.ID, task.Kind, task.JobID, err)
+		task.Status, task.ErrorCode, task.Error = domain.TaskDead, code, err.Error()
+		if err = queue.DeadLetter(ctx, task.ID, h.workerID, task.ErrorCode, task.Error); err == nil {
+			h.taskDead(ctx, task, te != nil && te.permanent)
+		}
+	default:
+		backoff := min(h.taskBackoff<<(task.Attempts-1), maxTaskBackoff)
+		log.Printf("Task %d (%s on job %s) failed, retrying in %s: %v", task.ID, task.Kind, task.JobID, backoff, err)
+		task.Status, task.ErrorCode, task.Error = domain.TaskPending, code, err.Error()
+		task.VisibleAt = time.Now().Add(backoff)
+		if err = queue.Fail(ctx, task.ID, h.workerID, task.ErrorCode, task.Error, task.VisibleAt); err == nil {
+			time.AfterFunc(backoff, h.wakeWorkers)
+		}
+	}
+	if err != nil {
+		// Most likely the lease ran out and another worker took the task.
+		log.Printf("Failed to record the outcome of task %d: %v", task.ID, err)
+	}
+}
+
+// processTaskWithLease runs task while renewing its lease. Losing the lease
+// cancels the task, since another worker may already be running it.
+func (h *Handler) processTaskWithLease(ctx context.Context, task *domain.Task) error {
+	taskCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		ticker := time.NewTicker(taskLease / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-taskCtx.Done():
+				return
+			case <-ticker.C:
+				if err := h.jobs.Queue().Extend(taskCtx, task.ID, h.workerID, taskLease); err != nil && taskCtx.Err() == nil {
+					log.Printf("Task %d: failed to renew lease: %v", task.ID, err)
+					if errors.Is(err, repository.ErrNotFound) {
+						cancel()
+					}
+				}
+			}
+		}
+	}()
+
+	switch task.Kind {
+	case domain.TaskJob:
+		return h.processJob(taskCtx, task.JobID)
+	case domain.TaskSessionTurn:
+		return h.processTurn(taskCtx, task)
+	case domain.TaskSessionEnd:
+		return h.processSessionEnd(taskCtx, task)
+	}
+	return permanentFailure("", fmt.Errorf("unknown task kind %q", task.Kind))
+}
+
+// taskDead records on its job that a task failed for good. A job that ran
+// out of attempts keeps its spooled audio, so it can be retried.
+func (h *Handler) taskDead(ctx context.Context, task *domain.Task, permanent bool) {
+	if task.Kind == domain.TaskSessionTurn {
+		// The session's next turn or its end saves the impression.
+		return
+	}
+	job, err := h.jobs.FindByID(ctx, task.JobID)
+	if err != nil {
+		log.Printf("Failed to load job %s: %v", task.JobID, err)
+		return
+	}
+	if task.End != nil {
+		job.Segments = task.End.Segments
+		job.Transcript = domain.TranscriptText(job.Segments)
+	}
+	if permanent {
+		h.failJob(ctx, job, errors.New(task.Error))
+		return
+	}
+	log.Printf("Job %s failed: %s", job.ID, task.Error)
+	job.Error = task.Error
+	h.setJobStatus(ctx, job, domain.JobFailed)
+}
+
+// processTurn extracts entities from a session's speaker turn, merges them
+// into the session job's note and saves the note as the session's
+// impression. Extraction is repeated when the task is retried; merging the
+// same entities twice changes nothing.
+func (h *Handler) processTurn(ctx context.Context, task *domain.Task) error {
+	turn := task.Turn
+	job, err := h.jobs.FindByID(ctx, task.JobID)
+	if err != nil {
+		return failure(protocol.ErrCodePersistenceFailed, fmt.Errorf("failed to load session job: %w", err))
+	}
+	extracted, err := h.extractor.ExtractEntities(ctx, turn.Text, turn.Language)
+	if err != nil {
+		return failure(protocol.ErrCodeExtractionFailed, err)
+	}
+	log.Printf("Job %s: extracted Clinical Note: %+v", job.ID, extracted)
+
+	var note domain.ClinicalNote
+	if job.Note != nil {
+		note = *job.Note
+	}
+	note.Merge(*extracted)
+	note.SourceLanguage = turn.SourceLanguage
+	job.Note = &note
+
+	// A turn retried after the session ended completes the impression
+	// again rather than reopening it.
+	status := fhir.ClinicalImpressionStatusInProgress
+	if job.Status.Done() {
+		status = fhir.ClinicalImpressionStatusCompleted
+	}
+	// The note is kept even if the impression cannot be saved, so the end
+	// of the session can save it.
+	saveErr := h.saveJobImpression(ctx, job, status, turn.RecordingID)
+	job.UpdatedAt = time.Now().UTC()
+	if err := h.jobs.Update(ctx, job); err != nil {
+		return failure(protocol.ErrCodePersistenceFailed, fmt.Errorf("failed to update session job: %w", err))
+	}
+	return saveErr
+}
+
+// processSessionEnd records a finished session's transcript on its job and
+// completes its impression, saving it now if earlier saves failed. Sessions
+// nothing was extracted from get no impression.
+func (h *Handler) processSessionEnd(ctx context.Context, task *domain.Task) error {
+	end := task.End
+	job, err := h.jobs.FindByID(ctx, task.JobID)
+	if err != nil {
+		return failure(protocol.ErrCodePersistenceFailed, fmt.Errorf("failed to load session job: %w", err))
+	}
+	job.Segments = end.Segments
+	job.Transcript = domain.TranscriptText(end.Segments)
+	if job.ImpressionID != "" || job.Note != nil && !job.Note.Empty() {
+		if job.Note == nil {
+			job.Note = &domain.ClinicalNote{}
+		}
+		if err := h.saveJobImpression(ctx, job, fhir.ClinicalImpressionStatusCompleted, end.RecordingID); err != nil {
+			return err
+		}
+		log.Printf("Session %s: finalized Clinical Impression %s", job.ID, job.ImpressionID)
+	}
+	job.Status, job.Error = domain.JobSaved, ""
+	if end.Error != "" {
+		job.Status, job.Error = domain.JobFailed, "transcription failed: "+end.Error
+	}
+	job.UpdatedAt = time.Now().UTC()
+	if err := h.jobs.Update(ctx, job); err != nil {
+		return failure(protocol.ErrCodePersistenceFailed, fmt.Errorf("failed to update session job: %w", err))
+	}
+	return nil
+}
+
+// saveJobImpression maps a session job's note to FHIR and saves it with
+// status: as a new impression the first time, then over it.
+func (h *Handler) saveJobImpression(ctx context.Context, job *domain.TranscriptionJob, status fhir.ClinicalImpressionStatus, recordingID string) error {
+	fhirResource, err := ehr.MapToFHIR(*job.Note, job.Encounter)
+	if err != nil {
+		return permanentFailure(protocol.ErrCodeMappingFailed, err)
+	}
+	fhirResource.Status = status
+	if recordingID != "" {
+		ehr.LinkRecording(fhirResource, recordingID)
+	}
+	ehr.LinkJob(fhirResource, job.ID)
+
+	if job.ImpressionID == "" {
+		err = h.repo.Save(ctx, fhirResource)
+	} else {
+		fhirResource.Id = &job.ImpressionID
+		err = h.repo.Update(ctx, fhirResource)
+	}
+	if err != nil {
+		return failure(protocol.ErrCodePersistenceFailed, err)
+	}
+	job.ImpressionID = *fhirResource.Id
+	return nil
+}
+
+// HandleListTasks handles GET /admin/tasks, listing queued tasks newest
+// first, optionally filtered by the status and job_id query parameters.
+// status=dead lists the tasks that failed for good.
+func (h *Handler) HandleListTasks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+	filter := repository.TaskFilter{JobID: query.Get("job_id")}
+	for _, status := range query["status"] {
+		filter.Statuses = append(filter.Statuses, domain.TaskStatus(status))
+	}
+	tasks, err := h.jobs.Queue().Find(r.Context(), filter)
+	if err != nil {
+		log.Printf("Failed to fetch tasks: %v", err)
+		http.Error(w, "Failed to fetch tasks", http.StatusInternalServerError)
+		return
+	}
+	if tasks == nil {
+		tasks = []*domain.Task{}
+	}
+	writeJSON(w, http.StatusOK, tasks)
+}
+
+// HandleRetryTask handles POST /admin/tasks/{id}/retry, queueing a dead
+// task again with fresh attempts. The response is the task.
+func (h *Handler) HandleRetryTask(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	queue := h.jobs.Queue()
+	err = queue.Retry(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.Error(w, "No dead task "+r.PathValue("id"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to retry task %d: %v", id, err)
+		http.Error(w, "Failed to retry task", http.StatusInternalServerError)
+		return
+	}
+	h.wakeWorkers()
+	log.Printf("Task %d queued again", id)
+
+	task, err := queue.FindByID(r.Context(), id)
+	if err != nil {
+		log.Printf("Failed to fetch task %d: %v", id, err)
+		http.Error(w, "Failed to fetch task", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
@@ -0,0 +1,182 @@
+package ingestion
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"clinical-agent-backend/internal/domain"
+	"clinical-agent-backend/internal/intelligence"
+	"clinical-agent-backend/internal/repository"
+
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+)
+
+// flakyExtractor fails its first failures calls.
+type flakyExtractor struct {
+	intelligence.EntityExtractor
+	failures atomic.Int32
+}
+
+func (e *flakyExtractor) ExtractEntities(ctx context.Context, text, language string) (*domain.ClinicalNote, error) {
+	if e.failures.Add(-1) >= 0 {
+		return nil, errors.New("model overloaded")
+	}
+	return e.EntityExtractor.ExtractEntities(ctx, text, language)
+}
+
+// waitForJobStatus polls the handler's job repository until job id has
+// status.
+func waitForJobStatus(t *testing.T, handler *Handler, id string, status domain.JobStatus) *domain.TranscriptionJob {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := handler.jobs.FindByID(context.Background(), id)
+		if err != nil {
+			t.Fatalf("FindByID failed: %v", err)
+		}
+		if job.Status == status {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for job %s to be %s", id, status)
+	return nil
+}
+
+func TestWorkQueue_RetriesFailedTasks(t *testing.T) {
+	handler, _ := newTestHandler(t, "Patient reports a headache.")
+	WithTaskRetries(3, time.Millisecond)(handler)
+	extractor := &flakyExtractor{EntityExtractor: handler.extractor}
+	extractor.failures.Store(2)
+	handler.extractor = extractor
+
+	job := waitForJob(t, handler, decodeJob(t, postUpload(t, handler, "clip.raw", make([]byte, 16000))).ID)
+	if job.Status != domain.JobSaved || job.ImpressionID == "" {
+		t.Fatalf("expected the job to succeed on its third attempt, got %+v", job)
+	}
+	tasks, err := handler.jobs.Queue().Find(context.Background(), repository.TaskFilter{JobID: job.ID})
+	if err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	if len(tasks) != 1 || tasks[0].Status != domain.TaskDone || tasks[0].Attempts != 3 {
+		t.Fatalf("expected one task done on attempt 3, got %+v", tasks)
+	}
+}
+
+// lostAckRepository saves impressions but reports its first save as
+// failed, as when the connection drops after the commit.
+type lostAckRepository struct {
+	repository.ClinicalImpressionRepository
+	lost atomic.Bool
+}
+
+func (r *lostAckRepository) Save(ctx context.Context, impression *fhir.ClinicalImpression) error {
+	if err := r.ClinicalImpressionRepository.Save(ctx, impression); err != nil {
+		return err
+	}
+	if r.lost.CompareAndSwap(false, true) {
+		return errors.New("connection reset after commit")
+	}
+	return nil
+}
+
+func TestWorkQueue_RetriedSaveKeepsOneImpression(t *testing.T) {
+	handler, repo := newTestHandler(t, "Patient reports a headache.")
+	WithTaskRetries(3, time.Millisecond)(handler)
+	handler.repo = &lostAckRepository{ClinicalImpressionRepository: repo}
+
+	job := waitForJob(t, handler, decodeJob(t, postUpload(t, handler, "clip.raw", make([]byte, 16000))).ID)
+	if job.Status != domain.JobSaved {
+		t.Fatalf("expected the job to succeed on its retry, got %+v", job)
+	}
+	impressions, _ := repo.FindAll(context.Background())
+	if len(impressions) != 1 || *impressions[0].Id != job.ImpressionID {
+		t.Errorf("expected the retry to reuse the saved impression, got %d impressions for job impression %s", len(impressions), job.ImpressionID)
+	}
+}
+
+func TestWorkQueue_DeadLetterAdmin(t *testing.T) {
+	handler, repo := newTestHandler(t, "Patient reports a headache.")
+	WithTaskRetries(2, time.Millisecond)(handler)
+	WithAdminToken("secret")(handler)
+	extractor := &flakyExtractor{EntityExtractor: handler.extractor}
+	extractor.failures.Store(2)
+	handler.extractor = extractor
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/tasks", handler.HandleListTasks)
+	mux.HandleFunc("/admin/tasks/{id}/retry", handler.HandleRetryTask)
+
+	job := waitForJob(t, handler, decodeJob(t, postUpload(t, handler, "clip.raw", make([]byte, 16000))).ID)
+	if job.Status != domain.JobFailed || !strings.Contains(job.Error, "model overloaded") {
+		t.Fatalf("expected the job to fail once its attempts ran out, got %+v", job)
+	}
+
+	if rec := adminRequest(mux, http.MethodGet, "/admin/tasks?status=dead", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a token, got %d", rec.Code)
+	}
+	rec := adminRequest(mux, http.MethodGet, "/admin/tasks?status=dead&job_id="+job.ID, "secret", "")
+	var dead []domain.Task
+	if err := json.Unmarshal(rec.Body.Bytes(), &dead); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET /admin/tasks: got %d %s", rec.Code, rec.Body.String())
+	}
+	if len(dead) != 1 || dead[0].Kind != domain.TaskJob || dead[0].Attempts != 2 || dead[0].ErrorCode != "extraction_failed" {
+		t.Fatalf("expected the job's dead task, got %+v", dead)
+	}
+
+	path := fmt.Sprintf("/admin/tasks/%d/retry", dead[0].ID)
+	if rec := adminRequest(mux, http.MethodPost, path, "secret", ""); rec.Code != http.StatusOK {
+		t.Fatalf("POST %s: got %d %s", path, rec.Code, rec.Body.String())
+	}
+	job = waitForJobStatus(t, handler, job.ID, domain.JobSaved)
+	if job.Error != "" || job.ImpressionID == "" {
+		t.Errorf("expected the retried job to be saved, got %+v", job)
+	}
+	waitForImpressions(t, repo, 1)
+
+	if rec := adminRequest(mux, http.MethodPost, path, "secret", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 retrying a task that is not dead, got %d", rec.Code)
+	}
+	if rec := adminRequest(mux, http.MethodPost, "/admin/tasks/abc/retry", "secret", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a bad task ID, got %d", rec.Code)
+	}
+}
+
+// tooLargeTranscriber fails every recognition as a provider does for
+// compressed audio above its request limit.
+type tooLargeTranscriber struct {
+	*intelligence.LocalTranscriber
+}
+
+func (tooLargeTranscriber) Recognize(ctx context.Context, cfg intelligence.StreamConfig, clip []byte) ([]intelligence.TranscriptResult, error) {
+	return nil, fmt.Errorf("%w: %s audio of %d bytes", intelligence.ErrAudioTooLarge, cfg.Encoding, len(clip))
+}
+
+func (tooLargeTranscriber) RecognizeLong(ctx context.Context, cfg intelligence.StreamConfig, recording io.Reader) ([]intelligence.TranscriptResult, error) {
+	return nil, fmt.Errorf("%w: %s audio", intelligence.ErrAudioTooLarge, cfg.Encoding)
+}
+
+func TestWorkQueue_OversizedAudioIsNotRetried(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	WithTaskRetries(3, time.Millisecond)(handler)
+	handler.sttClient = tooLargeTranscriber{LocalTranscriber: intelligence.NewLocalTranscriber(nil)}
+
+	job := waitForJob(t, handler, decodeJob(t, postUpload(t, handler, "clip.raw", make([]byte, 16000))).ID)
+	if job.Status != domain.JobFailed || !strings.Contains(job.Error, "exceeds the request limit") {
+		t.Fatalf("expected the job to fail, got %+v", job)
+	}
+	tasks, err := handler.jobs.Queue().Find(context.Background(), repository.TaskFilter{JobID: job.ID})
+	if err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	if len(tasks) != 1 || tasks[0].Status != domain.TaskDead || tasks[0].Attempts != 1 {
+		t.Fatalf("expected the task dead after one attempt, got %+v", tasks)
+	}
+}
@@ -0,0 +1,530 @@
+package ingestion
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"clinical-agent-backend/internal/intelligence"
+)
+
+// Resumable uploads follow the tus 1.0 protocol (core plus the creation,
+// checksum, expiration and termination extensions). Each upload is a pair
+// of files in the uploads directory under the spool directory: <id>.json
+// holds its metadata and <id>.part the bytes received so far, so uploads
+// survive restarts for as long as the spool directory does.
+
+const (
+	tusVersion          = "1.0.0"
+	tusExtensions       = "creation,checksum,expiration,termination"
+	tusChecksums        = "sha1,sha256,md5"
+	tusOffsetOctets     = "application/offset+octet-stream"
+	statusChecksumError = 460 // tus "Checksum Mismatch"
+
+	// defaultMaxUploadSize fits an hour and a half of 48 kHz stereo LINEAR16.
+	defaultMaxUploadSize = 1 << 30
+	// uploadExpiry is how long an upload is kept after its last change.
+	uploadExpiry = 24 * time.Hour
+	// uploadSweepInterval is how often expired uploads are removed.
+	uploadSweepInterval = 30 * time.Second
+)
+
+// WithMaxUploadSize limits resumable uploads to n bytes. The default is 1 GiB.
+func WithMaxUploadSize(n int64) Option {
+	return func(h *Handler) { h.maxUploadSize = n }
+}
+
+// resumableUpload is the stored state of one resumable upload.
+type resumableUpload struct {
+	ID        string            `json:"id"`
+	Length    int64             `json:"length"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	JobID     string            `json:"job_id,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+func (h *Handler) uploadDir() string {
+	return filepath.Join(h.spoolDir, "uploads")
+}
+
+func (h *Handler) uploadPath(id, ext string) string {
+	return filepath.Join(h.uploadDir(), id+ext)
+}
+
+// loadUpload reads an upload's metadata; it returns fs.ErrNotExist for
+// unknown IDs.
+func (h *Handler) loadUpload(id string) (*resumableUpload, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return nil, fs.ErrNotExist
+	}
+	data, err := os.ReadFile(h.uploadPath(id, ".json"))
+	if err != nil {
+		return nil, err
+	}
+	var u resumableUpload
+	if err := json.Unmarshal(data, &u); err != nil {
+		return nil, fmt.Errorf("corrupt upload %s: %w", id, err)
+	}
+	return &u, nil
+}
+
+// saveUpload writes an upload's metadata atomically.
+func (h *Handler) saveUpload(u *resumableUpload) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	tmp := h.uploadPath(u.ID, ".json.tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.uploadPath(u.ID, ".json"))
+}
+
+// uploadOffset returns how many bytes of u have been received and when the
+// upload last changed.
+func (h *Handler) uploadOffset(u *resumableUpload) (int64, time.Time, error) {
+	path := h.uploadPath(u.ID, ".part")
+	if u.JobID != "" {
+		path = h.uploadPath(u.ID, ".json")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if u.JobID != "" {
+		return u.Length, info.ModTime(), nil
+	}
+	return info.Size(), info.ModTime(), nil
+}
+
+// removeUpload deletes both files of an upload.
+func (h *Handler) removeUpload(id string) {
+	os.Remove(h.uploadPath(id, ".part"))
+	os.Remove(h.uploadPath(id, ".json"))
+}
+
+// lockUpload marks an upload as being written. It reports false if another
+// request holds it.
+func (h *Handler) lockUpload(id string) bool {
+	h.uploadsMu.Lock()
+	defer h.uploadsMu.Unlock()
+	if h.uploadsBusy[id] {
+		return false
+	}
+	h.uploadsBusy[id] = true
+	return true
+}
+
+func (h *Handler) unlockUpload(id string) {
+	h.uploadsMu.Lock()
+	defer h.uploadsMu.Unlock()
+	delete(h.uploadsBusy, id)
+}
+
+// tusPreamble sets the headers every tus response carries and rejects
+// requests for another protocol version. It reports false if the request
+// was answered.
+func tusPreamble(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Method == http.MethodOptions {
+		return true
+	}
+	if v := r.Header.Get("Tus-Resumable"); v != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, fmt.Sprintf("unsupported Tus-Resumable %q", v), http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// HandleCreateUpload handles /uploads: POST creates a resumable upload and
+// OPTIONS describes the server's tus support.
+//
+// The client announces the total size in Upload-Length and passes the
+// upload form fields (encoding, sample_rate_hertz, channels, language_code
+// and the encounter references) in Upload-Metadata.
+func (h *Handler) HandleCreateUpload(w http.ResponseWriter, r *http.Request) {
+	if !tusPreamble(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodOptions:
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		w.Header().Set("Tus-Checksum-Algorithm", tusChecksums)
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.maxUploadSize, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.refuseWhileDraining(w) {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		http.Error(w, "Upload-Length must be a positive integer", http.StatusBadRequest)
+		return
+	}
+	if length > h.maxUploadSize {
+		http.Error(w, fmt.Sprintf("upload exceeds the %d byte limit", h.maxUploadSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	field := func(key string) string { return metadata[key] }
+	cfg, err := uploadConfig(field)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Refuse audio the server cannot transcribe before it is uploaded, when
+	// the client declares it; otherwise it is checked once complete.
+	if cfg.Encoding == "" {
+		cfg.Encoding = intelligence.EncodingForMediaType(field("filetype"))
+	}
+	if cfg.Encoding != "" {
+		if err := h.checkEncoding(cfg.Encoding); err != nil {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+	}
+	if err := uploadEncounter(field).Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	u := &resumableUpload{ID: newSessionID(), Length: length, Metadata: metadata, CreatedAt: time.Now().UTC()}
+	if err := h.createUploadFiles(u); err != nil {
+		log.Printf("Failed to create upload: %v", err)
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Created resumable upload %s of %d bytes", u.ID, length)
+
+	w.Header().Set("Location", "/uploads/"+u.ID)
+	w.Header().Set("Upload-Expires", u.CreatedAt.Add(uploadExpiry).Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// createUploadFiles stores a new, empty upload.
+func (h *Handler) createUploadFiles(u *resumableUpload) error {
+	if err := os.MkdirAll(h.uploadDir(), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.uploadPath(u.ID, ".part"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	if err := h.saveUpload(u); err != nil {
+		os.Remove(h.uploadPath(u.ID, ".part"))
+		return err
+	}
+	return nil
+}
+
+// HandleResumableUpload handles /uploads/{id}: HEAD reports progress, PATCH
+// appends a chunk at Upload-Offset and DELETE abandons the upload. The PATCH
+// that completes the upload queues its transcription job, whose location is
+// returned in the Job-Location header (also on later HEADs).
+func (h *Handler) HandleResumableUpload(w http.ResponseWriter, r *http.Request) {
+	if !tusPreamble(w, r) {
+		return
+	}
+	id := r.PathValue("id")
+	u, err := h.loadUpload(id)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, "upload not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load upload %s: %v", id, err)
+		http.Error(w, "Failed to load upload", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		offset, modified, err := h.uploadOffset(u)
+		if err != nil {
+			http.Error(w, "upload not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+		w.Header().Set("Upload-Expires", modified.Add(uploadExpiry).Format(http.TimeFormat))
+		if u.JobID != "" {
+			w.Header().Set("Job-Location", "/jobs/"+u.JobID)
+		}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPatch:
+		if !h.lockUpload(u.ID) {
+			http.Error(w, "upload is being written by another request", http.StatusLocked)
+			return
+		}
+		defer h.unlockUpload(u.ID)
+		// Another request may have finished the upload since it was loaded.
+		if u, err = h.loadUpload(id); err != nil {
+			http.Error(w, "upload not found", http.StatusNotFound)
+			return
+		}
+		h.patchUpload(w, r, u)
+	case http.MethodDelete:
+		if !h.lockUpload(u.ID) {
+			http.Error(w, "upload is being written by another request", http.StatusLocked)
+			return
+		}
+		defer h.unlockUpload(u.ID)
+		h.removeUpload(u.ID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// patchUpload appends the request body to u. A chunk that fails its
+// checksum or overruns Upload-Length is discarded; a chunk cut short by the
+// connection is kept unless it carried a checksum.
+func (h *Handler) patchUpload(w http.ResponseWriter, r *http.Request, u *resumableUpload) {
+	if ct := r.Header.Get("Content-Type"); ct != tusOffsetOctets {
+		http.Error(w, "Content-Type must be "+tusOffsetOctets, http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Upload-Offset must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+	current, _, err := h.uploadOffset(u)
+	if err != nil {
+		http.Error(w, "upload not found", http.StatusNotFound)
+		return
+	}
+	if offset != current {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(current, 10))
+		http.Error(w, fmt.Sprintf("Upload-Offset %d does not match the current offset %d", offset, current), http.StatusConflict)
+		return
+	}
+
+	var sum hash.Hash
+	var want []byte
+	if header := r.Header.Get("Upload-Checksum"); header != "" {
+		sum, want, err = parseUploadChecksum(header)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if u.JobID == "" && current < u.Length {
+		written, err := h.appendChunk(u, current, r.Body, sum, want)
+		if err != nil {
+			var status uploadStatusError
+			if errors.As(err, &status) {
+				http.Error(w, err.Error(), int(status))
+				return
+			}
+			log.Printf("Upload %s: chunk at offset %d failed after %d bytes: %v", u.ID, current, written, err)
+			http.Error(w, "Failed to store chunk", http.StatusInternalServerError)
+			return
+		}
+		current += written
+	}
+
+	if current == u.Length && u.JobID == "" {
+		if status, err := h.finishUpload(r, u); err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(current, 10))
+	if u.JobID != "" {
+		w.Header().Set("Job-Location", "/jobs/"+u.JobID)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// uploadStatusError is a chunk rejection carrying its HTTP status.
+type uploadStatusError int
+
+func (e uploadStatusError) Error() string {
+	switch int(e) {
+	case statusChecksumError:
+		return "chunk checksum mismatch"
+	case http.StatusRequestEntityTooLarge:
+		return "chunk extends past Upload-Length"
+	}
+	return http.StatusText(int(e))
+}
+
+// appendChunk writes body to u's part file at offset and returns how many
+// bytes were kept.
+func (h *Handler) appendChunk(u *resumableUpload, offset int64, body io.Reader, sum hash.Hash, want []byte) (int64, error) {
+	f, err := os.OpenFile(h.uploadPath(u.ID, ".part"), os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	var dst io.Writer = f
+	if sum != nil {
+		dst = io.MultiWriter(f, sum)
+	}
+	remaining := u.Length - offset
+	written, copyErr := io.Copy(dst, io.LimitReader(body, remaining+1))
+
+	var reject error
+	switch {
+	case written > remaining:
+		reject = uploadStatusError(http.StatusRequestEntityTooLarge)
+	case sum != nil && copyErr != nil:
+		reject = copyErr
+	case sum != nil && !bytes.Equal(sum.Sum(nil), want):
+		reject = uploadStatusError(statusChecksumError)
+	}
+	if reject != nil {
+		if err := f.Truncate(offset); err != nil {
+			return 0, err
+		}
+		return 0, reject
+	}
+	if err := f.Sync(); err != nil {
+		return 0, err
+	}
+	if copyErr != nil {
+		log.Printf("Upload %s: chunk at offset %d interrupted after %d bytes: %v", u.ID, offset, written, copyErr)
+	}
+	return written, nil
+}
+
+// finishUpload turns a complete upload into a queued transcription job and
+// drops the uploaded bytes. An upload whose audio cannot be used is removed.
+func (h *Handler) finishUpload(r *http.Request, u *resumableUpload) (int, error) {
+	f, err := os.Open(h.uploadPath(u.ID, ".part"))
+	if err != nil {
+		log.Printf("Upload %s: failed to open data: %v", u.ID, err)
+		return http.StatusInternalServerError, errors.New("failed to read upload")
+	}
+	defer f.Close()
+
+	field := func(key string) string { return u.Metadata[key] }
+	cfg, audioStream, err := uploadAudio(field, f)
+	if err != nil {
+		h.removeUpload(u.ID)
+		return uploadErrorStatus(err), err
+	}
+	if err := h.checkStreamConfig(r.Context(), &cfg); err != nil {
+		h.removeUpload(u.ID)
+		return http.StatusUnsupportedMediaType, err
+	}
+
+	job, err := h.createJob(r.Context(), cfg, uploadEncounter(field), audioStream)
+	if err != nil {
+		log.Printf("Upload %s: failed to create transcription job: %v", u.ID, err)
+		return http.StatusInternalServerError, errors.New("failed to store upload")
+	}
+	u.JobID = job.ID
+	if err := h.saveUpload(u); err != nil {
+		log.Printf("Upload %s: failed to record job %s: %v", u.ID, job.ID, err)
+	}
+	os.Remove(h.uploadPath(u.ID, ".part"))
+	h.wakeWorkers()
+	log.Printf("Resumable upload %s complete, queued as job %s", u.ID, job.ID)
+	return 0, nil
+}
+
+// expireUploads removes uploads that have not changed for uploadExpiry.
+func (h *Handler) expireUploads() {
+	entries, err := os.ReadDir(h.uploadDir())
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		u, err := h.loadUpload(id)
+		if err != nil {
+			continue
+		}
+		if _, modified, err := h.uploadOffset(u); err == nil && time.Since(modified) < uploadExpiry {
+			continue
+		}
+		if h.lockUpload(id) {
+			log.Printf("Expiring resumable upload %s", id)
+			h.removeUpload(id)
+			h.unlockUpload(id)
+		}
+	}
+}
+
+// parseUploadMetadata decodes a tus Upload-Metadata header: comma-separated
+// "key base64value" pairs, where the value may be omitted.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for pair := range strings.SplitSeq(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("malformed Upload-Metadata")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("malformed Upload-Metadata value for %q", key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// parseUploadChecksum decodes a tus Upload-Checksum header ("sha256
+// base64digest") into a fresh hash and the expected digest.
+func parseUploadChecksum(header string) (hash.Hash, []byte, error) {
+	algorithm, encoded, _ := strings.Cut(header, " ")
+	var sum hash.Hash
+	switch algorithm {
+	case "sha1":
+		sum = sha1.New()
+	case "sha256":
+		sum = sha256.New()
+	case "md5":
+		sum = md5.New()
+	default:
+		return nil, nil, fmt.Errorf("unsupported checksum algorithm %q (supported: %s)", algorithm, tusChecksums)
+	}
+	want, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(want) != sum.Size() {
+		return nil, nil, errors.New("malformed Upload-Checksum")
+	}
+	return sum, want, nil
+}
@@ -0,0 +1,241 @@
+package ingestion
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"clinical-agent-backend/internal/audio"
+	"clinical-agent-backend/internal/domain"
+	"clinical-agent-backend/internal/intelligence"
+	"clinical-agent-backend/internal/repository"
+)
+
+// uploadMux routes the resumable upload endpoints to handler.
+func uploadMux(handler *Handler) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/uploads", handler.HandleCreateUpload)
+	mux.HandleFunc("/uploads/{id}", handler.HandleResumableUpload)
+	return mux
+}
+
+// tusRequest sends a tus request through mux.
+func tusRequest(mux http.Handler, method, path string, body []byte, headers ...string) *httptest.ResponseRecorder {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, r)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	if method == http.MethodPatch {
+		req.Header.Set("Content-Type", tusOffsetOctets)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+// createUpload starts a resumable upload of length bytes and returns its path.
+func createUpload(t *testing.T, mux http.Handler, length int, metadata string) string {
+	t.Helper()
+	rec := tusRequest(mux, http.MethodPost, "/uploads", nil,
+		"Upload-Length", strconv.Itoa(length), "Upload-Metadata", metadata)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	return rec.Header().Get("Location")
+}
+
+func tusMetadata(pairs ...string) string {
+	var parts []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, pairs[i]+" "+base64.StdEncoding.EncodeToString([]byte(pairs[i+1])))
+	}
+	return strings.Join(parts, ",")
+}
+
+func checksum(chunk []byte) string {
+	sum := sha256.Sum256(chunk)
+	return "sha256 " + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestResumableUpload(t *testing.T) {
+	handler, _ := newTestHandler(t, "chest pain")
+	mux := uploadMux(handler)
+	data := make([]byte, 48000)
+	location := createUpload(t, mux, len(data), tusMetadata("patient_reference", "Patient/7", "language_code", "en-GB",
+		"alternative_language_codes", "es-US, fr-FR"))
+
+	// First chunk with a checksum.
+	rec := tusRequest(mux, http.MethodPatch, location, data[:20000],
+		"Upload-Offset", "0", "Upload-Checksum", checksum(data[:20000]))
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != "20000" {
+		t.Fatalf("expected offset 20000, got %d %q: %s", rec.Code, rec.Header().Get("Upload-Offset"), rec.Body.String())
+	}
+
+	// A corrupted chunk is rejected and leaves the offset alone.
+	rec = tusRequest(mux, http.MethodPatch, location, data[20000:30000],
+		"Upload-Offset", "20000", "Upload-Checksum", checksum([]byte("something else")))
+	if rec.Code != statusChecksumError {
+		t.Fatalf("expected 460, got %d", rec.Code)
+	}
+	// So is a chunk at the wrong offset.
+	rec = tusRequest(mux, http.MethodPatch, location, data[30000:], "Upload-Offset", "30000")
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", rec.Code)
+	}
+
+	rec = tusRequest(mux, http.MethodHead, location, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Upload-Offset") != "20000" || rec.Header().Get("Upload-Length") != "48000" {
+		t.Fatalf("unexpected progress: %d %v", rec.Code, rec.Header())
+	}
+
+	// The final chunk queues the job.
+	rec = tusRequest(mux, http.MethodPatch, location, data[20000:], "Upload-Offset", "20000")
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != "48000" {
+		t.Fatalf("expected the upload to complete, got %d: %s", rec.Code, rec.Body.String())
+	}
+	jobLocation := rec.Header().Get("Job-Location")
+	id, ok := strings.CutPrefix(jobLocation, "/jobs/")
+	if !ok {
+		t.Fatalf("expected a Job-Location header, got %q", jobLocation)
+	}
+	job := waitForJob(t, handler, id)
+	if job.Status != domain.JobSaved || job.Encounter.PatientReference != "Patient/7" || job.Audio.LanguageCode != "en-GB" {
+		t.Errorf("unexpected job: %+v", job)
+	}
+	if !slices.Equal(job.Audio.AlternativeLanguageCodes, []string{"es-US", "fr-FR"}) || job.Note.SourceLanguage != "en-GB" {
+		t.Errorf("unexpected job languages: %v, note from %q", job.Audio.AlternativeLanguageCodes, job.Note.SourceLanguage)
+	}
+
+	rec = tusRequest(mux, http.MethodHead, location, nil)
+	if rec.Header().Get("Upload-Offset") != "48000" || rec.Header().Get("Job-Location") != jobLocation {
+		t.Errorf("expected HEAD to report the finished upload, got %v", rec.Header())
+	}
+}
+
+func TestResumableUpload_SurvivesRestart(t *testing.T) {
+	extractor, err := intelligence.NewRuleExtractor()
+	if err != nil {
+		t.Fatalf("failed to create rule extractor: %v", err)
+	}
+	jobs := repository.NewMemoryJobRepository()
+	spool := t.TempDir()
+	newServer := func() *Handler {
+		return NewHandler(intelligence.NewLocalTranscriber([]string{"fever"}), extractor,
+			repository.NewMemoryRepository(), WithJobRepository(jobs), WithJobSpoolDir(spool))
+	}
+
+	data := make([]byte, 32000)
+	first := uploadMux(newServer())
+	location := createUpload(t, first, len(data), "")
+	tusRequest(first, http.MethodPatch, location, data[:10000], "Upload-Offset", "0")
+
+	restarted := newServer()
+	restarted.StartJobWorkers(t.Context(), 1)
+	mux := uploadMux(restarted)
+	rec := tusRequest(mux, http.MethodHead, location, nil)
+	if rec.Header().Get("Upload-Offset") != "10000" {
+		t.Fatalf("expected the upload to resume at 10000, got %q", rec.Header().Get("Upload-Offset"))
+	}
+	rec = tusRequest(mux, http.MethodPatch, location, data[10000:], "Upload-Offset", "10000")
+	id, _ := strings.CutPrefix(rec.Header().Get("Job-Location"), "/jobs/")
+	if job := waitForJob(t, restarted, id); job.Status != domain.JobSaved {
+		t.Fatalf("expected the job to be saved, got %+v", job)
+	}
+	if entries, _ := os.ReadDir(restarted.uploadDir()); len(entries) != 1 {
+		t.Errorf("expected only the upload's metadata to remain, found %d files", len(entries))
+	}
+}
+
+func TestResumableUpload_Limits(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	handler.maxUploadSize = 1000
+	mux := uploadMux(handler)
+
+	rec := tusRequest(mux, http.MethodPost, "/uploads", nil, "Upload-Length", "1001")
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for an oversized upload, got %d", rec.Code)
+	}
+
+	location := createUpload(t, mux, 100, "")
+	rec = tusRequest(mux, http.MethodPatch, location, make([]byte, 101), "Upload-Offset", "0")
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for a chunk past Upload-Length, got %d", rec.Code)
+	}
+	if rec := tusRequest(mux, http.MethodHead, location, nil); rec.Header().Get("Upload-Offset") != "0" {
+		t.Errorf("expected the overrunning chunk to be discarded, got offset %q", rec.Header().Get("Upload-Offset"))
+	}
+
+	req := httptest.NewRequest(http.MethodHead, location, nil)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 without Tus-Resumable, got %d", rec.Code)
+	}
+
+	if rec := tusRequest(mux, http.MethodDelete, location, nil); rec.Code != http.StatusNoContent {
+		t.Errorf("expected 204 on delete, got %d", rec.Code)
+	}
+	if rec := tusRequest(mux, http.MethodHead, location, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 after delete, got %d", rec.Code)
+	}
+}
+
+func TestResumableUpload_SpeakerSettings(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	capture := &captureTranscriber{LocalTranscriber: intelligence.NewLocalTranscriber(nil)}
+	handler.sttClient = capture
+	mux := uploadMux(handler)
+
+	data := make([]byte, 32000)
+	location := createUpload(t, mux, len(data), tusMetadata(
+		"channels", "2", "separate_channels", "true", "speaker_roles", "1=patient, 2=clinician"))
+	rec := tusRequest(mux, http.MethodPatch, location, data, "Upload-Offset", "0")
+	id, _ := strings.CutPrefix(rec.Header().Get("Job-Location"), "/jobs/")
+	job := waitForJob(t, handler, id)
+
+	want := domain.SpeakerConfig{SeparateChannels: true, Roles: domain.SpeakerRoles{1: domain.RolePatient, 2: domain.RoleClinician}}
+	if !reflect.DeepEqual(job.Audio.Speakers, want) || !reflect.DeepEqual(capture.cfg.Speakers, want) {
+		t.Errorf("expected speaker settings %+v, got job %+v and recognition %+v", want, job.Audio.Speakers, capture.cfg.Speakers)
+	}
+
+	for _, metadata := range []string{
+		tusMetadata("speaker_roles", "1=doctor"),
+		tusMetadata("speaker_roles", "patient"),
+		tusMetadata("diarization", "maybe"),
+	} {
+		if rec := tusRequest(mux, http.MethodPost, "/uploads", nil, "Upload-Length", "100", "Upload-Metadata", metadata); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for metadata %q, got %d", metadata, rec.Code)
+		}
+	}
+}
+
+func TestResumableUpload_RejectsUnsupportedEncoding(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	handler.sttClient = intelligence.NewTranscodingTranscriber(handler.sttClient, audio.FFmpeg{Path: "/nonexistent/ffmpeg"})
+	mux := uploadMux(handler)
+
+	for _, metadata := range []string{
+		tusMetadata("encoding", intelligence.EncodingOggOpus),
+		tusMetadata("filename", "visit.webm", "filetype", "audio/webm;codecs=opus"),
+	} {
+		rec := tusRequest(mux, http.MethodPost, "/uploads", nil, "Upload-Length", "100", "Upload-Metadata", metadata)
+		if rec.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("%s: expected 415 before the upload starts, got %d", metadata, rec.Code)
+		}
+	}
+	createUpload(t, mux, 100, tusMetadata("filetype", "audio/flac"))
+}
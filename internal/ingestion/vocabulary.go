@@ -0,0 +1,252 @@
+package ingestion
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"clinical-agent-backend/internal/domain"
+	"clinical-agent-backend/internal/intelligence"
+	"clinical-agent-backend/internal/protocol"
+	"clinical-agent-backend/internal/repository"
+)
+
+// maxVocabularyBody bounds admin vocabulary requests; a full phrase set is
+// well under this.
+const maxVocabularyBody = 4 << 20
+
+// WithVocabularyRepository stores the phrase sets and custom classes edited
+// through the admin API in repo. By default they are kept in memory and lost
+// on restart.
+func WithVocabularyRepository(repo repository.VocabularyRepository) Option {
+	return func(h *Handler) { h.vocabulary = repo }
+}
+
+// WithBaseVocabulary sets the phrase sets and custom classes available
+// without any admin edits, typically loaded with
+// intelligence.LoadVocabularyDir. Stored entries replace those with the
+// same name.
+func WithBaseVocabulary(v domain.Vocabulary) Option {
+	return func(h *Handler) { h.baseVocabulary = v }
+}
+
+// WithAdminToken enables the admin API for requests carrying token as a
+// bearer token. Without it the admin API is disabled.
+func WithAdminToken(token string) Option {
+	return func(h *Handler) { h.adminToken = token }
+}
+
+// currentVocabulary returns the base vocabulary overlaid with the stored
+// entries. If the repository fails only the base vocabulary is used: a
+// session without the latest boosting beats no session.
+func (h *Handler) currentVocabulary(ctx context.Context) domain.Vocabulary {
+	stored, err := h.vocabulary.Vocabulary(ctx)
+	if err != nil {
+		log.Printf("Failed to load stored vocabulary, using vocabulary files only: %v", err)
+		return h.baseVocabulary
+	}
+	return h.baseVocabulary.Merge(stored)
+}
+
+// attachVocabulary looks up the phrase sets cfg requests, with the custom
+// classes they use, and attaches them to cfg.
+func (h *Handler) attachVocabulary(ctx context.Context, cfg *intelligence.StreamConfig) error {
+	v, err := h.currentVocabulary(ctx).Select(cfg.PhraseSets)
+	if err != nil {
+		return err
+	}
+	cfg.Vocabulary = v
+	return nil
+}
+
+// recognitionPayload describes the model and phrase sets of cfg to a client.
+func recognitionPayload(cfg intelligence.StreamConfig) protocol.RecognitionConfig {
+	payload := protocol.RecognitionConfig{Model: cfg.Model, UseEnhanced: cfg.UseEnhanced}
+	for _, set := range cfg.Vocabulary.PhraseSets {
+		payload.PhraseSets = append(payload.PhraseSets, set.Name)
+	}
+	return payload
+}
+
+// authorizeAdmin checks the request's bearer token against the admin token,
+// answering the request if it does not match.
+func (h *Handler) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if h.adminToken == "" {
+		http.Error(w, "admin API is disabled", http.StatusForbidden)
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		http.Error(w, "invalid admin token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// HandleGetVocabulary handles GET /admin/vocabulary, returning every phrase
+// set and custom class sessions can use.
+func (h *Handler) HandleGetVocabulary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+
+	stored, err := h.vocabulary.Vocabulary(r.Context())
+	if err != nil {
+		log.Printf("Failed to fetch vocabulary: %v", err)
+		http.Error(w, "Failed to fetch vocabulary", http.StatusInternalServerError)
+		return
+	}
+	v := h.baseVocabulary.Merge(stored)
+	if v.PhraseSets == nil {
+		v.PhraseSets = []domain.PhraseSet{}
+	}
+	if v.CustomClasses == nil {
+		v.CustomClasses = []domain.CustomClass{}
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+// HandlePhraseSet handles /admin/vocabulary/phrase-sets/{name}: PUT creates
+// or replaces the phrase set and DELETE removes it. Changes apply to
+// sessions and jobs that start afterwards.
+func (h *Handler) HandlePhraseSet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+	name := r.PathValue("name")
+
+	if r.Method == http.MethodDelete {
+		h.deleteVocabulary(w, r, "phrase set", func(v domain.Vocabulary) domain.Vocabulary {
+			v.PhraseSets = slices.DeleteFunc(slices.Clone(v.PhraseSets), func(s domain.PhraseSet) bool { return s.Name == name })
+			return v
+		}, func(ctx context.Context) error { return h.vocabulary.DeletePhraseSet(ctx, name) })
+		return
+	}
+
+	var set domain.PhraseSet
+	if !decodeVocabularyBody(w, r, &set, &set.Name) {
+		return
+	}
+	set.UpdatedAt = time.Now().UTC()
+	h.saveVocabulary(w, r, set, domain.Vocabulary{PhraseSets: []domain.PhraseSet{set}},
+		func(ctx context.Context) error { return h.vocabulary.SavePhraseSet(ctx, &set) })
+}
+
+// HandleCustomClass handles /admin/vocabulary/custom-classes/{name}: PUT
+// creates or replaces the custom class and DELETE removes it. A class still
+// used by a phrase set cannot be deleted.
+func (h *Handler) HandleCustomClass(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+	name := r.PathValue("name")
+
+	if r.Method == http.MethodDelete {
+		h.deleteVocabulary(w, r, "custom class", func(v domain.Vocabulary) domain.Vocabulary {
+			v.CustomClasses = slices.DeleteFunc(slices.Clone(v.CustomClasses), func(c domain.CustomClass) bool { return c.Name == name })
+			return v
+		}, func(ctx context.Context) error { return h.vocabulary.DeleteCustomClass(ctx, name) })
+		return
+	}
+
+	var class domain.CustomClass
+	if !decodeVocabularyBody(w, r, &class, &class.Name) {
+		return
+	}
+	class.UpdatedAt = time.Now().UTC()
+	h.saveVocabulary(w, r, class, domain.Vocabulary{CustomClasses: []domain.CustomClass{class}},
+		func(ctx context.Context) error { return h.vocabulary.SaveCustomClass(ctx, &class) })
+}
+
+// decodeVocabularyBody reads a phrase set or custom class from the request
+// body into dst, taking its name from the path. A body naming another entry
+// is rejected.
+func decodeVocabularyBody(w http.ResponseWriter, r *http.Request, dst any, name *string) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxVocabularyBody)).Decode(dst); err != nil {
+		http.Error(w, "Invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if *name != "" && *name != r.PathValue("name") {
+		http.Error(w, "name in body does not match the URL", http.StatusBadRequest)
+		return false
+	}
+	*name = r.PathValue("name")
+	return true
+}
+
+// saveVocabulary checks that the vocabulary stays valid with the change
+// applied, then stores it and responds with entry.
+func (h *Handler) saveVocabulary(w http.ResponseWriter, r *http.Request, entry any, change domain.Vocabulary, save func(context.Context) error) {
+	stored, err := h.vocabulary.Vocabulary(r.Context())
+	if err != nil {
+		log.Printf("Failed to fetch vocabulary: %v", err)
+		http.Error(w, "Failed to fetch vocabulary", http.StatusInternalServerError)
+		return
+	}
+	if err := h.baseVocabulary.Merge(stored).Merge(change).Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := save(r.Context()); err != nil {
+		log.Printf("Failed to save vocabulary: %v", err)
+		http.Error(w, "Failed to save vocabulary", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, entry)
+}
+
+// deleteVocabulary removes a stored entry, unless the vocabulary left
+// behind would be invalid. remove drops the entry from the stored
+// vocabulary; an entry of the same name from the base vocabulary takes its
+// place.
+func (h *Handler) deleteVocabulary(w http.ResponseWriter, r *http.Request, kind string, remove func(domain.Vocabulary) domain.Vocabulary, del func(context.Context) error) {
+	stored, err := h.vocabulary.Vocabulary(r.Context())
+	if err != nil {
+		log.Printf("Failed to fetch vocabulary: %v", err)
+		http.Error(w, "Failed to fetch vocabulary", http.StatusInternalServerError)
+		return
+	}
+	if err := h.baseVocabulary.Merge(remove(stored)).Validate(); err != nil {
+		http.Error(w, "cannot delete "+kind+": "+err.Error(), http.StatusConflict)
+		return
+	}
+	err = del(r.Context())
+	if errors.Is(err, repository.ErrNotFound) {
+		http.Error(w, kind+" not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete %s: %v", kind, err)
+		http.Error(w, "Failed to delete "+kind, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeJSON writes v as the response body with the given status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
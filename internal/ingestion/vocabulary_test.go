@@ -0,0 +1,166 @@
+package ingestion
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"clinical-agent-backend/internal/domain"
+	"clinical-agent-backend/internal/intelligence"
+	"clinical-agent-backend/internal/protocol"
+)
+
+// baseVocabulary stands in for the vocabulary files.
+var baseVocabulary = domain.Vocabulary{
+	PhraseSets: []domain.PhraseSet{{
+		Name:    "formulary",
+		Phrases: []domain.Phrase{{Value: "metformin"}, {Value: "take ${drugs}"}},
+		Boost:   10,
+	}},
+	CustomClasses: []domain.CustomClass{{Name: "drugs", Items: []string{"lisinopril", "atorvastatin"}}},
+}
+
+// adminMux routes the admin API the way the server does.
+func adminMux(handler *Handler) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/vocabulary", handler.HandleGetVocabulary)
+	mux.HandleFunc("/admin/vocabulary/phrase-sets/{name}", handler.HandlePhraseSet)
+	mux.HandleFunc("/admin/vocabulary/custom-classes/{name}", handler.HandleCustomClass)
+	return mux
+}
+
+func adminRequest(mux *http.ServeMux, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestVocabularyAdmin(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	WithBaseVocabulary(baseVocabulary)(handler)
+	mux := adminMux(handler)
+
+	if rec := adminRequest(mux, http.MethodGet, "/admin/vocabulary", "", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 while the admin API is disabled, got %d", rec.Code)
+	}
+	WithAdminToken("secret")(handler)
+	if rec := adminRequest(mux, http.MethodGet, "/admin/vocabulary", "wrong", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a wrong token, got %d", rec.Code)
+	}
+
+	steps := []struct {
+		method, path, body string
+		status             int
+	}{
+		{http.MethodPut, "/admin/vocabulary/phrase-sets/staff", `{"phrases": [{"value": "Dr ${clinicians}"}]}`, http.StatusBadRequest},
+		{http.MethodPut, "/admin/vocabulary/phrase-sets/staff", `{"name": "other", "phrases": [{"value": "Dr Okafor"}]}`, http.StatusBadRequest},
+		{http.MethodPut, "/admin/vocabulary/phrase-sets/staff", `{"phrases": [], "boost": 5}`, http.StatusBadRequest},
+		{http.MethodPut, "/admin/vocabulary/custom-classes/clinicians", `{"items": ["Okafor", "Lindqvist"]}`, http.StatusOK},
+		{http.MethodPut, "/admin/vocabulary/phrase-sets/staff", `{"phrases": [{"value": "Dr ${clinicians}", "boost": 15}]}`, http.StatusOK},
+		{http.MethodDelete, "/admin/vocabulary/custom-classes/clinicians", "", http.StatusConflict},
+		{http.MethodDelete, "/admin/vocabulary/custom-classes/drugs", "", http.StatusNotFound},
+	}
+	for _, step := range steps {
+		if rec := adminRequest(mux, step.method, step.path, "secret", step.body); rec.Code != step.status {
+			t.Errorf("%s %s %s: expected %d, got %d: %s", step.method, step.path, step.body, step.status, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := adminRequest(mux, http.MethodGet, "/admin/vocabulary", "secret", "")
+	var got domain.Vocabulary
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode vocabulary: %v", err)
+	}
+	var names []string
+	for _, set := range got.PhraseSets {
+		names = append(names, set.Name)
+	}
+	if !slices.Equal(names, []string{"formulary", "staff"}) || len(got.CustomClasses) != 2 {
+		t.Errorf("unexpected vocabulary: %+v", got)
+	}
+
+	// An upload picks the edited phrase set and the class it uses.
+	capture := &captureTranscriber{LocalTranscriber: intelligence.NewLocalTranscriber(nil)}
+	handler.sttClient = capture
+	job := decodeJob(t, postUploadFields(t, handler, map[string]string{
+		"model":       intelligence.ModelMedicalDictation,
+		"phrase_sets": "staff",
+	}))
+	if job.Audio.Model != intelligence.ModelMedicalDictation || !slices.Equal(job.Audio.PhraseSets, []string{"staff"}) {
+		t.Errorf("unexpected job audio: %+v", job.Audio)
+	}
+	waitForJob(t, handler, job.ID)
+	v := capture.cfg.Vocabulary
+	if capture.cfg.Model != intelligence.ModelMedicalDictation || len(v.PhraseSets) != 1 || v.PhraseSets[0].Name != "staff" ||
+		len(v.CustomClasses) != 1 || v.CustomClasses[0].Name != "clinicians" {
+		t.Errorf("unexpected recognition config: %+v", capture.cfg)
+	}
+
+	for _, path := range []string{"/admin/vocabulary/phrase-sets/staff", "/admin/vocabulary/custom-classes/clinicians"} {
+		if rec := adminRequest(mux, http.MethodDelete, path, "secret", ""); rec.Code != http.StatusNoContent {
+			t.Errorf("DELETE %s: expected 204, got %d: %s", path, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := postUploadFields(t, handler, map[string]string{"phrase_sets": "staff"}); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected a deleted phrase set to be rejected, got %d", rec.Code)
+	}
+}
+
+// postUploadFields uploads sample.wav with the given form fields.
+func postUploadFields(t *testing.T, handler *Handler, fields map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	raw, err := os.ReadFile("../../sample.wav")
+	if err != nil {
+		t.Fatalf("failed to read sample.wav: %v", err)
+	}
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for k, v := range fields {
+		form.WriteField(k, v)
+	}
+	part, err := form.CreateFormFile("audio", "sample.wav")
+	if err != nil {
+		t.Fatalf("failed to create form file: %v", err)
+	}
+	part.Write(raw)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload-audio", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	handler.HandleUpload(rec, req)
+	return rec
+}
+
+func TestServeWS_Recognition(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	WithBaseVocabulary(baseVocabulary)(handler)
+	conn := dialWS(t, handler)
+
+	sendControl(t, conn, protocol.TypeStart, protocol.StartPayload{
+		Recognition: protocol.RecognitionConfig{Model: intelligence.ModelMedicalConversation},
+	})
+	sendControl(t, conn, protocol.TypeEnd, nil)
+	events := readEvents(t, conn)
+	if len(events) == 0 || events[0].Type != protocol.TypeSessionStarted {
+		t.Fatalf("expected session.started, got %+v", events)
+	}
+	var started protocol.SessionStartedPayload
+	if err := events[0].DecodePayload(&started); err != nil {
+		t.Fatalf("failed to decode session.started: %v", err)
+	}
+	want := protocol.RecognitionConfig{Model: intelligence.ModelMedicalConversation, PhraseSets: []string{"formulary"}}
+	if started.Recognition.Model != want.Model || !slices.Equal(started.Recognition.PhraseSets, want.PhraseSets) {
+		t.Errorf("expected recognition %+v, got %+v", want, started.Recognition)
+	}
+}
@@ -0,0 +1,137 @@
+package intelligence
+
+import (
+	"strings"
+
+	"clinical-agent-backend/internal/domain"
+)
+
+// LowConfidenceThreshold is the word confidence below which assembled words
+// are flagged for review.
+const LowConfidenceThreshold = 0.7
+
+// TranscriptAssembler builds an ordered transcript from streaming results.
+// Each interim result is the provider's full hypothesis for the speech after
+// the last final result, so it replaces the previous interim; a final result
+// commits its text, word timings and confidence as the next segment. Only
+// committed segments make up the transcript.
+//
+// A final result whose words were spoken by several diarized speakers is
+// split into one segment per speaker turn. Results from separately
+// recognized channels take the channel as their speaker. Segments are
+// labelled with their speaker's role from Roles, and with the language the
+// provider recognized them in, or Language if it reported none.
+//
+// The zero value is ready to use. A TranscriptAssembler is not safe for
+// concurrent use.
+type TranscriptAssembler struct {
+	Roles    domain.SpeakerRoles
+	Language string
+
+	segments []domain.TranscriptSegment
+	interim  TranscriptResult
+}
+
+// Add applies a result. It returns the segments a final result committed;
+// final results without text commit nothing.
+func (a *TranscriptAssembler) Add(r TranscriptResult) []domain.TranscriptSegment {
+	if !r.IsFinal {
+		a.interim = r
+		return nil
+	}
+	a.interim = TranscriptResult{}
+	text := strings.TrimSpace(r.Text)
+	if text == "" {
+		return nil
+	}
+	first := len(a.segments)
+	words := make([]domain.Word, len(r.Words))
+	for i, w := range r.Words {
+		w.LowConfidence = w.Confidence > 0 && w.Confidence < LowConfidenceThreshold
+		if r.Channel > 0 {
+			w.Speaker = r.Channel
+		}
+		words[i] = w
+	}
+	turns := speakerTurns(words)
+	if len(turns) <= 1 {
+		speaker := r.Channel
+		if len(words) > 0 {
+			speaker = words[0].Speaker
+		}
+		a.commit(r, text, speaker, words)
+	} else {
+		for _, turn := range turns {
+			texts := make([]string, len(turn))
+			for i, w := range turn {
+				texts[i] = w.Text
+			}
+			a.commit(r, strings.Join(texts, " "), turn[0].Speaker, turn)
+		}
+	}
+	return append([]domain.TranscriptSegment(nil), a.segments[first:]...)
+}
+
+// commit appends a segment of the final result r.
+func (a *TranscriptAssembler) commit(r TranscriptResult, text string, speaker int, words []domain.Word) {
+	segment := domain.TranscriptSegment{
+		Index:      len(a.segments),
+		Text:       text,
+		Confidence: r.Confidence,
+		Speaker:    speaker,
+		Role:       a.Roles.Role(speaker),
+		Language:   r.LanguageCode,
+	}
+	if segment.Language == "" {
+		segment.Language = a.Language
+	}
+	if len(words) > 0 {
+		segment.Words = words
+		segment.StartMS = words[0].StartMS
+		segment.EndMS = words[len(words)-1].EndMS
+	}
+	a.segments = append(a.segments, segment)
+}
+
+// speakerTurns splits words into runs spoken by the same speaker.
+func speakerTurns(words []domain.Word) [][]domain.Word {
+	var turns [][]domain.Word
+	start := 0
+	for i := 1; i <= len(words); i++ {
+		if i == len(words) || words[i].Speaker != words[start].Speaker {
+			turns = append(turns, words[start:i])
+			start = i
+		}
+	}
+	return turns
+}
+
+// Interim returns the current uncommitted hypothesis, if any.
+func (a *TranscriptAssembler) Interim() (TranscriptResult, bool) {
+	return a.interim, a.interim.Text != ""
+}
+
+// Next returns the index the next committed segment will get.
+func (a *TranscriptAssembler) Next() int {
+	return len(a.segments)
+}
+
+// Segments returns a copy of the committed segments in order.
+func (a *TranscriptAssembler) Segments() []domain.TranscriptSegment {
+	return append([]domain.TranscriptSegment(nil), a.segments...)
+}
+
+// Text returns the committed transcript.
+func (a *TranscriptAssembler) Text() string {
+	return domain.TranscriptText(a.segments)
+}
+
+// AssembleTranscript commits the final results among results in order,
+// labelling speakers and languages as configured in cfg.
+func AssembleTranscript(results []TranscriptResult, cfg StreamConfig) []domain.TranscriptSegment {
+	a := TranscriptAssembler{Roles: cfg.Speakers.Roles, Language: cfg.LanguageCode}
+	for _, r := range results {
+		a.Add(r)
+	}
+	return a.segments
+}
@@ -0,0 +1,107 @@
+package intelligence
+
+import (
+	"testing"
+
+	"clinical-agent-backend/internal/domain"
+)
+
+func TestTranscriptAssembler(t *testing.T) {
+	var a TranscriptAssembler
+
+	a.Add(TranscriptResult{Text: "patient", Stability: 0.2})
+	a.Add(TranscriptResult{Text: "patient reports", Stability: 0.6})
+	if interim, ok := a.Interim(); !ok || interim.Text != "patient reports" || interim.Stability != 0.6 {
+		t.Errorf("expected the latest interim to replace the first, got %+v", interim)
+	}
+	if a.Text() != "" {
+		t.Errorf("interim text must not reach the transcript, got %q", a.Text())
+	}
+
+	segments := a.Add(TranscriptResult{Text: " patient reports a headache ", IsFinal: true, Stability: 1})
+	if len(segments) != 1 || segments[0].Index != 0 || segments[0].Text != "patient reports a headache" {
+		t.Errorf("unexpected first segment: %+v", segments)
+	}
+	if _, ok := a.Interim(); ok {
+		t.Error("a final result should clear the interim")
+	}
+
+	// Empty finals commit nothing; the next segment keeps its index.
+	if segments := a.Add(TranscriptResult{Text: "  ", IsFinal: true}); len(segments) != 0 {
+		t.Error("expected an empty final to be skipped")
+	}
+	a.Add(TranscriptResult{Text: "since"})
+	if a.Next() != 1 {
+		t.Errorf("expected the next segment to be 1, got %d", a.Next())
+	}
+	a.Add(TranscriptResult{Text: "since Monday", IsFinal: true})
+	a.Add(TranscriptResult{Text: "and"})
+
+	if got := a.Text(); got != "patient reports a headache since Monday" {
+		t.Errorf("unexpected transcript: %q", got)
+	}
+	segments = a.Segments()
+	if len(segments) != 2 || segments[1].Index != 1 || segments[1].Text != "since Monday" {
+		t.Errorf("unexpected segments: %+v", segments)
+	}
+}
+
+func TestTranscriptAssembler_Words(t *testing.T) {
+	var a TranscriptAssembler
+	segment := a.Add(TranscriptResult{Text: "took ibuprofen", IsFinal: true, Confidence: 0.75, Words: []domain.Word{
+		{Text: "took", StartMS: 1200, EndMS: 1400, Confidence: 0.95},
+		{Text: "ibuprofen", StartMS: 1450, EndMS: 2100, Confidence: 0.55},
+	}})[0]
+	if segment.StartMS != 1200 || segment.EndMS != 2100 || segment.Confidence != 0.75 {
+		t.Errorf("unexpected segment timing: %+v", segment)
+	}
+	if len(segment.Words) != 2 || segment.Words[0].LowConfidence || !segment.Words[1].LowConfidence {
+		t.Errorf("expected only ibuprofen to be flagged for review, got %+v", segment.Words)
+	}
+}
+
+func TestTranscriptAssembler_SpeakerTurns(t *testing.T) {
+	a := TranscriptAssembler{Roles: domain.SpeakerRoles{1: domain.RolePatient, 2: domain.RoleClinician}}
+
+	segments := a.Add(TranscriptResult{Text: "any fever no just a cough", IsFinal: true, Words: []domain.Word{
+		{Text: "any", StartMS: 0, EndMS: 200, Speaker: 2},
+		{Text: "fever", StartMS: 200, EndMS: 600, Speaker: 2},
+		{Text: "no", StartMS: 900, EndMS: 1000, Speaker: 1},
+		{Text: "just", StartMS: 1000, EndMS: 1200, Speaker: 1},
+		{Text: "a", StartMS: 1200, EndMS: 1300, Speaker: 1},
+		{Text: "cough", StartMS: 1300, EndMS: 1700, Speaker: 1},
+	}})
+	if len(segments) != 2 {
+		t.Fatalf("expected a segment per speaker turn, got %+v", segments)
+	}
+	if s := segments[0]; s.Index != 0 || s.Text != "any fever" || s.Speaker != 2 || s.Role != domain.RoleClinician || s.EndMS != 600 {
+		t.Errorf("unexpected clinician segment: %+v", s)
+	}
+	if s := segments[1]; s.Index != 1 || s.Text != "no just a cough" || s.Role != domain.RolePatient || s.StartMS != 900 {
+		t.Errorf("unexpected patient segment: %+v", s)
+	}
+
+	// With separate channels the channel is the speaker.
+	segments = a.Add(TranscriptResult{Text: "since Monday", IsFinal: true, Channel: 3})
+	if len(segments) != 1 || segments[0].Speaker != 3 || segments[0].Role != domain.RoleOther {
+		t.Errorf("unexpected channel segment: %+v", segments)
+	}
+
+	if got := domain.Dialogue(a.Segments()); got != "Clinician: any fever\nPatient: no just a cough\nOther: since Monday" {
+		t.Errorf("unexpected dialogue: %q", got)
+	}
+}
+
+func TestAssembleTranscript_Languages(t *testing.T) {
+	cfg := StreamConfig{LanguageCode: "en-US", AlternativeLanguageCodes: []string{"es-US"}}
+	segments := AssembleTranscript([]TranscriptResult{
+		{Text: "tengo fiebre desde ayer", IsFinal: true, LanguageCode: "es-US"},
+		{Text: "since yesterday", IsFinal: true},
+	}, cfg)
+	if segments[0].Language != "es-US" || segments[1].Language != "en-US" {
+		t.Errorf("expected the detected language, or the primary one, on each segment: %+v", segments)
+	}
+	if got := domain.TranscriptLanguage(segments, "en-US"); got != "es-US" {
+		t.Errorf("expected Spanish to dominate the transcript, got %q", got)
+	}
+}
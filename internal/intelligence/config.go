@@ -0,0 +1,204 @@
+package intelligence
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"clinical-agent-backend/internal/domain"
+)
+
+// Audio encodings accepted in StreamConfig.Encoding. Names follow the
+// Google Cloud Speech RecognitionConfig enum, except MP4_AAC (AAC in an
+// MP4/M4A container), which no provider accepts natively and is always
+// transcoded.
+const (
+	EncodingLinear16 = "LINEAR16"
+	EncodingFLAC     = "FLAC"
+	EncodingMulaw    = "MULAW"
+	EncodingOggOpus  = "OGG_OPUS"
+	EncodingWebmOpus = "WEBM_OPUS"
+	EncodingMP4AAC   = "MP4_AAC"
+)
+
+// SupportedEncodings lists the encodings StreamConfig.Validate accepts.
+var SupportedEncodings = []string{EncodingLinear16, EncodingFLAC, EncodingMulaw, EncodingOggOpus, EncodingWebmOpus, EncodingMP4AAC}
+
+// opusSampleRates are the only rates Opus streams can be decoded at.
+var opusSampleRates = []int{8000, 12000, 16000, 24000, 48000}
+
+// Recognition models accepted in StreamConfig.Model, named as in Google
+// Cloud Speech.
+const (
+	ModelDefault             = "default"
+	ModelMedicalConversation = "medical_conversation"
+	ModelMedicalDictation    = "medical_dictation"
+)
+
+// SupportedModels lists the models StreamConfig.Validate accepts.
+var SupportedModels = []string{
+	ModelDefault, ModelMedicalConversation, ModelMedicalDictation,
+	"latest_long", "latest_short", "phone_call", "video", "command_and_search",
+}
+
+// enhancedModels are the models that have an enhanced variant.
+var enhancedModels = []string{"phone_call", "video"}
+
+// maxAlternativeLanguages is how many alternative languages Google
+// accepts per request.
+const maxAlternativeLanguages = 3
+
+// languageTagPattern accepts BCP-47 tags such as "en", "en-US" or "es-419".
+var languageTagPattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// StreamConfig describes the audio a transcriber will receive and how it
+// should be recognized.
+type StreamConfig struct {
+	Encoding        string
+	SampleRateHertz int
+	Channels        int
+	LanguageCode    string
+	// AlternativeLanguageCodes are languages the speech may be in besides
+	// LanguageCode. The provider picks the best match for each result and
+	// reports it in TranscriptResult.LanguageCode.
+	AlternativeLanguageCodes []string
+	// Model is one of SupportedModels. The medical models only recognize
+	// US English.
+	Model string
+	// UseEnhanced selects the enhanced variant of Model.
+	UseEnhanced bool
+	// PhraseSets names the vocabulary phrase sets requested for the
+	// session; empty means all of them. Vocabulary holds their content.
+	PhraseSets []string
+	Vocabulary domain.Vocabulary
+	// Speakers selects diarization or per-channel recognition and the
+	// roles assigned to the speakers found.
+	Speakers domain.SpeakerConfig
+}
+
+// DefaultStreamConfig is 16 kHz mono LINEAR16 in US English, which is what
+// clients sent before the session handshake existed.
+func DefaultStreamConfig() StreamConfig {
+	return StreamConfig{
+		Encoding:        EncodingLinear16,
+		SampleRateHertz: 16000,
+		Channels:        1,
+		LanguageCode:    "en-US",
+		Model:           ModelDefault,
+	}
+}
+
+// WithDefaults fills unset fields from DefaultStreamConfig.
+func (c StreamConfig) WithDefaults() StreamConfig {
+	def := DefaultStreamConfig()
+	if c.Encoding == "" {
+		c.Encoding = def.Encoding
+	}
+	if c.SampleRateHertz == 0 {
+		c.SampleRateHertz = def.SampleRateHertz
+	}
+	if c.Channels == 0 {
+		c.Channels = def.Channels
+	}
+	if c.LanguageCode == "" {
+		c.LanguageCode = def.LanguageCode
+	}
+	if c.Model == "" {
+		c.Model = def.Model
+	}
+	return c
+}
+
+// FrameSize returns the number of bytes in one sample frame for raw
+// encodings (LINEAR16 and MULAW), or 0 for compressed encodings, whose
+// byte offsets do not map to time.
+func (c StreamConfig) FrameSize() int {
+	switch c.Encoding {
+	case EncodingLinear16:
+		return 2 * c.Channels
+	case EncodingMulaw:
+		return c.Channels
+	}
+	return 0
+}
+
+// Duration returns how long n bytes of audio play for. It reports false
+// for compressed encodings.
+func (c StreamConfig) Duration(n int64) (time.Duration, bool) {
+	bytesPerSecond := int64(c.FrameSize()) * int64(c.SampleRateHertz)
+	if bytesPerSecond == 0 {
+		return 0, false
+	}
+	return time.Duration(float64(n) / float64(bytesPerSecond) * float64(time.Second)), true
+}
+
+// Validate reports the first unsupported parameter, if any.
+func (c StreamConfig) Validate() error {
+	if !slices.Contains(SupportedEncodings, c.Encoding) {
+		return fmt.Errorf("unsupported encoding %q (supported: %v)", c.Encoding, SupportedEncodings)
+	}
+	if c.SampleRateHertz < 8000 || c.SampleRateHertz > 48000 {
+		return fmt.Errorf("unsupported sample rate %d Hz (must be 8000-48000)", c.SampleRateHertz)
+	}
+	if (c.Encoding == EncodingOggOpus || c.Encoding == EncodingWebmOpus) && !slices.Contains(opusSampleRates, c.SampleRateHertz) {
+		return fmt.Errorf("unsupported sample rate %d Hz for %s (supported: %v)", c.SampleRateHertz, c.Encoding, opusSampleRates)
+	}
+	if c.Channels < 1 || c.Channels > 8 {
+		return fmt.Errorf("unsupported channel count %d (must be 1-8)", c.Channels)
+	}
+	if !languageTagPattern.MatchString(c.LanguageCode) {
+		return fmt.Errorf("invalid language code %q (expected a BCP-47 tag such as \"en-US\")", c.LanguageCode)
+	}
+	if len(c.AlternativeLanguageCodes) > maxAlternativeLanguages {
+		return fmt.Errorf("too many alternative languages: %d (at most %d)", len(c.AlternativeLanguageCodes), maxAlternativeLanguages)
+	}
+	for i, code := range c.AlternativeLanguageCodes {
+		if !languageTagPattern.MatchString(code) {
+			return fmt.Errorf("invalid alternative language code %q (expected a BCP-47 tag such as \"es-US\")", code)
+		}
+		if strings.EqualFold(code, c.LanguageCode) || slices.ContainsFunc(c.AlternativeLanguageCodes[:i], func(other string) bool {
+			return strings.EqualFold(code, other)
+		}) {
+			return fmt.Errorf("duplicate language code %q", code)
+		}
+	}
+	if err := c.validateModel(); err != nil {
+		return err
+	}
+	if err := c.Vocabulary.Validate(); err != nil {
+		return err
+	}
+	return c.Speakers.Validate(c.Channels)
+}
+
+// validateModel checks the model against the languages and enhancement
+// requested.
+func (c StreamConfig) validateModel() error {
+	if !slices.Contains(SupportedModels, c.Model) {
+		return fmt.Errorf("unsupported model %q (supported: %v)", c.Model, SupportedModels)
+	}
+	if c.Model == ModelMedicalConversation || c.Model == ModelMedicalDictation {
+		if c.LanguageCode != "en-US" || len(c.AlternativeLanguageCodes) > 0 {
+			return fmt.Errorf("model %s only supports en-US", c.Model)
+		}
+	}
+	if c.UseEnhanced && !slices.Contains(enhancedModels, c.Model) {
+		return fmt.Errorf("model %s has no enhanced variant (available for %v)", c.Model, enhancedModels)
+	}
+	return nil
+}
+
+// normalizeLanguageTag puts a BCP-47 tag in its conventional case
+// ("es-us" becomes "es-US"), as providers report tags in lower case.
+func normalizeLanguageTag(tag string) string {
+	parts := strings.Split(tag, "-")
+	parts[0] = strings.ToLower(parts[0])
+	for i := 1; i < len(parts); i++ {
+		if len(parts[i]) == 2 {
+			parts[i] = strings.ToUpper(parts[i])
+		}
+	}
+	return strings.Join(parts, "-")
+}
@@ -0,0 +1,43 @@
+package intelligence
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"clinical-agent-backend/internal/domain"
+)
+
+// EntityExtractor turns transcript text into a structured ClinicalNote.
+// language is the BCP-47 tag of the text, or "" if unknown; notes are
+// written in English whatever the language of the text.
+type EntityExtractor interface {
+	ExtractEntities(ctx context.Context, text, language string) (*domain.ClinicalNote, error)
+}
+
+// FallbackExtractor tries Primary first and falls back to Fallback when the
+// primary extractor fails, e.g. because the LLM is unreachable.
+type FallbackExtractor struct {
+	Primary  EntityExtractor
+	Fallback EntityExtractor
+}
+
+// NewFallbackExtractor creates an extractor that degrades to fallback on error.
+func NewFallbackExtractor(primary, fallback EntityExtractor) *FallbackExtractor {
+	return &FallbackExtractor{Primary: primary, Fallback: fallback}
+}
+
+// ExtractEntities implements EntityExtractor.
+func (f *FallbackExtractor) ExtractEntities(ctx context.Context, text, language string) (*domain.ClinicalNote, error) {
+	note, err := f.Primary.ExtractEntities(ctx, text, language)
+	if err == nil {
+		return note, nil
+	}
+	log.Printf("Primary entity extraction failed, using fallback: %v", err)
+
+	note, fallbackErr := f.Fallback.ExtractEntities(ctx, text, language)
+	if fallbackErr != nil {
+		return nil, fmt.Errorf("fallback extraction failed: %w (primary: %v)", fallbackErr, err)
+	}
+	return note, nil
+}
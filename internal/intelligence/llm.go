@@ -13,8 +13,21 @@ import (
 	"google.golang.org/api/option"
 )
 
-// LLMClient wraps the Google Generative AI client.
+// llmBackend generates a completion for a single prompt. Each supported
+// LLM protocol provides one.
+type llmBackend interface {
+	generate(ctx context.Context, prompt string) (string, error)
+	close() error
+}
+
+// LLMClient runs clinical prompts against a configured LLM backend
+// (Gemini or an OpenAI-compatible chat completions server).
 type LLMClient struct {
+	backend llmBackend
+}
+
+// geminiBackend talks to Google Generative AI.
+type geminiBackend struct {
 	client *genai.Client
 	model  *genai.GenerativeModel
 }
@@ -45,12 +58,11 @@ func NewLLMClient(ctx context.Context, projectID string, location string) (*LLMC
 	// "responseMIMEType" is a configuration option.
 	model.ResponseMIMEType = "application/json"
 
-	return &LLMClient{client: client, model: model}, nil
+	return &LLMClient{backend: &geminiBackend{client: client, model: model}}, nil
 }
 
-// GenerateResponse generates a response from the model based on the prompt.
-func (c *LLMClient) GenerateResponse(ctx context.Context, prompt string) (string, error) {
-	resp, err := c.model.GenerateContent(ctx, genai.Text(prompt))
+func (g *geminiBackend) generate(ctx context.Context, prompt string) (string, error) {
+	resp, err := g.model.GenerateContent(ctx, genai.Text(prompt))
 	if err != nil {
 		return "", fmt.Errorf("failed to generate content: %w", err)
 	}
@@ -67,14 +79,36 @@ func (c *LLMClient) GenerateResponse(ctx context.Context, prompt string) (string
 	return "", fmt.Errorf("unexpected response format")
 }
 
-// ExtractEntities extracts medical entities from the provided text.
-func (c *LLMClient) ExtractEntities(ctx context.Context, text string) (*domain.ClinicalNote, error) {
+func (g *geminiBackend) close() error {
+	return g.client.Close()
+}
+
+// GenerateResponse generates a response from the model based on the prompt.
+func (c *LLMClient) GenerateResponse(ctx context.Context, prompt string) (string, error) {
+	return c.backend.generate(ctx, prompt)
+}
+
+// ExtractEntities extracts medical entities from the provided text. The
+// note is written in English even when the text is not.
+func (c *LLMClient) ExtractEntities(ctx context.Context, text, language string) (*domain.ClinicalNote, error) {
+	source := "The text may be in any language."
+	if language != "" {
+		source = fmt.Sprintf("The text is in the language with BCP-47 tag %q.", language)
+	}
 	prompt := fmt.Sprintf(`
 You are an expert clinical assistant. Extract the following entities from the text below:
 - Symptoms
 - Medications
 - HPI (History of Present Illness) key points
 
+The text may be a dialogue with one speaker turn per line, each prefixed
+with the speaker's role ("Clinician:", "Patient:" or "Other:"). Record what
+the patient reports or confirms; a clinician's question is not a finding
+unless the patient confirms it.
+
+%s Write every entity in English, translating where needed, using
+English clinical terms and generic medication names.
+
 Format the output as a JSON object matching this structure:
 {
   "symptoms": ["string"],
@@ -83,7 +117,7 @@ Format the output as a JSON object matching this structure:
 }
 
 Text: "%s"
-`, text)
+`, source, text)
 
 	respStr, err := c.GenerateResponse(ctx, prompt)
 	if err != nil {
@@ -108,5 +142,5 @@ Text: "%s"
 
 // Close closes the underlying client.
 func (c *LLMClient) Close() {
-	c.client.Close()
+	c.backend.close()
 }
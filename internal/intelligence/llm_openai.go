@@ -0,0 +1,158 @@
+package intelligence
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// OpenAIConfig configures a backend speaking the OpenAI chat completions
+// protocol, as served by OpenAI, Azure OpenAI, vLLM and Ollama.
+type OpenAIConfig struct {
+	// BaseURL is the API root, e.g. "https://api.openai.com/v1",
+	// "http://localhost:11434/v1" or, for Azure,
+	// "https://<resource>.openai.azure.com/openai/deployments/<deployment>".
+	BaseURL string
+	// Model is sent as the "model" field. Azure ignores it in favour of the
+	// deployment in BaseURL.
+	Model string
+	// APIKey is sent as a bearer token, or as the "api-key" header for Azure.
+	APIKey string
+	// APIVersion enables Azure mode and is sent as the api-version query parameter.
+	APIVersion string
+	// JSONMode requests response_format {"type": "json_object"}.
+	JSONMode bool
+	// HTTPClient overrides the default client (60s timeout).
+	HTTPClient *http.Client
+}
+
+// openAIBackend talks to an OpenAI-compatible /chat/completions endpoint.
+type openAIBackend struct {
+	cfg        OpenAIConfig
+	endpoint   string
+	httpClient *http.Client
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatResponseFormat struct {
+	Type string `json:"type"`
+}
+
+type chatCompletionRequest struct {
+	Model          string              `json:"model,omitempty"`
+	Messages       []chatMessage       `json:"messages"`
+	Temperature    float64             `json:"temperature"`
+	ResponseFormat *chatResponseFormat `json:"response_format,omitempty"`
+}
+
+type chatCompletionResponse struct {
+	Choices []struct {
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// NewOpenAILLMClient creates an LLMClient backed by an OpenAI-compatible server.
+func NewOpenAILLMClient(cfg OpenAIConfig) (*LLMClient, error) {
+	if cfg.BaseURL == "" {
+		return nil, fmt.Errorf("OpenAI base URL is required")
+	}
+	if cfg.Model == "" && cfg.APIVersion == "" {
+		return nil, fmt.Errorf("OpenAI model is required")
+	}
+
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.BaseURL, "/") + "/chat/completions")
+	if err != nil {
+		return nil, fmt.Errorf("invalid OpenAI base URL: %w", err)
+	}
+	if cfg.APIVersion != "" {
+		q := endpoint.Query()
+		q.Set("api-version", cfg.APIVersion)
+		endpoint.RawQuery = q.Encode()
+	}
+
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 60 * time.Second}
+	}
+
+	return &LLMClient{backend: &openAIBackend{
+		cfg:        cfg,
+		endpoint:   endpoint.String(),
+		httpClient: httpClient,
+	}}, nil
+}
+
+func (o *openAIBackend) generate(ctx context.Context, prompt string) (string, error) {
+	reqBody := chatCompletionRequest{
+		Model:    o.cfg.Model,
+		Messages: []chatMessage{{Role: "user", Content: prompt}},
+	}
+	if o.cfg.JSONMode {
+		reqBody.ResponseFormat = &chatResponseFormat{Type: "json_object"}
+	}
+	payload, err := json.Marshal(reqBody)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal chat completion request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return "", fmt.Errorf("failed to build chat completion request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.cfg.APIKey != "" {
+		if o.cfg.APIVersion != "" {
+			req.Header.Set("api-key", o.cfg.APIKey)
+		} else {
+			req.Header.Set("Authorization", "Bearer "+o.cfg.APIKey)
+		}
+	}
+
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to call chat completions: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read chat completion response: %w", err)
+	}
+
+	var completion chatCompletionResponse
+	if err := json.Unmarshal(body, &completion); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("chat completions returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+		}
+		return "", fmt.Errorf("failed to unmarshal chat completion response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if completion.Error != nil {
+			return "", fmt.Errorf("chat completions returned status %d: %s", resp.StatusCode, completion.Error.Message)
+		}
+		return "", fmt.Errorf("chat completions returned status %d", resp.StatusCode)
+	}
+
+	if len(completion.Choices) == 0 || completion.Choices[0].Message.Content == "" {
+		return "", fmt.Errorf("no content generated")
+	}
+	return completion.Choices[0].Message.Content, nil
+}
+
+func (o *openAIBackend) close() error {
+	o.httpClient.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,136 @@
+package intelligence
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOpenAIBackend_ExtractEntities(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer test-key" {
+			t.Errorf("unexpected Authorization header: %q", got)
+		}
+
+		var req chatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		if req.Model != "llama3" {
+			t.Errorf("unexpected model: %q", req.Model)
+		}
+		if req.ResponseFormat == nil || req.ResponseFormat.Type != "json_object" {
+			t.Errorf("expected JSON response format, got %+v", req.ResponseFormat)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":` +
+			`"{\"symptoms\":[\"cough\"],\"medications\":[\"albuterol\"],\"hpi\":[\"Cough for 3 days\"]}"}}]}`))
+	}))
+	defer server.Close()
+
+	client, err := NewOpenAILLMClient(OpenAIConfig{
+		BaseURL:  server.URL + "/v1",
+		Model:    "llama3",
+		APIKey:   "test-key",
+		JSONMode: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	note, err := client.ExtractEntities(context.Background(), "I've been coughing for 3 days, using my albuterol.", "en-US")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(note.Symptoms, []string{"cough"}) || !reflect.DeepEqual(note.Medications, []string{"albuterol"}) {
+		t.Errorf("unexpected note: %+v", note)
+	}
+}
+
+func TestLLMClient_ExtractEntitiesInEnglish(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req chatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		prompt := req.Messages[len(req.Messages)-1].Content
+		if !strings.Contains(prompt, `"es-US"`) || !strings.Contains(prompt, "in English") || !strings.Contains(prompt, "Tengo fiebre") {
+			t.Errorf("expected the prompt to name the source language and ask for English, got:\n%s", prompt)
+		}
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"{\"symptoms\":[\"fever\"],\"medications\":[],\"hpi\":[]}"}}]}`))
+	}))
+	defer server.Close()
+
+	client, err := NewOpenAILLMClient(OpenAIConfig{BaseURL: server.URL + "/v1", Model: "llama3"})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	note, err := client.ExtractEntities(context.Background(), "Tengo fiebre desde ayer.", "es-US")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(note.Symptoms, []string{"fever"}) {
+		t.Errorf("unexpected note: %+v", note)
+	}
+}
+
+func TestOpenAIBackend_Azure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("api-version"); got != "2024-06-01" {
+			t.Errorf("unexpected api-version: %q", got)
+		}
+		if got := r.Header.Get("api-key"); got != "azure-key" {
+			t.Errorf("unexpected api-key header: %q", got)
+		}
+		if r.Header.Get("Authorization") != "" {
+			t.Errorf("Authorization header must not be sent to Azure")
+		}
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"hello"}}]}`))
+	}))
+	defer server.Close()
+
+	client, err := NewOpenAILLMClient(OpenAIConfig{
+		BaseURL:    server.URL + "/openai/deployments/gpt-4o",
+		APIKey:     "azure-key",
+		APIVersion: "2024-06-01",
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	resp, err := client.GenerateResponse(context.Background(), "hi")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp != "hello" {
+		t.Errorf("unexpected response: %q", resp)
+	}
+}
+
+func TestOpenAIBackend_ErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"error":{"message":"rate limit exceeded"}}`))
+	}))
+	defer server.Close()
+
+	client, err := NewOpenAILLMClient(OpenAIConfig{BaseURL: server.URL, Model: "gpt-4o-mini"})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	if _, err := client.GenerateResponse(context.Background(), "hi"); err == nil {
+		t.Fatal("expected error for non-200 response")
+	}
+}
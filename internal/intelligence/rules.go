@@ -0,0 +1,249 @@
+package intelligence
+
+import (
+	"bufio"
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strings"
+
+	"clinical-agent-backend/internal/domain"
+)
+
+//go:embed lexicon/*.txt
+var bundledLexicon embed.FS
+
+// Lexicon file names looked up by NewRuleExtractorFromFS.
+const (
+	symptomLexiconFile    = "symptoms.txt"
+	medicationLexiconFile = "medications.txt"
+)
+
+// negationCues mark a following term as absent ("denies chest pain",
+// "sin fiebre").
+var negationCues = map[string]bool{
+	"no": true, "not": true, "denies": true, "denied": true, "deny": true,
+	"without": true, "negative": true, "never": true, "none": true,
+	"sin": true, "niega": true, "nunca": true, "ningún": true, "ninguna": true,
+}
+
+// negationWindow is how many words before a term are checked for a cue.
+const negationWindow = 3
+
+var (
+	sentenceSplitter = regexp.MustCompile(`[^.!?\n]+[.!?]?`)
+	wordPattern      = regexp.MustCompile(`[\p{L}\p{N}'-]+`)
+	// speakerPrefix matches the role label of a dialogue line
+	// (see domain.DialogueLine).
+	speakerPrefix = regexp.MustCompile(`^(?i)(clinician|patient|other):\s*`)
+)
+
+// lexiconEntry is one canonical term and the pattern matching its synonyms.
+type lexiconEntry struct {
+	canonical string
+	pattern   *regexp.Regexp
+}
+
+// RuleExtractor is an offline EntityExtractor that recognizes symptoms and
+// medications from dictionary files. It serves as a fallback when the LLM
+// is unavailable and as a quality baseline for model output.
+type RuleExtractor struct {
+	symptoms    []lexiconEntry
+	medications []lexiconEntry
+}
+
+// NewRuleExtractor creates a RuleExtractor using the bundled lexicons.
+func NewRuleExtractor() (*RuleExtractor, error) {
+	sub, err := fs.Sub(bundledLexicon, "lexicon")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bundled lexicon: %w", err)
+	}
+	return NewRuleExtractorFromFS(sub)
+}
+
+// NewRuleExtractorFromFS creates a RuleExtractor from symptoms.txt and
+// medications.txt in fsys. Each line holds a canonical term followed by
+// optional "|"-separated synonyms; blank lines and "#" comments are ignored.
+func NewRuleExtractorFromFS(fsys fs.FS) (*RuleExtractor, error) {
+	symptoms, err := loadLexicon(fsys, symptomLexiconFile)
+	if err != nil {
+		return nil, err
+	}
+	medications, err := loadLexicon(fsys, medicationLexiconFile)
+	if err != nil {
+		return nil, err
+	}
+	return &RuleExtractor{symptoms: symptoms, medications: medications}, nil
+}
+
+func loadLexicon(fsys fs.FS, name string) ([]lexiconEntry, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lexicon %s: %w", name, err)
+	}
+	defer f.Close()
+
+	var entries []lexiconEntry
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var terms []string
+		for _, term := range strings.Split(line, "|") {
+			if term = strings.ToLower(strings.TrimSpace(term)); term != "" {
+				terms = append(terms, term)
+			}
+		}
+		if len(terms) == 0 {
+			continue
+		}
+		pattern, err := compileTerms(terms)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lexicon entry %s:%d: %w", name, lineNo, err)
+		}
+		entries = append(entries, lexiconEntry{canonical: terms[0], pattern: pattern})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read lexicon %s: %w", name, err)
+	}
+	return entries, nil
+}
+
+// compileTerms builds a case-insensitive whole-word pattern for the terms,
+// preferring longer synonyms so "lower back pain" wins over "back pain".
+func compileTerms(terms []string) (*regexp.Regexp, error) {
+	sorted := append([]string(nil), terms...)
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+
+	alternatives := make([]string, len(sorted))
+	for i, term := range sorted {
+		words := strings.Fields(term)
+		for j, w := range words {
+			words[j] = regexp.QuoteMeta(w)
+		}
+		alternatives[i] = strings.Join(words, `\s+`)
+	}
+	return regexp.Compile(`(?i)\b(` + strings.Join(alternatives, "|") + `)\b`)
+}
+
+// match is a lexicon hit within a sentence.
+type match struct {
+	canonical  string
+	start, end int
+}
+
+// findMatches returns non-overlapping hits for entries, longest first.
+func findMatches(sentence string, entries []lexiconEntry) []match {
+	var hits []match
+	for _, entry := range entries {
+		for _, loc := range entry.pattern.FindAllStringSubmatchIndex(sentence, -1) {
+			hits = append(hits, match{canonical: entry.canonical, start: loc[2], end: loc[3]})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].start != hits[j].start {
+			return hits[i].start < hits[j].start
+		}
+		return hits[i].end-hits[i].start > hits[j].end-hits[j].start
+	})
+
+	var kept []match
+	lastEnd := -1
+	for _, h := range hits {
+		if h.start < lastEnd {
+			continue
+		}
+		kept = append(kept, h)
+		lastEnd = h.end
+	}
+	return kept
+}
+
+// isNegated reports whether a negation cue precedes offset within the window.
+func isNegated(sentence string, offset int) bool {
+	words := wordPattern.FindAllString(strings.ToLower(sentence[:offset]), -1)
+	if len(words) > negationWindow {
+		words = words[len(words)-negationWindow:]
+	}
+	for _, w := range words {
+		if negationCues[w] {
+			return true
+		}
+	}
+	return false
+}
+
+// splitSpeaker separates the role label from a dialogue line. Lines
+// without a label have no role.
+func splitSpeaker(line string) (domain.SpeakerRole, string) {
+	m := speakerPrefix.FindStringSubmatch(line)
+	if m == nil {
+		return "", line
+	}
+	return domain.SpeakerRole(strings.ToLower(m[1])), line[len(m[0]):]
+}
+
+// ExtractEntities implements EntityExtractor. Negated symptoms ("no fever")
+// are left out of Symptoms, but every sentence mentioning a lexicon term,
+// including pertinent negatives, is kept as an HPI point.
+//
+// Text may be speaker-attributed dialogue, one "Role: words" line per turn.
+// Questions asked by the clinician ("Any chest pain?") are skipped, since
+// they report nothing about the patient.
+//
+// The language is not used: the lexicons list Spanish synonyms next to the
+// English terms, so Spanish speech maps to English symptoms and
+// medications. HPI sentences are quoted in the original language.
+func (e *RuleExtractor) ExtractEntities(ctx context.Context, text, language string) (*domain.ClinicalNote, error) {
+	note := &domain.ClinicalNote{
+		Symptoms:    []string{},
+		Medications: []string{},
+		HPI:         []string{},
+	}
+	seen := make(map[string]bool)
+
+	for _, line := range strings.Split(text, "\n") {
+		role, line := splitSpeaker(strings.TrimSpace(line))
+		for _, sentence := range sentenceSplitter.FindAllString(line, -1) {
+			sentence = strings.TrimSpace(sentence)
+			if sentence == "" || (role == domain.RoleClinician && strings.HasSuffix(sentence, "?")) {
+				continue
+			}
+			e.extractSentence(note, seen, sentence)
+		}
+	}
+
+	return note, nil
+}
+
+// extractSentence adds the entities mentioned in one sentence to note.
+// seen holds the entities already added.
+func (e *RuleExtractor) extractSentence(note *domain.ClinicalNote, seen map[string]bool, sentence string) {
+	mentioned := false
+
+	for _, m := range findMatches(sentence, e.symptoms) {
+		mentioned = true
+		if isNegated(sentence, m.start) || seen["s:"+m.canonical] {
+			continue
+		}
+		seen["s:"+m.canonical] = true
+		note.Symptoms = append(note.Symptoms, m.canonical)
+	}
+	for _, m := range findMatches(sentence, e.medications) {
+		mentioned = true
+		if seen["m:"+m.canonical] {
+			continue
+		}
+		seen["m:"+m.canonical] = true
+		note.Medications = append(note.Medications, m.canonical)
+	}
+
+	if mentioned {
+		note.HPI = append(note.HPI, sentence)
+	}
+}
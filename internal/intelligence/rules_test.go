@@ -0,0 +1,100 @@
+package intelligence
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRuleExtractor_ExtractEntities(t *testing.T) {
+	extractor, err := NewRuleExtractor()
+	if err != nil {
+		t.Fatalf("failed to load bundled lexicon: %v", err)
+	}
+
+	text := "I've had a bad Headache and some lower back pain since Monday. " +
+		"No fever, but I feel short of breath. I took Tylenol and ibuprofen. " +
+		"The weather was nice."
+
+	note, err := extractor.ExtractEntities(context.Background(), text, "en-US")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := []string{"headache", "back pain", "shortness of breath"}; !reflect.DeepEqual(note.Symptoms, want) {
+		t.Errorf("symptoms: expected %v, got %v", want, note.Symptoms)
+	}
+	if want := []string{"acetaminophen", "ibuprofen"}; !reflect.DeepEqual(note.Medications, want) {
+		t.Errorf("medications: expected %v, got %v", want, note.Medications)
+	}
+	if len(note.HPI) != 3 {
+		t.Errorf("expected 3 HPI sentences, got %d: %v", len(note.HPI), note.HPI)
+	}
+}
+
+func TestRuleExtractor_Dialogue(t *testing.T) {
+	extractor, err := NewRuleExtractor()
+	if err != nil {
+		t.Fatalf("failed to load bundled lexicon: %v", err)
+	}
+
+	text := "Clinician: Any chest pain or fever?\n" +
+		"Patient: Just a headache.\n" +
+		"Clinician: Take ibuprofen for the headache."
+
+	note, err := extractor.ExtractEntities(context.Background(), text, "en-US")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"headache"}; !reflect.DeepEqual(note.Symptoms, want) {
+		t.Errorf("symptoms: expected %v, got %v", want, note.Symptoms)
+	}
+	if want := []string{"ibuprofen"}; !reflect.DeepEqual(note.Medications, want) {
+		t.Errorf("medications: expected %v, got %v", want, note.Medications)
+	}
+	if want := []string{"Just a headache.", "Take ibuprofen for the headache."}; !reflect.DeepEqual(note.HPI, want) {
+		t.Errorf("HPI: expected %v, got %v", want, note.HPI)
+	}
+}
+
+func TestRuleExtractor_Spanish(t *testing.T) {
+	extractor, err := NewRuleExtractor()
+	if err != nil {
+		t.Fatalf("failed to load bundled lexicon: %v", err)
+	}
+
+	text := "Tengo dolor de cabeza y fiebre desde ayer. Sin tos. Tomé paracetamol."
+	note, err := extractor.ExtractEntities(context.Background(), text, "es-US")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"headache", "fever"}; !reflect.DeepEqual(note.Symptoms, want) {
+		t.Errorf("symptoms: expected %v, got %v", want, note.Symptoms)
+	}
+	if want := []string{"acetaminophen"}; !reflect.DeepEqual(note.Medications, want) {
+		t.Errorf("medications: expected %v, got %v", want, note.Medications)
+	}
+}
+
+func TestRuleExtractor_CustomLexicon(t *testing.T) {
+	fsys := fstest.MapFS{
+		"symptoms.txt":    {Data: []byte("# comment\nitching | pruritus\n")},
+		"medications.txt": {Data: []byte("hydroxyzine | atarax\n")},
+	}
+	extractor, err := NewRuleExtractorFromFS(fsys)
+	if err != nil {
+		t.Fatalf("failed to load lexicon: %v", err)
+	}
+
+	note, err := extractor.ExtractEntities(context.Background(), "Pruritus responded to Atarax", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(note.Symptoms, []string{"itching"}) {
+		t.Errorf("unexpected symptoms: %v", note.Symptoms)
+	}
+	if !reflect.DeepEqual(note.Medications, []string{"hydroxyzine"}) {
+		t.Errorf("unexpected medications: %v", note.Medications)
+	}
+}
@@ -4,15 +4,19 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
+	"time"
+
+	"clinical-agent-backend/internal/domain"
 
 	speech "cloud.google.com/go/speech/apiv1"
 	speechpb "cloud.google.com/go/speech/apiv1/speechpb"
 )
 
 // STTClient wraps the Google Cloud Speech client.
+// It implements Transcriber as the "google" provider.
 type STTClient struct {
-	client *speech.Client
+	client   *speech.Client
+	rotation rotationConfig
 }
 
 // NewSTTClient creates a new Speech-to-Text client.
@@ -21,89 +25,118 @@ func NewSTTClient(ctx context.Context) (*STTClient, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create speech client: %w", err)
 	}
-	return &STTClient{client: client}, nil
+	return &STTClient{client: client, rotation: defaultRotation}, nil
 }
 
-// StreamTranscribe streams audio data to Google Cloud Speech-to-Text and returns a channel of transcripts.
-func (s *STTClient) StreamTranscribe(ctx context.Context, audioStream io.Reader) (<-chan string, <-chan error) {
-	transcripts := make(chan string)
-	errs := make(chan error)
+// googleEncodings maps StreamConfig encodings to the Speech API enum.
+var googleEncodings = map[string]speechpb.RecognitionConfig_AudioEncoding{
+	EncodingLinear16: speechpb.RecognitionConfig_LINEAR16,
+	EncodingFLAC:     speechpb.RecognitionConfig_FLAC,
+	EncodingMulaw:    speechpb.RecognitionConfig_MULAW,
+	EncodingOggOpus:  speechpb.RecognitionConfig_OGG_OPUS,
+	EncodingWebmOpus: speechpb.RecognitionConfig_WEBM_OPUS,
+}
 
-	stream, err := s.client.StreamingRecognize(ctx)
-	if err != nil {
-		go func() { errs <- fmt.Errorf("failed to start streaming recognize: %w", err) }()
-		return transcripts, errs
+// SupportsEncoding reports whether Google is given encoding directly. Only
+// raw encodings are: streams can only be rotated past Google's per-stream
+// duration limit with a fixed byte rate, so FLAC and Opus are decoded to
+// LINEAR16 first (FLAC in-process, Opus with ffmpeg). Without a decoder they
+// are refused rather than cut off mid-encounter.
+func (s *STTClient) SupportsEncoding(encoding string) bool {
+	return StreamConfig{Encoding: encoding, Channels: 1}.FrameSize() > 0
+}
+
+// recognitionConfig converts a StreamConfig into a Speech API config.
+func recognitionConfig(cfg StreamConfig) *speechpb.RecognitionConfig {
+	cfg = cfg.WithDefaults()
+	rc := &speechpb.RecognitionConfig{
+		Encoding:          googleEncodings[cfg.Encoding],
+		SampleRateHertz:   int32(cfg.SampleRateHertz),
+		AudioChannelCount: int32(cfg.Channels),
+		LanguageCode:      cfg.LanguageCode,
+		Model:             cfg.Model,
+		UseEnhanced:       cfg.UseEnhanced,
+		Adaptation:        speechAdaptation(cfg.Vocabulary),
+
+		AlternativeLanguageCodes: cfg.AlternativeLanguageCodes,
+
+		EnableWordTimeOffsets: true,
+		EnableWordConfidence:  true,
+
+		EnableSeparateRecognitionPerChannel: cfg.Speakers.SeparateChannels,
+	}
+	if cfg.Speakers.Diarization {
+		rc.DiarizationConfig = &speechpb.SpeakerDiarizationConfig{
+			EnableSpeakerDiarization: true,
+			MinSpeakerCount:          int32(cfg.Speakers.MinSpeakers),
+			MaxSpeakerCount:          int32(cfg.Speakers.MaxSpeakers),
+		}
 	}
+	return rc
+}
 
-	// Send the initial configuration message.
-	if err := stream.Send(&speechpb.StreamingRecognizeRequest{
-		StreamingRequest: &speechpb.StreamingRecognizeRequest_StreamingConfig{
-			StreamingConfig: &speechpb.StreamingRecognitionConfig{
-				Config: &speechpb.RecognitionConfig{
-					Encoding:        speechpb.RecognitionConfig_LINEAR16,
-					SampleRateHertz: 16000,
-					LanguageCode:    "en-US",
-					Model:           "default",
-					UseEnhanced:     false,
-				},
-				InterimResults: true,
-			},
-		},
-	}); err != nil {
-		go func() { errs <- fmt.Errorf("failed to send config: %w", err) }()
-		return transcripts, errs
+// resultLanguage returns the language a result was recognized in.
+func resultLanguage(languageCode string) string {
+	if languageCode == "" {
+		return ""
 	}
+	return normalizeLanguageTag(languageCode)
+}
 
-	// Goroutine to send audio data
-	go func() {
-		defer stream.CloseSend()
-		buf := make([]byte, 4096)
-		for {
-			n, err := audioStream.Read(buf)
-			if n > 0 {
-				if err := stream.Send(&speechpb.StreamingRecognizeRequest{
-					StreamingRequest: &speechpb.StreamingRecognizeRequest_AudioContent{
-						AudioContent: buf[:n],
-					},
-				}); err != nil {
-					errs <- fmt.Errorf("failed to send audio: %w", err)
-					return
-				}
-			}
-			if err == io.EOF {
-				return
-			}
-			if err != nil {
-				errs <- fmt.Errorf("error reading audio stream: %w", err)
-				return
-			}
-		}
-	}()
-
-	// Goroutine to receive transcripts
-	go func() {
-		defer close(transcripts)
-		defer close(errs)
-		for {
-			resp, err := stream.Recv()
-			if err == io.EOF {
-				return
-			}
-			if err != nil {
-				errs <- fmt.Errorf("stream receive error: %w", err)
-				return
-			}
-			for _, result := range resp.Results {
-				if len(result.Alternatives) > 0 {
-					transcript := result.Alternatives[0].Transcript
-					if result.IsFinal {
-						log.Printf("Final Transcript: %s", transcript)
-					}
-					transcripts <- transcript
-				}
-			}
+// resultChannel returns the channel a result belongs to when channels are
+// recognized separately, and 0 otherwise.
+func resultChannel(cfg StreamConfig, channelTag int32) int {
+	if !cfg.Speakers.SeparateChannels {
+		return 0
+	}
+	return int(channelTag)
+}
+
+// resultWords converts the words of a recognition alternative, shifting
+// their offsets by offset (where the recognized audio starts within the
+// session or recording).
+func resultWords(alt *speechpb.SpeechRecognitionAlternative, offset time.Duration) []domain.Word {
+	if len(alt.Words) == 0 {
+		return nil
+	}
+	words := make([]domain.Word, len(alt.Words))
+	for i, w := range alt.Words {
+		words[i] = domain.Word{
+			Text:       w.Word,
+			StartMS:    (offset + w.StartTime.AsDuration()).Milliseconds(),
+			EndMS:      (offset + w.EndTime.AsDuration()).Milliseconds(),
+			Confidence: w.Confidence,
+			Speaker:    int(w.SpeakerTag),
 		}
-	}()
+	}
+	return words
+}
+
+// StreamTranscribe streams audio data to Google Cloud Speech-to-Text and returns a channel of transcripts.
+// The session transparently rolls over to fresh recognition streams before
+// Google's per-stream duration limit and across silence gaps.
+func (s *STTClient) StreamTranscribe(ctx context.Context, cfg StreamConfig, audioStream io.Reader) (<-chan TranscriptResult, <-chan error) {
+	rotator := newStreamRotator(cfg, s.rotation, func(ctx context.Context) (speechpb.Speech_StreamingRecognizeClient, error) {
+		return s.client.StreamingRecognize(ctx)
+	})
+	return rotator.run(ctx, audioStream)
+}
+
+// Recognize transcribes a short audio clip with a synchronous request.
+func (s *STTClient) Recognize(ctx context.Context, cfg StreamConfig, audio []byte) ([]TranscriptResult, error) {
+	resp, err := s.client.Recognize(ctx, &speechpb.RecognizeRequest{
+		Config: recognitionConfig(cfg),
+		Audio: &speechpb.RecognitionAudio{
+			AudioSource: &speechpb.RecognitionAudio_Content{Content: audio},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to recognize audio: %w", err)
+	}
+	return finalResults(cfg, resp.Results, 0), nil
+}
 
-	return transcripts, errs
+// Close closes the underlying speech client.
+func (s *STTClient) Close() error {
+	return s.client.Close()
 }
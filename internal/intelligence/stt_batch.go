@@ -0,0 +1,244 @@
+package intelligence
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"clinical-agent-backend/internal/domain"
+
+	speechpb "cloud.google.com/go/speech/apiv1/speechpb"
+)
+
+const (
+	// maxInlineAudioBytes stays just under Google's 10 MiB limit on inline
+	// audio content, leaving room for the rest of the request.
+	maxInlineAudioBytes = 10<<20 - 64<<10
+	// longRunningPollInterval is how often a long-running operation is polled.
+	longRunningPollInterval = 5 * time.Second
+	// splitSearchWindow is how far back from the size limit a chunk boundary
+	// may move to land on a quiet stretch of LINEAR16 audio.
+	splitSearchWindow = 10 * time.Second
+	// splitQuietWindow is the length of audio compared when looking for the
+	// quietest point to cut at.
+	splitQuietWindow = 100 * time.Millisecond
+)
+
+// ErrAudioTooLarge is returned for compressed audio above Google's inline
+// content limit. Retrying does not help; decoding the audio to LINEAR16
+// first, as TranscodingTranscriber does, lets it be split.
+var ErrAudioTooLarge = errors.New("compressed audio exceeds the request limit")
+
+// RecognizeLong transcribes a recording with Google's long-running
+// recognition, polling the operation until it completes. Raw audio above the
+// inline content limit is split into several operations, cut at quiet points
+// where possible; compressed audio above the limit fails with
+// ErrAudioTooLarge. Only one chunk of the recording is held in memory at a
+// time.
+func (s *STTClient) RecognizeLong(ctx context.Context, cfg StreamConfig, audio io.Reader) ([]TranscriptResult, error) {
+	chunks := newInlineChunker(cfg.WithDefaults(), audio, maxInlineAudioBytes)
+	var results []TranscriptResult
+	var done int64
+	for i := 1; ; i++ {
+		chunk, err := chunks.next()
+		if err == io.EOF {
+			return results, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d: %w", i, err)
+		}
+		// Word offsets are relative to the chunk; a recording that needs
+		// splitting is raw audio, whose duration follows from its size.
+		offset, _ := cfg.WithDefaults().Duration(done)
+		chunkResults, err := s.longRunningRecognize(ctx, cfg, chunk, offset)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d: %w", i, err)
+		}
+		results = append(results, chunkResults...)
+		done += int64(len(chunk))
+	}
+}
+
+// longRunningRecognize runs one long-running recognition operation to
+// completion. offset is where audio starts within the recording.
+func (s *STTClient) longRunningRecognize(ctx context.Context, cfg StreamConfig, audio []byte, offset time.Duration) ([]TranscriptResult, error) {
+	op, err := s.client.LongRunningRecognize(ctx, &speechpb.LongRunningRecognizeRequest{
+		Config: recognitionConfig(cfg),
+		Audio: &speechpb.RecognitionAudio{
+			AudioSource: &speechpb.RecognitionAudio_Content{Content: audio},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start long-running recognition: %w", err)
+	}
+
+	ticker := time.NewTicker(longRunningPollInterval)
+	defer ticker.Stop()
+	lastProgress := int32(-1)
+	for {
+		resp, err := op.Poll(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("long-running recognition %s failed: %w", op.Name(), err)
+		}
+		if op.Done() {
+			return finalResults(cfg, resp.GetResults(), offset), nil
+		}
+		if meta, err := op.Metadata(); err == nil && meta.GetProgressPercent() != lastProgress {
+			lastProgress = meta.GetProgressPercent()
+			log.Printf("Long-running recognition %s: %d%%", op.Name(), lastProgress)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// finalResults converts the top alternative of each batch recognition
+// result into a final TranscriptResult, with word offsets shifted by offset.
+//
+// With diarization, Google repeats every word of the request with its
+// speaker tag in a last result without a transcript; those tags are copied
+// onto the words of the earlier results and the repeat is dropped. Results
+// from separately recognized channels are put in order of time.
+func finalResults(cfg StreamConfig, results []*speechpb.SpeechRecognitionResult, offset time.Duration) []TranscriptResult {
+	var out []TranscriptResult
+	var speakers []domain.Word
+	for _, result := range results {
+		if len(result.Alternatives) == 0 {
+			continue
+		}
+		alt := result.Alternatives[0]
+		if strings.TrimSpace(alt.Transcript) == "" {
+			speakers = append(speakers, resultWords(alt, offset)...)
+			continue
+		}
+		out = append(out, TranscriptResult{
+			Text:         alt.Transcript,
+			IsFinal:      true,
+			Stability:    1,
+			Confidence:   alt.Confidence,
+			Words:        resultWords(alt, offset),
+			Channel:      resultChannel(cfg, result.ChannelTag),
+			LanguageCode: resultLanguage(result.LanguageCode),
+		})
+	}
+	applySpeakerTags(out, speakers)
+	if cfg.Speakers.SeparateChannels {
+		slices.SortStableFunc(out, func(a, b TranscriptResult) int {
+			return cmp.Compare(resultStart(a), resultStart(b))
+		})
+	}
+	return out
+}
+
+// applySpeakerTags copies the speaker of each word in tagged onto the
+// matching word (same text and start) of results. Both lists are in order.
+func applySpeakerTags(results []TranscriptResult, tagged []domain.Word) {
+	next := 0
+	for i := range results {
+		for j := range results[i].Words {
+			w := &results[i].Words[j]
+			for k := next; k < len(tagged); k++ {
+				if tagged[k].StartMS == w.StartMS && tagged[k].Text == w.Text {
+					w.Speaker = tagged[k].Speaker
+					next = k + 1
+					break
+				}
+			}
+		}
+	}
+}
+
+// resultStart returns where a result starts in milliseconds, or 0 if it has
+// no word timings.
+func resultStart(r TranscriptResult) int64 {
+	if len(r.Words) == 0 {
+		return 0
+	}
+	return r.Words[0].StartMS
+}
+
+// inlineChunker cuts audio read from a stream into chunks of at most limit
+// bytes. Cuts fall on frame boundaries; for LINEAR16 each cut moves to the
+// quietest point in the last few seconds before the limit so words are not
+// split.
+type inlineChunker struct {
+	cfg   StreamConfig
+	r     io.Reader
+	limit int
+	buf   []byte // audio read ahead, starting at the previous chunk
+	cut   int    // where the previous chunk ended in buf
+	done  bool
+}
+
+func newInlineChunker(cfg StreamConfig, r io.Reader, limit int) *inlineChunker {
+	return &inlineChunker{cfg: cfg, r: r, limit: limit}
+}
+
+// next returns the next chunk, or io.EOF after the last one. Even empty
+// audio yields one chunk. A chunk is only valid until the next call.
+func (c *inlineChunker) next() ([]byte, error) {
+	if c.done {
+		return nil, io.EOF
+	}
+	if c.buf == nil {
+		c.buf = make([]byte, 0, c.limit+1)
+	}
+	c.buf = c.buf[:copy(c.buf, c.buf[c.cut:])]
+	c.cut = 0
+
+	// One byte past the limit tells whether the audio goes on.
+	n, err := io.ReadFull(c.r, c.buf[len(c.buf):c.limit+1])
+	c.buf = c.buf[:len(c.buf)+n]
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	if len(c.buf) <= c.limit {
+		c.done = true
+		return c.buf, nil
+	}
+
+	frame := c.cfg.FrameSize()
+	if frame == 0 {
+		return nil, fmt.Errorf("%w: %s audio is over %d bytes", ErrAudioTooLarge, c.cfg.Encoding, c.limit)
+	}
+	end := c.limit - c.limit%frame
+	if c.cfg.Encoding == EncodingLinear16 {
+		bytesPerSecond := frame * c.cfg.SampleRateHertz
+		from := end - int(splitSearchWindow.Seconds()*float64(bytesPerSecond))
+		end = quietestCut(c.buf, frame, max(from, end/2), end, int(splitQuietWindow.Seconds()*float64(bytesPerSecond)))
+	}
+	c.cut = end
+	return c.buf[:end], nil
+}
+
+// quietestCut returns a frame-aligned offset in [from, to] in the middle of
+// the window-byte stretch of 16-bit PCM with the least energy.
+func quietestCut(pcm []byte, frame, from, to, window int) int {
+	from -= from % frame
+	window -= window % frame
+	if window <= 0 || to-from < window {
+		return to
+	}
+	best, bestEnergy := to, int64(-1)
+	for end := to; end-window >= from; end -= window {
+		var energy int64
+		for i := end - window; i+1 < end; i += 2 {
+			v := int64(int16(binary.LittleEndian.Uint16(pcm[i:])))
+			energy += v * v
+		}
+		if bestEnergy < 0 || energy < bestEnergy {
+			best, bestEnergy = end-(window/2-window/2%frame), energy
+		}
+	}
+	return best
+}
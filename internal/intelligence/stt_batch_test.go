@@ -0,0 +1,191 @@
+package intelligence
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"clinical-agent-backend/internal/domain"
+
+	speechpb "cloud.google.com/go/speech/apiv1/speechpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func word(text string, start, end time.Duration, speaker int32) *speechpb.WordInfo {
+	return &speechpb.WordInfo{Word: text, StartTime: durationpb.New(start), EndTime: durationpb.New(end), SpeakerTag: speaker}
+}
+
+func TestFinalResults_SpeakerTags(t *testing.T) {
+	cfg := StreamConfig{Speakers: domain.SpeakerConfig{Diarization: true}}
+	results := []*speechpb.SpeechRecognitionResult{
+		{Alternatives: []*speechpb.SpeechRecognitionAlternative{{Transcript: "any fever", Words: []*speechpb.WordInfo{
+			word("any", 0, 200*time.Millisecond, 0), word("fever", 200*time.Millisecond, 600*time.Millisecond, 0),
+		}}}},
+		{Alternatives: []*speechpb.SpeechRecognitionAlternative{{Transcript: "no", Words: []*speechpb.WordInfo{
+			word("no", time.Second, 1200*time.Millisecond, 0),
+		}}}},
+		// The diarization summary repeats every word with its speaker.
+		{Alternatives: []*speechpb.SpeechRecognitionAlternative{{Words: []*speechpb.WordInfo{
+			word("any", 0, 200*time.Millisecond, 1), word("fever", 200*time.Millisecond, 600*time.Millisecond, 1),
+			word("no", time.Second, 1200*time.Millisecond, 2),
+		}}}},
+	}
+
+	out := finalResults(cfg, results, time.Second)
+	if len(out) != 2 {
+		t.Fatalf("expected the summary to be dropped, got %d results", len(out))
+	}
+	if w := out[0].Words[1]; w.Speaker != 1 || w.StartMS != 1200 {
+		t.Errorf("unexpected first result word: %+v", w)
+	}
+	if w := out[1].Words[0]; w.Speaker != 2 {
+		t.Errorf("unexpected second result word: %+v", w)
+	}
+}
+
+func TestFinalResults_SeparateChannels(t *testing.T) {
+	cfg := StreamConfig{Channels: 2, Speakers: domain.SpeakerConfig{SeparateChannels: true}}
+	results := []*speechpb.SpeechRecognitionResult{
+		{ChannelTag: 1, Alternatives: []*speechpb.SpeechRecognitionAlternative{{Transcript: "any fever", Words: []*speechpb.WordInfo{
+			word("any", 0, 200*time.Millisecond, 0),
+		}}}},
+		{ChannelTag: 1, Alternatives: []*speechpb.SpeechRecognitionAlternative{{Transcript: "since when", Words: []*speechpb.WordInfo{
+			word("since", 3*time.Second, 4*time.Second, 0),
+		}}}},
+		{ChannelTag: 2, Alternatives: []*speechpb.SpeechRecognitionAlternative{{Transcript: "a little", Words: []*speechpb.WordInfo{
+			word("a", time.Second, 2*time.Second, 0),
+		}}}},
+	}
+
+	var order []string
+	for _, r := range finalResults(cfg, results, 0) {
+		order = append(order, fmt.Sprintf("%d:%s", r.Channel, r.Text))
+	}
+	if want := "1:any fever 2:a little 1:since when"; strings.Join(order, " ") != want {
+		t.Errorf("expected channels interleaved by time (%s), got %v", want, order)
+	}
+}
+
+// inlineChunks reads all of audio's chunks, copying each.
+func inlineChunks(cfg StreamConfig, audio []byte, limit int) ([][]byte, error) {
+	c := newInlineChunker(cfg, bytes.NewReader(audio), limit)
+	var chunks [][]byte
+	for {
+		chunk, err := c.next()
+		if err == io.EOF {
+			return chunks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, bytes.Clone(chunk))
+	}
+}
+
+func TestInlineChunker_CutsAtQuietPoint(t *testing.T) {
+	cfg := StreamConfig{Encoding: EncodingLinear16, SampleRateHertz: 1000, Channels: 1}
+	// Five seconds of loud audio with 200ms of silence starting at 3s.
+	audio := bytes.Repeat([]byte{0x10, 0x27}, 5*1000)
+	copy(audio[3000*2:], make([]byte, 200*2))
+
+	chunks, err := inlineChunks(cfg, audio, 4000*2)
+	if err != nil {
+		t.Fatalf("inlineChunker failed: %v", err)
+	}
+	if len(chunks) != 2 {
+		t.Fatalf("expected 2 chunks, got %d", len(chunks))
+	}
+	if cut := len(chunks[0]); cut < 3000*2 || cut > 3200*2 || cut%2 != 0 {
+		t.Errorf("expected a frame-aligned cut inside the silence, got byte %d", cut)
+	}
+	if !bytes.Equal(append(chunks[0], chunks[1]...), audio) {
+		t.Error("chunks do not reassemble into the original audio")
+	}
+}
+
+func TestInlineChunker_MuLawAndCompressed(t *testing.T) {
+	mulaw := StreamConfig{Encoding: EncodingMulaw, SampleRateHertz: 8000, Channels: 2}
+	chunks, err := inlineChunks(mulaw, make([]byte, 25), 10)
+	if err != nil {
+		t.Fatalf("inlineChunker failed: %v", err)
+	}
+	if len(chunks) != 3 || len(chunks[0]) != 10 || len(chunks[1]) != 10 || len(chunks[2]) != 5 {
+		t.Errorf("unexpected chunk sizes: %d chunks", len(chunks))
+	}
+
+	opus := StreamConfig{Encoding: EncodingOggOpus, SampleRateHertz: 48000, Channels: 1}
+	if _, err := inlineChunks(opus, make([]byte, 11), 10); !errors.Is(err, ErrAudioTooLarge) || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("expected size error for compressed audio, got %v", err)
+	}
+}
+
+func TestStreamConfig_ValidateLanguages(t *testing.T) {
+	tests := []struct {
+		alternatives []string
+		ok           bool
+	}{
+		{[]string{"es-US", "fr"}, true},
+		{[]string{"es-US", "fr", "de", "it"}, false},
+		{[]string{"es_US"}, false},
+		{[]string{"EN-us"}, false},
+		{[]string{"es-US", "es-us"}, false},
+	}
+	for _, tt := range tests {
+		cfg := DefaultStreamConfig()
+		cfg.AlternativeLanguageCodes = tt.alternatives
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%v: expected ok=%v, got %v", tt.alternatives, tt.ok, err)
+		}
+	}
+	if got := normalizeLanguageTag("es-us"); got != "es-US" {
+		t.Errorf("expected es-US, got %q", got)
+	}
+}
+
+func TestStreamConfig_ValidateModel(t *testing.T) {
+	tests := []struct {
+		model        string
+		language     string
+		alternatives []string
+		enhanced     bool
+		ok           bool
+	}{
+		{ModelMedicalConversation, "en-US", nil, false, true},
+		{ModelMedicalDictation, "es-US", nil, false, false},
+		{ModelMedicalConversation, "en-US", []string{"es-US"}, false, false},
+		{"phone_call", "es-US", nil, true, true},
+		{ModelDefault, "en-US", nil, true, false},
+		{"medical", "en-US", nil, false, false},
+	}
+	for _, tt := range tests {
+		cfg := DefaultStreamConfig()
+		cfg.Model, cfg.LanguageCode, cfg.AlternativeLanguageCodes, cfg.UseEnhanced = tt.model, tt.language, tt.alternatives, tt.enhanced
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%+v: expected ok=%v, got %v", tt, tt.ok, err)
+		}
+	}
+}
+
+func TestStreamConfig_Duration(t *testing.T) {
+	tests := []struct {
+		cfg  StreamConfig
+		n    int64
+		want time.Duration
+		ok   bool
+	}{
+		{StreamConfig{Encoding: EncodingLinear16, SampleRateHertz: 16000, Channels: 1}, 32000, time.Second, true},
+		{StreamConfig{Encoding: EncodingLinear16, SampleRateHertz: 8000, Channels: 2}, 16000, 500 * time.Millisecond, true},
+		{StreamConfig{Encoding: EncodingMulaw, SampleRateHertz: 8000, Channels: 1}, 80000, 10 * time.Second, true},
+		{StreamConfig{Encoding: EncodingFLAC, SampleRateHertz: 16000, Channels: 1}, 32000, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := tt.cfg.Duration(tt.n)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%+v.Duration(%d) = %v, %v; want %v, %v", tt.cfg, tt.n, got, ok, tt.want, tt.ok)
+		}
+	}
+}
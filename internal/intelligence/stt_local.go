@@ -0,0 +1,150 @@
+package intelligence
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// localBytesPerPhrase is one second of 16 kHz, 16-bit mono PCM.
+const localBytesPerPhrase = 32000
+
+// LocalTranscriber is a deterministic, offline Transcriber intended for
+// development and tests. It never inspects the audio itself: every
+// BytesPerPhrase bytes of input yields the next phrase of Script, so the
+// same input always produces the same transcript.
+type LocalTranscriber struct {
+	Script         []string
+	BytesPerPhrase int
+}
+
+// NewLocalTranscriber creates a LocalTranscriber that emits the given phrases.
+// With no phrases it emits a placeholder describing each audio segment.
+func NewLocalTranscriber(script []string) *LocalTranscriber {
+	return &LocalTranscriber{Script: script, BytesPerPhrase: localBytesPerPhrase}
+}
+
+// NewLocalTranscriberFromEnv creates a LocalTranscriber whose script is read
+// from the file named by STT_LOCAL_SCRIPT (one phrase per line), if set.
+func NewLocalTranscriberFromEnv() (*LocalTranscriber, error) {
+	path := os.Getenv("STT_LOCAL_SCRIPT")
+	if path == "" {
+		return NewLocalTranscriber(nil), nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open local STT script: %w", err)
+	}
+	defer f.Close()
+
+	var script []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			script = append(script, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read local STT script: %w", err)
+	}
+	return NewLocalTranscriber(script), nil
+}
+
+// phrase returns the transcript emitted for the n-th audio segment.
+func (l *LocalTranscriber) phrase(n int) string {
+	if len(l.Script) == 0 {
+		return fmt.Sprintf("[audio segment %d]", n+1)
+	}
+	return l.Script[n%len(l.Script)]
+}
+
+func (l *LocalTranscriber) bytesPerPhrase() int {
+	if l.BytesPerPhrase <= 0 {
+		return localBytesPerPhrase
+	}
+	return l.BytesPerPhrase
+}
+
+// StreamTranscribe emits one final result for every BytesPerPhrase bytes
+// read, plus one for any trailing partial segment. The config is ignored.
+func (l *LocalTranscriber) StreamTranscribe(ctx context.Context, cfg StreamConfig, audioStream io.Reader) (<-chan TranscriptResult, <-chan error) {
+	transcripts := make(chan TranscriptResult)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(transcripts)
+
+		emit := func(n int) bool {
+			select {
+			case transcripts <- TranscriptResult{Text: l.phrase(n), IsFinal: true, Stability: 1}:
+				return true
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return false
+			}
+		}
+
+		size := l.bytesPerPhrase()
+		buf := make([]byte, 4096)
+		pending, segment := 0, 0
+		for {
+			n, err := audioStream.Read(buf)
+			pending += n
+			for pending >= size {
+				if !emit(segment) {
+					return
+				}
+				pending -= size
+				segment++
+			}
+			if err == io.EOF {
+				if pending > 0 {
+					emit(segment)
+				}
+				return
+			}
+			if err != nil {
+				errs <- fmt.Errorf("error reading audio stream: %w", err)
+				return
+			}
+		}
+	}()
+
+	return transcripts, errs
+}
+
+// Recognize returns one final result per BytesPerPhrase bytes of the clip,
+// plus one for any trailing partial segment.
+func (l *LocalTranscriber) Recognize(ctx context.Context, cfg StreamConfig, audio []byte) ([]TranscriptResult, error) {
+	return l.finalResults(int64(len(audio))), nil
+}
+
+// RecognizeLong behaves like Recognize; the local provider has no length
+// limits.
+func (l *LocalTranscriber) RecognizeLong(ctx context.Context, cfg StreamConfig, audio io.Reader) ([]TranscriptResult, error) {
+	n, err := io.Copy(io.Discard, audio)
+	if err != nil {
+		return nil, fmt.Errorf("error reading audio: %w", err)
+	}
+	return l.finalResults(n), nil
+}
+
+// finalResults returns the results for n bytes of audio.
+func (l *LocalTranscriber) finalResults(n int64) []TranscriptResult {
+	size := int64(l.bytesPerPhrase())
+	segments := (n + size - 1) / size
+	results := make([]TranscriptResult, 0, segments)
+	for i := 0; i < int(segments); i++ {
+		results = append(results, TranscriptResult{Text: l.phrase(i), IsFinal: true, Stability: 1})
+	}
+	return results
+}
+
+// Close is a no-op for the local provider.
+func (l *LocalTranscriber) Close() error {
+	return nil
+}
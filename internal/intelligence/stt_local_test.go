@@ -0,0 +1,71 @@
+package intelligence
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"clinical-agent-backend/internal/domain"
+)
+
+func TestLocalTranscriber_StreamTranscribe(t *testing.T) {
+	stt := NewLocalTranscriber([]string{"patient reports headache", "no fever"})
+	stt.BytesPerPhrase = 100
+
+	// 250 bytes: two full segments plus a trailing partial one.
+	transcripts, errs := stt.StreamTranscribe(context.Background(), DefaultStreamConfig(), bytes.NewReader(make([]byte, 250)))
+
+	var got []string
+	for result := range transcripts {
+		if !result.IsFinal {
+			t.Errorf("expected only final results, got %+v", result)
+		}
+		got = append(got, result.Text)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{"patient reports headache", "no fever", "patient reports headache"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d transcripts, got %d: %v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("transcript %d: expected %q, got %q", i, want[i], got[i])
+		}
+	}
+}
+
+func TestLocalTranscriber_Recognize(t *testing.T) {
+	stt := NewLocalTranscriber(nil)
+
+	results, err := stt.Recognize(context.Background(), DefaultStreamConfig(), make([]byte, localBytesPerPhrase+1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if text := domain.TranscriptText(AssembleTranscript(results, DefaultStreamConfig())); text != "[audio segment 1] [audio segment 2]" {
+		t.Errorf("unexpected transcript: %q", text)
+	}
+}
+
+func TestNewTranscriber_UnknownProvider(t *testing.T) {
+	if _, err := NewTranscriber(context.Background(), "does-not-exist"); err == nil {
+		t.Fatal("expected error for unknown provider")
+	}
+}
+
+func TestRegisterTranscriber(t *testing.T) {
+	want := NewLocalTranscriber([]string{"registered"})
+	RegisterTranscriber("test-provider", func(ctx context.Context) (Transcriber, error) {
+		return want, nil
+	})
+
+	got, err := NewTranscriber(context.Background(), "test-provider")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != want {
+		t.Errorf("expected registered transcriber to be returned")
+	}
+}
@@ -0,0 +1,494 @@
+package intelligence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	speechpb "cloud.google.com/go/speech/apiv1/speechpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// rotationConfig controls when a long-running session moves to a fresh
+// recognition stream. Google caps a streaming call at about five minutes
+// and aborts it after roughly ten seconds without audio.
+type rotationConfig struct {
+	// softLimit rotates after the next final result once a stream is this old.
+	softLimit time.Duration
+	// hardLimit rotates immediately, mid-utterance if necessary.
+	hardLimit time.Duration
+	// idleTimeout half-closes the stream when no audio has arrived for this
+	// long; the next audio frame opens a new one.
+	idleTimeout time.Duration
+	// maxCarryOver bounds how much unfinalized audio is replayed into the new
+	// stream. Beyond it, the latest interim result is promoted to final and
+	// only the audio after it is replayed.
+	maxCarryOver time.Duration
+	// maxRetries is how many retryable stream failures in a row are absorbed
+	// before the session fails.
+	maxRetries int
+	// tick is how often stream age and idleness are checked.
+	tick time.Duration
+}
+
+var defaultRotation = rotationConfig{
+	softLimit:    4 * time.Minute,
+	hardLimit:    4*time.Minute + 45*time.Second,
+	idleTimeout:  8 * time.Second,
+	maxCarryOver: 30 * time.Second,
+	maxRetries:   3,
+	tick:         500 * time.Millisecond,
+}
+
+// carryOverChunkSize is the size of audio requests used to replay carry-over.
+const carryOverChunkSize = 8192
+
+// streamOpener starts a new bidirectional recognition call.
+type streamOpener func(ctx context.Context) (speechpb.Speech_StreamingRecognizeClient, error)
+
+// streamRotator runs one logical transcription session over as many
+// provider streams as needed, so a session is not bound by provider
+// duration limits or silence timeouts.
+//
+// Audio sent to the current stream is kept until a final result covers it.
+// On rotation the old stream is abandoned and the unfinalized audio is
+// replayed into the new one, so no words are lost, and finalized audio is
+// never replayed, so no words are duplicated. Rotation needs byte offsets,
+// so it is only possible for raw LINEAR16 and MULAW audio; other encodings
+// use a single stream.
+type streamRotator struct {
+	cfg      StreamConfig
+	rotation rotationConfig
+	open     streamOpener
+
+	bytesPerSecond int64
+	frameSize      int64
+}
+
+func newStreamRotator(cfg StreamConfig, rotation rotationConfig, open streamOpener) *streamRotator {
+	cfg = cfg.WithDefaults()
+	r := &streamRotator{cfg: cfg, rotation: rotation, open: open}
+	r.frameSize = int64(cfg.FrameSize())
+	r.bytesPerSecond = r.frameSize * int64(cfg.SampleRateHertz)
+	return r
+}
+
+// canRotate reports whether audio offsets can be computed for the encoding.
+func (r *streamRotator) canRotate() bool {
+	return r.bytesPerSecond > 0
+}
+
+// bytesFor converts a stream offset into a whole number of audio frames.
+func (r *streamRotator) bytesFor(d time.Duration) int64 {
+	if !r.canRotate() {
+		return 0
+	}
+	n := int64(d.Seconds() * float64(r.bytesPerSecond))
+	return n - n%r.frameSize
+}
+
+func (r *streamRotator) durationOf(n int64) time.Duration {
+	if !r.canRotate() {
+		return 0
+	}
+	return time.Duration(float64(n) / float64(r.bytesPerSecond) * float64(time.Second))
+}
+
+// recognitionStream is one provider call within the session.
+type recognitionStream struct {
+	gen      int
+	client   speechpb.Speech_StreamingRecognizeClient
+	cancel   context.CancelFunc
+	openedAt time.Time
+
+	// startByte is the session offset of the first audio byte sent on this
+	// stream; result offsets are relative to it.
+	startByte int64
+	// audio holds the bytes sent on this stream from audioBase on that are
+	// not yet covered by a final result.
+	audio     []byte
+	audioBase int64
+	// keepAudio is false when offsets are unknown and audio cannot be replayed.
+	keepAudio bool
+	// interim is the latest non-final result and interimEnd its session offset.
+	interim    *TranscriptResult
+	interimEnd int64
+	// channelEnds holds the session offset each channel is finalized to
+	// when channels are recognized separately. Audio is only dropped once
+	// every channel has finalized it.
+	channelEnds map[int]int64
+}
+
+// streamEvent is a response, or the terminal error (io.EOF on a clean end),
+// from the stream with the given generation.
+type streamEvent struct {
+	gen  int
+	resp *speechpb.StreamingRecognizeResponse
+	err  error
+}
+
+// isRetryable reports whether a stream failure should rotate rather than
+// end the session: audio timeouts, duration limits and transient outages.
+func isRetryable(err error) bool {
+	switch status.Code(err) {
+	case codes.OutOfRange, codes.DeadlineExceeded, codes.Unavailable, codes.Aborted:
+		return true
+	}
+	return false
+}
+
+// run starts the session and returns its result and error channels with the
+// same contract as Transcriber.StreamTranscribe.
+func (r *streamRotator) run(ctx context.Context, audioStream io.Reader) (<-chan TranscriptResult, <-chan error) {
+	results := make(chan TranscriptResult)
+	errs := make(chan error, 1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	audioCh := make(chan []byte)
+	readErr := make(chan error, 1)
+
+	// Goroutine to read audio data
+	go func() {
+		defer close(audioCh)
+		for {
+			buf := make([]byte, 4096)
+			n, err := audioStream.Read(buf)
+			if n > 0 {
+				select {
+				case audioCh <- buf[:n]:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				readErr <- fmt.Errorf("error reading audio stream: %w", err)
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer close(errs)
+		defer close(results)
+		defer cancel()
+		if err := r.loop(ctx, audioCh, results); err != nil {
+			errs <- err
+			return
+		}
+		select {
+		case err := <-readErr:
+			errs <- err
+		default:
+		}
+	}()
+
+	return results, errs
+}
+
+// loop multiplexes audio, stream responses and rotation timers until all
+// audio has been recognized or the session fails.
+func (r *streamRotator) loop(ctx context.Context, audioCh <-chan []byte, results chan<- TranscriptResult) error {
+	events := make(chan streamEvent)
+	streams := make(map[int]*recognitionStream)
+	pending := 0 // streams whose receive goroutine has not yet reported its end
+	nextGen := 0
+
+	var cur *recognitionStream
+	var total int64 // session bytes received so far
+	lastAudio := time.Now()
+	retries := 0
+
+	defer func() {
+		for _, st := range streams {
+			st.cancel()
+		}
+	}()
+
+	emit := func(result TranscriptResult) error {
+		select {
+		case results <- result:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	open := func(carry []byte, startByte int64) (*recognitionStream, error) {
+		streamCtx, cancel := context.WithCancel(ctx)
+		client, err := r.open(streamCtx)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to start streaming recognize: %w", err)
+		}
+		// Send the initial configuration message.
+		if err := client.Send(&speechpb.StreamingRecognizeRequest{
+			StreamingRequest: &speechpb.StreamingRecognizeRequest_StreamingConfig{
+				StreamingConfig: &speechpb.StreamingRecognitionConfig{
+					Config:         recognitionConfig(r.cfg),
+					InterimResults: true,
+				},
+			},
+		}); err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to send config: %w", err)
+		}
+
+		st := &recognitionStream{
+			gen:       nextGen,
+			client:    client,
+			cancel:    cancel,
+			openedAt:  time.Now(),
+			startByte: startByte,
+			audioBase: startByte,
+			keepAudio: r.canRotate(),
+		}
+		nextGen++
+		streams[st.gen] = st
+		pending++
+
+		// Goroutine to receive transcripts
+		go func() {
+			for {
+				resp, err := client.Recv()
+				select {
+				case events <- streamEvent{gen: st.gen, resp: resp, err: err}:
+				case <-ctx.Done():
+					return
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+
+		for off := 0; off < len(carry); off += carryOverChunkSize {
+			end := min(off+carryOverChunkSize, len(carry))
+			st.send(carry[off:end])
+		}
+		return st, nil
+	}
+
+	// rotate abandons st and replays its unfinalized audio into a new stream.
+	rotate := func(st *recognitionStream, why string) (*recognitionStream, error) {
+		st.cancel()
+		delete(streams, st.gen)
+
+		carry, carryStart := st.audio, st.audioBase
+		if r.durationOf(int64(len(carry))) > r.rotation.maxCarryOver && st.interim != nil && st.interimEnd > st.audioBase {
+			// Too much to replay: keep what the old stream heard so far.
+			promoted := *st.interim
+			promoted.IsFinal, promoted.Stability = true, 1
+			if err := emit(promoted); err != nil {
+				return nil, err
+			}
+			skip := min(st.interimEnd-st.audioBase, int64(len(carry)))
+			carry, carryStart = carry[skip:], st.audioBase+skip
+		}
+
+		log.Printf("Rotating STT stream (%s) after %s, replaying %s of audio",
+			why, time.Since(st.openedAt).Round(time.Second), r.durationOf(int64(len(carry))).Round(time.Millisecond))
+		return open(carry, carryStart)
+	}
+
+	ticker := time.NewTicker(r.rotation.tick)
+	defer ticker.Stop()
+
+	for {
+		if audioCh == nil && pending == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case chunk, ok := <-audioCh:
+			if !ok {
+				// End of input: let the current stream finalize what it has.
+				audioCh = nil
+				if cur != nil {
+					cur.client.CloseSend()
+					cur = nil
+				}
+				continue
+			}
+			lastAudio = time.Now()
+			if cur == nil {
+				st, err := open(nil, total)
+				if err != nil {
+					return err
+				}
+				cur = st
+			}
+			cur.send(chunk)
+			total += int64(len(chunk))
+
+		case ev := <-events:
+			st, live := streams[ev.gen]
+			if ev.err != nil {
+				pending--
+				if !live {
+					continue // abandoned by a rotation
+				}
+				if ev.err != io.EOF && r.canRotate() && isRetryable(ev.err) && retries < r.rotation.maxRetries {
+					retries++
+					log.Printf("STT stream failed (%v); reopening (attempt %d/%d)", ev.err, retries, r.rotation.maxRetries)
+					next, err := rotate(st, "stream error")
+					if err != nil {
+						return err
+					}
+					if st == cur {
+						cur = next
+					} else {
+						// The failed stream was draining; the replacement only
+						// needs to finish the replayed audio.
+						next.client.CloseSend()
+					}
+					continue
+				}
+				delete(streams, ev.gen)
+				st.cancel()
+				if ev.err == io.EOF {
+					if st == cur {
+						cur = nil
+					}
+					continue
+				}
+				return fmt.Errorf("stream receive error: %w", ev.err)
+			}
+			if !live {
+				continue
+			}
+
+			// A response holds any final results followed by the interim
+			// results for the rest of the audio, most stable first.
+			// Together the interim pieces form a single hypothesis.
+			gotFinal := false
+			var interim *TranscriptResult
+			var interimEnd int64
+			for _, result := range ev.resp.Results {
+				if len(result.Alternatives) == 0 {
+					continue
+				}
+				retries = 0
+				alt := result.Alternatives[0]
+				text := strings.TrimSpace(alt.Transcript)
+				end := st.startByte + r.bytesFor(result.ResultEndTime.AsDuration())
+				if !result.IsFinal {
+					if interim == nil {
+						interim = &TranscriptResult{Stability: 1}
+					}
+					interim.Text = strings.TrimSpace(interim.Text + " " + text)
+					interim.Stability = min(interim.Stability, result.Stability)
+					interimEnd = max(interimEnd, end)
+					continue
+				}
+				log.Printf("Final Transcript: %s", text)
+				channel := resultChannel(r.cfg, result.ChannelTag)
+				st.finalize(r.finalizedEnd(st, channel, end))
+				gotFinal = true
+				final := TranscriptResult{
+					Text:       text,
+					IsFinal:    true,
+					Stability:  1,
+					Confidence: alt.Confidence,
+					// Word offsets count from the start of this stream.
+					Words:        resultWords(alt, r.durationOf(st.startByte)),
+					Channel:      channel,
+					LanguageCode: resultLanguage(result.LanguageCode),
+				}
+				if err := emit(final); err != nil {
+					return err
+				}
+			}
+			if interim != nil {
+				st.interim, st.interimEnd = interim, interimEnd
+				if err := emit(*interim); err != nil {
+					return err
+				}
+			}
+
+			if gotFinal && st == cur && r.canRotate() && time.Since(st.openedAt) >= r.rotation.softLimit {
+				next, err := rotate(st, "duration limit")
+				if err != nil {
+					return err
+				}
+				cur = next
+			}
+
+		case <-ticker.C:
+			if cur == nil || !r.canRotate() {
+				continue
+			}
+			if idle := time.Since(lastAudio); idle >= r.rotation.idleTimeout {
+				log.Printf("No audio for %s, closing STT stream until audio resumes", idle.Round(time.Second))
+				cur.client.CloseSend()
+				cur = nil
+				continue
+			}
+			if time.Since(cur.openedAt) >= r.rotation.hardLimit {
+				next, err := rotate(cur, "hard duration limit")
+				if err != nil {
+					return err
+				}
+				cur = next
+			}
+		}
+	}
+}
+
+// finalizedEnd records that channel of st is finalized up to the session
+// offset end and returns how far all of the stream's audio is finalized.
+// Channel 0 means channels are not recognized separately.
+func (r *streamRotator) finalizedEnd(st *recognitionStream, channel int, end int64) int64 {
+	if channel == 0 {
+		return end
+	}
+	if st.channelEnds == nil {
+		st.channelEnds = make(map[int]int64)
+	}
+	st.channelEnds[channel] = max(st.channelEnds[channel], end)
+	done := end
+	for c := 1; c <= r.cfg.Channels; c++ {
+		e, ok := st.channelEnds[c]
+		if !ok {
+			return st.audioBase
+		}
+		done = min(done, e)
+	}
+	return done
+}
+
+// send forwards audio to the provider and keeps it for carry-over. Send
+// errors are not reported here: gRPC surfaces them from Recv.
+func (st *recognitionStream) send(chunk []byte) {
+	if err := st.client.Send(&speechpb.StreamingRecognizeRequest{
+		StreamingRequest: &speechpb.StreamingRecognizeRequest_AudioContent{
+			AudioContent: chunk,
+		},
+	}); err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("Failed to send audio to STT stream: %v", err)
+	}
+	if st.keepAudio {
+		st.audio = append(st.audio, chunk...)
+	}
+}
+
+// finalize drops audio up to the session offset end, which a final result
+// has covered.
+func (st *recognitionStream) finalize(end int64) {
+	if end > st.audioBase {
+		drop := min(end-st.audioBase, int64(len(st.audio)))
+		st.audio = append([]byte(nil), st.audio[drop:]...)
+		st.audioBase += drop
+	}
+	st.interim = nil
+}
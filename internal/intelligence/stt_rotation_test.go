@@ -0,0 +1,371 @@
+package intelligence
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"clinical-agent-backend/internal/domain"
+
+	speechpb "cloud.google.com/go/speech/apiv1/speechpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// fakeRecognizeStream is a scripted stand-in for a Google streaming call.
+type fakeRecognizeStream struct {
+	grpc.ClientStream
+
+	ctx       context.Context
+	responses chan *speechpb.StreamingRecognizeResponse
+	failures  chan error
+	halfClose chan struct{}
+
+	mu     sync.Mutex
+	audio  []byte
+	closed bool
+}
+
+func (f *fakeRecognizeStream) Send(req *speechpb.StreamingRecognizeRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.audio = append(f.audio, req.GetAudioContent()...)
+	return nil
+}
+
+func (f *fakeRecognizeStream) Recv() (*speechpb.StreamingRecognizeResponse, error) {
+	select {
+	case resp := <-f.responses:
+		return resp, nil
+	case err := <-f.failures:
+		return nil, err
+	case <-f.halfClose:
+		return nil, io.EOF
+	case <-f.ctx.Done():
+		return nil, f.ctx.Err()
+	}
+}
+
+func (f *fakeRecognizeStream) CloseSend() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.closed {
+		f.closed = true
+		close(f.halfClose)
+	}
+	return nil
+}
+
+func (f *fakeRecognizeStream) received() []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]byte(nil), f.audio...)
+}
+
+func (f *fakeRecognizeStream) isClosed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
+
+// fakeSpeech opens fakeRecognizeStreams and records them in order.
+type fakeSpeech struct {
+	mu      sync.Mutex
+	streams []*fakeRecognizeStream
+}
+
+func (p *fakeSpeech) open(ctx context.Context) (speechpb.Speech_StreamingRecognizeClient, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st := &fakeRecognizeStream{
+		ctx:       ctx,
+		responses: make(chan *speechpb.StreamingRecognizeResponse),
+		failures:  make(chan error, 1),
+		halfClose: make(chan struct{}),
+	}
+	p.streams = append(p.streams, st)
+	return st, nil
+}
+
+// waitForStream waits until the n-th stream (0-based) has been opened.
+func (p *fakeSpeech) waitForStream(t *testing.T, n int) *fakeRecognizeStream {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		p.mu.Lock()
+		if len(p.streams) > n {
+			st := p.streams[n]
+			p.mu.Unlock()
+			return st
+		}
+		p.mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for stream %d", n)
+	return nil
+}
+
+// waitForAudio waits until st has received n bytes of audio.
+func waitForAudio(t *testing.T, st *fakeRecognizeStream, n int) []byte {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if audio := st.received(); len(audio) >= n {
+			return audio
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d bytes, got %d", n, len(st.received()))
+	return nil
+}
+
+func finalResponse(text string, end time.Duration) *speechpb.StreamingRecognizeResponse {
+	return &speechpb.StreamingRecognizeResponse{Results: []*speechpb.StreamingRecognitionResult{{
+		Alternatives:  []*speechpb.SpeechRecognitionAlternative{{Transcript: text}},
+		IsFinal:       true,
+		ResultEndTime: durationpb.New(end),
+	}}}
+}
+
+// pcm returns n bytes where each byte encodes its session offset.
+func pcm(offset, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte((offset + i) / 2)
+	}
+	return b
+}
+
+func testRotation() rotationConfig {
+	return rotationConfig{
+		softLimit:    time.Hour,
+		hardLimit:    time.Hour,
+		idleTimeout:  time.Hour,
+		maxCarryOver: time.Minute,
+		maxRetries:   3,
+		tick:         5 * time.Millisecond,
+	}
+}
+
+type rotationHarness struct {
+	speech  *fakeSpeech
+	audio   *io.PipeWriter
+	results <-chan TranscriptResult
+	errs    <-chan error
+	finals  chan TranscriptResult
+}
+
+func startRotation(t *testing.T, rotation rotationConfig) *rotationHarness {
+	t.Helper()
+	speech := &fakeSpeech{}
+	pr, pw := io.Pipe()
+	rotator := newStreamRotator(DefaultStreamConfig(), rotation, speech.open)
+	results, errs := rotator.run(context.Background(), pr)
+
+	h := &rotationHarness{speech: speech, audio: pw, results: results, errs: errs, finals: make(chan TranscriptResult, 16)}
+	go func() {
+		for result := range results {
+			if result.IsFinal {
+				h.finals <- result
+			}
+		}
+		close(h.finals)
+	}()
+	return h
+}
+
+// finish ends the audio and returns the text of every final result.
+func (h *rotationHarness) finish(t *testing.T) []string {
+	t.Helper()
+	var texts []string
+	for _, result := range h.finishResults(t) {
+		texts = append(texts, result.Text)
+	}
+	return texts
+}
+
+// finishResults ends the audio and returns every final result.
+func (h *rotationHarness) finishResults(t *testing.T) []TranscriptResult {
+	t.Helper()
+	h.audio.Close()
+	var finals []TranscriptResult
+	for result := range h.finals {
+		finals = append(finals, result)
+	}
+	if err := <-h.errs; err != nil {
+		t.Fatalf("unexpected session error: %v", err)
+	}
+	return finals
+}
+
+func TestStreamRotator_HardLimitReplaysUnfinalizedAudio(t *testing.T) {
+	rotation := testRotation()
+	rotation.hardLimit = 100 * time.Millisecond
+	h := startRotation(t, rotation)
+
+	// 0.1s of audio; the first 0.05s (1600 bytes) is finalized.
+	h.audio.Write(pcm(0, 3200))
+	first := h.speech.waitForStream(t, 0)
+	waitForAudio(t, first, 3200)
+	first.responses <- finalResponse("hello", 50*time.Millisecond)
+
+	second := h.speech.waitForStream(t, 1)
+	replayed := waitForAudio(t, second, 1600)
+	if !bytes.Equal(replayed, pcm(1600, 1600)) {
+		t.Fatalf("expected only the unfinalized 1600 bytes to be replayed, got %d bytes", len(replayed))
+	}
+
+	// Offsets on the new stream are relative to the replayed audio.
+	h.audio.Write(pcm(3200, 1600))
+	waitForAudio(t, second, 3200)
+	second.responses <- finalResponse("world", 100*time.Millisecond)
+
+	texts := h.finish(t)
+	if len(texts) != 2 || texts[0] != "hello" || texts[1] != "world" {
+		t.Errorf("expected [hello world], got %v", texts)
+	}
+}
+
+func TestStreamRotator_AudioTimeoutReopensStream(t *testing.T) {
+	h := startRotation(t, testRotation())
+
+	h.audio.Write(pcm(0, 3200))
+	first := h.speech.waitForStream(t, 0)
+	waitForAudio(t, first, 3200)
+	first.responses <- finalResponse("before", 100*time.Millisecond)
+	first.failures <- status.Error(codes.OutOfRange, "Audio Timeout Error: Long duration elapsed without audio.")
+
+	second := h.speech.waitForStream(t, 1)
+	h.audio.Write(pcm(3200, 3200))
+	if got := waitForAudio(t, second, 3200); !bytes.Equal(got, pcm(3200, 3200)) {
+		t.Fatalf("expected the new stream to receive only new audio")
+	}
+	second.responses <- finalResponse("after", 100*time.Millisecond)
+
+	texts := h.finish(t)
+	if len(texts) != 2 || texts[0] != "before" || texts[1] != "after" {
+		t.Errorf("expected [before after], got %v", texts)
+	}
+}
+
+func TestStreamRotator_IdleGapClosesAndReopens(t *testing.T) {
+	rotation := testRotation()
+	rotation.idleTimeout = 50 * time.Millisecond
+	h := startRotation(t, rotation)
+
+	h.audio.Write(pcm(0, 3200))
+	first := h.speech.waitForStream(t, 0)
+	waitForAudio(t, first, 3200)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !first.isClosed() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected idle stream to be half-closed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	h.audio.Write(pcm(3200, 3200))
+	second := h.speech.waitForStream(t, 1)
+	if got := waitForAudio(t, second, 3200); !bytes.Equal(got, pcm(3200, 3200)) {
+		t.Fatalf("expected the new stream to start with the resumed audio")
+	}
+	h.finish(t)
+}
+
+func TestStreamRotator_FatalError(t *testing.T) {
+	h := startRotation(t, testRotation())
+
+	h.audio.Write(pcm(0, 3200))
+	first := h.speech.waitForStream(t, 0)
+	first.failures <- status.Error(codes.PermissionDenied, "bad credentials")
+
+	for range h.finals {
+	}
+	if err := <-h.errs; status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied, got %v", err)
+	}
+}
+
+func TestStreamRotator_PromotesWholeInterimHypothesis(t *testing.T) {
+	rotation := testRotation()
+	rotation.hardLimit = 100 * time.Millisecond
+	rotation.maxCarryOver = 10 * time.Millisecond
+	h := startRotation(t, rotation)
+
+	h.audio.Write(pcm(0, 3200))
+	first := h.speech.waitForStream(t, 0)
+	waitForAudio(t, first, 3200)
+	// Google splits an interim hypothesis into a stable and an unstable part.
+	first.responses <- &speechpb.StreamingRecognizeResponse{Results: []*speechpb.StreamingRecognitionResult{
+		{Alternatives: []*speechpb.SpeechRecognitionAlternative{{Transcript: "the patient"}}, Stability: 0.9, ResultEndTime: durationpb.New(50 * time.Millisecond)},
+		{Alternatives: []*speechpb.SpeechRecognitionAlternative{{Transcript: " has a cough"}}, Stability: 0.1, ResultEndTime: durationpb.New(80 * time.Millisecond)},
+	}}
+
+	// Replaying more than maxCarryOver promotes the whole hypothesis and
+	// replays only the audio after it.
+	second := h.speech.waitForStream(t, 1)
+	if replayed := waitForAudio(t, second, 640); !bytes.Equal(replayed, pcm(2560, 640)) {
+		t.Fatalf("expected the 640 bytes after the hypothesis to be replayed, got %d bytes", len(replayed))
+	}
+
+	texts := h.finish(t)
+	if len(texts) != 1 || texts[0] != "the patient has a cough" {
+		t.Errorf("expected [the patient has a cough], got %v", texts)
+	}
+}
+
+func TestStreamRotator_WordOffsetsCountFromSessionStart(t *testing.T) {
+	rotation := testRotation()
+	rotation.hardLimit = 100 * time.Millisecond
+	h := startRotation(t, rotation)
+
+	h.audio.Write(pcm(0, 3200))
+	first := h.speech.waitForStream(t, 0)
+	waitForAudio(t, first, 3200)
+	first.responses <- finalResponse("hello", 50*time.Millisecond)
+
+	// The second stream starts at the first unfinalized byte, 50ms in.
+	second := h.speech.waitForStream(t, 1)
+	waitForAudio(t, second, 1600)
+	resp := finalResponse("world", 40*time.Millisecond)
+	resp.Results[0].Alternatives[0].Confidence = 0.8
+	resp.Results[0].Alternatives[0].Words = []*speechpb.WordInfo{{
+		Word:       "world",
+		StartTime:  durationpb.New(10 * time.Millisecond),
+		EndTime:    durationpb.New(40 * time.Millisecond),
+		Confidence: 0.5,
+	}}
+	second.responses <- resp
+
+	finals := h.finishResults(t)
+	if len(finals) != 2 {
+		t.Fatalf("expected 2 final results, got %d", len(finals))
+	}
+	got := finals[1]
+	want := domain.Word{Text: "world", StartMS: 60, EndMS: 90, Confidence: 0.5}
+	if got.Confidence != 0.8 || len(got.Words) != 1 || got.Words[0] != want {
+		t.Errorf("expected %+v at confidence 0.8, got %+v", want, got)
+	}
+}
+
+func TestStreamRotator_SeparateChannelsFinalizeTogether(t *testing.T) {
+	cfg := StreamConfig{Channels: 2, Speakers: domain.SpeakerConfig{SeparateChannels: true}}
+	r := newStreamRotator(cfg, testRotation(), nil)
+	st := &recognitionStream{audioBase: 100}
+
+	if end := r.finalizedEnd(st, 1, 500); end != 100 {
+		t.Errorf("expected nothing finalized until every channel has a final result, got %d", end)
+	}
+	if end := r.finalizedEnd(st, 2, 300); end != 300 {
+		t.Errorf("expected audio finalized up to the slower channel, got %d", end)
+	}
+	if end := r.finalizedEnd(st, 2, 900); end != 500 {
+		t.Errorf("expected audio finalized up to channel 1, got %d", end)
+	}
+}
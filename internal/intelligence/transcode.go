@@ -0,0 +1,204 @@
+package intelligence
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+
+	"clinical-agent-backend/internal/audio"
+)
+
+// EncodingSupport is implemented by transcribers that accept only some
+// encodings. Transcribers that do not implement it are assumed to accept
+// LINEAR16 only.
+type EncodingSupport interface {
+	SupportsEncoding(encoding string) bool
+}
+
+// transcodeSampleRate is the rate decoded audio is resampled to.
+const transcodeSampleRate = 16000
+
+// TranscodingTranscriber decodes audio its provider cannot recognize natively
+// into LINEAR16 before passing it on. Encodings the provider supports are
+// passed through untouched. mu-law and FLAC are decoded in-process; Opus and
+// AAC need ffmpeg.
+type TranscodingTranscriber struct {
+	Transcriber
+	FFmpeg audio.FFmpeg
+}
+
+// NewTranscodingTranscriber wraps t with a decoding stage.
+func NewTranscodingTranscriber(t Transcriber, ffmpeg audio.FFmpeg) *TranscodingTranscriber {
+	return &TranscodingTranscriber{Transcriber: t, FFmpeg: ffmpeg}
+}
+
+// native reports whether the wrapped provider accepts encoding as is.
+func (t *TranscodingTranscriber) native(encoding string) bool {
+	if es, ok := t.Transcriber.(EncodingSupport); ok {
+		return es.SupportsEncoding(encoding)
+	}
+	return encoding == EncodingLinear16
+}
+
+// SupportsEncoding reports whether audio in encoding can be transcribed,
+// natively or after decoding.
+func (t *TranscodingTranscriber) SupportsEncoding(encoding string) bool {
+	switch {
+	case t.native(encoding):
+		return true
+	case encoding == EncodingMulaw, encoding == EncodingFLAC:
+		return true
+	case encoding == EncodingOggOpus, encoding == EncodingWebmOpus, encoding == EncodingMP4AAC:
+		return t.FFmpeg.Available()
+	}
+	return false
+}
+
+// decode converts audio in cfg's encoding into LINEAR16 and returns the
+// config describing the result.
+func (t *TranscodingTranscriber) decode(ctx context.Context, cfg StreamConfig, r io.Reader) (io.ReadCloser, StreamConfig, error) {
+	var (
+		pcm    io.Reader
+		format audio.Format
+		err    error
+	)
+	switch cfg.Encoding {
+	case EncodingMulaw:
+		pcm = audio.DecodeMuLaw(r)
+		format = audio.Format{SampleRate: cfg.SampleRateHertz, Channels: cfg.Channels}
+	case EncodingFLAC:
+		if pcm, format, err = audio.DecodeFLAC(r); err != nil {
+			return nil, cfg, err
+		}
+	case EncodingOggOpus, EncodingWebmOpus, EncodingMP4AAC:
+		format = audio.Format{SampleRate: transcodeSampleRate, Channels: cfg.Channels}
+		out, err := t.FFmpeg.Decode(ctx, r, format)
+		if err != nil {
+			return nil, cfg, fmt.Errorf("cannot decode %s: %w", cfg.Encoding, err)
+		}
+		return out, linear16Config(cfg, format), nil
+	default:
+		return nil, cfg, fmt.Errorf("no decoder for encoding %q", cfg.Encoding)
+	}
+
+	pcm = audio.Resample(pcm, format, transcodeSampleRate)
+	format.SampleRate = transcodeSampleRate
+	return io.NopCloser(pcm), linear16Config(cfg, format), nil
+}
+
+// linear16Config describes decoded audio in format, keeping cfg's
+// recognition settings.
+func linear16Config(cfg StreamConfig, format audio.Format) StreamConfig {
+	cfg.Encoding = EncodingLinear16
+	cfg.SampleRateHertz = format.SampleRate
+	cfg.Channels = format.Channels
+	return cfg
+}
+
+// StreamTranscribe decodes the stream if needed and transcribes it with the
+// wrapped provider.
+func (t *TranscodingTranscriber) StreamTranscribe(ctx context.Context, cfg StreamConfig, audioStream io.Reader) (<-chan TranscriptResult, <-chan error) {
+	if t.native(cfg.Encoding) {
+		return t.Transcriber.StreamTranscribe(ctx, cfg, audioStream)
+	}
+
+	transcripts := make(chan TranscriptResult)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(transcripts)
+
+		// Decoders may block reading a header, so they start here rather
+		// than in the caller's goroutine.
+		pcm, pcmCfg, err := t.decode(ctx, cfg, audioStream)
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer pcm.Close()
+
+		results, providerErrs := t.Transcriber.StreamTranscribe(ctx, pcmCfg, pcm)
+		for result := range results {
+			transcripts <- result
+		}
+		if err := <-providerErrs; err != nil {
+			errs <- err
+		}
+	}()
+	return transcripts, errs
+}
+
+// Recognize decodes the clip if needed and transcribes it with the wrapped
+// provider.
+func (t *TranscodingTranscriber) Recognize(ctx context.Context, cfg StreamConfig, clip []byte) ([]TranscriptResult, error) {
+	if t.native(cfg.Encoding) {
+		return t.Transcriber.Recognize(ctx, cfg, clip)
+	}
+	pcm, pcmCfg, err := t.decode(ctx, cfg, bytes.NewReader(clip))
+	if err != nil {
+		return nil, err
+	}
+	defer pcm.Close()
+	decoded, err := io.ReadAll(pcm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s audio: %w", cfg.Encoding, err)
+	}
+	return t.Transcriber.Recognize(ctx, pcmCfg, decoded)
+}
+
+// RecognizeLong decodes the recording if needed and transcribes it with the
+// wrapped provider's batch mode. Decoded audio is streamed to the provider
+// as it is produced.
+func (t *TranscodingTranscriber) RecognizeLong(ctx context.Context, cfg StreamConfig, recording io.Reader) ([]TranscriptResult, error) {
+	if t.native(cfg.Encoding) {
+		return t.Transcriber.RecognizeLong(ctx, cfg, recording)
+	}
+	pcm, pcmCfg, err := t.decode(ctx, cfg, recording)
+	if err != nil {
+		return nil, err
+	}
+	defer pcm.Close()
+	return t.Transcriber.RecognizeLong(ctx, pcmCfg, pcm)
+}
+
+// EncodingForMediaType returns the encoding of audio of MIME type
+// mediaType, as declared by browsers and upload clients, or "" if it does
+// not determine one.
+func EncodingForMediaType(mediaType string) string {
+	mediaType, _, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return ""
+	}
+	switch mediaType {
+	case "audio/flac", "audio/x-flac":
+		return EncodingFLAC
+	case "audio/ogg", "audio/opus":
+		return EncodingOggOpus
+	case "audio/webm", "video/webm":
+		return EncodingWebmOpus
+	case "audio/mp4", "audio/aac", "audio/x-m4a", "video/mp4":
+		return EncodingMP4AAC
+	}
+	return ""
+}
+
+// EncodingForContainer returns the encoding of audio in container c, or ""
+// if the container carries no signature. WAV is reported as LINEAR16; its
+// header must be stripped with audio.ReadWAVHeader first.
+func EncodingForContainer(c audio.Container) string {
+	switch c {
+	case audio.ContainerWAV:
+		return EncodingLinear16
+	case audio.ContainerFLAC:
+		return EncodingFLAC
+	case audio.ContainerOgg:
+		return EncodingOggOpus
+	case audio.ContainerWebM:
+		return EncodingWebmOpus
+	case audio.ContainerMP4:
+		return EncodingMP4AAC
+	}
+	return ""
+}
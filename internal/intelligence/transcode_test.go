@@ -0,0 +1,95 @@
+package intelligence
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"clinical-agent-backend/internal/audio"
+)
+
+// recordingTranscriber captures the config and audio it was given.
+type recordingTranscriber struct {
+	*LocalTranscriber
+	cfg   StreamConfig
+	audio []byte
+}
+
+func (r *recordingTranscriber) Recognize(ctx context.Context, cfg StreamConfig, clip []byte) ([]TranscriptResult, error) {
+	r.cfg, r.audio = cfg, clip
+	return r.LocalTranscriber.Recognize(ctx, cfg, clip)
+}
+
+func TestTranscodingTranscriber_MuLaw(t *testing.T) {
+	inner := &recordingTranscriber{LocalTranscriber: NewLocalTranscriber([]string{"hello"})}
+	tr := NewTranscodingTranscriber(inner, audio.FFmpeg{Path: "/nonexistent/ffmpeg"})
+
+	// One second of 8 kHz mu-law silence.
+	clip := bytes.Repeat([]byte{0xFF}, 8000)
+	cfg := StreamConfig{Encoding: EncodingMulaw, SampleRateHertz: 8000, Channels: 1, LanguageCode: "en-US"}
+	if _, err := tr.Recognize(context.Background(), cfg, clip); err != nil {
+		t.Fatalf("Recognize failed: %v", err)
+	}
+	if inner.cfg.Encoding != EncodingLinear16 || inner.cfg.SampleRateHertz != 16000 || inner.cfg.LanguageCode != "en-US" {
+		t.Errorf("unexpected provider config: %+v", inner.cfg)
+	}
+	// 16000 samples of 16-bit PCM, give or take the final interpolated frame.
+	if n := len(inner.audio); n < 31998 || n > 32002 {
+		t.Errorf("expected ~32000 bytes of PCM, got %d", n)
+	}
+}
+
+func TestTranscodingTranscriber_StreamMuLaw(t *testing.T) {
+	tr := NewTranscodingTranscriber(NewLocalTranscriber([]string{"one", "two"}), audio.FFmpeg{})
+	cfg := StreamConfig{Encoding: EncodingMulaw, SampleRateHertz: 8000, Channels: 1, LanguageCode: "en-US"}
+
+	// Two seconds of mu-law decode to two local-provider phrases.
+	results, errs := tr.StreamTranscribe(context.Background(), cfg, bytes.NewReader(make([]byte, 16000)))
+	var got []string
+	for r := range results {
+		got = append(got, r.Text)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 2 || got[0] != "one" || got[1] != "two" {
+		t.Errorf("unexpected transcripts: %v", got)
+	}
+}
+
+func TestTranscodingTranscriber_GoogleDecodesOpus(t *testing.T) {
+	// Opus streams sent to Google as-is could not be rotated, so they need
+	// ffmpeg like any other encoding Google is not given directly.
+	tr := NewTranscodingTranscriber(&STTClient{}, audio.FFmpeg{Path: "/nonexistent/ffmpeg"})
+	for _, enc := range []string{EncodingLinear16, EncodingMulaw, EncodingFLAC} {
+		if !tr.SupportsEncoding(enc) {
+			t.Errorf("expected %s to be supported", enc)
+		}
+	}
+	for _, enc := range []string{EncodingOggOpus, EncodingWebmOpus} {
+		if tr.native(enc) || tr.SupportsEncoding(enc) {
+			t.Errorf("expected %s to need ffmpeg", enc)
+		}
+	}
+}
+
+func TestTranscodingTranscriber_SupportsEncoding(t *testing.T) {
+	tr := NewTranscodingTranscriber(NewLocalTranscriber(nil), audio.FFmpeg{Path: "/nonexistent/ffmpeg"})
+	for _, enc := range []string{EncodingLinear16, EncodingMulaw, EncodingFLAC} {
+		if !tr.SupportsEncoding(enc) {
+			t.Errorf("expected %s to be supported", enc)
+		}
+	}
+	for _, enc := range []string{EncodingOggOpus, EncodingWebmOpus, EncodingMP4AAC} {
+		if tr.SupportsEncoding(enc) {
+			t.Errorf("expected %s to need ffmpeg", enc)
+		}
+	}
+
+	results, errs := tr.StreamTranscribe(context.Background(), StreamConfig{Encoding: EncodingOggOpus}, bytes.NewReader(nil))
+	for range results {
+	}
+	if err := <-errs; err == nil {
+		t.Error("expected an error decoding Opus without ffmpeg")
+	}
+}
@@ -0,0 +1,105 @@
+package intelligence
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"clinical-agent-backend/internal/domain"
+)
+
+// TranscriptResult is a single recognition hypothesis from a streaming
+// transcriber. Interim results may be revised; final results will not.
+type TranscriptResult struct {
+	Text    string
+	IsFinal bool
+	// Stability estimates how likely an interim result is to change (0-1).
+	// Final results always report 1.
+	Stability float32
+	// Confidence is the provider's estimate that a final result is correct
+	// (0-1), or 0 if it gave none.
+	Confidence float32
+	// Words lists the words of a final result with their offsets from the
+	// start of the session's audio, when the provider reports them.
+	Words []domain.Word
+	// Channel is the audio channel (from 1) a final result was recognized
+	// from when channels are recognized separately, and 0 otherwise.
+	Channel int
+	// LanguageCode is the language the provider recognized a final result
+	// in, when it reports one.
+	LanguageCode string
+}
+
+// Transcriber converts audio into text. Implementations must support both
+// real-time streaming (WebSocket sessions) and batch recognition (uploads).
+type Transcriber interface {
+	// StreamTranscribe streams audio to the provider and returns a channel of
+	// results. The error channel is buffered, yields at most one error and is
+	// closed after the result channel, so callers may drain results first.
+	StreamTranscribe(ctx context.Context, cfg StreamConfig, audioStream io.Reader) (<-chan TranscriptResult, <-chan error)
+	// Recognize transcribes a short audio clip (up to MaxSyncRecognizeDuration)
+	// in a single request and returns its final results in order.
+	Recognize(ctx context.Context, cfg StreamConfig, audio []byte) ([]TranscriptResult, error)
+	// RecognizeLong transcribes a complete recording of any length as a
+	// batch operation, blocking until the provider has finished. The
+	// recording is read from audio as needed rather than held in memory.
+	RecognizeLong(ctx context.Context, cfg StreamConfig, audio io.Reader) ([]TranscriptResult, error)
+	// Close releases any resources held by the provider.
+	Close() error
+}
+
+// MaxSyncRecognizeDuration is the longest clip Recognize should be given;
+// longer recordings go through RecognizeLong.
+const MaxSyncRecognizeDuration = 55 * time.Second
+
+// TranscriberFactory builds a Transcriber for a registered provider.
+type TranscriberFactory func(ctx context.Context) (Transcriber, error)
+
+var (
+	transcriberMu        sync.RWMutex
+	transcriberProviders = map[string]TranscriberFactory{
+		"google": func(ctx context.Context) (Transcriber, error) { return NewSTTClient(ctx) },
+		"local":  func(ctx context.Context) (Transcriber, error) { return NewLocalTranscriberFromEnv() },
+	}
+)
+
+// DefaultTranscriberProvider is used when no provider is configured.
+const DefaultTranscriberProvider = "google"
+
+// RegisterTranscriber makes a provider available to NewTranscriber.
+// Registering an existing name replaces the previous factory.
+func RegisterTranscriber(name string, factory TranscriberFactory) {
+	transcriberMu.Lock()
+	defer transcriberMu.Unlock()
+	transcriberProviders[name] = factory
+}
+
+// TranscriberProviders returns the names of all registered providers.
+func TranscriberProviders() []string {
+	transcriberMu.RLock()
+	defer transcriberMu.RUnlock()
+	names := make([]string, 0, len(transcriberProviders))
+	for name := range transcriberProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewTranscriber creates a Transcriber for the named provider.
+// An empty name selects DefaultTranscriberProvider.
+func NewTranscriber(ctx context.Context, provider string) (Transcriber, error) {
+	if provider == "" {
+		provider = DefaultTranscriberProvider
+	}
+	transcriberMu.RLock()
+	factory, ok := transcriberProviders[provider]
+	transcriberMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown STT provider %q (available: %v)", provider, TranscriberProviders())
+	}
+	return factory(ctx)
+}
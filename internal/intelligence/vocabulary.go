@@ -0,0 +1,83 @@
+package intelligence
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"clinical-agent-backend/internal/domain"
+
+	speechpb "cloud.google.com/go/speech/apiv1/speechpb"
+)
+
+// speechAdaptation converts a vocabulary to inline Google speech
+// adaptation, or returns nil if it is empty. Custom classes are identified
+// by name, so phrases refer to them as "${name}".
+func speechAdaptation(v domain.Vocabulary) *speechpb.SpeechAdaptation {
+	if len(v.PhraseSets) == 0 {
+		return nil
+	}
+	adaptation := &speechpb.SpeechAdaptation{}
+	for _, set := range v.PhraseSets {
+		ps := &speechpb.PhraseSet{Boost: set.Boost}
+		for _, p := range set.Phrases {
+			ps.Phrases = append(ps.Phrases, &speechpb.PhraseSet_Phrase{Value: p.Value, Boost: p.Boost})
+		}
+		adaptation.PhraseSets = append(adaptation.PhraseSets, ps)
+	}
+	for _, class := range v.CustomClasses {
+		cc := &speechpb.CustomClass{CustomClassId: class.Name}
+		for _, item := range class.Items {
+			cc.Items = append(cc.Items, &speechpb.CustomClass_ClassItem{Value: item})
+		}
+		adaptation.CustomClasses = append(adaptation.CustomClasses, cc)
+	}
+	return adaptation
+}
+
+// LoadVocabularyDir reads the phrase sets and custom classes from the JSON
+// files in dir, each shaped like domain.Vocabulary:
+//
+//	{"phrase_sets": [{"name": "formulary", "boost": 10, "phrases": [{"value": "take ${drugs}"}]}],
+//	 "custom_classes": [{"name": "drugs", "items": ["metformin", "lisinopril"]}]}
+//
+// Names must be unique across the files, and phrases may refer to custom
+// classes defined in any of them.
+func LoadVocabularyDir(dir string) (domain.Vocabulary, error) {
+	var v domain.Vocabulary
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return v, err
+	}
+	sets := make(map[string]string)
+	classes := make(map[string]string)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return v, fmt.Errorf("failed to read vocabulary file: %w", err)
+		}
+		var file domain.Vocabulary
+		if err := json.Unmarshal(data, &file); err != nil {
+			return v, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		for _, set := range file.PhraseSets {
+			if other, ok := sets[set.Name]; ok {
+				return v, fmt.Errorf("%s: phrase set %s is already defined in %s", path, set.Name, other)
+			}
+			sets[set.Name] = path
+		}
+		for _, class := range file.CustomClasses {
+			if other, ok := classes[class.Name]; ok {
+				return v, fmt.Errorf("%s: custom class %s is already defined in %s", path, class.Name, other)
+			}
+			classes[class.Name] = path
+		}
+		v.PhraseSets = append(v.PhraseSets, file.PhraseSets...)
+		v.CustomClasses = append(v.CustomClasses, file.CustomClasses...)
+	}
+	if err := v.Validate(); err != nil {
+		return v, fmt.Errorf("invalid vocabulary in %s: %w", dir, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,68 @@
+package intelligence
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"clinical-agent-backend/internal/domain"
+)
+
+func TestRecognitionConfig_Adaptation(t *testing.T) {
+	cfg := DefaultStreamConfig()
+	if rc := recognitionConfig(cfg); rc.Adaptation != nil {
+		t.Errorf("expected no adaptation without a vocabulary, got %v", rc.Adaptation)
+	}
+
+	cfg.Vocabulary = domain.Vocabulary{
+		PhraseSets: []domain.PhraseSet{{
+			Name:    "formulary",
+			Phrases: []domain.Phrase{{Value: "metformin"}, {Value: "take ${drugs}", Boost: 15}},
+			Boost:   10,
+		}},
+		CustomClasses: []domain.CustomClass{{Name: "drugs", Items: []string{"lisinopril", "atorvastatin"}}},
+	}
+	a := recognitionConfig(cfg).Adaptation
+	if a == nil || len(a.PhraseSets) != 1 || len(a.CustomClasses) != 1 {
+		t.Fatalf("unexpected adaptation: %v", a)
+	}
+	set := a.PhraseSets[0]
+	if set.Boost != 10 || len(set.Phrases) != 2 || set.Phrases[1].Value != "take ${drugs}" || set.Phrases[1].Boost != 15 {
+		t.Errorf("unexpected phrase set: %v", set)
+	}
+	class := a.CustomClasses[0]
+	if class.CustomClassId != "drugs" || len(class.Items) != 2 || class.Items[0].Value != "lisinopril" {
+		t.Errorf("unexpected custom class: %v", class)
+	}
+}
+
+func TestLoadVocabularyDir(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("formulary.json", `{"phrase_sets": [{"name": "formulary", "boost": 10, "phrases": [{"value": "take ${drugs}"}]}]}`)
+	write("classes.json", `{"custom_classes": [{"name": "drugs", "items": ["metformin"]}]}`)
+	write("notes.txt", "ignored")
+
+	v, err := LoadVocabularyDir(dir)
+	if err != nil {
+		t.Fatalf("LoadVocabularyDir failed: %v", err)
+	}
+	if len(v.PhraseSets) != 1 || len(v.CustomClasses) != 1 {
+		t.Errorf("unexpected vocabulary: %+v", v)
+	}
+
+	write("more.json", `{"phrase_sets": [{"name": "formulary", "phrases": [{"value": "insulin"}]}]}`)
+	if _, err := LoadVocabularyDir(dir); err == nil || !strings.Contains(err.Error(), "already defined") {
+		t.Errorf("expected duplicate phrase set error, got %v", err)
+	}
+
+	write("more.json", `{"phrase_sets": [{"name": "cardiology", "phrases": [{"value": "${procedures}"}]}]}`)
+	if _, err := LoadVocabularyDir(dir); err == nil || !strings.Contains(err.Error(), "unknown custom class") {
+		t.Errorf("expected unknown class error, got %v", err)
+	}
+}
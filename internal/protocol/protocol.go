@@ -0,0 +1,275 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"clinical-agent-backend/internal/domain"
+)
+
+// Version is the current /ws/audio protocol version. Every JSON text frame
+// carries it in the "v" field; binary frames carry raw audio.
+const Version = 1
+
+// Client-to-server message types.
+const (
+	TypeStart     = "start"
+	TypeReconnect = "reconnect"
+	TypeAck       = "ack"
+	TypeConfig    = "config"
+	TypePause     = "pause"
+	TypeResume    = "resume"
+	TypeEnd       = "end"
+)
+
+// Server-to-client event types.
+const (
+	TypeSessionStarted    = "session.started"
+	TypeSessionResumed    = "session.resumed"
+	TypeTranscriptInterim = "transcript.interim"
+	TypeTranscriptFinal   = "transcript.final"
+	TypeSpeechStarted     = "speech.started"
+	TypeSpeechEnded       = "speech.ended"
+	TypeNote              = "note"
+	TypeImpression        = "impression"
+	TypeError             = "error"
+	TypeSessionClosed     = "session.closed"
+)
+
+// Error codes sent in ErrorPayload.Code.
+const (
+	ErrCodeBadMessage         = "bad_message"
+	ErrCodeStartRequired      = "start_required"
+	ErrCodeInvalidConfig      = "invalid_config"
+	ErrCodeSessionNotFound    = "session_not_found"
+	ErrCodeReplayGap          = "replay_gap"
+	ErrCodeUnsupportedVersion = "unsupported_version"
+	ErrCodeSTTFailed          = "stt_failed"
+	ErrCodeExtractionFailed   = "extraction_failed"
+	ErrCodeMappingFailed      = "mapping_failed"
+	ErrCodePersistenceFailed  = "persistence_failed"
+	ErrCodeServerDraining     = "server_draining"
+)
+
+// Envelope wraps every JSON message exchanged on /ws/audio.
+type Envelope struct {
+	Version int    `json:"v"`
+	Type    string `json:"type"`
+	// Seq numbers server events in the order they were sent, starting at 1.
+	// It is omitted on client messages.
+	Seq     uint64          `json:"seq,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// AudioConfig describes the audio the client will send. Unset fields take
+// the server defaults (LINEAR16, 16000 Hz, 1 channel, en-US).
+type AudioConfig struct {
+	Encoding        string `json:"encoding,omitempty"`
+	SampleRateHertz int    `json:"sample_rate_hertz,omitempty"`
+	Channels        int    `json:"channels,omitempty"`
+	LanguageCode    string `json:"language_code,omitempty"`
+	// AlternativeLanguageCodes lists up to three more languages the speech
+	// may be in. Each final transcript reports the language detected.
+	AlternativeLanguageCodes []string `json:"alternative_language_codes,omitempty"`
+}
+
+// StartPayload opens a session. It must be the first frame; the server
+// validates it before accepting any audio.
+type StartPayload struct {
+	Audio     AudioConfig             `json:"audio"`
+	Encounter domain.EncounterContext `json:"encounter"`
+	// InterimResults enables transcript.interim events (default true).
+	InterimResults *bool `json:"interim_results,omitempty"`
+	// SequencedAudio declares that every binary frame starts with an 8-byte
+	// big-endian audio sequence number, so audio resent after a reconnect
+	// can be deduplicated.
+	SequencedAudio bool `json:"sequenced_audio,omitempty"`
+	// Speakers enables speaker separation and assigns speaker roles. By
+	// default transcripts are not attributed to speakers.
+	Speakers domain.SpeakerConfig `json:"speakers"`
+	// Recognition selects the speech model and vocabulary.
+	Recognition RecognitionConfig `json:"recognition"`
+	// VAD turns server-side voice activity detection on or off, overriding
+	// the server default. It needs LINEAR16 or MULAW audio.
+	VAD *bool `json:"vad,omitempty"`
+}
+
+// RecognitionConfig selects the speech model and the vocabulary phrase sets
+// boosted for a session.
+type RecognitionConfig struct {
+	// Model is a provider model such as "medical_conversation" (default
+	// "default").
+	Model       string `json:"model,omitempty"`
+	UseEnhanced bool   `json:"use_enhanced,omitempty"`
+	// PhraseSets names the phrase sets to boost; empty means all of them.
+	PhraseSets []string `json:"phrase_sets,omitempty"`
+}
+
+// ReconnectPayload reattaches a new connection to a session whose connection
+// dropped. It replaces start as the first frame.
+type ReconnectPayload struct {
+	ResumeToken string `json:"resume_token"`
+	// LastEventSeq is the last server event the client processed; later
+	// events are replayed.
+	LastEventSeq uint64 `json:"last_event_seq"`
+}
+
+// AckPayload acknowledges server events up to Seq, letting the server
+// discard them from its replay buffer.
+type AckPayload struct {
+	Seq uint64 `json:"seq"`
+}
+
+// ConfigPayload changes session settings mid-stream.
+type ConfigPayload struct {
+	InterimResults *bool `json:"interim_results,omitempty"`
+}
+
+// SessionStartedPayload acknowledges a start message.
+type SessionStartedPayload struct {
+	SessionID       string `json:"session_id"`
+	ProtocolVersion int    `json:"protocol_version"`
+	// Audio echoes the effective audio configuration after defaults.
+	Audio AudioConfig `json:"audio"`
+	// Speakers echoes the speaker configuration.
+	Speakers domain.SpeakerConfig `json:"speakers"`
+	// Recognition echoes the effective model and phrase sets.
+	Recognition RecognitionConfig `json:"recognition"`
+	// VAD reports whether voice activity detection is on, and so whether
+	// speech.started and speech.ended events will be sent.
+	VAD bool `json:"vad"`
+	// RecordingID names the archived recording of the session's audio,
+	// when the server archives audio.
+	RecordingID string `json:"recording_id,omitempty"`
+	// ResumeToken lets the client reconnect to the session after a network
+	// drop, within ResumeGraceSeconds of losing the connection.
+	ResumeToken        string `json:"resume_token"`
+	ResumeGraceSeconds int    `json:"resume_grace_seconds"`
+}
+
+// SessionResumedPayload acknowledges a reconnect. Events after the client's
+// LastEventSeq are replayed immediately after it.
+type SessionResumedPayload struct {
+	SessionID string `json:"session_id"`
+	// LastAudioSeq is the highest audio frame sequence number received;
+	// the client should resend audio after it.
+	LastAudioSeq uint64 `json:"last_audio_seq"`
+	// Transcript holds every final transcript of the session so far, indexed
+	// by segment.
+	Transcript []string `json:"transcript"`
+	// PendingExtractions counts speaker turns still being processed.
+	PendingExtractions int `json:"pending_extractions"`
+}
+
+// TranscriptPayload carries an interim or final transcript.
+type TranscriptPayload struct {
+	Text string `json:"text"`
+	// Segment numbers the finalized stretches of the transcript from 0. An
+	// interim result carries the number its final result will get and
+	// replaces any earlier interim result for that segment.
+	Segment int `json:"segment"`
+	// Stability estimates how likely the text is to change (0-1).
+	// Final transcripts always report 1.
+	Stability float32 `json:"stability"`
+	// The remaining fields are only set on final transcripts, when the
+	// speech provider reports them. Times are milliseconds from the start
+	// of the session's audio.
+	StartMS    int64         `json:"start_ms,omitempty"`
+	EndMS      int64         `json:"end_ms,omitempty"`
+	Confidence float32       `json:"confidence,omitempty"`
+	Words      []domain.Word `json:"words,omitempty"`
+	// Speaker and Role attribute a final transcript to a speaker when
+	// speakers are separated. Speaker numbers diarized voices, or audio
+	// channels with separate_channels, from 1.
+	Speaker int                `json:"speaker,omitempty"`
+	Role    domain.SpeakerRole `json:"role,omitempty"`
+	// Language is the BCP-47 tag a final transcript was recognized in.
+	Language string `json:"language,omitempty"`
+}
+
+// SpeechPayload marks the start or end of an utterance detected by
+// voice activity detection.
+type SpeechPayload struct {
+	// OffsetMS is milliseconds from the start of the session's audio.
+	OffsetMS int64 `json:"offset_ms"`
+}
+
+// NotePayload carries the session's running note once entities from a
+// speaker turn have been merged into it.
+type NotePayload struct {
+	// TranscriptSeq is the Seq of the last transcript.final event of the
+	// turn the note came from.
+	TranscriptSeq uint64              `json:"transcript_seq"`
+	Note          domain.ClinicalNote `json:"note"`
+}
+
+// ImpressionPayload reports that the session's FHIR ClinicalImpression was
+// saved with the running note. Every event of a session carries the same ID.
+type ImpressionPayload struct {
+	TranscriptSeq uint64 `json:"transcript_seq"`
+	ID            string `json:"id"`
+}
+
+// ErrorPayload describes a failure. Fatal errors are followed by session.closed.
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// TranscriptSeq links pipeline failures to the transcript that caused them.
+	TranscriptSeq uint64 `json:"transcript_seq,omitempty"`
+}
+
+// SessionClosedPayload is the last event before the server closes the socket.
+type SessionClosedPayload struct {
+	Reason string `json:"reason"`
+	// ImpressionID is the session's ClinicalImpression, if one was saved.
+	ImpressionID string `json:"impression_id,omitempty"`
+}
+
+// Encode builds a JSON frame for the given message type and payload.
+func Encode(msgType string, seq uint64, payload any) ([]byte, error) {
+	env := Envelope{Version: Version, Type: msgType, Seq: seq}
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal %s payload: %w", msgType, err)
+		}
+		env.Payload = raw
+	}
+	return json.Marshal(env)
+}
+
+// Decode parses a JSON frame and checks its protocol version.
+func Decode(data []byte) (*Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+	if env.Type == "" {
+		return nil, fmt.Errorf("invalid message: missing type")
+	}
+	if env.Version != Version {
+		return &env, &VersionError{Got: env.Version}
+	}
+	return &env, nil
+}
+
+// DecodePayload unmarshals the envelope payload into v. A missing payload
+// leaves v untouched.
+func (e *Envelope) DecodePayload(v any) error {
+	if len(e.Payload) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(e.Payload, v); err != nil {
+		return fmt.Errorf("invalid %s payload: %w", e.Type, err)
+	}
+	return nil
+}
+
+// VersionError is returned by Decode for messages from another protocol version.
+type VersionError struct {
+	Got int
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("unsupported protocol version %d (server speaks %d)", e.Got, Version)
+}
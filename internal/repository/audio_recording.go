@@ -0,0 +1,180 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"clinical-agent-backend/internal/domain"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// AudioRecordingRepository stores the metadata of archived audio; the audio
+// itself lives in a blob store. PostgresRecordingRepository,
+// SQLiteRecordingRepository and MemoryRecordingRepository implement it.
+type AudioRecordingRepository interface {
+	// Create stores a new recording. The caller assigns its ID.
+	Create(ctx context.Context, rec *domain.AudioRecording) error
+	// FindByID retrieves a single recording, or ErrNotFound.
+	FindByID(ctx context.Context, id string) (*domain.AudioRecording, error)
+	// Find retrieves the recordings matching filter, newest first.
+	Find(ctx context.Context, filter RecordingFilter) ([]*domain.AudioRecording, error)
+	// Delete removes a recording, or returns ErrNotFound.
+	Delete(ctx context.Context, id string) error
+}
+
+// RecordingFilter narrows AudioRecordingRepository.Find. Empty fields match
+// every recording.
+type RecordingFilter struct {
+	PatientReference   string
+	EncounterReference string
+	// ExpiredBy matches recordings due for deletion at that time.
+	ExpiredBy time.Time
+}
+
+// matches reports whether rec satisfies the filter.
+func (f RecordingFilter) matches(rec *domain.AudioRecording) bool {
+	if f.PatientReference != "" && rec.Encounter.PatientReference != f.PatientReference {
+		return false
+	}
+	if f.EncounterReference != "" && rec.Encounter.EncounterReference != f.EncounterReference {
+		return false
+	}
+	if !f.ExpiredBy.IsZero() && (rec.ExpiresAt.IsZero() || rec.ExpiresAt.After(f.ExpiredBy)) {
+		return false
+	}
+	return true
+}
+
+// where renders the filter as a SQL condition, numbering parameters with
+// placeholder (e.g. "$%d" for Postgres, "?" for SQLite).
+func (f RecordingFilter) where(placeholder func(n int) string) (string, []any) {
+	var conds []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, placeholder(len(args))))
+	}
+	if f.PatientReference != "" {
+		add("patient_reference = %s", f.PatientReference)
+	}
+	if f.EncounterReference != "" {
+		add("encounter_reference = %s", f.EncounterReference)
+	}
+	if !f.ExpiredBy.IsZero() {
+		add("expires_at <= %s", f.ExpiredBy.UTC())
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(conds, " AND "), args
+}
+
+const recordingColumns = `id, source, patient_reference, encounter_reference, practitioner_reference,
+	audio, size, sha256, data_key, created_at, expires_at`
+
+// recordingValues returns the column values of rec in recordingColumns
+// order.
+func recordingValues(rec *domain.AudioRecording) ([]any, error) {
+	audio, err := json.Marshal(rec.Audio)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal recording audio: %w", err)
+	}
+	var expires any
+	if !rec.ExpiresAt.IsZero() {
+		expires = rec.ExpiresAt.UTC()
+	}
+	return []any{
+		rec.ID, string(rec.Source),
+		rec.Encounter.PatientReference, rec.Encounter.EncounterReference, rec.Encounter.PractitionerReference,
+		string(audio), rec.Size, rec.SHA256, rec.DataKey, rec.CreatedAt.UTC(), expires,
+	}, nil
+}
+
+// scanRecording reads a row selected with recordingColumns.
+func scanRecording(scan func(dest ...any) error) (*domain.AudioRecording, error) {
+	var rec domain.AudioRecording
+	var source string
+	var audio []byte
+	var expires *time.Time
+	if err := scan(&rec.ID, &source,
+		&rec.Encounter.PatientReference, &rec.Encounter.EncounterReference, &rec.Encounter.PractitionerReference,
+		&audio, &rec.Size, &rec.SHA256, &rec.DataKey, &rec.CreatedAt, &expires,
+	); err != nil {
+		return nil, err
+	}
+	rec.Source = domain.RecordingSource(source)
+	if expires != nil {
+		rec.ExpiresAt = *expires
+	}
+	if err := json.Unmarshal(audio, &rec.Audio); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal recording audio: %w", err)
+	}
+	return &rec, nil
+}
+
+// PostgresRecordingRepository stores recording metadata in PostgreSQL.
+type PostgresRecordingRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresRecordingRepository creates a new Postgres-backed recording
+// repository.
+func NewPostgresRecordingRepository(db *pgxpool.Pool) *PostgresRecordingRepository {
+	return &PostgresRecordingRepository{db: db}
+}
+
+// Create inserts a new recording.
+func (r *PostgresRecordingRepository) Create(ctx context.Context, rec *domain.AudioRecording) error {
+	values, err := recordingValues(rec)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO audio_recordings (` + recordingColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+	if _, err := r.db.Exec(ctx, query, values...); err != nil {
+		return fmt.Errorf("failed to insert audio recording: %w", err)
+	}
+	return nil
+}
+
+// FindByID retrieves a single recording.
+func (r *PostgresRecordingRepository) FindByID(ctx context.Context, id string) (*domain.AudioRecording, error) {
+	row := r.db.QueryRow(ctx, `SELECT `+recordingColumns+` FROM audio_recordings WHERE id = $1`, id)
+	rec, err := scanRecording(row.Scan)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audio recording: %w", err)
+	}
+	return rec, nil
+}
+
+// Find retrieves the recordings matching filter, newest first.
+func (r *PostgresRecordingRepository) Find(ctx context.Context, filter RecordingFilter) ([]*domain.AudioRecording, error) {
+	where, args := filter.where(func(n int) string { return fmt.Sprintf("$%d", n) })
+	rows, err := r.db.Query(ctx, `SELECT `+recordingColumns+` FROM audio_recordings `+where+` ORDER BY created_at DESC, id DESC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audio recordings: %w", err)
+	}
+	defer rows.Close()
+	return scanAll(rows, scanRecording)
+}
+
+// Delete removes a recording.
+func (r *PostgresRecordingRepository) Delete(ctx context.Context, id string) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM audio_recordings WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete audio recording: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+
+	"clinical-agent-backend/internal/domain"
+)
+
+// MemoryRecordingRepository keeps recording metadata in process memory. It
+// is lost on restart, and with it the keys to the archived audio, so use it
+// only for tests and throwaway local runs.
+type MemoryRecordingRepository struct {
+	mu         sync.RWMutex
+	recordings []*domain.AudioRecording
+}
+
+// NewMemoryRecordingRepository creates an empty in-memory recording
+// repository.
+func NewMemoryRecordingRepository() *MemoryRecordingRepository {
+	return &MemoryRecordingRepository{}
+}
+
+// copyRecording returns a copy of rec that shares no mutable state with it.
+func copyRecording(rec *domain.AudioRecording) *domain.AudioRecording {
+	c := *rec
+	c.Audio.AlternativeLanguageCodes = slices.Clone(rec.Audio.AlternativeLanguageCodes)
+	c.Audio.PhraseSets = slices.Clone(rec.Audio.PhraseSets)
+	c.Audio.Speakers.Roles = maps.Clone(rec.Audio.Speakers.Roles)
+	c.DataKey = slices.Clone(rec.DataKey)
+	return &c
+}
+
+// Create stores a copy of the recording.
+func (r *MemoryRecordingRepository) Create(ctx context.Context, rec *domain.AudioRecording) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recordings = append(r.recordings, copyRecording(rec))
+	return nil
+}
+
+// FindByID retrieves a single recording.
+func (r *MemoryRecordingRepository) FindByID(ctx context.Context, id string) (*domain.AudioRecording, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, rec := range r.recordings {
+		if rec.ID == id {
+			return copyRecording(rec), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Find retrieves the recordings matching filter, newest first.
+func (r *MemoryRecordingRepository) Find(ctx context.Context, filter RecordingFilter) ([]*domain.AudioRecording, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var recordings []*domain.AudioRecording
+	for i := len(r.recordings) - 1; i >= 0; i-- {
+		if filter.matches(r.recordings[i]) {
+			recordings = append(recordings, copyRecording(r.recordings[i]))
+		}
+	}
+	return recordings, nil
+}
+
+// Delete removes a recording.
+func (r *MemoryRecordingRepository) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, rec := range r.recordings {
+		if rec.ID == id {
+			r.recordings = slices.Delete(r.recordings, i, i+1)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"clinical-agent-backend/internal/domain"
+)
+
+// SQLiteRecordingRepository stores recording metadata in SQLite.
+type SQLiteRecordingRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteRecordingRepository creates a new SQLite-backed recording
+// repository.
+func NewSQLiteRecordingRepository(db *sql.DB) *SQLiteRecordingRepository {
+	return &SQLiteRecordingRepository{db: db}
+}
+
+// Create inserts a new recording.
+func (r *SQLiteRecordingRepository) Create(ctx context.Context, rec *domain.AudioRecording) error {
+	values, err := recordingValues(rec)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO audio_recordings (` + recordingColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	if _, err := r.db.ExecContext(ctx, query, values...); err != nil {
+		return fmt.Errorf("failed to insert audio recording: %w", err)
+	}
+	return nil
+}
+
+// FindByID retrieves a single recording.
+func (r *SQLiteRecordingRepository) FindByID(ctx context.Context, id string) (*domain.AudioRecording, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+recordingColumns+` FROM audio_recordings WHERE id = ?`, id)
+	rec, err := scanRecording(row.Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audio recording: %w", err)
+	}
+	return rec, nil
+}
+
+// Find retrieves the recordings matching filter, newest first.
+func (r *SQLiteRecordingRepository) Find(ctx context.Context, filter RecordingFilter) ([]*domain.AudioRecording, error) {
+	where, args := filter.where(sqlitePlaceholder)
+	rows, err := r.db.QueryContext(ctx, `SELECT `+recordingColumns+` FROM audio_recordings `+where+` ORDER BY created_at DESC, id DESC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audio recordings: %w", err)
+	}
+	defer rows.Close()
+	return scanAll(rows, scanRecording)
+}
+
+// Delete removes a recording.
+func (r *SQLiteRecordingRepository) Delete(ctx context.Context, id string) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM audio_recordings WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete audio recording: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"clinical-agent-backend/internal/db"
+	"clinical-agent-backend/internal/domain"
+)
+
+// testRecordingRepositoryContract exercises the behaviour every
+// AudioRecordingRepository implementation must provide.
+func testRecordingRepositoryContract(t *testing.T, repo AudioRecordingRepository) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tag := fmt.Sprintf("%d", time.Now().UnixNano())
+	patient := "Patient/" + tag
+	created := time.Now().Truncate(time.Millisecond)
+	newRecording := func(id string, offset time.Duration) *domain.AudioRecording {
+		return &domain.AudioRecording{
+			ID:        tag + "-" + id,
+			Source:    domain.RecordingFromSession,
+			Encounter: domain.EncounterContext{PatientReference: patient, EncounterReference: "Encounter/" + id + tag},
+			Audio:     domain.JobAudio{Encoding: "LINEAR16", SampleRateHertz: 16000, Channels: 1, LanguageCode: "en-US"},
+			Size:      32000,
+			SHA256:    fmt.Sprintf("%064x", offset),
+			DataKey:   []byte{0, 1, 2, 0xff},
+			CreatedAt: created.Add(offset),
+		}
+	}
+	kept := newRecording("kept", 0)
+	kept.Audio.Speakers = domain.SpeakerConfig{Diarization: true, Roles: domain.SpeakerRoles{2: domain.RolePatient}}
+	expiring := newRecording("expiring", time.Second)
+	expiring.Source = domain.RecordingFromUpload
+	expiring.ExpiresAt = created.Add(time.Hour)
+
+	t.Run("Create and FindByID", func(t *testing.T) {
+		for _, rec := range []*domain.AudioRecording{kept, expiring} {
+			if err := repo.Create(ctx, rec); err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+		}
+		for _, want := range []*domain.AudioRecording{kept, expiring} {
+			got, err := repo.FindByID(ctx, want.ID)
+			if err != nil {
+				t.Fatalf("FindByID failed: %v", err)
+			}
+			if got.Source != want.Source || got.Encounter != want.Encounter || !reflect.DeepEqual(got.Audio, want.Audio) ||
+				got.Size != want.Size || got.SHA256 != want.SHA256 || !bytes.Equal(got.DataKey, want.DataKey) {
+				t.Errorf("expected %+v, got %+v", want, got)
+			}
+			if !got.CreatedAt.Equal(want.CreatedAt) || !got.ExpiresAt.Equal(want.ExpiresAt) {
+				t.Errorf("expected times %v/%v, got %v/%v", want.CreatedAt, want.ExpiresAt, got.CreatedAt, got.ExpiresAt)
+			}
+		}
+		if _, err := repo.FindByID(ctx, tag+"-missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+
+	ids := func(filter RecordingFilter) []string {
+		recordings, err := repo.Find(ctx, filter)
+		if err != nil {
+			t.Fatalf("Find failed: %v", err)
+		}
+		var ids []string
+		for _, rec := range recordings {
+			if rec.Encounter.PatientReference == patient {
+				ids = append(ids, rec.ID)
+			}
+		}
+		return ids
+	}
+
+	t.Run("Find", func(t *testing.T) {
+		if got := ids(RecordingFilter{PatientReference: patient}); !reflect.DeepEqual(got, []string{expiring.ID, kept.ID}) {
+			t.Errorf("expected newest first, got %v", got)
+		}
+		if got := ids(RecordingFilter{EncounterReference: kept.Encounter.EncounterReference}); !reflect.DeepEqual(got, []string{kept.ID}) {
+			t.Errorf("expected the encounter's recording, got %v", got)
+		}
+		if got := ids(RecordingFilter{ExpiredBy: created.Add(time.Minute)}); len(got) != 0 {
+			t.Errorf("expected nothing expired yet, got %v", got)
+		}
+		if got := ids(RecordingFilter{ExpiredBy: created.Add(2 * time.Hour)}); !reflect.DeepEqual(got, []string{expiring.ID}) {
+			t.Errorf("expected the expired recording, got %v", got)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := repo.Delete(ctx, expiring.ID); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if _, err := repo.FindByID(ctx, expiring.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected the recording to be deleted, got %v", err)
+		}
+		if err := repo.Delete(ctx, expiring.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+}
+
+func TestMemoryRecordingRepository_Contract(t *testing.T) {
+	testRecordingRepositoryContract(t, NewMemoryRecordingRepository())
+}
+
+func TestSQLiteRecordingRepository_Contract(t *testing.T) {
+	conn, err := db.NewSQLite(context.Background(), t.TempDir()+"/test.db")
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	defer conn.Close()
+
+	testRecordingRepositoryContract(t, NewSQLiteRecordingRepository(conn))
+}
+
+func TestPostgresRecordingRepository_Contract(t *testing.T) {
+	testRecordingRepositoryContract(t, NewPostgresRecordingRepository(testPostgresPool(t)))
+}
@@ -2,68 +2,87 @@ package repository
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/samply/golang-fhir-models/fhir-models/fhir"
 )
 
-// ClinicalImpressionRepository handles database operations for Clinical Impressions.
-type ClinicalImpressionRepository struct {
+// PostgresRepository handles PostgreSQL operations for Clinical Impressions.
+type PostgresRepository struct {
 	db *pgxpool.Pool
 }
 
-// NewClinicalImpressionRepository creates a new repository instance.
-func NewClinicalImpressionRepository(db *pgxpool.Pool) *ClinicalImpressionRepository {
-	return &ClinicalImpressionRepository{db: db}
+// NewPostgresRepository creates a new repository instance.
+func NewPostgresRepository(db *pgxpool.Pool) *PostgresRepository {
+	return &PostgresRepository{db: db}
 }
 
-// Save persists a FHIR ClinicalImpression resource to the database.
-func (r *ClinicalImpressionRepository) Save(ctx context.Context, impression *fhir.ClinicalImpression) error {
-	// Serialize FHIR resource to JSON
-	rawFHIR, err := json.Marshal(impression)
+// Save persists a FHIR ClinicalImpression resource to the database. An
+// impression already saved for the same job is kept as is.
+func (r *PostgresRepository) Save(ctx context.Context, impression *fhir.ClinicalImpression) error {
+	row, err := newImpressionRow(impression)
 	if err != nil {
-		return fmt.Errorf("failed to marshal FHIR resource: %w", err)
+		return err
 	}
 
-	// Extract fields for searchable columns (optional, but good for indexing later)
-	var patientID string
-	if impression.Subject.Reference != nil {
-		patientID = *impression.Subject.Reference
-	}
-
-	var description string
-	if impression.Summary != nil {
-		description = *impression.Summary
-	}
-
-	// Extract status from JSON because the struct field is an int enum
-	var tempMap map[string]interface{}
-	if err := json.Unmarshal(rawFHIR, &tempMap); err != nil {
-		return fmt.Errorf("failed to unmarshal raw FHIR for status extraction: %w", err)
-	}
-	status, _ := tempMap["status"].(string)
-
 	query := `
-		INSERT INTO clinical_impressions (patient_id, status, description, raw_fhir)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO clinical_impressions (patient_id, status, description, audio_recording_id, job_id, raw_fhir)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT DO NOTHING
 		RETURNING id
 	`
 
 	var id int
-	err = r.db.QueryRow(ctx, query, patientID, status, description, rawFHIR).Scan(&id)
+	err = r.db.QueryRow(ctx, query, row.patientID, row.status, row.description, row.audioRecordingID, row.jobID, row.rawFHIR).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = r.db.QueryRow(ctx, `SELECT id FROM clinical_impressions WHERE job_id = $1`, row.jobID).Scan(&id)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to insert clinical impression: %w", err)
 	}
 
+	idStr := strconv.Itoa(id)
+	impression.Id = &idStr
+	return nil
+}
+
+// Update replaces a saved FHIR ClinicalImpression resource.
+func (r *PostgresRepository) Update(ctx context.Context, impression *fhir.ClinicalImpression) error {
+	if impression.Id == nil {
+		return ErrNotFound
+	}
+	key, err := strconv.Atoi(*impression.Id)
+	if err != nil {
+		return ErrNotFound
+	}
+	row, err := newImpressionRow(impression)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE clinical_impressions
+		SET patient_id = $1, status = $2, description = $3, audio_recording_id = $4, raw_fhir = $5
+		WHERE id = $6
+	`
+	tag, err := r.db.Exec(ctx, query, row.patientID, row.status, row.description, row.audioRecordingID, row.rawFHIR, key)
+	if err != nil {
+		return fmt.Errorf("failed to update clinical impression: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
 	return nil
 }
 
 // FindAll retrieves all clinical impressions, ordered by ID descending.
-func (r *ClinicalImpressionRepository) FindAll(ctx context.Context) ([]*fhir.ClinicalImpression, error) {
+func (r *PostgresRepository) FindAll(ctx context.Context) ([]*fhir.ClinicalImpression, error) {
 	query := `
-		SELECT raw_fhir FROM clinical_impressions
+		SELECT id, raw_fhir FROM clinical_impressions
 		ORDER BY id DESC
 	`
 	rows, err := r.db.Query(ctx, query)
@@ -74,16 +93,17 @@ func (r *ClinicalImpressionRepository) FindAll(ctx context.Context) ([]*fhir.Cli
 
 	var impressions []*fhir.ClinicalImpression
 	for rows.Next() {
+		var id int
 		var rawFHIR []byte
-		if err := rows.Scan(&rawFHIR); err != nil {
+		if err := rows.Scan(&id, &rawFHIR); err != nil {
 			return nil, fmt.Errorf("failed to scan raw FHIR: %w", err)
 		}
 
-		var impression fhir.ClinicalImpression
-		if err := json.Unmarshal(rawFHIR, &impression); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal FHIR: %w", err)
+		impression, err := decodeImpression(strconv.Itoa(id), rawFHIR)
+		if err != nil {
+			return nil, err
 		}
-		impressions = append(impressions, &impression)
+		impressions = append(impressions, impression)
 	}
 
 	if rows.Err() != nil {
@@ -92,3 +112,22 @@ func (r *ClinicalImpressionRepository) FindAll(ctx context.Context) ([]*fhir.Cli
 
 	return impressions, nil
 }
+
+// FindByID retrieves a single clinical impression by its database ID.
+func (r *PostgresRepository) FindByID(ctx context.Context, id string) (*fhir.ClinicalImpression, error) {
+	key, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	var rawFHIR []byte
+	err = r.db.QueryRow(ctx, `SELECT raw_fhir FROM clinical_impressions WHERE id = $1`, key).Scan(&rawFHIR)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query clinical impression: %w", err)
+	}
+
+	return decodeImpression(id, rawFHIR)
+}
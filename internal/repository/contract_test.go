@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"clinical-agent-backend/internal/db"
+	"clinical-agent-backend/internal/ehr"
+
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+)
+
+// testRepositoryContract exercises the behaviour every
+// ClinicalImpressionRepository implementation must provide. The backend may
+// already contain rows, so assertions only concern records saved here.
+func testRepositoryContract(t *testing.T, repo ClinicalImpressionRepository) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	newImpression := func(summary string) *fhir.ClinicalImpression {
+		now := time.Now().Format(time.RFC3339)
+		subject := "Patient/contract"
+		return &fhir.ClinicalImpression{
+			Status:  fhir.ClinicalImpressionStatusCompleted,
+			Date:    &now,
+			Summary: &summary,
+			Subject: fhir.Reference{Reference: &subject},
+		}
+	}
+
+	tag := fmt.Sprintf("contract-%d", time.Now().UnixNano())
+	first := newImpression(tag + "-first")
+	second := newImpression(tag + "-second")
+
+	t.Run("Save assigns IDs", func(t *testing.T) {
+		for _, impression := range []*fhir.ClinicalImpression{first, second} {
+			if err := repo.Save(ctx, impression); err != nil {
+				t.Fatalf("Save failed: %v", err)
+			}
+			if impression.Id == nil || *impression.Id == "" {
+				t.Fatal("expected Save to set Id")
+			}
+		}
+		if *first.Id == *second.Id {
+			t.Errorf("expected distinct IDs, both were %s", *first.Id)
+		}
+	})
+
+	t.Run("Save once per job", func(t *testing.T) {
+		saved := newImpression(tag + "-job")
+		ehr.LinkJob(saved, tag)
+		if err := repo.Save(ctx, saved); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		again := newImpression(tag + "-job-again")
+		ehr.LinkJob(again, tag)
+		if err := repo.Save(ctx, again); err != nil {
+			t.Fatalf("saving again failed: %v", err)
+		}
+		if again.Id == nil || *again.Id != *saved.Id {
+			t.Fatalf("expected the impression saved for the job (%s), got %v", *saved.Id, again.Id)
+		}
+		got, err := repo.FindByID(ctx, *saved.Id)
+		if err != nil || got.Summary == nil || *got.Summary != tag+"-job" {
+			t.Errorf("expected the first save to be kept, got %v (%v)", got, err)
+		}
+	})
+
+	t.Run("FindByID round-trips", func(t *testing.T) {
+		got, err := repo.FindByID(ctx, *second.Id)
+		if err != nil {
+			t.Fatalf("FindByID failed: %v", err)
+		}
+		if got.Id == nil || *got.Id != *second.Id {
+			t.Errorf("expected Id %s, got %v", *second.Id, got.Id)
+		}
+		if got.Summary == nil || *got.Summary != *second.Summary {
+			t.Errorf("expected summary %q, got %v", *second.Summary, got.Summary)
+		}
+		if got.Status != fhir.ClinicalImpressionStatusCompleted {
+			t.Errorf("expected status completed, got %v", got.Status)
+		}
+		if got.Subject.Reference == nil || *got.Subject.Reference != "Patient/contract" {
+			t.Errorf("unexpected subject: %+v", got.Subject)
+		}
+	})
+
+	t.Run("Update replaces", func(t *testing.T) {
+		updated := newImpression(tag + "-first-updated")
+		updated.Id = first.Id
+		updated.Status = fhir.ClinicalImpressionStatusInProgress
+		if err := repo.Update(ctx, updated); err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+		got, err := repo.FindByID(ctx, *first.Id)
+		if err != nil {
+			t.Fatalf("FindByID failed: %v", err)
+		}
+		if got.Summary == nil || *got.Summary != *updated.Summary || got.Status != fhir.ClinicalImpressionStatusInProgress {
+			t.Errorf("expected the updated impression, got summary %v status %v", got.Summary, got.Status)
+		}
+
+		for _, id := range []string{"0", "not-an-id"} {
+			missing := newImpression(tag + "-missing")
+			missing.Id = &id
+			if err := repo.Update(ctx, missing); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Update(%q): expected ErrNotFound, got %v", id, err)
+			}
+		}
+	})
+
+	t.Run("FindByID missing", func(t *testing.T) {
+		for _, id := range []string{"0", "not-an-id"} {
+			if _, err := repo.FindByID(ctx, id); !errors.Is(err, ErrNotFound) {
+				t.Errorf("FindByID(%q): expected ErrNotFound, got %v", id, err)
+			}
+		}
+	})
+
+	t.Run("FindAll newest first", func(t *testing.T) {
+		all, err := repo.FindAll(ctx)
+		if err != nil {
+			t.Fatalf("FindAll failed: %v", err)
+		}
+		firstIdx, secondIdx := -1, -1
+		for i, impression := range all {
+			if impression.Id == nil {
+				t.Fatalf("FindAll returned impression without Id")
+			}
+			switch *impression.Id {
+			case *first.Id:
+				firstIdx = i
+			case *second.Id:
+				secondIdx = i
+			}
+		}
+		if firstIdx < 0 || secondIdx < 0 {
+			t.Fatalf("saved impressions missing from FindAll (first=%d, second=%d)", firstIdx, secondIdx)
+		}
+		if secondIdx > firstIdx {
+			t.Errorf("expected newest impression first, got first=%d second=%d", firstIdx, secondIdx)
+		}
+	})
+}
+
+func TestMemoryRepository_Contract(t *testing.T) {
+	testRepositoryContract(t, NewMemoryRepository())
+}
+
+func TestSQLiteRepository_Contract(t *testing.T) {
+	conn, err := db.NewSQLite(context.Background(), t.TempDir()+"/test.db")
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	defer conn.Close()
+
+	testRepositoryContract(t, NewSQLiteRepository(conn))
+}
+
+func TestPostgresRepository_Contract(t *testing.T) {
+	testRepositoryContract(t, NewPostgresRepository(testPostgresPool(t)))
+}
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+)
+
+// MemoryRepository keeps Clinical Impressions in process memory. It is meant
+// for tests and throwaway local runs; data is lost on restart.
+type MemoryRepository struct {
+	mu     sync.RWMutex
+	nextID int
+	rows   []memoryRow
+}
+
+type memoryRow struct {
+	id      string
+	jobID   string
+	rawFHIR []byte
+}
+
+// NewMemoryRepository creates an empty in-memory repository.
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{nextID: 1}
+}
+
+// Save stores a serialized copy of the impression.
+func (r *MemoryRepository) Save(ctx context.Context, impression *fhir.ClinicalImpression) error {
+	row, err := newImpressionRow(impression)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.rows {
+		if row.jobID != "" && existing.jobID == row.jobID {
+			impression.Id = &existing.id
+			return nil
+		}
+	}
+	id := strconv.Itoa(r.nextID)
+	r.nextID++
+	r.rows = append(r.rows, memoryRow{id: id, jobID: row.jobID, rawFHIR: row.rawFHIR})
+
+	impression.Id = &id
+	return nil
+}
+
+// Update replaces the stored copy of the impression.
+func (r *MemoryRepository) Update(ctx context.Context, impression *fhir.ClinicalImpression) error {
+	if impression.Id == nil {
+		return ErrNotFound
+	}
+	row, err := newImpressionRow(impression)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.rows {
+		if r.rows[i].id == *impression.Id {
+			r.rows[i].rawFHIR = row.rawFHIR
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// FindAll retrieves all clinical impressions, newest first.
+func (r *MemoryRepository) FindAll(ctx context.Context) ([]*fhir.ClinicalImpression, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	impressions := make([]*fhir.ClinicalImpression, 0, len(r.rows))
+	for i := len(r.rows) - 1; i >= 0; i-- {
+		impression, err := decodeImpression(r.rows[i].id, r.rows[i].rawFHIR)
+		if err != nil {
+			return nil, err
+		}
+		impressions = append(impressions, impression)
+	}
+	return impressions, nil
+}
+
+// FindByID retrieves a single clinical impression.
+func (r *MemoryRepository) FindByID(ctx context.Context, id string) (*fhir.ClinicalImpression, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, row := range r.rows {
+		if row.id == id {
+			return decodeImpression(row.id, row.rawFHIR)
+		}
+	}
+	return nil, ErrNotFound
+}
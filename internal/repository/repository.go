@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"clinical-agent-backend/internal/ehr"
+
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+)
+
+// ErrNotFound is returned when a requested record does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ClinicalImpressionRepository persists and queries FHIR ClinicalImpression
+// resources. PostgresRepository, SQLiteRepository and MemoryRepository
+// implement it.
+type ClinicalImpressionRepository interface {
+	// Save persists a new impression and sets its Id to the assigned key.
+	// An impression linked to a job (ehr.LinkJob) that already has one is
+	// not saved again; Id is set to the existing impression's key.
+	Save(ctx context.Context, impression *fhir.ClinicalImpression) error
+	// Update replaces the saved impression with impression's Id, or
+	// returns ErrNotFound.
+	Update(ctx context.Context, impression *fhir.ClinicalImpression) error
+	// FindAll retrieves all impressions, newest first.
+	FindAll(ctx context.Context) ([]*fhir.ClinicalImpression, error)
+	// FindByID retrieves a single impression, or ErrNotFound.
+	FindByID(ctx context.Context, id string) (*fhir.ClinicalImpression, error)
+}
+
+// impressionRow holds the serialized resource and its searchable columns.
+type impressionRow struct {
+	patientID   string
+	status      string
+	description string
+	// audioRecordingID links the impression to its archived audio.
+	audioRecordingID string
+	// jobID is the transcription job the impression was saved for.
+	jobID   string
+	rawFHIR []byte
+}
+
+// newImpressionRow serializes an impression and extracts the fields stored
+// in searchable columns.
+func newImpressionRow(impression *fhir.ClinicalImpression) (*impressionRow, error) {
+	// Serialize FHIR resource to JSON
+	rawFHIR, err := json.Marshal(impression)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal FHIR resource: %w", err)
+	}
+
+	row := &impressionRow{rawFHIR: rawFHIR, audioRecordingID: ehr.RecordingID(impression), jobID: ehr.JobID(impression)}
+	if impression.Subject.Reference != nil {
+		row.patientID = *impression.Subject.Reference
+	}
+	if impression.Summary != nil {
+		row.description = *impression.Summary
+	}
+
+	// Extract status from JSON because the struct field is an int enum
+	var tempMap map[string]interface{}
+	if err := json.Unmarshal(rawFHIR, &tempMap); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal raw FHIR for status extraction: %w", err)
+	}
+	row.status, _ = tempMap["status"].(string)
+
+	return row, nil
+}
+
+// decodeImpression unmarshals a stored resource and stamps it with its key.
+func decodeImpression(id string, rawFHIR []byte) (*fhir.ClinicalImpression, error) {
+	var impression fhir.ClinicalImpression
+	if err := json.Unmarshal(rawFHIR, &impression); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal FHIR: %w", err)
+	}
+	impression.Id = &id
+	return &impression, nil
+}
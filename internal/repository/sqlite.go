@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/samply/golang-fhir-models/fhir-models/fhir"
+)
+
+// SQLiteRepository stores Clinical Impressions in SQLite, for single-node
+// clinics and developer laptops. Open the database with db.NewSQLite.
+type SQLiteRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteRepository creates a new SQLite-backed repository.
+func NewSQLiteRepository(db *sql.DB) *SQLiteRepository {
+	return &SQLiteRepository{db: db}
+}
+
+// Save persists a FHIR ClinicalImpression resource to the database. An
+// impression already saved for the same job is kept as is.
+func (r *SQLiteRepository) Save(ctx context.Context, impression *fhir.ClinicalImpression) error {
+	row, err := newImpressionRow(impression)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO clinical_impressions (patient_id, status, description, audio_recording_id, job_id, raw_fhir)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING
+		RETURNING id
+	`
+	var id int64
+	err = r.db.QueryRowContext(ctx, query, row.patientID, row.status, row.description, row.audioRecordingID, row.jobID, string(row.rawFHIR)).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = r.db.QueryRowContext(ctx, `SELECT id FROM clinical_impressions WHERE job_id = ?`, row.jobID).Scan(&id)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to insert clinical impression: %w", err)
+	}
+
+	idStr := strconv.FormatInt(id, 10)
+	impression.Id = &idStr
+	return nil
+}
+
+// Update replaces a saved FHIR ClinicalImpression resource.
+func (r *SQLiteRepository) Update(ctx context.Context, impression *fhir.ClinicalImpression) error {
+	if impression.Id == nil {
+		return ErrNotFound
+	}
+	key, err := strconv.ParseInt(*impression.Id, 10, 64)
+	if err != nil {
+		return ErrNotFound
+	}
+	row, err := newImpressionRow(impression)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE clinical_impressions
+		SET patient_id = ?, status = ?, description = ?, audio_recording_id = ?, raw_fhir = ?
+		WHERE id = ?
+	`
+	res, err := r.db.ExecContext(ctx, query, row.patientID, row.status, row.description, row.audioRecordingID, string(row.rawFHIR), key)
+	if err != nil {
+		return fmt.Errorf("failed to update clinical impression: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to read updated clinical impression count: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// FindAll retrieves all clinical impressions, ordered by ID descending.
+func (r *SQLiteRepository) FindAll(ctx context.Context) ([]*fhir.ClinicalImpression, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT id, raw_fhir FROM clinical_impressions ORDER BY id DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query clinical impressions: %w", err)
+	}
+	defer rows.Close()
+
+	var impressions []*fhir.ClinicalImpression
+	for rows.Next() {
+		var id int64
+		var rawFHIR string
+		if err := rows.Scan(&id, &rawFHIR); err != nil {
+			return nil, fmt.Errorf("failed to scan raw FHIR: %w", err)
+		}
+
+		impression, err := decodeImpression(strconv.FormatInt(id, 10), []byte(rawFHIR))
+		if err != nil {
+			return nil, err
+		}
+		impressions = append(impressions, impression)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return impressions, nil
+}
+
+// FindByID retrieves a single clinical impression by its database ID.
+func (r *SQLiteRepository) FindByID(ctx context.Context, id string) (*fhir.ClinicalImpression, error) {
+	key, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	var rawFHIR string
+	err = r.db.QueryRowContext(ctx, `SELECT raw_fhir FROM clinical_impressions WHERE id = ?`, key).Scan(&rawFHIR)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query clinical impression: %w", err)
+	}
+
+	return decodeImpression(id, []byte(rawFHIR))
+}
@@ -0,0 +1,358 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"clinical-agent-backend/internal/domain"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TranscriptionJobRepository persists upload transcription jobs.
+// PostgresJobRepository, SQLiteJobRepository and MemoryJobRepository
+// implement it.
+type TranscriptionJobRepository interface {
+	// Create stores a new job and queues tasks on it in the same
+	// transaction, setting their IDs. The caller assigns the job's ID.
+	Create(ctx context.Context, job *domain.TranscriptionJob, tasks ...*domain.Task) error
+	// Update overwrites a stored job, or returns ErrNotFound.
+	Update(ctx context.Context, job *domain.TranscriptionJob) error
+	// FindByID retrieves a single job, or ErrNotFound.
+	FindByID(ctx context.Context, id string) (*domain.TranscriptionJob, error)
+	// Find retrieves the jobs matching filter, newest first.
+	Find(ctx context.Context, filter JobFilter) ([]*domain.TranscriptionJob, error)
+	// Queue returns the work queue of tasks on the jobs.
+	Queue() WorkQueue
+}
+
+// JobFilter narrows TranscriptionJobRepository.Find. Empty fields match
+// every job.
+type JobFilter struct {
+	IDs                []string
+	PatientReference   string
+	EncounterReference string
+	Statuses           []domain.JobStatus
+	// CreatedFrom and CreatedTo bound the creation time, inclusive and
+	// exclusive respectively.
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// Originals selects only the jobs that are not reprocessing another.
+	Originals bool
+	// ReprocessOf selects the jobs reprocessing the given original job.
+	ReprocessOf string
+}
+
+// matches reports whether job satisfies the filter.
+func (f JobFilter) matches(job *domain.TranscriptionJob) bool {
+	if len(f.IDs) > 0 && !slices.Contains(f.IDs, job.ID) {
+		return false
+	}
+	if !f.CreatedFrom.IsZero() && job.CreatedAt.Before(f.CreatedFrom) {
+		return false
+	}
+	if !f.CreatedTo.IsZero() && !job.CreatedAt.Before(f.CreatedTo) {
+		return false
+	}
+	if f.Originals && job.ReprocessOf != "" {
+		return false
+	}
+	if f.ReprocessOf != "" && job.ReprocessOf != f.ReprocessOf {
+		return false
+	}
+	if f.PatientReference != "" && job.Encounter.PatientReference != f.PatientReference {
+		return false
+	}
+	if f.EncounterReference != "" && job.Encounter.EncounterReference != f.EncounterReference {
+		return false
+	}
+	if len(f.Statuses) == 0 {
+		return true
+	}
+	for _, s := range f.Statuses {
+		if job.Status == s {
+			return true
+		}
+	}
+	return false
+}
+
+// where renders the filter as a SQL condition, numbering parameters with
+// placeholder (e.g. "$%d" for Postgres, "?" for SQLite).
+func (f JobFilter) where(placeholder func(n int) string) (string, []any) {
+	var conds []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, placeholder(len(args))))
+	}
+	if len(f.IDs) > 0 {
+		var in []string
+		for _, id := range f.IDs {
+			args = append(args, id)
+			in = append(in, placeholder(len(args)))
+		}
+		conds = append(conds, "id IN ("+strings.Join(in, ", ")+")")
+	}
+	if f.PatientReference != "" {
+		add("patient_reference = %s", f.PatientReference)
+	}
+	if f.EncounterReference != "" {
+		add("encounter_reference = %s", f.EncounterReference)
+	}
+	if len(f.Statuses) > 0 {
+		var in []string
+		for _, s := range f.Statuses {
+			args = append(args, string(s))
+			in = append(in, placeholder(len(args)))
+		}
+		conds = append(conds, "status IN ("+strings.Join(in, ", ")+")")
+	}
+	if !f.CreatedFrom.IsZero() {
+		add("created_at >= %s", f.CreatedFrom.UTC())
+	}
+	if !f.CreatedTo.IsZero() {
+		add("created_at < %s", f.CreatedTo.UTC())
+	}
+	if f.Originals {
+		conds = append(conds, "reprocess_of = ''")
+	}
+	if f.ReprocessOf != "" {
+		add("reprocess_of = %s", f.ReprocessOf)
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(conds, " AND "), args
+}
+
+const jobColumns = `id, status, patient_reference, encounter_reference, practitioner_reference,
+	encoding, sample_rate_hertz, channels, language_code, alternative_language_codes, speakers,
+	model, use_enhanced, phrase_sets, audio_path,
+	transcript, segments, note, impression_id, error, created_at, updated_at,
+	source, recording_id, reprocess_of, version`
+
+// marshalNote serializes the job's note, or returns nil if it has none.
+func marshalNote(job *domain.TranscriptionJob) ([]byte, error) {
+	if job.Note == nil {
+		return nil, nil
+	}
+	note, err := json.Marshal(job.Note)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal clinical note: %w", err)
+	}
+	return note, nil
+}
+
+// marshalSegments serializes the job's transcript segments, or returns nil
+// if it has none.
+func marshalSegments(job *domain.TranscriptionJob) ([]byte, error) {
+	if len(job.Segments) == 0 {
+		return nil, nil
+	}
+	segments, err := json.Marshal(job.Segments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal transcript segments: %w", err)
+	}
+	return segments, nil
+}
+
+// marshalSpeakers serializes the job's speaker configuration, or returns
+// nil if it is the default.
+func marshalSpeakers(job *domain.TranscriptionJob) ([]byte, error) {
+	speakers, err := json.Marshal(job.Audio.Speakers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal speaker configuration: %w", err)
+	}
+	if string(speakers) == "{}" {
+		return nil, nil
+	}
+	return speakers, nil
+}
+
+// marshalJobDocuments serializes the JSON columns of a job that change as
+// it progresses.
+func marshalJobDocuments(job *domain.TranscriptionJob) (segments, note []byte, err error) {
+	if segments, err = marshalSegments(job); err != nil {
+		return nil, nil, err
+	}
+	if note, err = marshalNote(job); err != nil {
+		return nil, nil, err
+	}
+	return segments, note, nil
+}
+
+// jobValues returns the column values of job in jobColumns order.
+func jobValues(job *domain.TranscriptionJob, speakers, segments, note any) []any {
+	return []any{
+		job.ID, string(job.Status),
+		job.Encounter.PatientReference, job.Encounter.EncounterReference, job.Encounter.PractitionerReference,
+		job.Audio.Encoding, job.Audio.SampleRateHertz, job.Audio.Channels, job.Audio.LanguageCode,
+		strings.Join(job.Audio.AlternativeLanguageCodes, ","), speakers,
+		job.Audio.Model, job.Audio.UseEnhanced, strings.Join(job.Audio.PhraseSets, ","), job.AudioPath,
+		job.Transcript, segments, note, job.ImpressionID, job.Error, job.CreatedAt.UTC(), job.UpdatedAt.UTC(),
+		string(job.Source), job.RecordingID, job.ReprocessOf, job.Version,
+	}
+}
+
+// jobUpdateValues returns the ID of job followed by the columns that change
+// as it progresses, for the UPDATE statements.
+func jobUpdateValues(job *domain.TranscriptionJob, segments, note any) []any {
+	return []any{
+		job.ID, string(job.Status), job.AudioPath, job.Transcript, segments,
+		note, job.ImpressionID, job.Error, job.UpdatedAt.UTC(),
+	}
+}
+
+// scanJob reads a row selected with jobColumns.
+func scanJob(scan func(dest ...any) error) (*domain.TranscriptionJob, error) {
+	var job domain.TranscriptionJob
+	var status, source string
+	var alternativeLanguages, phraseSets string
+	var speakers, segments, note []byte
+	if err := scan(&job.ID, &status,
+		&job.Encounter.PatientReference, &job.Encounter.EncounterReference, &job.Encounter.PractitionerReference,
+		&job.Audio.Encoding, &job.Audio.SampleRateHertz, &job.Audio.Channels, &job.Audio.LanguageCode,
+		&alternativeLanguages, &speakers,
+		&job.Audio.Model, &job.Audio.UseEnhanced, &phraseSets, &job.AudioPath,
+		&job.Transcript, &segments, &note, &job.ImpressionID, &job.Error, &job.CreatedAt, &job.UpdatedAt,
+		&source, &job.RecordingID, &job.ReprocessOf, &job.Version,
+	); err != nil {
+		return nil, err
+	}
+	job.Status = domain.JobStatus(status)
+	job.Source = domain.RecordingSource(source)
+	if alternativeLanguages != "" {
+		job.Audio.AlternativeLanguageCodes = strings.Split(alternativeLanguages, ",")
+	}
+	if phraseSets != "" {
+		job.Audio.PhraseSets = strings.Split(phraseSets, ",")
+	}
+	if len(speakers) > 0 {
+		if err := json.Unmarshal(speakers, &job.Audio.Speakers); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal speaker configuration: %w", err)
+		}
+	}
+	if len(segments) > 0 {
+		if err := json.Unmarshal(segments, &job.Segments); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal transcript segments: %w", err)
+		}
+	}
+	if len(note) > 0 {
+		job.Note = &domain.ClinicalNote{}
+		if err := json.Unmarshal(note, job.Note); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal clinical note: %w", err)
+		}
+	}
+	return &job, nil
+}
+
+// PostgresJobRepository stores transcription jobs in PostgreSQL.
+type PostgresJobRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresJobRepository creates a new Postgres-backed job repository.
+func NewPostgresJobRepository(db *pgxpool.Pool) *PostgresJobRepository {
+	return &PostgresJobRepository{db: db}
+}
+
+// Create inserts a new job and its tasks.
+func (r *PostgresJobRepository) Create(ctx context.Context, job *domain.TranscriptionJob, tasks ...*domain.Task) error {
+	speakers, err := marshalSpeakers(job)
+	if err != nil {
+		return err
+	}
+	segments, note, err := marshalJobDocuments(job)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO transcription_jobs (` + jobColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22,
+			$23, $24, $25, $26)`
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, query, jobValues(job, speakers, segments, note)...); err != nil {
+		return fmt.Errorf("failed to insert transcription job: %w", err)
+	}
+	for _, task := range tasks {
+		if err := insertPostgresTask(ctx, tx, task); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transcription job: %w", err)
+	}
+	return nil
+}
+
+// Update overwrites every mutable column of a job.
+func (r *PostgresJobRepository) Update(ctx context.Context, job *domain.TranscriptionJob) error {
+	segments, note, err := marshalJobDocuments(job)
+	if err != nil {
+		return err
+	}
+	query := `
+		UPDATE transcription_jobs SET status = $2, audio_path = $3, transcript = $4, segments = $5,
+			note = $6, impression_id = $7, error = $8, updated_at = $9
+		WHERE id = $1
+	`
+	tag, err := r.db.Exec(ctx, query, jobUpdateValues(job, segments, note)...)
+	if err != nil {
+		return fmt.Errorf("failed to update transcription job: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// FindByID retrieves a single job.
+func (r *PostgresJobRepository) FindByID(ctx context.Context, id string) (*domain.TranscriptionJob, error) {
+	row := r.db.QueryRow(ctx, `SELECT `+jobColumns+` FROM transcription_jobs WHERE id = $1`, id)
+	job, err := scanJob(row.Scan)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transcription job: %w", err)
+	}
+	return job, nil
+}
+
+// Queue returns the work queue in the same database.
+func (r *PostgresJobRepository) Queue() WorkQueue {
+	return &PostgresWorkQueue{db: r.db}
+}
+
+// Find retrieves the jobs matching filter, newest first.
+func (r *PostgresJobRepository) Find(ctx context.Context, filter JobFilter) ([]*domain.TranscriptionJob, error) {
+	where, args := filter.where(func(n int) string { return fmt.Sprintf("$%d", n) })
+	rows, err := r.db.Query(ctx, `SELECT `+jobColumns+` FROM transcription_jobs `+where+` ORDER BY created_at DESC, id DESC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transcription jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []*domain.TranscriptionJob
+	for rows.Next() {
+		job, err := scanJob(rows.Scan)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan transcription job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", rows.Err())
+	}
+	return jobs, nil
+}
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+
+	"clinical-agent-backend/internal/domain"
+)
+
+// MemoryJobRepository keeps transcription jobs and their work queue in
+// process memory. Jobs are lost on restart, so use it only for tests and
+// throwaway local runs.
+type MemoryJobRepository struct {
+	mu         sync.RWMutex
+	jobs       []*domain.TranscriptionJob
+	tasks      []*domain.Task
+	nextTaskID int64
+}
+
+// NewMemoryJobRepository creates an empty in-memory job repository.
+func NewMemoryJobRepository() *MemoryJobRepository {
+	return &MemoryJobRepository{}
+}
+
+// copyJob returns a copy of job that shares no mutable state with it.
+func copyJob(job *domain.TranscriptionJob) *domain.TranscriptionJob {
+	c := *job
+	c.Audio.AlternativeLanguageCodes = slices.Clone(job.Audio.AlternativeLanguageCodes)
+	c.Audio.PhraseSets = slices.Clone(job.Audio.PhraseSets)
+	c.Audio.Speakers.Roles = maps.Clone(job.Audio.Speakers.Roles)
+	c.Segments = append([]domain.TranscriptSegment(nil), job.Segments...)
+	if job.Note != nil {
+		note := *job.Note
+		c.Note = &note
+	}
+	return &c
+}
+
+// Create stores a copy of the job and its tasks.
+func (r *MemoryJobRepository) Create(ctx context.Context, job *domain.TranscriptionJob, tasks ...*domain.Task) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.jobs = append(r.jobs, copyJob(job))
+	for _, task := range tasks {
+		r.enqueue(task)
+	}
+	return nil
+}
+
+// Queue returns a work queue over the repository's tasks.
+func (r *MemoryJobRepository) Queue() WorkQueue {
+	return &memoryWorkQueue{r: r}
+}
+
+// Update replaces the stored copy of the job.
+func (r *MemoryJobRepository) Update(ctx context.Context, job *domain.TranscriptionJob) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, stored := range r.jobs {
+		if stored.ID == job.ID {
+			updated := copyJob(job)
+			updated.CreatedAt = stored.CreatedAt
+			updated.Encounter = stored.Encounter
+			updated.Audio = stored.Audio
+			updated.Source = stored.Source
+			updated.RecordingID = stored.RecordingID
+			updated.ReprocessOf = stored.ReprocessOf
+			updated.Version = stored.Version
+			r.jobs[i] = updated
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// FindByID retrieves a single job.
+func (r *MemoryJobRepository) FindByID(ctx context.Context, id string) (*domain.TranscriptionJob, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, job := range r.jobs {
+		if job.ID == id {
+			return copyJob(job), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Find retrieves the jobs matching filter, newest first.
+func (r *MemoryJobRepository) Find(ctx context.Context, filter JobFilter) ([]*domain.TranscriptionJob, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var jobs []*domain.TranscriptionJob
+	for i := len(r.jobs) - 1; i >= 0; i-- {
+		if filter.matches(r.jobs[i]) {
+			jobs = append(jobs, copyJob(r.jobs[i]))
+		}
+	}
+	return jobs, nil
+}
@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"clinical-agent-backend/internal/domain"
+)
+
+// SQLiteJobRepository stores transcription jobs in SQLite.
+type SQLiteJobRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteJobRepository creates a new SQLite-backed job repository.
+func NewSQLiteJobRepository(db *sql.DB) *SQLiteJobRepository {
+	return &SQLiteJobRepository{db: db}
+}
+
+func sqlitePlaceholder(int) string { return "?" }
+
+// Create inserts a new job and its tasks.
+func (r *SQLiteJobRepository) Create(ctx context.Context, job *domain.TranscriptionJob, tasks ...*domain.Task) error {
+	speakers, err := marshalSpeakers(job)
+	if err != nil {
+		return err
+	}
+	segments, note, err := marshalJobDocuments(job)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO transcription_jobs (` + jobColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, query, jobValues(job, sqliteText(speakers), sqliteText(segments), sqliteText(note))...); err != nil {
+		return fmt.Errorf("failed to insert transcription job: %w", err)
+	}
+	for _, task := range tasks {
+		if err := insertSQLiteTask(ctx, tx, task); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transcription job: %w", err)
+	}
+	return nil
+}
+
+// Queue returns the work queue in the same database.
+func (r *SQLiteJobRepository) Queue() WorkQueue {
+	return &SQLiteWorkQueue{db: r.db}
+}
+
+// Update overwrites every mutable column of a job.
+func (r *SQLiteJobRepository) Update(ctx context.Context, job *domain.TranscriptionJob) error {
+	segments, note, err := marshalJobDocuments(job)
+	if err != nil {
+		return err
+	}
+	query := `
+		UPDATE transcription_jobs SET status = ?2, audio_path = ?3, transcript = ?4, segments = ?5,
+			note = ?6, impression_id = ?7, error = ?8, updated_at = ?9
+		WHERE id = ?1
+	`
+	res, err := r.db.ExecContext(ctx, query, jobUpdateValues(job, sqliteText(segments), sqliteText(note))...)
+	if err != nil {
+		return fmt.Errorf("failed to update transcription job: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// sqliteText stores a JSON document as TEXT, or NULL if there is none.
+func sqliteText(doc []byte) any {
+	if doc == nil {
+		return nil
+	}
+	return string(doc)
+}
+
+// FindByID retrieves a single job.
+func (r *SQLiteJobRepository) FindByID(ctx context.Context, id string) (*domain.TranscriptionJob, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+jobColumns+` FROM transcription_jobs WHERE id = ?`, id)
+	job, err := scanJob(row.Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transcription job: %w", err)
+	}
+	return job, nil
+}
+
+// Find retrieves the jobs matching filter, newest first.
+func (r *SQLiteJobRepository) Find(ctx context.Context, filter JobFilter) ([]*domain.TranscriptionJob, error) {
+	where, args := filter.where(sqlitePlaceholder)
+	rows, err := r.db.QueryContext(ctx, `SELECT `+jobColumns+` FROM transcription_jobs `+where+` ORDER BY created_at DESC, rowid DESC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transcription jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []*domain.TranscriptionJob
+	for rows.Next() {
+		job, err := scanJob(rows.Scan)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan transcription job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return jobs, nil
+}
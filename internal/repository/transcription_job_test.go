@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"clinical-agent-backend/internal/db"
+	"clinical-agent-backend/internal/domain"
+)
+
+// testJobRepositoryContract exercises the behaviour every
+// TranscriptionJobRepository implementation must provide.
+func testJobRepositoryContract(t *testing.T, repo TranscriptionJobRepository) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tag := fmt.Sprintf("%d", time.Now().UnixNano())
+	patient := "Patient/" + tag
+	created := time.Now().Truncate(time.Millisecond)
+	newJob := func(id, encounter string, offset time.Duration) *domain.TranscriptionJob {
+		return &domain.TranscriptionJob{
+			ID:      tag + "-" + id,
+			Status:  domain.JobQueued,
+			Source:  domain.RecordingFromUpload,
+			Version: 1,
+			Encounter: domain.EncounterContext{
+				PatientReference:   patient,
+				EncounterReference: encounter,
+			},
+			Audio:     domain.JobAudio{Encoding: "LINEAR16", SampleRateHertz: 16000, Channels: 1, LanguageCode: "en-US"},
+			AudioPath: "/spool/" + id,
+			CreatedAt: created.Add(offset),
+			UpdatedAt: created.Add(offset),
+		}
+	}
+	first := newJob("first", "Encounter/a"+tag, 0)
+	first.Audio.AlternativeLanguageCodes = []string{"es-US", "fr"}
+	first.Audio.Speakers = domain.SpeakerConfig{Diarization: true, MaxSpeakers: 3, Roles: domain.SpeakerRoles{1: domain.RolePatient}}
+	first.Audio.Model = "medical_conversation"
+	first.Audio.PhraseSets = []string{"formulary", "clinicians"}
+	first.RecordingID = first.ID
+	second := newJob("second", "Encounter/b"+tag, time.Second)
+	second.Source = domain.RecordingFromSession
+	second.ReprocessOf = first.ID
+	second.Version = 2
+
+	t.Run("Create and FindByID", func(t *testing.T) {
+		for _, job := range []*domain.TranscriptionJob{first, second} {
+			if err := repo.Create(ctx, job); err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+		}
+		got, err := repo.FindByID(ctx, first.ID)
+		if err != nil {
+			t.Fatalf("FindByID failed: %v", err)
+		}
+		if got.Status != domain.JobQueued || got.Encounter != first.Encounter || !reflect.DeepEqual(got.Audio, first.Audio) || got.AudioPath != first.AudioPath {
+			t.Errorf("unexpected job: %+v", got)
+		}
+		if got.Source != domain.RecordingFromUpload || got.RecordingID != first.ID || got.ReprocessOf != "" || got.Version != 1 {
+			t.Errorf("unexpected source or version: %+v", got)
+		}
+		if got, _ := repo.FindByID(ctx, second.ID); got.Source != domain.RecordingFromSession || got.ReprocessOf != first.ID || got.Version != 2 {
+			t.Errorf("unexpected source or version: %+v", got)
+		}
+		if !got.CreatedAt.Equal(first.CreatedAt) {
+			t.Errorf("expected created_at %v, got %v", first.CreatedAt, got.CreatedAt)
+		}
+		if got.Note != nil {
+			t.Errorf("expected no note, got %+v", got.Note)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		first.Status = domain.JobSaved
+		first.Transcript = "headache since Monday"
+		first.Segments = []domain.TranscriptSegment{
+			{Index: 0, Text: "headache", StartMS: 0, EndMS: 600, Confidence: 0.9,
+				Words: []domain.Word{{Text: "headache", StartMS: 0, EndMS: 600, Confidence: 0.9}}},
+			{Index: 1, Text: "since Monday", StartMS: 700, EndMS: 1500, Confidence: 0.6,
+				Words: []domain.Word{
+					{Text: "since", StartMS: 700, EndMS: 900, Confidence: 0.8},
+					{Text: "Monday", StartMS: 950, EndMS: 1500, Confidence: 0.4, LowConfidence: true},
+				}},
+		}
+		first.Note = &domain.ClinicalNote{Symptoms: []string{"headache"}}
+		first.ImpressionID = "42"
+		first.AudioPath = ""
+		first.UpdatedAt = created.Add(time.Minute)
+		if err := repo.Update(ctx, first); err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+		got, err := repo.FindByID(ctx, first.ID)
+		if err != nil {
+			t.Fatalf("FindByID failed: %v", err)
+		}
+		if got.Status != domain.JobSaved || got.Transcript != first.Transcript || got.ImpressionID != "42" || got.AudioPath != "" {
+			t.Errorf("update not persisted: %+v", got)
+		}
+		if !reflect.DeepEqual(got.Segments, first.Segments) {
+			t.Errorf("unexpected segments: %+v", got.Segments)
+		}
+		if got.Note == nil || len(got.Note.Symptoms) != 1 || got.Note.Symptoms[0] != "headache" {
+			t.Errorf("unexpected note: %+v", got.Note)
+		}
+		if !got.UpdatedAt.Equal(first.UpdatedAt) {
+			t.Errorf("expected updated_at %v, got %v", first.UpdatedAt, got.UpdatedAt)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		if _, err := repo.FindByID(ctx, tag+"-missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("FindByID: expected ErrNotFound, got %v", err)
+		}
+		if err := repo.Update(ctx, &domain.TranscriptionJob{ID: tag + "-missing"}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Update: expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Find", func(t *testing.T) {
+		ids := func(filter JobFilter) []string {
+			jobs, err := repo.Find(ctx, filter)
+			if err != nil {
+				t.Fatalf("Find failed: %v", err)
+			}
+			var out []string
+			for _, job := range jobs {
+				out = append(out, job.ID)
+			}
+			return out
+		}
+		if got := ids(JobFilter{PatientReference: patient}); fmt.Sprint(got) != fmt.Sprint([]string{second.ID, first.ID}) {
+			t.Errorf("by patient: expected newest first, got %v", got)
+		}
+		if got := ids(JobFilter{EncounterReference: second.Encounter.EncounterReference}); fmt.Sprint(got) != fmt.Sprint([]string{second.ID}) {
+			t.Errorf("by encounter: got %v", got)
+		}
+		if got := ids(JobFilter{PatientReference: patient, Statuses: []domain.JobStatus{domain.JobQueued, domain.JobTranscribing}}); fmt.Sprint(got) != fmt.Sprint([]string{second.ID}) {
+			t.Errorf("by status: got %v", got)
+		}
+		if got := ids(JobFilter{IDs: []string{first.ID, tag + "-missing"}}); fmt.Sprint(got) != fmt.Sprint([]string{first.ID}) {
+			t.Errorf("by ID: got %v", got)
+		}
+		if got := ids(JobFilter{PatientReference: patient, CreatedFrom: created.Add(time.Second)}); fmt.Sprint(got) != fmt.Sprint([]string{second.ID}) {
+			t.Errorf("created from: got %v", got)
+		}
+		if got := ids(JobFilter{PatientReference: patient, CreatedTo: created.Add(time.Second)}); fmt.Sprint(got) != fmt.Sprint([]string{first.ID}) {
+			t.Errorf("created to: got %v", got)
+		}
+		if got := ids(JobFilter{PatientReference: patient, Originals: true}); fmt.Sprint(got) != fmt.Sprint([]string{first.ID}) {
+			t.Errorf("originals: got %v", got)
+		}
+		if got := ids(JobFilter{ReprocessOf: first.ID}); fmt.Sprint(got) != fmt.Sprint([]string{second.ID}) {
+			t.Errorf("by original: got %v", got)
+		}
+	})
+}
+
+func TestMemoryJobRepository_Contract(t *testing.T) {
+	testJobRepositoryContract(t, NewMemoryJobRepository())
+}
+
+func TestSQLiteJobRepository_Contract(t *testing.T) {
+	conn, err := db.NewSQLite(context.Background(), t.TempDir()+"/test.db")
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	defer conn.Close()
+
+	testJobRepositoryContract(t, NewSQLiteJobRepository(conn))
+}
+
+func TestPostgresJobRepository_Contract(t *testing.T) {
+	testJobRepositoryContract(t, NewPostgresJobRepository(testPostgresPool(t)))
+}
@@ -0,0 +1,185 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"clinical-agent-backend/internal/domain"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// VocabularyRepository stores the phrase sets and custom classes that
+// administrators edit at runtime. PostgresVocabularyRepository,
+// SQLiteVocabularyRepository and MemoryVocabularyRepository implement it.
+type VocabularyRepository interface {
+	// SavePhraseSet creates or replaces the phrase set named set.Name.
+	SavePhraseSet(ctx context.Context, set *domain.PhraseSet) error
+	// DeletePhraseSet removes a phrase set, or returns ErrNotFound.
+	DeletePhraseSet(ctx context.Context, name string) error
+	// SaveCustomClass creates or replaces the custom class named class.Name.
+	SaveCustomClass(ctx context.Context, class *domain.CustomClass) error
+	// DeleteCustomClass removes a custom class, or returns ErrNotFound.
+	DeleteCustomClass(ctx context.Context, name string) error
+	// Vocabulary retrieves every phrase set and custom class, ordered by name.
+	Vocabulary(ctx context.Context) (domain.Vocabulary, error)
+}
+
+// marshalPhrases serializes the phrases of a phrase set.
+func marshalPhrases(set *domain.PhraseSet) ([]byte, error) {
+	phrases, err := json.Marshal(set.Phrases)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal phrases: %w", err)
+	}
+	return phrases, nil
+}
+
+// marshalItems serializes the items of a custom class.
+func marshalItems(class *domain.CustomClass) ([]byte, error) {
+	items, err := json.Marshal(class.Items)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal custom class items: %w", err)
+	}
+	return items, nil
+}
+
+// scanPhraseSet reads a row selected with name, phrases, boost, updated_at.
+func scanPhraseSet(scan func(dest ...any) error) (domain.PhraseSet, error) {
+	var set domain.PhraseSet
+	var phrases []byte
+	if err := scan(&set.Name, &phrases, &set.Boost, &set.UpdatedAt); err != nil {
+		return set, fmt.Errorf("failed to scan phrase set: %w", err)
+	}
+	if err := json.Unmarshal(phrases, &set.Phrases); err != nil {
+		return set, fmt.Errorf("failed to unmarshal phrases: %w", err)
+	}
+	return set, nil
+}
+
+// scanCustomClass reads a row selected with name, items, updated_at.
+func scanCustomClass(scan func(dest ...any) error) (domain.CustomClass, error) {
+	var class domain.CustomClass
+	var items []byte
+	if err := scan(&class.Name, &items, &class.UpdatedAt); err != nil {
+		return class, fmt.Errorf("failed to scan custom class: %w", err)
+	}
+	if err := json.Unmarshal(items, &class.Items); err != nil {
+		return class, fmt.Errorf("failed to unmarshal custom class items: %w", err)
+	}
+	return class, nil
+}
+
+// rowScanner is the iteration shared by pgx.Rows and *sql.Rows.
+type rowScanner interface {
+	Next() bool
+	Scan(dest ...any) error
+	Err() error
+}
+
+// scanAll reads every row with scanRow.
+func scanAll[T any](rows rowScanner, scanRow func(scan func(dest ...any) error) (T, error)) ([]T, error) {
+	var out []T
+	for rows.Next() {
+		v, err := scanRow(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return out, nil
+}
+
+const (
+	upsertPhraseSet = `
+		INSERT INTO speech_phrase_sets (name, phrases, boost, updated_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (name) DO UPDATE SET phrases = excluded.phrases, boost = excluded.boost, updated_at = excluded.updated_at
+	`
+	upsertCustomClass = `
+		INSERT INTO speech_custom_classes (name, items, updated_at) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET items = excluded.items, updated_at = excluded.updated_at
+	`
+	selectPhraseSets    = `SELECT name, phrases, boost, updated_at FROM speech_phrase_sets ORDER BY name`
+	selectCustomClasses = `SELECT name, items, updated_at FROM speech_custom_classes ORDER BY name`
+)
+
+// PostgresVocabularyRepository stores the vocabulary in PostgreSQL.
+type PostgresVocabularyRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresVocabularyRepository creates a new Postgres-backed vocabulary
+// repository.
+func NewPostgresVocabularyRepository(db *pgxpool.Pool) *PostgresVocabularyRepository {
+	return &PostgresVocabularyRepository{db: db}
+}
+
+// SavePhraseSet upserts a phrase set.
+func (r *PostgresVocabularyRepository) SavePhraseSet(ctx context.Context, set *domain.PhraseSet) error {
+	phrases, err := marshalPhrases(set)
+	if err != nil {
+		return err
+	}
+	if _, err := r.db.Exec(ctx, upsertPhraseSet, set.Name, phrases, set.Boost, set.UpdatedAt.UTC()); err != nil {
+		return fmt.Errorf("failed to save phrase set: %w", err)
+	}
+	return nil
+}
+
+// DeletePhraseSet removes a phrase set.
+func (r *PostgresVocabularyRepository) DeletePhraseSet(ctx context.Context, name string) error {
+	return r.delete(ctx, "speech_phrase_sets", name)
+}
+
+// SaveCustomClass upserts a custom class.
+func (r *PostgresVocabularyRepository) SaveCustomClass(ctx context.Context, class *domain.CustomClass) error {
+	items, err := marshalItems(class)
+	if err != nil {
+		return err
+	}
+	if _, err := r.db.Exec(ctx, upsertCustomClass, class.Name, items, class.UpdatedAt.UTC()); err != nil {
+		return fmt.Errorf("failed to save custom class: %w", err)
+	}
+	return nil
+}
+
+// DeleteCustomClass removes a custom class.
+func (r *PostgresVocabularyRepository) DeleteCustomClass(ctx context.Context, name string) error {
+	return r.delete(ctx, "speech_custom_classes", name)
+}
+
+func (r *PostgresVocabularyRepository) delete(ctx context.Context, table, name string) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM `+table+` WHERE name = $1`, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete from %s: %w", table, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Vocabulary retrieves every phrase set and custom class.
+func (r *PostgresVocabularyRepository) Vocabulary(ctx context.Context) (domain.Vocabulary, error) {
+	var v domain.Vocabulary
+	rows, err := r.db.Query(ctx, selectPhraseSets)
+	if err != nil {
+		return v, fmt.Errorf("failed to query phrase sets: %w", err)
+	}
+	v.PhraseSets, err = scanAll(rows, scanPhraseSet)
+	rows.Close()
+	if err != nil {
+		return v, err
+	}
+
+	rows, err = r.db.Query(ctx, selectCustomClasses)
+	if err != nil {
+		return v, fmt.Errorf("failed to query custom classes: %w", err)
+	}
+	defer rows.Close()
+	v.CustomClasses, err = scanAll(rows, scanCustomClass)
+	return v, err
+}
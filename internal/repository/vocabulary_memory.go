@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+
+	"clinical-agent-backend/internal/domain"
+)
+
+// MemoryVocabularyRepository keeps the vocabulary in process memory. Edits
+// are lost on restart.
+type MemoryVocabularyRepository struct {
+	mu      sync.RWMutex
+	sets    map[string]domain.PhraseSet
+	classes map[string]domain.CustomClass
+}
+
+// NewMemoryVocabularyRepository creates an empty in-memory vocabulary
+// repository.
+func NewMemoryVocabularyRepository() *MemoryVocabularyRepository {
+	return &MemoryVocabularyRepository{
+		sets:    make(map[string]domain.PhraseSet),
+		classes: make(map[string]domain.CustomClass),
+	}
+}
+
+// SavePhraseSet stores a copy of the phrase set.
+func (r *MemoryVocabularyRepository) SavePhraseSet(ctx context.Context, set *domain.PhraseSet) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := *set
+	c.Phrases = slices.Clone(set.Phrases)
+	r.sets[set.Name] = c
+	return nil
+}
+
+// DeletePhraseSet removes a phrase set.
+func (r *MemoryVocabularyRepository) DeletePhraseSet(ctx context.Context, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.sets[name]; !ok {
+		return ErrNotFound
+	}
+	delete(r.sets, name)
+	return nil
+}
+
+// SaveCustomClass stores a copy of the custom class.
+func (r *MemoryVocabularyRepository) SaveCustomClass(ctx context.Context, class *domain.CustomClass) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := *class
+	c.Items = slices.Clone(class.Items)
+	r.classes[class.Name] = c
+	return nil
+}
+
+// DeleteCustomClass removes a custom class.
+func (r *MemoryVocabularyRepository) DeleteCustomClass(ctx context.Context, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.classes[name]; !ok {
+		return ErrNotFound
+	}
+	delete(r.classes, name)
+	return nil
+}
+
+// Vocabulary retrieves every phrase set and custom class, ordered by name.
+func (r *MemoryVocabularyRepository) Vocabulary(ctx context.Context) (domain.Vocabulary, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var v domain.Vocabulary
+	for _, set := range r.sets {
+		set.Phrases = slices.Clone(set.Phrases)
+		v.PhraseSets = append(v.PhraseSets, set)
+	}
+	for _, class := range r.classes {
+		class.Items = slices.Clone(class.Items)
+		v.CustomClasses = append(v.CustomClasses, class)
+	}
+	slices.SortFunc(v.PhraseSets, func(a, b domain.PhraseSet) int { return cmp.Compare(a.Name, b.Name) })
+	slices.SortFunc(v.CustomClasses, func(a, b domain.CustomClass) int { return cmp.Compare(a.Name, b.Name) })
+	return v, nil
+}
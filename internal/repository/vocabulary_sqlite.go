@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"clinical-agent-backend/internal/domain"
+)
+
+// SQLiteVocabularyRepository stores the vocabulary in SQLite.
+type SQLiteVocabularyRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteVocabularyRepository creates a new SQLite-backed vocabulary
+// repository.
+func NewSQLiteVocabularyRepository(db *sql.DB) *SQLiteVocabularyRepository {
+	return &SQLiteVocabularyRepository{db: db}
+}
+
+// SavePhraseSet upserts a phrase set.
+func (r *SQLiteVocabularyRepository) SavePhraseSet(ctx context.Context, set *domain.PhraseSet) error {
+	phrases, err := marshalPhrases(set)
+	if err != nil {
+		return err
+	}
+	if _, err := r.db.ExecContext(ctx, upsertPhraseSet, set.Name, string(phrases), set.Boost, set.UpdatedAt.UTC()); err != nil {
+		return fmt.Errorf("failed to save phrase set: %w", err)
+	}
+	return nil
+}
+
+// DeletePhraseSet removes a phrase set.
+func (r *SQLiteVocabularyRepository) DeletePhraseSet(ctx context.Context, name string) error {
+	return r.delete(ctx, "speech_phrase_sets", name)
+}
+
+// SaveCustomClass upserts a custom class.
+func (r *SQLiteVocabularyRepository) SaveCustomClass(ctx context.Context, class *domain.CustomClass) error {
+	items, err := marshalItems(class)
+	if err != nil {
+		return err
+	}
+	if _, err := r.db.ExecContext(ctx, upsertCustomClass, class.Name, string(items), class.UpdatedAt.UTC()); err != nil {
+		return fmt.Errorf("failed to save custom class: %w", err)
+	}
+	return nil
+}
+
+// DeleteCustomClass removes a custom class.
+func (r *SQLiteVocabularyRepository) DeleteCustomClass(ctx context.Context, name string) error {
+	return r.delete(ctx, "speech_custom_classes", name)
+}
+
+func (r *SQLiteVocabularyRepository) delete(ctx context.Context, table, name string) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM `+table+` WHERE name = ?`, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete from %s: %w", table, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Vocabulary retrieves every phrase set and custom class.
+func (r *SQLiteVocabularyRepository) Vocabulary(ctx context.Context) (domain.Vocabulary, error) {
+	var v domain.Vocabulary
+	rows, err := r.db.QueryContext(ctx, selectPhraseSets)
+	if err != nil {
+		return v, fmt.Errorf("failed to query phrase sets: %w", err)
+	}
+	v.PhraseSets, err = scanAll(rows, scanPhraseSet)
+	rows.Close()
+	if err != nil {
+		return v, err
+	}
+
+	rows, err = r.db.QueryContext(ctx, selectCustomClasses)
+	if err != nil {
+		return v, fmt.Errorf("failed to query custom classes: %w", err)
+	}
+	defer rows.Close()
+	v.CustomClasses, err = scanAll(rows, scanCustomClass)
+	return v, err
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"clinical-agent-backend/internal/db"
+	"clinical-agent-backend/internal/domain"
+)
+
+// testVocabularyRepositoryContract exercises the behaviour every
+// VocabularyRepository implementation must provide.
+func testVocabularyRepositoryContract(t *testing.T, repo VocabularyRepository) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tag := fmt.Sprintf("%d", time.Now().UnixNano())
+	updated := time.Now().Truncate(time.Millisecond)
+	formulary := &domain.PhraseSet{
+		Name:      "formulary-" + tag,
+		Phrases:   []domain.Phrase{{Value: "metformin"}, {Value: "take ${drugs-" + tag + "}", Boost: 15}},
+		Boost:     10,
+		UpdatedAt: updated,
+	}
+	drugs := &domain.CustomClass{Name: "drugs-" + tag, Items: []string{"lisinopril", "atorvastatin"}, UpdatedAt: updated}
+
+	// find returns the stored entries created by this run.
+	find := func() (sets []domain.PhraseSet, classes []domain.CustomClass) {
+		v, err := repo.Vocabulary(ctx)
+		if err != nil {
+			t.Fatalf("Vocabulary failed: %v", err)
+		}
+		for _, s := range v.PhraseSets {
+			if s.Name == formulary.Name {
+				sets = append(sets, s)
+			}
+		}
+		for _, c := range v.CustomClasses {
+			if c.Name == drugs.Name {
+				classes = append(classes, c)
+			}
+		}
+		return sets, classes
+	}
+	matches := func(gotSet domain.PhraseSet, wantSet *domain.PhraseSet) bool {
+		return reflect.DeepEqual(gotSet.Phrases, wantSet.Phrases) && gotSet.Boost == wantSet.Boost && gotSet.UpdatedAt.Equal(wantSet.UpdatedAt)
+	}
+
+	t.Run("Save and Vocabulary", func(t *testing.T) {
+		if err := repo.SavePhraseSet(ctx, formulary); err != nil {
+			t.Fatalf("SavePhraseSet failed: %v", err)
+		}
+		if err := repo.SaveCustomClass(ctx, drugs); err != nil {
+			t.Fatalf("SaveCustomClass failed: %v", err)
+		}
+		sets, classes := find()
+		if len(sets) != 1 || !matches(sets[0], formulary) {
+			t.Errorf("unexpected phrase sets: %+v", sets)
+		}
+		if len(classes) != 1 || !reflect.DeepEqual(classes[0].Items, drugs.Items) || !classes[0].UpdatedAt.Equal(updated) {
+			t.Errorf("unexpected custom classes: %+v", classes)
+		}
+	})
+
+	t.Run("Save replaces", func(t *testing.T) {
+		formulary.Phrases = []domain.Phrase{{Value: "empagliflozin"}}
+		formulary.Boost = 5
+		formulary.UpdatedAt = updated.Add(time.Minute)
+		if err := repo.SavePhraseSet(ctx, formulary); err != nil {
+			t.Fatalf("SavePhraseSet failed: %v", err)
+		}
+		if sets, _ := find(); len(sets) != 1 || !matches(sets[0], formulary) {
+			t.Errorf("phrase set not replaced: %+v", sets)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := repo.DeletePhraseSet(ctx, formulary.Name); err != nil {
+			t.Fatalf("DeletePhraseSet failed: %v", err)
+		}
+		if err := repo.DeleteCustomClass(ctx, drugs.Name); err != nil {
+			t.Fatalf("DeleteCustomClass failed: %v", err)
+		}
+		if sets, classes := find(); len(sets) != 0 || len(classes) != 0 {
+			t.Errorf("expected entries to be deleted, got %+v %+v", sets, classes)
+		}
+		if err := repo.DeletePhraseSet(ctx, formulary.Name); !errors.Is(err, ErrNotFound) {
+			t.Errorf("DeletePhraseSet: expected ErrNotFound, got %v", err)
+		}
+		if err := repo.DeleteCustomClass(ctx, drugs.Name); !errors.Is(err, ErrNotFound) {
+			t.Errorf("DeleteCustomClass: expected ErrNotFound, got %v", err)
+		}
+	})
+}
+
+func TestMemoryVocabularyRepository_Contract(t *testing.T) {
+	testVocabularyRepositoryContract(t, NewMemoryVocabularyRepository())
+}
+
+func TestSQLiteVocabularyRepository_Contract(t *testing.T) {
+	conn, err := db.NewSQLite(context.Background(), t.TempDir()+"/test.db")
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	defer conn.Close()
+
+	testVocabularyRepositoryContract(t, NewSQLiteVocabularyRepository(conn))
+}
+
+func TestPostgresVocabularyRepository_Contract(t *testing.T) {
+	testVocabularyRepositoryContract(t, NewPostgresVocabularyRepository(testPostgresPool(t)))
+}
@@ -0,0 +1,316 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"clinical-agent-backend/internal/domain"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// WorkQueue is a durable queue of tasks on transcription jobs. Workers
+// claim tasks under a lease: a worker that stops or stalls loses its task
+// when the lease runs out, and another worker takes it over. Tasks of one
+// job are claimed one at a time, in the order they were queued.
+// TranscriptionJobRepository.Queue returns the queue kept with the jobs.
+type WorkQueue interface {
+	// Enqueue stores a new task and sets its ID. A task stored running is
+	// already leased to its Worker until its VisibleAt.
+	Enqueue(ctx context.Context, task *domain.Task) error
+	// Claim leases the next ready task to worker for lease, counting an
+	// attempt. A task is ready when it is pending and visible, or running
+	// with an expired lease, and no earlier task of its job is unfinished.
+	// Claim returns nil if no task is ready.
+	Claim(ctx context.Context, worker string, lease time.Duration) (*domain.Task, error)
+	// ClaimByID leases task id to worker like Claim, returning nil if it is
+	// not ready.
+	ClaimByID(ctx context.Context, id int64, worker string, lease time.Duration) (*domain.Task, error)
+	// Extend renews worker's lease on a running task. It returns
+	// ErrNotFound if worker no longer holds the task.
+	Extend(ctx context.Context, id int64, worker string, lease time.Duration) error
+	// Complete marks a task worker holds as done, or returns ErrNotFound.
+	Complete(ctx context.Context, id int64, worker string) error
+	// Fail records a failed attempt of a task worker holds and makes the
+	// task pending again from retryAt, or returns ErrNotFound.
+	Fail(ctx context.Context, id int64, worker, code, message string, retryAt time.Time) error
+	// Release hands a task worker holds back without counting the attempt,
+	// as when the worker stops before finishing it: the task is pending
+	// again at once and keeps the error of its last failed attempt. It
+	// returns ErrNotFound if worker no longer holds the task.
+	Release(ctx context.Context, id int64, worker string) error
+	// DeadLetter records the failure of a task worker holds and stops
+	// retrying it, or returns ErrNotFound.
+	DeadLetter(ctx context.Context, id int64, worker, code, message string) error
+	// Retry makes a dead task pending again with fresh attempts, or returns
+	// ErrNotFound if there is no dead task id.
+	Retry(ctx context.Context, id int64) error
+	// FindByID retrieves a single task, or ErrNotFound.
+	FindByID(ctx context.Context, id int64) (*domain.Task, error)
+	// Find retrieves the tasks matching filter, newest first.
+	Find(ctx context.Context, filter TaskFilter) ([]*domain.Task, error)
+}
+
+// TaskFilter narrows WorkQueue.Find. Empty fields match every task.
+type TaskFilter struct {
+	JobID    string
+	Statuses []domain.TaskStatus
+}
+
+// matches reports whether task satisfies the filter.
+func (f TaskFilter) matches(task *domain.Task) bool {
+	if f.JobID != "" && task.JobID != f.JobID {
+		return false
+	}
+	if len(f.Statuses) == 0 {
+		return true
+	}
+	for _, s := range f.Statuses {
+		if task.Status == s {
+			return true
+		}
+	}
+	return false
+}
+
+// where renders the filter as a SQL condition, numbering parameters with
+// placeholder (e.g. "$%d" for Postgres, "?" for SQLite).
+func (f TaskFilter) where(placeholder func(n int) string) (string, []any) {
+	var conds []string
+	var args []any
+	if f.JobID != "" {
+		args = append(args, f.JobID)
+		conds = append(conds, "job_id = "+placeholder(len(args)))
+	}
+	if len(f.Statuses) > 0 {
+		var in []string
+		for _, s := range f.Statuses {
+			args = append(args, string(s))
+			in = append(in, placeholder(len(args)))
+		}
+		conds = append(conds, "status IN ("+strings.Join(in, ", ")+")")
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(conds, " AND "), args
+}
+
+const taskColumns = `id, kind, job_id, status, payload, attempts, max_attempts, visible_at,
+	worker, error_code, error, created_at, updated_at`
+
+// taskReady is the SQL condition under which the task aliased q may be
+// claimed at the time in parameter now.
+func taskReady(now string) string {
+	return `q.status IN ('pending', 'running') AND q.visible_at <= ` + now + `
+		AND NOT EXISTS (
+			SELECT 1 FROM work_queue e
+			WHERE e.job_id = q.job_id AND e.id < q.id AND e.status IN ('pending', 'running')
+		)`
+}
+
+// taskPayload holds the JSON payload column of a task.
+type taskPayload struct {
+	Turn *domain.SessionTurn `json:"turn,omitempty"`
+	End  *domain.SessionEnd  `json:"end,omitempty"`
+}
+
+// marshalTaskPayload serializes the task's input, or returns nil if it has
+// none.
+func marshalTaskPayload(task *domain.Task) ([]byte, error) {
+	if task.Turn == nil && task.End == nil {
+		return nil, nil
+	}
+	payload, err := json.Marshal(taskPayload{Turn: task.Turn, End: task.End})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal task payload: %w", err)
+	}
+	return payload, nil
+}
+
+// taskValues returns the column values of task in taskColumns order,
+// without the ID.
+func taskValues(task *domain.Task, payload any) []any {
+	return []any{
+		string(task.Kind), task.JobID, string(task.Status), payload, task.Attempts, task.MaxAttempts,
+		task.VisibleAt.UTC(), task.Worker, task.ErrorCode, task.Error, task.CreatedAt.UTC(), task.UpdatedAt.UTC(),
+	}
+}
+
+// scanTask reads a row selected with taskColumns.
+func scanTask(scan func(dest ...any) error) (*domain.Task, error) {
+	var task domain.Task
+	var kind, status string
+	var payload []byte
+	if err := scan(&task.ID, &kind, &task.JobID, &status, &payload, &task.Attempts, &task.MaxAttempts,
+		&task.VisibleAt, &task.Worker, &task.ErrorCode, &task.Error, &task.CreatedAt, &task.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	task.Kind = domain.TaskKind(kind)
+	task.Status = domain.TaskStatus(status)
+	if len(payload) > 0 {
+		var p taskPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal task payload: %w", err)
+		}
+		task.Turn, task.End = p.Turn, p.End
+	}
+	return &task, nil
+}
+
+// pgxQuerier is satisfied by both a pool and a transaction.
+type pgxQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// insertPostgresTask inserts task with q and sets its ID.
+func insertPostgresTask(ctx context.Context, q pgxQuerier, task *domain.Task) error {
+	payload, err := marshalTaskPayload(task)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO work_queue (kind, job_id, status, payload, attempts, max_attempts, visible_at,
+			worker, error_code, error, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id`
+	if err := q.QueryRow(ctx, query, taskValues(task, payload)...).Scan(&task.ID); err != nil {
+		return fmt.Errorf("failed to insert task: %w", err)
+	}
+	return nil
+}
+
+// PostgresWorkQueue keeps the work queue in PostgreSQL. Workers on any
+// number of nodes may share it: claims skip rows other workers have
+// locked.
+type PostgresWorkQueue struct {
+	db *pgxpool.Pool
+}
+
+// Enqueue inserts a new task.
+func (q *PostgresWorkQueue) Enqueue(ctx context.Context, task *domain.Task) error {
+	return insertPostgresTask(ctx, q.db, task)
+}
+
+// Claim leases the oldest ready task.
+func (q *PostgresWorkQueue) Claim(ctx context.Context, worker string, lease time.Duration) (*domain.Task, error) {
+	return q.claim(ctx, "", worker, lease)
+}
+
+// ClaimByID leases task id if it is ready.
+func (q *PostgresWorkQueue) ClaimByID(ctx context.Context, id int64, worker string, lease time.Duration) (*domain.Task, error) {
+	return q.claim(ctx, "AND q.id = $4", worker, lease, id)
+}
+
+func (q *PostgresWorkQueue) claim(ctx context.Context, cond, worker string, lease time.Duration, args ...any) (*domain.Task, error) {
+	now := time.Now().UTC()
+	query := `
+		UPDATE work_queue SET status = 'running', attempts = attempts + 1, worker = $1,
+			visible_at = $3, updated_at = $2
+		WHERE id = (
+			SELECT q.id FROM work_queue q
+			WHERE ` + taskReady("$2") + ` ` + cond + `
+			ORDER BY q.id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + taskColumns
+	row := q.db.QueryRow(ctx, query, append([]any{worker, now, now.Add(lease)}, args...)...)
+	task, err := scanTask(row.Scan)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim task: %w", err)
+	}
+	return task, nil
+}
+
+// Extend renews a lease.
+func (q *PostgresWorkQueue) Extend(ctx context.Context, id int64, worker string, lease time.Duration) error {
+	now := time.Now().UTC()
+	return q.update(ctx, `visible_at = $3, updated_at = $4`, id, worker, now.Add(lease), now)
+}
+
+// Complete marks a task done.
+func (q *PostgresWorkQueue) Complete(ctx context.Context, id int64, worker string) error {
+	return q.update(ctx, `status = 'done', updated_at = $3`, id, worker, time.Now().UTC())
+}
+
+// Fail schedules a retry.
+func (q *PostgresWorkQueue) Fail(ctx context.Context, id int64, worker, code, message string, retryAt time.Time) error {
+	return q.update(ctx, `status = 'pending', error_code = $3, error = $4, visible_at = $5, updated_at = $6`,
+		id, worker, code, message, retryAt.UTC(), time.Now().UTC())
+}
+
+// Release hands back an unfinished task, giving back its attempt.
+func (q *PostgresWorkQueue) Release(ctx context.Context, id int64, worker string) error {
+	now := time.Now().UTC()
+	return q.update(ctx, `status = 'pending', attempts = attempts - 1, visible_at = $3, updated_at = $3`, id, worker, now)
+}
+
+// DeadLetter stops retrying a task.
+func (q *PostgresWorkQueue) DeadLetter(ctx context.Context, id int64, worker, code, message string) error {
+	return q.update(ctx, `status = 'dead', error_code = $3, error = $4, updated_at = $5`,
+		id, worker, code, message, time.Now().UTC())
+}
+
+// update sets columns of a running task held by worker; id and worker are
+// parameters $1 and $2.
+func (q *PostgresWorkQueue) update(ctx context.Context, set string, id int64, worker string, args ...any) error {
+	query := `UPDATE work_queue SET ` + set + ` WHERE id = $1 AND worker = $2 AND status = 'running'`
+	tag, err := q.db.Exec(ctx, query, append([]any{id, worker}, args...)...)
+	if err != nil {
+		return fmt.Errorf("failed to update task: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Retry revives a dead task.
+func (q *PostgresWorkQueue) Retry(ctx context.Context, id int64) error {
+	now := time.Now().UTC()
+	query := `
+		UPDATE work_queue SET status = 'pending', attempts = 0, visible_at = $2, updated_at = $2
+		WHERE id = $1 AND status = 'dead'
+	`
+	tag, err := q.db.Exec(ctx, query, id, now)
+	if err != nil {
+		return fmt.Errorf("failed to retry task: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// FindByID retrieves a single task.
+func (q *PostgresWorkQueue) FindByID(ctx context.Context, id int64) (*domain.Task, error) {
+	row := q.db.QueryRow(ctx, `SELECT `+taskColumns+` FROM work_queue WHERE id = $1`, id)
+	task, err := scanTask(row.Scan)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query task: %w", err)
+	}
+	return task, nil
+}
+
+// Find retrieves the tasks matching filter, newest first.
+func (q *PostgresWorkQueue) Find(ctx context.Context, filter TaskFilter) ([]*domain.Task, error) {
+	where, args := filter.where(func(n int) string { return fmt.Sprintf("$%d", n) })
+	rows, err := q.db.Query(ctx, `SELECT `+taskColumns+` FROM work_queue `+where+` ORDER BY id DESC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tasks: %w", err)
+	}
+	defer rows.Close()
+	return scanAll(rows, scanTask)
+}
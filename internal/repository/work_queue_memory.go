@@ -0,0 +1,181 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"clinical-agent-backend/internal/domain"
+)
+
+// copyTask returns a copy of task that shares no mutable state with it.
+func copyTask(task *domain.Task) *domain.Task {
+	c := *task
+	if task.Turn != nil {
+		turn := *task.Turn
+		c.Turn = &turn
+	}
+	if task.End != nil {
+		end := *task.End
+		end.Segments = append([]domain.TranscriptSegment(nil), task.End.Segments...)
+		c.End = &end
+	}
+	return &c
+}
+
+// enqueue stores a copy of task and sets its ID. The caller holds r.mu.
+func (r *MemoryJobRepository) enqueue(task *domain.Task) {
+	r.nextTaskID++
+	task.ID = r.nextTaskID
+	r.tasks = append(r.tasks, copyTask(task))
+}
+
+// memoryWorkQueue is the work queue of a MemoryJobRepository, sharing its
+// lock.
+type memoryWorkQueue struct {
+	r *MemoryJobRepository
+}
+
+// Enqueue stores a copy of the task.
+func (q *memoryWorkQueue) Enqueue(ctx context.Context, task *domain.Task) error {
+	q.r.mu.Lock()
+	defer q.r.mu.Unlock()
+	q.r.enqueue(task)
+	return nil
+}
+
+// ready reports whether the task at index i may be claimed at now. The
+// caller holds the lock.
+func (q *memoryWorkQueue) ready(i int, now time.Time) bool {
+	task := q.r.tasks[i]
+	if task.Status.Finished() || task.VisibleAt.After(now) {
+		return false
+	}
+	for _, earlier := range q.r.tasks[:i] {
+		if earlier.JobID == task.JobID && !earlier.Status.Finished() {
+			return false
+		}
+	}
+	return true
+}
+
+// Claim leases the oldest ready task.
+func (q *memoryWorkQueue) Claim(ctx context.Context, worker string, lease time.Duration) (*domain.Task, error) {
+	return q.claim(func(*domain.Task) bool { return true }, worker, lease), nil
+}
+
+// ClaimByID leases task id if it is ready.
+func (q *memoryWorkQueue) ClaimByID(ctx context.Context, id int64, worker string, lease time.Duration) (*domain.Task, error) {
+	return q.claim(func(task *domain.Task) bool { return task.ID == id }, worker, lease), nil
+}
+
+func (q *memoryWorkQueue) claim(match func(*domain.Task) bool, worker string, lease time.Duration) *domain.Task {
+	q.r.mu.Lock()
+	defer q.r.mu.Unlock()
+	now := time.Now()
+	for i, task := range q.r.tasks {
+		if match(task) && q.ready(i, now) {
+			task.Status = domain.TaskRunning
+			task.Attempts++
+			task.Worker = worker
+			task.VisibleAt = now.Add(lease)
+			task.UpdatedAt = now
+			return copyTask(task)
+		}
+	}
+	return nil
+}
+
+// Extend renews a lease.
+func (q *memoryWorkQueue) Extend(ctx context.Context, id int64, worker string, lease time.Duration) error {
+	return q.update(id, worker, func(task *domain.Task) {
+		task.VisibleAt = time.Now().Add(lease)
+	})
+}
+
+// Complete marks a task done.
+func (q *memoryWorkQueue) Complete(ctx context.Context, id int64, worker string) error {
+	return q.update(id, worker, func(task *domain.Task) {
+		task.Status = domain.TaskDone
+	})
+}
+
+// Fail schedules a retry.
+func (q *memoryWorkQueue) Fail(ctx context.Context, id int64, worker, code, message string, retryAt time.Time) error {
+	return q.update(id, worker, func(task *domain.Task) {
+		task.Status = domain.TaskPending
+		task.ErrorCode, task.Error = code, message
+		task.VisibleAt = retryAt
+	})
+}
+
+// Release hands back an unfinished task, giving back its attempt.
+func (q *memoryWorkQueue) Release(ctx context.Context, id int64, worker string) error {
+	return q.update(id, worker, func(task *domain.Task) {
+		task.Status = domain.TaskPending
+		task.Attempts--
+		task.VisibleAt = time.Now()
+	})
+}
+
+// DeadLetter stops retrying a task.
+func (q *memoryWorkQueue) DeadLetter(ctx context.Context, id int64, worker, code, message string) error {
+	return q.update(id, worker, func(task *domain.Task) {
+		task.Status = domain.TaskDead
+		task.ErrorCode, task.Error = code, message
+	})
+}
+
+// update applies set to a running task held by worker.
+func (q *memoryWorkQueue) update(id int64, worker string, set func(task *domain.Task)) error {
+	q.r.mu.Lock()
+	defer q.r.mu.Unlock()
+	for _, task := range q.r.tasks {
+		if task.ID == id && task.Worker == worker && task.Status == domain.TaskRunning {
+			set(task)
+			task.UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// Retry revives a dead task.
+func (q *memoryWorkQueue) Retry(ctx context.Context, id int64) error {
+	q.r.mu.Lock()
+	defer q.r.mu.Unlock()
+	for _, task := range q.r.tasks {
+		if task.ID == id && task.Status == domain.TaskDead {
+			now := time.Now()
+			task.Status = domain.TaskPending
+			task.Attempts = 0
+			task.VisibleAt, task.UpdatedAt = now, now
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// FindByID retrieves a single task.
+func (q *memoryWorkQueue) FindByID(ctx context.Context, id int64) (*domain.Task, error) {
+	q.r.mu.RLock()
+	defer q.r.mu.RUnlock()
+	for _, task := range q.r.tasks {
+		if task.ID == id {
+			return copyTask(task), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Find retrieves the tasks matching filter, newest first.
+func (q *memoryWorkQueue) Find(ctx context.Context, filter TaskFilter) ([]*domain.Task, error) {
+	q.r.mu.RLock()
+	defer q.r.mu.RUnlock()
+	var tasks []*domain.Task
+	for i := len(q.r.tasks) - 1; i >= 0; i-- {
+		if filter.matches(q.r.tasks[i]) {
+			tasks = append(tasks, copyTask(q.r.tasks[i]))
+		}
+	}
+	return tasks, nil
+}
@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"clinical-agent-backend/internal/domain"
+)
+
+// sqliteExecer is satisfied by both a database and a transaction.
+type sqliteExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// insertSQLiteTask inserts task with db and sets its ID.
+func insertSQLiteTask(ctx context.Context, db sqliteExecer, task *domain.Task) error {
+	payload, err := marshalTaskPayload(task)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO work_queue (kind, job_id, status, payload, attempts, max_attempts, visible_at,
+			worker, error_code, error, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := db.ExecContext(ctx, query, taskValues(task, sqliteText(payload))...)
+	if err != nil {
+		return fmt.Errorf("failed to insert task: %w", err)
+	}
+	if task.ID, err = res.LastInsertId(); err != nil {
+		return fmt.Errorf("failed to read inserted task id: %w", err)
+	}
+	return nil
+}
+
+// SQLiteWorkQueue keeps the work queue in SQLite. SQLite runs one write
+// at a time, so a claim is a single UPDATE.
+type SQLiteWorkQueue struct {
+	db *sql.DB
+}
+
+// Enqueue inserts a new task.
+func (q *SQLiteWorkQueue) Enqueue(ctx context.Context, task *domain.Task) error {
+	return insertSQLiteTask(ctx, q.db, task)
+}
+
+// Claim leases the oldest ready task.
+func (q *SQLiteWorkQueue) Claim(ctx context.Context, worker string, lease time.Duration) (*domain.Task, error) {
+	return q.claim(ctx, "", worker, lease)
+}
+
+// ClaimByID leases task id if it is ready.
+func (q *SQLiteWorkQueue) ClaimByID(ctx context.Context, id int64, worker string, lease time.Duration) (*domain.Task, error) {
+	return q.claim(ctx, "AND q.id = ?4", worker, lease, id)
+}
+
+func (q *SQLiteWorkQueue) claim(ctx context.Context, cond, worker string, lease time.Duration, args ...any) (*domain.Task, error) {
+	now := time.Now().UTC()
+	query := `
+		UPDATE work_queue SET status = 'running', attempts = attempts + 1, worker = ?1,
+			visible_at = ?3, updated_at = ?2
+		WHERE id = (
+			SELECT q.id FROM work_queue q
+			WHERE ` + taskReady("?2") + ` ` + cond + `
+			ORDER BY q.id
+			LIMIT 1
+		)
+		RETURNING ` + taskColumns
+	row := q.db.QueryRowContext(ctx, query, append([]any{worker, now, now.Add(lease)}, args...)...)
+	task, err := scanTask(row.Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim task: %w", err)
+	}
+	return task, nil
+}
+
+// Extend renews a lease.
+func (q *SQLiteWorkQueue) Extend(ctx context.Context, id int64, worker string, lease time.Duration) error {
+	now := time.Now().UTC()
+	return q.update(ctx, `visible_at = ?3, updated_at = ?4`, id, worker, now.Add(lease), now)
+}
+
+// Complete marks a task done.
+func (q *SQLiteWorkQueue) Complete(ctx context.Context, id int64, worker string) error {
+	return q.update(ctx, `status = 'done', updated_at = ?3`, id, worker, time.Now().UTC())
+}
+
+// Fail schedules a retry.
+func (q *SQLiteWorkQueue) Fail(ctx context.Context, id int64, worker, code, message string, retryAt time.Time) error {
+	return q.update(ctx, `status = 'pending', error_code = ?3, error = ?4, visible_at = ?5, updated_at = ?6`,
+		id, worker, code, message, retryAt.UTC(), time.Now().UTC())
+}
+
+// Release hands back an unfinished task, giving back its attempt.
+func (q *SQLiteWorkQueue) Release(ctx context.Context, id int64, worker string) error {
+	now := time.Now().UTC()
+	return q.update(ctx, `status = 'pending', attempts = attempts - 1, visible_at = ?3, updated_at = ?3`, id, worker, now)
+}
+
+// DeadLetter stops retrying a task.
+func (q *SQLiteWorkQueue) DeadLetter(ctx context.Context, id int64, worker, code, message string) error {
+	return q.update(ctx, `status = 'dead', error_code = ?3, error = ?4, updated_at = ?5`,
+		id, worker, code, message, time.Now().UTC())
+}
+
+// update sets columns of a running task held by worker; id and worker are
+// parameters ?1 and ?2.
+func (q *SQLiteWorkQueue) update(ctx context.Context, set string, id int64, worker string, args ...any) error {
+	query := `UPDATE work_queue SET ` + set + ` WHERE id = ?1 AND worker = ?2 AND status = 'running'`
+	res, err := q.db.ExecContext(ctx, query, append([]any{id, worker}, args...)...)
+	if err != nil {
+		return fmt.Errorf("failed to update task: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Retry revives a dead task.
+func (q *SQLiteWorkQueue) Retry(ctx context.Context, id int64) error {
+	query := `
+		UPDATE work_queue SET status = 'pending', attempts = 0, visible_at = ?2, updated_at = ?2
+		WHERE id = ?1 AND status = 'dead'
+	`
+	res, err := q.db.ExecContext(ctx, query, id, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to retry task: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// FindByID retrieves a single task.
+func (q *SQLiteWorkQueue) FindByID(ctx context.Context, id int64) (*domain.Task, error) {
+	row := q.db.QueryRowContext(ctx, `SELECT `+taskColumns+` FROM work_queue WHERE id = ?`, id)
+	task, err := scanTask(row.Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query task: %w", err)
+	}
+	return task, nil
+}
+
+// Find retrieves the tasks matching filter, newest first.
+func (q *SQLiteWorkQueue) Find(ctx context.Context, filter TaskFilter) ([]*domain.Task, error) {
+	where, args := filter.where(sqlitePlaceholder)
+	rows, err := q.db.QueryContext(ctx, `SELECT `+taskColumns+` FROM work_queue `+where+` ORDER BY id DESC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tasks: %w", err)
+	}
+	defer rows.Close()
+	return scanAll(rows, scanTask)
+}
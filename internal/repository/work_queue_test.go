@@ -0,0 +1,242 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"clinical-agent-backend/internal/db"
+	"clinical-agent-backend/internal/domain"
+)
+
+// testWorkQueueContract exercises the behaviour every WorkQueue
+// implementation must provide, through the queue of jobs. The backend may
+// already contain tasks, so claims name the tasks queued here.
+func testWorkQueueContract(t *testing.T, jobs TranscriptionJobRepository) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	queue := jobs.Queue()
+
+	tag := fmt.Sprintf("queue-%d", time.Now().UnixNano())
+	now := time.Now().Truncate(time.Millisecond)
+	newTask := func(kind domain.TaskKind, jobID string) *domain.Task {
+		return &domain.Task{
+			Kind: kind, JobID: jobID, Status: domain.TaskPending, MaxAttempts: 3,
+			VisibleAt: now, CreatedAt: now, UpdatedAt: now,
+		}
+	}
+	job := &domain.TranscriptionJob{
+		ID: tag, Status: domain.JobTranscribing, Source: domain.RecordingFromSession, Version: 1,
+		Audio:     domain.JobAudio{Encoding: "LINEAR16", SampleRateHertz: 16000, Channels: 1, LanguageCode: "en-US"},
+		CreatedAt: now, UpdatedAt: now,
+	}
+	first := newTask(domain.TaskSessionTurn, job.ID)
+	first.Turn = &domain.SessionTurn{TranscriptSeq: 4, Text: "headache since Monday", Language: "en-us", SourceLanguage: "en-us"}
+	second := newTask(domain.TaskSessionEnd, job.ID)
+	second.End = &domain.SessionEnd{Segments: []domain.TranscriptSegment{{Text: "headache since Monday"}}, RecordingID: "rec-" + tag}
+
+	t.Run("Create queues tasks with the job", func(t *testing.T) {
+		if err := jobs.Create(ctx, job, first, second); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		if first.ID == 0 || second.ID <= first.ID {
+			t.Fatalf("expected increasing task IDs, got %d and %d", first.ID, second.ID)
+		}
+		got, err := queue.FindByID(ctx, second.ID)
+		if err != nil {
+			t.Fatalf("FindByID failed: %v", err)
+		}
+		if got.Kind != domain.TaskSessionEnd || got.JobID != job.ID || got.Status != domain.TaskPending || got.MaxAttempts != 3 {
+			t.Errorf("unexpected task: %+v", got)
+		}
+		if got.End == nil || len(got.End.Segments) != 1 || got.End.RecordingID != second.End.RecordingID || got.Turn != nil {
+			t.Errorf("unexpected payload: %+v", got.End)
+		}
+		if got, _ := queue.FindByID(ctx, first.ID); got.Turn == nil || *got.Turn != *first.Turn {
+			t.Errorf("unexpected payload: %+v", got.Turn)
+		}
+		if _, err := queue.FindByID(ctx, second.ID+1000000); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("tasks of a job run in order", func(t *testing.T) {
+		if got, err := queue.ClaimByID(ctx, second.ID, "w1", time.Minute); err != nil || got != nil {
+			t.Fatalf("expected the second task to wait, got %+v, %v", got, err)
+		}
+		got, err := queue.ClaimByID(ctx, first.ID, "w1", time.Minute)
+		if err != nil || got == nil {
+			t.Fatalf("ClaimByID failed: %+v, %v", got, err)
+		}
+		if got.Status != domain.TaskRunning || got.Attempts != 1 || got.Worker != "w1" || !got.VisibleAt.After(now) {
+			t.Errorf("unexpected claimed task: %+v", got)
+		}
+	})
+
+	t.Run("a lease excludes other workers", func(t *testing.T) {
+		if got, err := queue.ClaimByID(ctx, first.ID, "w2", time.Minute); err != nil || got != nil {
+			t.Errorf("expected the leased task not to be claimed, got %+v, %v", got, err)
+		}
+		if err := queue.Extend(ctx, first.ID, "w2", time.Minute); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound extending another worker's task, got %v", err)
+		}
+		if err := queue.Extend(ctx, first.ID, "w1", time.Minute); err != nil {
+			t.Errorf("Extend failed: %v", err)
+		}
+	})
+
+	t.Run("Fail retries and expired leases are taken over", func(t *testing.T) {
+		if err := queue.Fail(ctx, first.ID, "w1", "extraction_failed", "timeout", time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("Fail failed: %v", err)
+		}
+		if got, _ := queue.ClaimByID(ctx, first.ID, "w1", time.Minute); got != nil {
+			t.Fatalf("expected the task to wait for its retry, got %+v", got)
+		}
+		if got, _ := queue.FindByID(ctx, first.ID); got.Status != domain.TaskPending || got.ErrorCode != "extraction_failed" || got.Error != "timeout" {
+			t.Errorf("unexpected failed task: %+v", got)
+		}
+		if err := queue.Fail(ctx, first.ID, "w1", "extraction_failed", "timeout", time.Now()); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound failing a pending task, got %v", err)
+		}
+
+		retry := newTask(domain.TaskJob, tag+"-retry")
+		retry.VisibleAt = now.Add(-time.Second)
+		if err := queue.Enqueue(ctx, retry); err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+		if got, _ := queue.ClaimByID(ctx, retry.ID, "w1", 0); got == nil || got.Attempts != 1 {
+			t.Fatalf("expected a claim, got %+v", got)
+		}
+		got, err := queue.ClaimByID(ctx, retry.ID, "w2", time.Minute)
+		if err != nil || got == nil {
+			t.Fatalf("expected the expired lease to be taken over, got %+v, %v", got, err)
+		}
+		if got.Attempts != 2 || got.Worker != "w2" {
+			t.Errorf("unexpected task: %+v", got)
+		}
+		if err := queue.Complete(ctx, retry.ID, "w1"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound completing a lost task, got %v", err)
+		}
+		if err := queue.Complete(ctx, retry.ID, "w2"); err != nil {
+			t.Errorf("Complete failed: %v", err)
+		}
+		if got, _ := queue.FindByID(ctx, retry.ID); got.Status != domain.TaskDone {
+			t.Errorf("expected done, got %s", got.Status)
+		}
+	})
+
+	t.Run("Release gives back the attempt", func(t *testing.T) {
+		released := newTask(domain.TaskJob, tag+"-released")
+		if err := queue.Enqueue(ctx, released); err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+		queue.ClaimByID(ctx, released.ID, "w1", time.Minute)
+		if err := queue.Fail(ctx, released.ID, "w1", "stt_failed", "unavailable", time.Now()); err != nil {
+			t.Fatalf("Fail failed: %v", err)
+		}
+		if got, _ := queue.ClaimByID(ctx, released.ID, "w1", time.Minute); got == nil || got.Attempts != 2 {
+			t.Fatalf("expected the second attempt, got %+v", got)
+		}
+		if err := queue.Release(ctx, released.ID, "w2"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound releasing another worker's task, got %v", err)
+		}
+		if err := queue.Release(ctx, released.ID, "w1"); err != nil {
+			t.Fatalf("Release failed: %v", err)
+		}
+		got, _ := queue.FindByID(ctx, released.ID)
+		if got.Status != domain.TaskPending || got.Attempts != 1 || got.ErrorCode != "stt_failed" || got.Error != "unavailable" || got.VisibleAt.After(time.Now()) {
+			t.Errorf("expected the task pending at once with one attempt and its last error, got %+v", got)
+		}
+		if got, _ := queue.ClaimByID(ctx, released.ID, "w2", time.Minute); got == nil || got.Attempts != 2 {
+			t.Errorf("expected the released task to be claimed for its second attempt again, got %+v", got)
+		}
+	})
+
+	t.Run("DeadLetter and Retry", func(t *testing.T) {
+		dead := newTask(domain.TaskJob, tag+"-dead")
+		dead.Status = domain.TaskRunning
+		dead.Attempts = 1
+		dead.Worker = "w1"
+		dead.VisibleAt = time.Now().Add(time.Minute)
+		if err := queue.Enqueue(ctx, dead); err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+		if got, _ := queue.ClaimByID(ctx, dead.ID, "w2", time.Minute); got != nil {
+			t.Fatalf("expected a task enqueued running to stay leased, got %+v", got)
+		}
+		if err := queue.Retry(ctx, dead.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound retrying a running task, got %v", err)
+		}
+		if err := queue.DeadLetter(ctx, dead.ID, "w1", "mapping_failed", "bad note"); err != nil {
+			t.Fatalf("DeadLetter failed: %v", err)
+		}
+		if got, _ := queue.ClaimByID(ctx, dead.ID, "w2", time.Minute); got != nil {
+			t.Fatalf("expected a dead task not to be claimed, got %+v", got)
+		}
+		found, err := queue.Find(ctx, TaskFilter{JobID: dead.JobID, Statuses: []domain.TaskStatus{domain.TaskDead}})
+		if err != nil {
+			t.Fatalf("Find failed: %v", err)
+		}
+		if len(found) != 1 || found[0].ID != dead.ID || found[0].ErrorCode != "mapping_failed" || found[0].Error != "bad note" {
+			t.Fatalf("unexpected dead tasks: %+v", found)
+		}
+
+		if err := queue.Retry(ctx, dead.ID); err != nil {
+			t.Fatalf("Retry failed: %v", err)
+		}
+		got, err := queue.ClaimByID(ctx, dead.ID, "w2", time.Minute)
+		if err != nil || got == nil {
+			t.Fatalf("expected the retried task to be claimed, got %+v, %v", got, err)
+		}
+		if got.Attempts != 1 {
+			t.Errorf("expected fresh attempts, got %d", got.Attempts)
+		}
+	})
+
+	t.Run("Find filters by job and status", func(t *testing.T) {
+		found, err := queue.Find(ctx, TaskFilter{JobID: job.ID})
+		if err != nil {
+			t.Fatalf("Find failed: %v", err)
+		}
+		if len(found) != 2 || found[0].ID != second.ID || found[1].ID != first.ID {
+			t.Fatalf("expected both tasks of the job newest first, got %+v", found)
+		}
+		found, _ = queue.Find(ctx, TaskFilter{JobID: job.ID, Statuses: []domain.TaskStatus{domain.TaskRunning, domain.TaskDone}})
+		if len(found) != 0 {
+			t.Errorf("expected no running or done tasks, got %+v", found)
+		}
+	})
+
+	t.Run("Claim takes a ready task", func(t *testing.T) {
+		if err := queue.Enqueue(ctx, newTask(domain.TaskJob, tag+"-claim")); err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+		got, err := queue.Claim(ctx, "w3", time.Minute)
+		if err != nil || got == nil {
+			t.Fatalf("expected a task, got %+v, %v", got, err)
+		}
+		if got.Status != domain.TaskRunning || got.Worker != "w3" {
+			t.Errorf("unexpected claimed task: %+v", got)
+		}
+	})
+}
+
+func TestMemoryWorkQueue_Contract(t *testing.T) {
+	testWorkQueueContract(t, NewMemoryJobRepository())
+}
+
+func TestSQLiteWorkQueue_Contract(t *testing.T) {
+	conn, err := db.NewSQLite(context.Background(), t.TempDir()+"/test.db")
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	defer conn.Close()
+
+	testWorkQueueContract(t, NewSQLiteJobRepository(conn))
+}
+
+func TestPostgresWorkQueue_Contract(t *testing.T) {
+	testWorkQueueContract(t, NewPostgresJobRepository(testPostgresPool(t)))
+}